@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	"github.com/ethereum/go-ethereum/ethdb/pebble"
+	"github.com/ethereum/go-ethereum/internal/flags"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/portalnetwork/beacon"
+	"github.com/ethereum/go-ethereum/portalnetwork/state"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	beaconAPIFlag = &cli.StringFlag{
+		Name:     "beacon-api",
+		Usage:    "URL of the beacon node REST API to bridge from",
+		Required: true,
+		Category: flags.PortalNetworkCategory,
+	}
+	gethChainDataFlag = &cli.StringFlag{
+		Name:     "geth.chaindata",
+		Usage:    "Chaindata directory of the geth database to bridge the state from",
+		Required: true,
+		Category: flags.PortalNetworkCategory,
+	}
+	stateBlockFlag = &cli.Uint64Flag{
+		Name:     "block",
+		Usage:    "Number of the block whose state is bridged (default = head block)",
+		Category: flags.PortalNetworkCategory,
+	}
+	stateFromBlockFlag = &cli.Uint64Flag{
+		Name:     "from-block",
+		Usage:    "Only bridge the state that changed since this block",
+		Category: flags.PortalNetworkCategory,
+	}
+)
+
+var (
+	bridgeCommand = &cli.Command{
+		Name:  "bridge",
+		Usage: "Bridge content from external sources into the portal network",
+		Subcommands: []*cli.Command{
+			bridgeBeaconCommand,
+			bridgeStateCommand,
+		},
+	}
+	bridgeStateCommand = &cli.Command{
+		Action: bridgeState,
+		Name:   "state",
+		Usage:  "Gossip the state of a geth database into the state network",
+		Flags: slices.Concat([]cli.Flag{
+			gethChainDataFlag,
+			stateBlockFlag,
+			stateFromBlockFlag,
+		}, portalProtocolFlags, historyRpcFlags),
+		Description: `
+The bridge state command walks the account and storage tries of a block in a
+geth database and gossips every trie node and contract bytecode with the proofs
+the state network requires. With --from-block only the trie nodes and bytecode
+that are new since that block are gossiped. The progress is saved in the data
+directory, an interrupted run resumes where it stopped.
+`,
+	}
+	bridgeBeaconCommand = &cli.Command{
+		Action: bridgeBeacon,
+		Name:   "beacon",
+		Usage:  "Gossip light client data of a beacon node into the beacon network",
+		Flags: slices.Concat([]cli.Flag{
+			beaconAPIFlag,
+		}, portalProtocolFlags, historyRpcFlags),
+		Description: `
+The bridge beacon command polls the light client endpoints of a beacon node
+and gossips finality and optimistic updates every slot, a bootstrap and the
+historical summaries for every newly finalized epoch and the light client
+update of every completed sync committee period.
+`,
+	}
+)
+
+func bridgeBeacon(ctx *cli.Context) error {
+	err := setDefaultLogger(ctx.Int(utils.PortalLogLevelFlag.Name), ctx.String(utils.PortalLogFormatFlag.Name))
+	if err != nil {
+		return err
+	}
+	config, dirLock, err := getLockedPortalConfig(ctx)
+	if err != nil {
+		return err
+	}
+	defer dirLock.Unlock()
+	beaconNetwork, closeFunc, err := startBeaconNetwork(config)
+	if err != nil {
+		return err
+	}
+	defer closeFunc()
+
+	bridge := beacon.NewBeaconBridge(beacon.NewBeaconAPIClient(ctx.String(beaconAPIFlag.Name), configs.Mainnet), beaconNetwork)
+	bridge.Start()
+	defer bridge.Stop()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	<-interrupt
+	log.Info("Stopping beacon bridge")
+	return nil
+}
+
+// startBeaconNetwork joins the beacon network without serving RPC.
+func startBeaconNetwork(config *Config) (*beacon.BeaconNetwork, func(), error) {
+	conn, discV5, localNode, utp, closeFunc, err := startPortalNode(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	beaconNetwork, _, err := initBeacon(*config, rpc.NewServer(), conn, localNode, discV5, utp)
+	if err != nil {
+		closeFunc()
+		return nil, nil, err
+	}
+	return beaconNetwork, func() {
+		beaconNetwork.Stop()
+		closeFunc()
+	}, nil
+}
+
+func bridgeState(ctx *cli.Context) error {
+	err := setDefaultLogger(ctx.Int(utils.PortalLogLevelFlag.Name), ctx.String(utils.PortalLogFormatFlag.Name))
+	if err != nil {
+		return err
+	}
+	config, dirLock, err := getLockedPortalConfig(ctx)
+	if err != nil {
+		return err
+	}
+	defer dirLock.Unlock()
+	db, err := openChainDatabase(ctx.String(gethChainDataFlag.Name))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stateNetwork, closeFunc, err := startStateNetwork(config, chainHeaders{db})
+	if err != nil {
+		return err
+	}
+	defer closeFunc()
+
+	bridge := state.NewStateBridge(db, stateNetwork, filepath.Join(config.DataDir, "state_bridge.json"))
+	var block, from *types.Header
+	if ctx.IsSet(stateBlockFlag.Name) {
+		block, err = bridge.Header(ctx.Uint64(stateBlockFlag.Name))
+	} else {
+		block, err = bridge.HeadHeader()
+	}
+	if err != nil {
+		return err
+	}
+	if ctx.IsSet(stateFromBlockFlag.Name) {
+		if ctx.Uint64(stateFromBlockFlag.Name) >= block.Number.Uint64() {
+			return fmt.Errorf("--%s must be smaller than the bridged block %d", stateFromBlockFlag.Name, block.Number)
+		}
+		if from, err = bridge.Header(ctx.Uint64(stateFromBlockFlag.Name)); err != nil {
+			return err
+		}
+	}
+
+	bridgeCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			log.Info("Stopping state bridge")
+			cancel()
+		case <-bridgeCtx.Done():
+		}
+	}()
+	return bridge.Bridge(bridgeCtx, from, block)
+}
+
+// openChainDatabase opens a geth chain database and its freezer read-only.
+func openChainDatabase(dir string) (ethdb.Database, error) {
+	var (
+		kvdb ethdb.KeyValueStore
+		err  error
+	)
+	switch rawdb.PreexistingDatabase(dir) {
+	case rawdb.DBPebble:
+		kvdb, err = pebble.New(dir, 512, 64, "", true)
+	case rawdb.DBLeveldb:
+		kvdb, err = leveldb.New(dir, 512, 64, "", true)
+	default:
+		return nil, fmt.Errorf("no geth database found in %s", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db, err := rawdb.NewDatabaseWithFreezer(kvdb, filepath.Join(dir, "ancient"), "", true)
+	if err != nil {
+		kvdb.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// chainHeaders resolves the block headers of offered state content from the
+// bridged database.
+type chainHeaders struct {
+	db ethdb.Database
+}
+
+func (c chainHeaders) LookupBlockHeader(blockHash []byte) (*types.Header, error) {
+	hash := common.BytesToHash(blockHash)
+	number := rawdb.ReadHeaderNumber(c.db, hash)
+	if number == nil {
+		return nil, fmt.Errorf("header %s not found", hash)
+	}
+	return rawdb.ReadHeader(c.db, hash, *number), nil
+}
+
+// startStateNetwork joins the state network without serving RPC.
+func startStateNetwork(config *Config, headers state.HeaderProvider) (*state.StateNetwork, func(), error) {
+	conn, discV5, localNode, utp, closeFunc, err := startPortalNode(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	stateNetwork, err := initState(*config, rpc.NewServer(), conn, localNode, discV5, utp, headers)
+	if err != nil {
+		closeFunc()
+		return nil, nil, err
+	}
+	return stateNetwork, func() {
+		stateNetwork.Stop()
+		closeFunc()
+	}, nil
+}
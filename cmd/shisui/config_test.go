@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
 	"github.com/stretchr/testify/require"
 	"github.com/urfave/cli/v2"
 )
@@ -68,3 +69,142 @@ func TestKeyConfig(t *testing.T) {
 	keyEnc := string(keyStored)
 	require.Equal(t, keyEnc, pk)
 }
+
+func TestBeaconCheckpointConfig(t *testing.T) {
+	flagSet := flag.NewFlagSet("test", 0)
+	flagSet.String("data.dir", t.TempDir(), "test")
+	checkpoint := "0x766647f3c4e1fc91c0db9a9374032ae038778411fbff222974e11f2e3ce7dadf"
+	flagSet.String("beacon.checkpoint", checkpoint, "test")
+	flagSet.Int("beacon.checkpoint.peers", 3, "test")
+	flagSet.Uint64("beacon.finality.ttl", 128, "test")
+	flagSet.Uint64("beacon.optimistic.ttl", 16, "test")
+
+	ctx := cli.NewContext(nil, flagSet, nil)
+	ctx.Command = &cli.Command{Name: "mycommand"}
+
+	config, err := getPortalConfig(ctx)
+	require.NoError(t, err)
+	require.Equal(t, checkpoint, config.BeaconCheckpoint.String())
+	require.Equal(t, 3, config.BeaconCheckpointPeers)
+	require.Equal(t, uint64(128), config.FinalityUpdateTTL)
+	require.Equal(t, uint64(16), config.OptimisticUpdateTTL)
+
+	require.NoError(t, flagSet.Set("beacon.checkpoint", "0x7666"))
+	_, err = getPortalConfig(ctx)
+	require.ErrorContains(t, err, "invalid beacon checkpoint")
+}
+
+func TestEngineConfig(t *testing.T) {
+	flagSet := flag.NewFlagSet("test", 0)
+	flagSet.String("data.dir", t.TempDir(), "test")
+	flagSet.String("beacon.engine.api", "http://127.0.0.1:8551", "test")
+	flagSet.String("beacon.engine.jwtsecret", "", "test")
+
+	ctx := cli.NewContext(nil, flagSet, nil)
+	ctx.Command = &cli.Command{Name: "mycommand"}
+
+	_, err := getPortalConfig(ctx)
+	require.ErrorContains(t, err, "beacon.engine.jwtsecret")
+
+	require.NoError(t, flagSet.Set("beacon.engine.jwtsecret", "/tmp/jwtsecret"))
+	config, err := getPortalConfig(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "http://127.0.0.1:8551", config.EngineAPI)
+	require.Equal(t, "/tmp/jwtsecret", config.EngineJWTSecret)
+}
+
+func TestStateCapacityConfig(t *testing.T) {
+	flagSet := flag.NewFlagSet("test", 0)
+	flagSet.String("data.dir", t.TempDir(), "test")
+	flagSet.Uint64("data.capacity", 2000, "test")
+	flagSet.Uint64("state.capacity", 0, "test")
+
+	ctx := cli.NewContext(nil, flagSet, nil)
+	ctx.Command = &cli.Command{Name: "mycommand"}
+
+	config, err := getPortalConfig(ctx)
+	require.NoError(t, err)
+	require.Zero(t, config.StateCapacity)
+	require.Equal(t, uint64(2000), networkCapacity(*config, "state"))
+
+	require.NoError(t, flagSet.Set("state.capacity", "500"))
+	config, err = getPortalConfig(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2000), config.DataCapacity)
+	require.Equal(t, uint64(500), config.StateCapacity)
+	require.Equal(t, uint64(500), networkCapacity(*config, "state"))
+}
+
+func TestStorageBudgetConfig(t *testing.T) {
+	flagSet := flag.NewFlagSet("test", 0)
+	flagSet.String("data.dir", t.TempDir(), "test")
+	flagSet.Uint64("data.capacity", 3000, "test")
+	flagSet.Uint64("beacon.capacity", 100, "test")
+	flagSet.String("storage.weights", "history=2, state=1", "test")
+
+	ctx := cli.NewContext(nil, flagSet, nil)
+	ctx.Command = &cli.Command{Name: "mycommand"}
+
+	config, err := getPortalConfig(ctx)
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint64{"history": 2, "state": 1}, config.StorageWeights)
+
+	// the capacity left by the capped beacon network is split by weight
+	config.Networks = []string{"history", "beacon", "state"}
+	config.Storage = newStorageCoordinator(*config)
+	require.Equal(t, uint64(100), networkCapacity(*config, "beacon"))
+	require.Equal(t, uint64(1933), networkCapacity(*config, "history"))
+	require.Equal(t, uint64(966), networkCapacity(*config, "state"))
+
+	for _, weights := range []string{"history", "history=0", "era=1", "state=x"} {
+		require.NoError(t, flagSet.Set("storage.weights", weights))
+		_, err = getPortalConfig(ctx)
+		require.Error(t, err, weights)
+	}
+}
+
+func TestStorageBackendConfig(t *testing.T) {
+	flagSet := flag.NewFlagSet("test", 0)
+	flagSet.String("data.dir", t.TempDir(), "test")
+	flagSet.String("storage.backend", "", "test")
+
+	ctx := cli.NewContext(nil, flagSet, nil)
+	ctx.Command = &cli.Command{Name: "mycommand"}
+
+	config, err := getPortalConfig(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "sqlite", config.StorageBackend)
+
+	require.NoError(t, flagSet.Set("storage.backend", "pebble"))
+	config, err = getPortalConfig(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "pebble", config.StorageBackend)
+
+	require.NoError(t, flagSet.Set("storage.backend", "leveldb"))
+	_, err = getPortalConfig(ctx)
+	require.ErrorContains(t, err, "invalid --storage.backend")
+}
+
+func TestDataDirLock(t *testing.T) {
+	flagSet := flag.NewFlagSet("test", 0)
+	tmpDir := filepath.Join(t.TempDir(), "data")
+	flagSet.String("data.dir", tmpDir, "test")
+
+	command := &cli.Command{Name: "mycommand"}
+
+	ctx := cli.NewContext(nil, flagSet, nil)
+	ctx.Command = command
+
+	config, dirLock, err := getLockedPortalConfig(ctx)
+	require.NoError(t, err)
+	require.Equal(t, config.DataDir, tmpDir)
+
+	// a second instance on the same data directory is refused
+	_, _, err = getLockedPortalConfig(ctx)
+	require.ErrorIs(t, err, node.ErrDatadirUsed)
+
+	require.NoError(t, dirLock.Unlock())
+	_, dirLock, err = getLockedPortalConfig(ctx)
+	require.NoError(t, err)
+	require.NoError(t, dirLock.Unlock())
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/discover/portalwire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/portalnetwork/beacon"
+	"github.com/ethereum/go-ethereum/portalnetwork/history"
+	"github.com/ethereum/go-ethereum/portalnetwork/state"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	dbCommand = &cli.Command{
+		Name:  "db",
+		Usage: "Manage the local content storage",
+		Subcommands: []*cli.Command{
+			dbMigrateCommand,
+			dbScrubCommand,
+		},
+	}
+	dbMigrateCommand = &cli.Command{
+		Action: dbMigrate,
+		Name:   "migrate",
+		Usage:  "Copy the history network content from sqlite into pebble",
+		Flags: []cli.Flag{
+			utils.PortalDataDirFlag,
+			utils.PortalDataCapacityFlag,
+			utils.PortalPrivateKeyFlag,
+			utils.PortalLogLevelFlag,
+			utils.PortalLogFormatFlag,
+		},
+		Description: `
+The db migrate command copies the content and the radius of the sqlite history
+storage into a new pebble storage in the data directory, which is used with
+--storage.backend pebble. The sqlite database is left in place and can be
+deleted once the node runs on pebble.
+`,
+	}
+	dbScrubCommand = &cli.Command{
+		Action: dbScrub,
+		Name:   "scrub",
+		Usage:  "Revalidate the stored content and remove the invalid content",
+		Flags: []cli.Flag{
+			utils.PortalDataDirFlag,
+			utils.PortalDataCapacityFlag,
+			utils.PortalPrivateKeyFlag,
+			utils.PortalStorageBackendFlag,
+			utils.PortalLogLevelFlag,
+			utils.PortalLogFormatFlag,
+			scrubNetworkFlag,
+			scrubQuarantineFlag,
+			scrubDryRunFlag,
+		},
+		Description: `
+The db scrub command validates every stored content item of the network again.
+History headers are checked against the master accumulator and bodies and
+receipts against the stored headers, state trie nodes and bytecode against the
+hash in their content key and beacon bootstraps and light client updates
+against the state roots of their headers. Content that fails is deleted, or
+moved into the quarantine directory of the network with --quarantine. Content
+stored without its content key, bodies and receipts without a stored header
+and beacon historical summaries can't be checked and are kept. Run it while the
+node is stopped.
+`,
+	}
+)
+
+var (
+	scrubNetworkFlag = &cli.StringFlag{
+		Name:  "network",
+		Usage: "Network whose storage is scrubbed (history, state, beacon)",
+		Value: portalwire.History.Name(),
+	}
+	scrubQuarantineFlag = &cli.BoolFlag{
+		Name:  "quarantine",
+		Usage: "Move the invalid content into <datadir>/<network>/quarantine instead of deleting it",
+	}
+	scrubDryRunFlag = &cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Only report the invalid content",
+	}
+)
+
+func dbMigrate(ctx *cli.Context) error {
+	err := setDefaultLogger(ctx.Int(utils.PortalLogLevelFlag.Name), ctx.String(utils.PortalLogFormatFlag.Name))
+	if err != nil {
+		return err
+	}
+	config, dirLock, err := getLockedPortalConfig(ctx)
+	if err != nil {
+		return err
+	}
+	defer dirLock.Unlock()
+	networkName := portalwire.History.Name()
+	if _, err = os.Stat(sqliteStoragePath(config.DataDir, networkName)); err != nil {
+		return fmt.Errorf("no sqlite storage to migrate: %w", err)
+	}
+	nodeId := enode.PubkeyToIDV4(&config.PrivateKey.PublicKey)
+	db, err := history.NewDB(config.DataDir, networkName)
+	if err != nil {
+		return err
+	}
+	src, err := history.NewHistoryStorage(storage.PortalStorageConfig{
+		StorageCapacityMB: config.DataCapacity,
+		DB:                db,
+		NodeId:            nodeId,
+		NetworkName:       networkName,
+	})
+	if err != nil {
+		return err
+	}
+	sqliteStorage := src.(*history.ContentStorage)
+	defer sqliteStorage.Close()
+
+	kvdb, err := storage.NewPebbleDB(config.DataDir, networkName)
+	if err != nil {
+		return err
+	}
+	dst, err := storage.NewPebbleStorage(storage.PortalStorageConfig{
+		StorageCapacityMB: config.DataCapacity,
+		KVStore:           kvdb,
+		NodeId:            nodeId,
+		NetworkName:       networkName,
+	})
+	if err != nil {
+		kvdb.Close()
+		return err
+	}
+	defer dst.Close()
+	if dst.ContentCount() != 0 {
+		return fmt.Errorf("the pebble storage already holds %d items", dst.ContentCount())
+	}
+
+	start := time.Now()
+	count, err := sqliteStorage.MigrateTo(dst)
+	if err != nil {
+		return err
+	}
+	size, err := dst.UsedSize()
+	if err != nil {
+		return err
+	}
+	log.Info("Migrated history storage to pebble", "items", count, "size", common.StorageSize(size), "radius", dst.Radius().Hex(), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+func dbScrub(ctx *cli.Context) error {
+	err := setDefaultLogger(ctx.Int(utils.PortalLogLevelFlag.Name), ctx.String(utils.PortalLogFormatFlag.Name))
+	if err != nil {
+		return err
+	}
+	config, dirLock, err := getLockedPortalConfig(ctx)
+	if err != nil {
+		return err
+	}
+	defer dirLock.Unlock()
+	networkName := ctx.String(scrubNetworkFlag.Name)
+	switch networkName {
+	case portalwire.History.Name(), portalwire.State.Name(), portalwire.Beacon.Name():
+	default:
+		return fmt.Errorf("unknown network %s", networkName)
+	}
+	nodeId := enode.PubkeyToIDV4(&config.PrivateKey.PublicKey)
+	// the provenance of the removed content is dropped with it
+	provenance, err := storage.OpenProvenanceStore(config.DataDir, networkName, nodeId)
+	if err != nil {
+		return err
+	}
+	defer provenance.Close()
+	scrubConfig := storage.ScrubConfig{DryRun: ctx.Bool(scrubDryRunFlag.Name), Provenance: provenance}
+	if ctx.Bool(scrubQuarantineFlag.Name) {
+		scrubConfig.QuarantineDir = quarantineDir(config.DataDir, networkName)
+	}
+	var scrubber *storage.Scrubber
+	switch networkName {
+	case portalwire.History.Name():
+		contentStorage, err := openHistoryStorage(*config, nodeId)
+		if err != nil {
+			return err
+		}
+		if closer, ok := contentStorage.(io.Closer); ok {
+			defer closer.Close()
+		}
+		accumulator, err := history.NewMasterAccumulator()
+		if err != nil {
+			return err
+		}
+		scrubber, err = history.NewScrubber(contentStorage, &accumulator, scrubConfig)
+		if err != nil {
+			return err
+		}
+	case portalwire.State.Name():
+		db, err := state.NewDB(config.DataDir)
+		if err != nil {
+			return err
+		}
+		stateStorage, err := state.NewStateStorage(storage.PortalStorageConfig{
+			StorageCapacityMB: networkCapacity(*config, networkName),
+			DB:                db,
+			NodeId:            nodeId,
+			NetworkName:       networkName,
+		})
+		if err != nil {
+			db.Close()
+			return err
+		}
+		defer stateStorage.Close()
+		scrubber, err = state.NewScrubber(stateStorage, scrubConfig)
+		if err != nil {
+			return err
+		}
+	case portalwire.Beacon.Name():
+		if err := os.MkdirAll(filepath.Join(config.DataDir, networkName), 0755); err != nil {
+			return err
+		}
+		db, err := sql.Open("sqlite3", filepath.Join(config.DataDir, networkName, networkName+".sqlite"))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		beaconStorage, err := beacon.NewBeaconStorage(storage.PortalStorageConfig{
+			StorageCapacityMB: networkCapacity(*config, networkName),
+			DB:                db,
+			NodeId:            nodeId,
+			Spec:              configs.Mainnet,
+			NetworkName:       networkName,
+		})
+		if err != nil {
+			return err
+		}
+		scrubber, err = beacon.NewScrubber(beaconStorage, scrubConfig)
+		if err != nil {
+			return err
+		}
+	}
+	res, err := scrubber.Scrub(context.Background())
+	if err != nil {
+		return err
+	}
+	log.Info("Scrubbed storage", "network", networkName, "checked", res.Checked, "invalid", res.Invalid, "skipped", res.Skipped, "unindexed", res.Unindexed, "elapsed", common.PrettyDuration(res.Elapsed))
+	if res.Invalid > 0 && scrubConfig.QuarantineDir != "" && !scrubConfig.DryRun {
+		log.Info("Moved the invalid content into quarantine", "dir", scrubConfig.QuarantineDir)
+	}
+	return nil
+}
+
+// openHistoryStorage opens the history network content storage of the
+// configured backend.
+func openHistoryStorage(config Config, nodeId enode.ID) (storage.ContentStorage, error) {
+	networkName := portalwire.History.Name()
+	if config.StorageBackend == storage.BackendPebble {
+		if _, err := os.Stat(sqliteStoragePath(config.DataDir, networkName)); err == nil {
+			log.Warn("Found a sqlite history storage, copy its content with 'shisui db migrate'", "datadir", config.DataDir)
+		}
+		kvdb, err := storage.NewPebbleDB(config.DataDir, networkName)
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewPebbleStorage(storage.PortalStorageConfig{
+			StorageCapacityMB: networkCapacity(config, networkName),
+			KVStore:           kvdb,
+			NodeId:            nodeId,
+			NetworkName:       networkName,
+		})
+	}
+	db, err := history.NewDB(config.DataDir, networkName)
+	if err != nil {
+		return nil, err
+	}
+	return history.NewHistoryStorage(storage.PortalStorageConfig{
+		StorageCapacityMB: networkCapacity(config, networkName),
+		DB:                db,
+		NodeId:            nodeId,
+		NetworkName:       networkName,
+	})
+}
+
+func sqliteStoragePath(dataDir string, network string) string {
+	return filepath.Join(dataDir, network, network+".sqlite")
+}
+
+func quarantineDir(dataDir string, network string) string {
+	return filepath.Join(dataDir, network, "quarantine")
+}
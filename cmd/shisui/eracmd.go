@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/internal/era"
+	"github.com/ethereum/go-ethereum/internal/flags"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/discover/portalwire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/portalnetwork/history"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/urfave/cli/v2"
+)
+
+const era1Network = "mainnet"
+
+var (
+	fromEpochFlag = &cli.Uint64Flag{
+		Name:     "from-epoch",
+		Usage:    "First epoch to export",
+		Category: flags.PortalNetworkCategory,
+	}
+	toEpochFlag = &cli.Uint64Flag{
+		Name:     "to-epoch",
+		Usage:    "Last epoch to export (inclusive)",
+		Category: flags.PortalNetworkCategory,
+	}
+	era1OutputFlag = &cli.StringFlag{
+		Name:     "output",
+		Usage:    "Directory the era1 files are written to",
+		Value:    "./era1",
+		Category: flags.PortalNetworkCategory,
+	}
+	epochAccumulatorsFlag = &cli.StringFlag{
+		Name:     "epoch-accumulators",
+		Usage:    "Directory of SSZ encoded epoch accumulators, named <epoch root>.bin (default: fetched from the network)",
+		Category: flags.PortalNetworkCategory,
+	}
+)
+
+var (
+	exportCommand = &cli.Command{
+		Name:  "export",
+		Usage: "Export portal network content",
+		Subcommands: []*cli.Command{
+			exportEra1Command,
+		},
+	}
+	importCommand = &cli.Command{
+		Name:  "import",
+		Usage: "Import content into local storage",
+		Subcommands: []*cli.Command{
+			importEra1Command,
+		},
+	}
+	importEra1Command = &cli.Command{
+		Action:    importEra1,
+		Name:      "era1",
+		Usage:     "Import era1 archives into local history storage",
+		ArgsUsage: "<dir>",
+		Flags: []cli.Flag{
+			utils.PortalDataDirFlag,
+			utils.PortalDataCapacityFlag,
+			utils.PortalHistoryCapacityFlag,
+			utils.PortalStorageBackendFlag,
+			utils.PortalPrivateKeyFlag,
+			utils.PortalLogLevelFlag,
+			utils.PortalLogFormatFlag,
+		},
+		Description: `
+The import era1 command writes the in-radius headers with proof, bodies and
+receipts of all era1 archives in the given directory straight into the local
+history storage of --storage.backend, without gossiping them. When the content
+doesn't fit into the history capacity the radius is reduced upfront. The
+imported content is recorded as stored by the node itself.
+`,
+	}
+	exportEra1Command = &cli.Command{
+		Action: exportEra1,
+		Name:   "era1",
+		Usage:  "Export pre-merge history to era1 archives",
+		Flags: slices.Concat([]cli.Flag{
+			fromEpochFlag,
+			toEpochFlag,
+			era1OutputFlag,
+			epochAccumulatorsFlag,
+		}, portalProtocolFlags, historyRpcFlags),
+		Description: `
+The export era1 command assembles headers, bodies and receipts of the given
+epochs out of local storage or the history network and writes them into era1
+archives. The total difficulty is taken from the epoch accumulators and the
+accumulator root of every archive is verified against the master accumulator.
+`,
+	}
+)
+
+func exportEra1(ctx *cli.Context) error {
+	err := setDefaultLogger(ctx.Int(utils.PortalLogLevelFlag.Name), ctx.String(utils.PortalLogFormatFlag.Name))
+	if err != nil {
+		return err
+	}
+	from, to := ctx.Uint64(fromEpochFlag.Name), ctx.Uint64(toEpochFlag.Name)
+	if to < from {
+		return fmt.Errorf("--%s must not be smaller than --%s", toEpochFlag.Name, fromEpochFlag.Name)
+	}
+	dir := ctx.String(era1OutputFlag.Name)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("error creating output directory: %w", err)
+	}
+
+	config, dirLock, err := getLockedPortalConfig(ctx)
+	if err != nil {
+		return err
+	}
+	defer dirLock.Unlock()
+	historyNetwork, closeFunc, err := startHistoryNetwork(config)
+	if err != nil {
+		return err
+	}
+	defer closeFunc()
+
+	masterAccumulator, err := history.NewMasterAccumulator()
+	if err != nil {
+		return err
+	}
+	accumulatorDir := ctx.String(epochAccumulatorsFlag.Name)
+	start := time.Now()
+	for epoch := from; epoch <= to; epoch++ {
+		if epoch >= uint64(len(masterAccumulator.HistoricalEpochs)) {
+			return history.ErrEpochNotPreMerge
+		}
+		epochAccu, err := readEpochAccumulator(historyNetwork, accumulatorDir, masterAccumulator.HistoricalEpochs[epoch])
+		if err != nil {
+			return fmt.Errorf("failed to read epoch accumulator %d: %w", epoch, err)
+		}
+		root, err := exportEpoch(historyNetwork, dir, epoch, epochAccu)
+		if err != nil {
+			return fmt.Errorf("failed to export epoch %d: %w", epoch, err)
+		}
+		log.Info("Exported era1 archive", "epoch", epoch, "root", root, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	return nil
+}
+
+func importEra1(ctx *cli.Context) error {
+	if ctx.Args().Len() != 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	err := setDefaultLogger(ctx.Int(utils.PortalLogLevelFlag.Name), ctx.String(utils.PortalLogFormatFlag.Name))
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(ctx.Args().First(), "*.era1"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no era1 files found in %s", ctx.Args().First())
+	}
+	slices.Sort(files)
+
+	config, dirLock, err := getLockedPortalConfig(ctx)
+	if err != nil {
+		return err
+	}
+	defer dirLock.Unlock()
+	nodeId := enode.PubkeyToIDV4(&config.PrivateKey.PublicKey)
+	contentStorage, err := openHistoryStorage(*config, nodeId)
+	if err != nil {
+		return err
+	}
+	defer contentStorage.(io.Closer).Close()
+	historyStorage, ok := contentStorage.(history.Era1Storage)
+	if !ok {
+		return fmt.Errorf("era1 archives can't be imported into the %s storage", config.StorageBackend)
+	}
+	provenance, err := storage.OpenProvenanceStore(config.DataDir, portalwire.History.Name(), nodeId)
+	if err != nil {
+		return err
+	}
+	defer provenance.Close()
+
+	masterAccumulator, err := history.NewMasterAccumulator()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err = history.NewEra1Importer(historyStorage, nodeId, &masterAccumulator, provenance).Import(files); err != nil {
+		return err
+	}
+	log.Info("Imported era1 archives", "files", len(files), "radius", historyStorage.Radius().Hex(), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// exportEpoch writes one epoch into the output directory. The archive is
+// written under a temporary name and only renamed once its accumulator root
+// has been verified.
+func exportEpoch(historyNetwork *history.HistoryNetwork, dir string, epoch uint64, epochAccu *history.EpochAccumulator) (common.Hash, error) {
+	tmpName := filepath.Join(dir, era.Filename(era1Network, int(epoch), common.Hash{})+".tmp")
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("could not create era file: %w", err)
+	}
+	root, err := historyNetwork.ExportEpoch(f, epoch, epochAccu)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return common.Hash{}, err
+	}
+	return root, os.Rename(tmpName, filepath.Join(dir, era.Filename(era1Network, int(epoch), root)))
+}
+
+// readEpochAccumulator loads the epoch accumulator from the given directory,
+// or from the history network when no directory is configured.
+func readEpochAccumulator(historyNetwork *history.HistoryNetwork, dir string, epochRoot []byte) (*history.EpochAccumulator, error) {
+	if dir == "" {
+		return historyNetwork.GetEpochAccumulator(epochRoot)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, hexutil.Encode(epochRoot)+".bin"))
+	if err != nil {
+		return nil, err
+	}
+	return history.DecodeEpochAccumulator(data)
+}
+
+// startHistoryNetwork joins the history network without serving RPC, for
+// commands that only need to read content from the network.
+func startHistoryNetwork(config *Config) (*history.HistoryNetwork, func(), error) {
+	conn, discV5, localNode, utp, closeFunc, err := startPortalNode(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	historyNetwork, err := initHistory(*config, rpc.NewServer(), conn, localNode, discV5, utp, false)
+	if err != nil {
+		closeFunc()
+		return nil, nil, err
+	}
+	return historyNetwork, func() {
+		historyNetwork.Stop()
+		closeFunc()
+	}, nil
+}
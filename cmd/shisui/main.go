@@ -5,6 +5,7 @@ import (
 	"crypto/ecdsa"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,7 +13,9 @@ import (
 	"path"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -26,6 +29,7 @@ import (
 	"github.com/ethereum/go-ethereum/internal/flags"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/discover/portalwire"
 	"github.com/ethereum/go-ethereum/p2p/enode"
@@ -38,8 +42,10 @@ import (
 	"github.com/ethereum/go-ethereum/portalnetwork/storage"
 	"github.com/ethereum/go-ethereum/portalnetwork/web3"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gofrs/flock"
 	"github.com/mattn/go-isatty"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/protolambda/zrnt/eth2/configs"
 	"github.com/urfave/cli/v2"
 )
@@ -50,6 +56,10 @@ var (
 
 const (
 	privateKeyFileName = "clientKey"
+	dataDirLockName    = "LOCK"
+	// The time a shutdown waits for the uTP transfers in flight and the
+	// validation of the received content.
+	shutdownTimeout = 30 * time.Second
 )
 
 type Config struct {
@@ -58,16 +68,45 @@ type Config struct {
 	RpcAddr      string
 	DataDir      string
 	DataCapacity uint64
-	LogLevel     int
-	Networks     []string
+	// HistoryCapacity, BeaconCapacity and StateCapacity cap the share of
+	// DataCapacity a network may use in MB, 0 means no cap.
+	HistoryCapacity uint64
+	BeaconCapacity  uint64
+	StateCapacity   uint64
+	// StorageWeights split DataCapacity between the networks by name.
+	StorageWeights map[string]uint64
+	// Storage enforces DataCapacity across the networks, commands that run a
+	// single network leave it nil.
+	Storage *storage.StorageCoordinator
+	// StorageCache is the size of the content cache of a network in MB.
+	StorageCache uint64
+	// StorageScrub revalidates the stored content in the background.
+	StorageScrub bool
+	// StorageBackend is the backend of the history network content storage.
+	StorageBackend string
+	LogLevel       int
+	Networks       []string
+
+	BeaconCheckpoint      common.Root
+	BeaconCheckpointPeers int
+	FinalityUpdateTTL     uint64
+	OptimisticUpdateTTL   uint64
+	EngineAPI             string
+	EngineJWTSecret       string
 }
 
 type Client struct {
 	DiscV5API      *discover.DiscV5API
 	HistoryNetwork *history.HistoryNetwork
 	BeaconNetwork  *beacon.BeaconNetwork
+	BeaconSyncer   *beacon.LightClientSyncer
+	EngineDriver   *beacon.EngineDriver
 	StateNetwork   *state.StateNetwork
+	HistoryLookup  *history.HistoryNetwork
+	Storage        *storage.StorageCoordinator
 	Server         *http.Server
+	// stopped is closed once the shutdown is done
+	stopped chan struct{}
 }
 
 var app = flags.NewApp("the go-portal-network command line interface")
@@ -85,9 +124,24 @@ var (
 		utils.PortalRPCPortFlag,
 		utils.PortalDataDirFlag,
 		utils.PortalDataCapacityFlag,
+		utils.PortalHistoryCapacityFlag,
+		utils.PortalBeaconCapacityFlag,
+		utils.PortalStateCapacityFlag,
+		utils.PortalStorageWeightsFlag,
+		utils.PortalStorageCacheFlag,
+		utils.PortalStorageScrubFlag,
+		utils.PortalStorageBackendFlag,
 		utils.PortalLogLevelFlag,
 		utils.PortalLogFormatFlag,
 	}
+	beaconFlags = []cli.Flag{
+		utils.PortalBeaconCheckpointFlag,
+		utils.PortalBeaconCheckpointPeersFlag,
+		utils.PortalBeaconFinalityTTLFlag,
+		utils.PortalBeaconOptimisticTTLFlag,
+		utils.PortalBeaconEngineAPIFlag,
+		utils.PortalBeaconJWTSecretFlag,
+	}
 	metricsFlags = []cli.Flag{
 		utils.MetricsEnabledFlag,
 		utils.MetricsHTTPFlag,
@@ -107,7 +161,13 @@ var (
 
 func init() {
 	app.Action = shisui
-	app.Flags = slices.Concat(portalProtocolFlags, historyRpcFlags, metricsFlags, debug.Flags)
+	app.Flags = slices.Concat(portalProtocolFlags, historyRpcFlags, beaconFlags, metricsFlags, debug.Flags)
+	app.Commands = []*cli.Command{
+		exportCommand,
+		importCommand,
+		bridgeCommand,
+		dbCommand,
+	}
 	flags.AutoEnvVars(app.Flags, "SHISUI")
 }
 
@@ -136,10 +196,11 @@ func shisui(ctx *cli.Context) error {
 		storageCapacity.Update(ctx.Int64(utils.PortalDataCapacityFlag.Name))
 	}
 
-	config, err := getPortalConfig(ctx)
+	config, dirLock, err := getLockedPortalConfig(ctx)
 	if err != nil {
-		return nil
+		return err
 	}
+	defer dirLock.Unlock()
 
 	clientChan := make(chan *Client, 1)
 	go handlerInterrupt(clientChan)
@@ -191,18 +252,37 @@ func handlerInterrupt(clientChan <-chan *Client) {
 			log.Warn("Waiting for the client to start...")
 		}
 		c := <-clientChan
-		c.closePortalRpcServer()
+		c.shutdown()
 	}()
 
 	<-interrupt
 	os.Exit(1)
 }
 
-func (cli *Client) closePortalRpcServer() {
+// shutdown stops the client. The networks stop taking offers and finish the
+// transfers in flight and the validation of the received content first, then
+// the RPC server, the networks and their storages are closed.
+func (cli *Client) shutdown() {
+	defer close(cli.stopped)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	log.Info("Draining networks...")
+	cli.drain(ctx)
+	log.Info("Closing servers...")
+	if err := cli.Server.Shutdown(ctx); err != nil {
+		log.Warn("Failed to shut down the RPC server", "err", err)
+		cli.Server.Close()
+	}
 	if cli.HistoryNetwork != nil {
 		log.Info("Closing history network...")
 		cli.HistoryNetwork.Stop()
 	}
+	if cli.EngineDriver != nil {
+		cli.EngineDriver.Stop()
+	}
+	if cli.BeaconSyncer != nil {
+		cli.BeaconSyncer.Stop()
+	}
 	if cli.BeaconNetwork != nil {
 		log.Info("Closing beacon network...")
 		cli.BeaconNetwork.Stop()
@@ -211,17 +291,45 @@ func (cli *Client) closePortalRpcServer() {
 		log.Info("Closing state network...")
 		cli.StateNetwork.Stop()
 	}
+	if cli.HistoryLookup != nil {
+		cli.HistoryLookup.Stop()
+	}
+	if cli.Storage != nil {
+		cli.Storage.Stop()
+	}
 	log.Info("Closing Database...")
 	cli.DiscV5API.DiscV5.LocalNode().Database().Close()
 	log.Info("Closing UDPv5 protocol...")
 	cli.DiscV5API.DiscV5.Close()
-	log.Info("Closing servers...")
-	cli.Server.Close()
-	os.Exit(1)
+}
+
+// drain drains the enabled networks at the same time.
+func (cli *Client) drain(ctx context.Context) {
+	var networks []interface{ Drain(context.Context) error }
+	if cli.HistoryNetwork != nil {
+		networks = append(networks, cli.HistoryNetwork)
+	}
+	if cli.BeaconNetwork != nil {
+		networks = append(networks, cli.BeaconNetwork)
+	}
+	if cli.StateNetwork != nil {
+		networks = append(networks, cli.StateNetwork)
+	}
+	var wg sync.WaitGroup
+	for _, network := range networks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := network.Drain(ctx); err != nil {
+				log.Warn("Stopping before the transfers are done", "err", err)
+			}
+		}()
+	}
+	wg.Wait()
 }
 
 func startPortalRpcServer(config Config, conn discover.UDPConn, addr string, clientChan chan<- *Client) error {
-	client := &Client{}
+	client := &Client{stopped: make(chan struct{})}
 
 	discV5, localNode, err := initDiscV5(config, conn)
 	if err != nil {
@@ -243,9 +351,16 @@ func startPortalRpcServer(config Config, conn discover.UDPConn, addr string, cli
 	}
 	utp := discover.NewPortalUtp(context.Background(), config.Protocol, discV5, conn)
 
+	config.Storage = newStorageCoordinator(config)
+	client.Storage = config.Storage
+	err = server.RegisterName("portal", storage.NewStorageAPI(config.Storage))
+	if err != nil {
+		return err
+	}
+
 	var historyNetwork *history.HistoryNetwork
 	if slices.Contains(config.Networks, portalwire.History.Name()) {
-		historyNetwork, err = initHistory(config, server, conn, localNode, discV5, utp)
+		historyNetwork, err = initHistory(config, server, conn, localNode, discV5, utp, false)
 		if err != nil {
 			return err
 		}
@@ -254,21 +369,48 @@ func startPortalRpcServer(config Config, conn discover.UDPConn, addr string, cli
 
 	var beaconNetwork *beacon.BeaconNetwork
 	if slices.Contains(config.Networks, portalwire.Beacon.Name()) {
-		beaconNetwork, err = initBeacon(config, server, conn, localNode, discV5, utp)
+		beaconNetwork, client.BeaconSyncer, err = initBeacon(config, server, conn, localNode, discV5, utp)
 		if err != nil {
 			return err
 		}
 		client.BeaconNetwork = beaconNetwork
+		client.BeaconSyncer.Start()
+
+		if config.EngineAPI != "" {
+			var blocks beacon.BlockSource
+			if historyNetwork != nil {
+				blocks = historyNetwork
+			}
+			client.EngineDriver, err = startEngineDriver(config, blocks, client.BeaconSyncer)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	var stateNetwork *state.StateNetwork
 	if slices.Contains(config.Networks, portalwire.State.Name()) {
-		stateNetwork, err = initState(config, server, conn, localNode, discV5, utp)
+		// the state network resolves block headers through the history
+		// network, it is joined for lookups only if it is not enabled
+		var headers state.HeaderProvider
+		if historyNetwork != nil {
+			headers = historyNetwork
+		} else {
+			client.HistoryLookup, err = initHistory(config, server, conn, localNode, discV5, utp, true)
+			if err != nil {
+				return err
+			}
+			headers = client.HistoryLookup
+		}
+		stateNetwork, err = initState(config, server, conn, localNode, discV5, utp, headers)
 		if err != nil {
 			return err
 		}
 		client.StateNetwork = stateNetwork
 	}
+	// the allocations only change once every network is attached, otherwise
+	// the first networks would take the budget of the later ones
+	config.Storage.Start()
 
 	ethapi := &ethapi.API{
 		History: historyNetwork,
@@ -280,14 +422,51 @@ func startPortalRpcServer(config Config, conn discover.UDPConn, addr string, cli
 		return err
 	}
 
+	var handler http.Handler = server
+	if beaconNetwork != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/", server)
+		mux.Handle(beacon.LightClientRestPath, beacon.NewLightClientRestAPI(beaconNetwork))
+		handler = mux
+	}
 	httpServer := &http.Server{
 		Addr:    addr,
-		Handler: server,
+		Handler: handler,
 	}
 	client.Server = httpServer
 
 	clientChan <- client
-	return httpServer.ListenAndServe()
+	if err = httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// the server is closed by the shutdown, which returns once the storages
+	// are closed
+	<-client.stopped
+	return nil
+}
+
+// startPortalNode sets up the discv5 and uTP stack that the sub-networks run
+// on, for commands that join a network without the full RPC server.
+func startPortalNode(config *Config) (*net.UDPConn, *discover.UDPv5, *enode.LocalNode, *discover.PortalUtp, func(), error) {
+	addr, err := net.ResolveUDPAddr("udp", config.Protocol.ListenAddr)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	discV5, localNode, err := initDiscV5(*config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, nil, nil, err
+	}
+	utp := discover.NewPortalUtp(context.Background(), config.Protocol, discV5, conn)
+	closeFunc := func() {
+		localNode.Database().Close()
+		discV5.Close()
+	}
+	return conn, discV5, localNode, utp, closeFunc, nil
 }
 
 func initDiscV5(config Config, conn discover.UDPConn) (*discover.UDPv5, *enode.LocalNode, error) {
@@ -370,20 +549,30 @@ func doPortMapping(natm nat.Interface, ln *enode.LocalNode, addr *net.UDPAddr) {
 	}()
 }
 
-func initHistory(config Config, server *rpc.Server, conn discover.UDPConn, localNode *enode.LocalNode, discV5 *discover.UDPv5, utp *discover.PortalUtp) (*history.HistoryNetwork, error) {
-	networkName := portalwire.History.Name()
-	db, err := history.NewDB(config.DataDir, networkName)
-	if err != nil {
-		return nil, err
-	}
-	contentStorage, err := history.NewHistoryStorage(storage.PortalStorageConfig{
-		StorageCapacityMB: config.DataCapacity,
-		DB:                db,
-		NodeId:            localNode.ID(),
-		NetworkName:       networkName,
-	})
-	if err != nil {
-		return nil, err
+// initHistory joins the history network. A lookup only history network
+// stores no content and serves no RPC API, other networks use it to look up
+// block headers.
+func initHistory(config Config, server *rpc.Server, conn discover.UDPConn, localNode *enode.LocalNode, discV5 *discover.UDPv5, utp *discover.PortalUtp, lookupOnly bool) (*history.HistoryNetwork, error) {
+	contentStorage := storage.NewNopStorage()
+	var (
+		opts       []discover.PortalProtocolOption
+		provenance *storage.ProvenanceStore
+	)
+	if !lookupOnly {
+		var err error
+		contentStorage, err = openHistoryStorage(config, localNode.ID())
+		if err != nil {
+			return nil, err
+		}
+		contentStorage = cacheStorage(config, portalwire.History.Name(), contentStorage)
+		if err = attachStorage(config, portalwire.History.Name(), contentStorage); err != nil {
+			return nil, err
+		}
+		provenance, err = storage.OpenProvenanceStore(config.DataDir, portalwire.History.Name(), localNode.ID())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, discover.WithProvenance(provenance))
 	}
 	contentQueue := make(chan *discover.ContentElement, 50)
 
@@ -396,45 +585,71 @@ func initHistory(config Config, server *rpc.Server, conn discover.UDPConn, local
 		discV5,
 		utp,
 		contentStorage,
-		contentQueue)
+		contentQueue,
+		opts...)
 
 	if err != nil {
 		return nil, err
 	}
+	accumulator, err := history.NewMasterAccumulator()
+	if err != nil {
+		return nil, err
+	}
+	historyNetwork := history.NewHistoryNetwork(protocol, &accumulator)
+	if lookupOnly {
+		return historyNetwork, historyNetwork.Start()
+	}
 	historyAPI := discover.NewPortalAPI(protocol)
-	historyNetworkAPI := history.NewHistoryNetworkAPI(historyAPI)
+	historyNetworkAPI := history.NewHistoryNetworkAPI(historyAPI, historyNetwork)
 	err = server.RegisterName("portal", historyNetworkAPI)
 	if err != nil {
 		return nil, err
 	}
-	accumulator, err := history.NewMasterAccumulator()
-	if err != nil {
+	if err = historyNetwork.Start(); err != nil {
 		return nil, err
 	}
-	historyNetwork := history.NewHistoryNetwork(protocol, &accumulator)
-	return historyNetwork, historyNetwork.Start()
+	if config.StorageScrub {
+		scrubber, err := history.NewScrubber(contentStorage, &accumulator, storage.ScrubConfig{
+			QuarantineDir: quarantineDir(config.DataDir, portalwire.History.Name()),
+			Provenance:    provenance,
+		})
+		if err != nil {
+			return nil, err
+		}
+		historyNetwork.StartScrubber(scrubber)
+	}
+	return historyNetwork, nil
 }
 
-func initBeacon(config Config, server *rpc.Server, conn discover.UDPConn, localNode *enode.LocalNode, discV5 *discover.UDPv5, utp *discover.PortalUtp) (*beacon.BeaconNetwork, error) {
+func initBeacon(config Config, server *rpc.Server, conn discover.UDPConn, localNode *enode.LocalNode, discV5 *discover.UDPv5, utp *discover.PortalUtp) (*beacon.BeaconNetwork, *beacon.LightClientSyncer, error) {
 	dbPath := path.Join(config.DataDir, "beacon")
 	err := os.MkdirAll(dbPath, 0755)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	sqlDb, err := sql.Open("sqlite3", path.Join(dbPath, "beacon.sqlite"))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	contentStorage, err := beacon.NewBeaconStorage(storage.PortalStorageConfig{
-		StorageCapacityMB: config.DataCapacity,
-		DB:                sqlDb,
-		NodeId:            localNode.ID(),
-		Spec:              configs.Mainnet,
-		NetworkName:       portalwire.Beacon.Name(),
+		StorageCapacityMB:   networkCapacity(config, portalwire.Beacon.Name()),
+		DB:                  sqlDb,
+		NodeId:              localNode.ID(),
+		Spec:                configs.Mainnet,
+		NetworkName:         portalwire.Beacon.Name(),
+		FinalityUpdateTTL:   config.FinalityUpdateTTL,
+		OptimisticUpdateTTL: config.OptimisticUpdateTTL,
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if err = attachStorage(config, portalwire.Beacon.Name(), contentStorage); err != nil {
+		return nil, nil, err
+	}
+	provenance, err := storage.OpenProvenanceStore(config.DataDir, portalwire.Beacon.Name(), localNode.ID())
+	if err != nil {
+		return nil, nil, err
 	}
 	contentQueue := make(chan *discover.ContentElement, 50)
 
@@ -447,39 +662,85 @@ func initBeacon(config Config, server *rpc.Server, conn discover.UDPConn, localN
 		discV5,
 		utp,
 		contentStorage,
-		contentQueue)
+		contentQueue,
+		discover.WithProvenance(provenance))
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	beaconNetwork := beacon.NewBeaconNetwork(protocol)
 	portalApi := discover.NewPortalAPI(protocol)
 
-	beaconAPI := beacon.NewBeaconNetworkAPI(portalApi)
+	beaconAPI := beacon.NewBeaconNetworkAPI(portalApi, beaconNetwork)
 	err = server.RegisterName("portal", beaconAPI)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	beaconNetwork := beacon.NewBeaconNetwork(protocol)
-	return beaconNetwork, beaconNetwork.Start()
+	checkpoints, err := beacon.NewCheckpointStore(sqlDb)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lightApi := beacon.NewPortalLightApi(protocol, configs.Mainnet)
+	syncer := beacon.NewLightClientSyncer(lightApi, beaconNetwork, checkpoints, config.BeaconCheckpoint, config.BeaconCheckpointPeers)
+	if err = beaconNetwork.Start(); err != nil {
+		return nil, nil, err
+	}
+	if config.StorageScrub {
+		scrubber, err := beacon.NewScrubber(contentStorage, storage.ScrubConfig{
+			QuarantineDir: quarantineDir(config.DataDir, portalwire.Beacon.Name()),
+			Provenance:    provenance,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		beaconNetwork.StartScrubber(scrubber)
+	}
+	return beaconNetwork, syncer, nil
 }
 
-func initState(config Config, server *rpc.Server, conn discover.UDPConn, localNode *enode.LocalNode, discV5 *discover.UDPv5, utp *discover.PortalUtp) (*state.StateNetwork, error) {
-	networkName := portalwire.State.Name()
-	db, err := history.NewDB(config.DataDir, networkName)
+// startEngineDriver connects to the engine API of the execution client and
+// drives it with the heads of the light client.
+func startEngineDriver(config Config, blocks beacon.BlockSource, syncer *beacon.LightClientSyncer) (*beacon.EngineDriver, error) {
+	secret, err := node.ObtainJWTSecret(config.EngineJWTSecret)
+	if err != nil {
+		return nil, err
+	}
+	engineClient, err := rpc.DialOptions(context.Background(), config.EngineAPI, rpc.WithHTTPAuth(node.NewJWTAuth([32]byte(secret))))
 	if err != nil {
 		return nil, err
 	}
-	contentStorage, err := history.NewHistoryStorage(storage.PortalStorageConfig{
-		StorageCapacityMB: config.DataCapacity,
+	if blocks == nil {
+		log.Warn("History network is disabled, the execution client only receives forkchoice updates")
+	}
+	driver := beacon.NewEngineDriver(engineClient, blocks)
+	driver.Start(syncer)
+	return driver, nil
+}
+
+func initState(config Config, server *rpc.Server, conn discover.UDPConn, localNode *enode.LocalNode, discV5 *discover.UDPv5, utp *discover.PortalUtp, headers state.HeaderProvider) (*state.StateNetwork, error) {
+	db, err := state.NewDB(config.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	stateStorage, err := state.NewStateStorage(storage.PortalStorageConfig{
+		StorageCapacityMB: networkCapacity(config, portalwire.State.Name()),
 		DB:                db,
 		NodeId:            localNode.ID(),
-		NetworkName:       networkName,
+		NetworkName:       portalwire.State.Name(),
 	})
 	if err != nil {
 		return nil, err
 	}
-	stateStore := state.NewStateStorage(contentStorage, db)
+	stateStore := cacheStorage(config, portalwire.State.Name(), stateStorage)
+	if err = attachStorage(config, portalwire.State.Name(), stateStore); err != nil {
+		return nil, err
+	}
+	provenance, err := storage.OpenProvenanceStore(config.DataDir, portalwire.State.Name(), localNode.ID())
+	if err != nil {
+		return nil, err
+	}
 	contentQueue := make(chan *discover.ContentElement, 50)
 
 	protocol, err := discover.NewPortalProtocol(
@@ -491,20 +752,148 @@ func initState(config Config, server *rpc.Server, conn discover.UDPConn, localNo
 		discV5,
 		utp,
 		stateStore,
-		contentQueue)
+		contentQueue,
+		discover.WithProvenance(provenance))
 
 	if err != nil {
 		return nil, err
 	}
+	stateNetwork := state.NewStateNetwork(protocol, headers)
 	api := discover.NewPortalAPI(protocol)
-	stateNetworkAPI := state.NewStateNetworkAPI(api)
+	stateNetworkAPI := state.NewStateNetworkAPI(api, stateNetwork)
 	err = server.RegisterName("portal", stateNetworkAPI)
 	if err != nil {
 		return nil, err
 	}
-	client := rpc.DialInProc(server)
-	historyNetwork := state.NewStateNetwork(protocol, client)
-	return historyNetwork, historyNetwork.Start()
+	if err = stateNetwork.Start(); err != nil {
+		return nil, err
+	}
+	if config.StorageScrub {
+		scrubber, err := state.NewScrubber(stateStore, storage.ScrubConfig{
+			QuarantineDir: quarantineDir(config.DataDir, portalwire.State.Name()),
+			Provenance:    provenance,
+		})
+		if err != nil {
+			return nil, err
+		}
+		stateNetwork.StartScrubber(scrubber)
+	}
+	return stateNetwork, nil
+}
+
+// newStorageCoordinator splits the data capacity between the storages of the
+// enabled networks.
+func newStorageCoordinator(config Config) *storage.StorageCoordinator {
+	caps := map[string]uint64{
+		portalwire.History.Name(): config.HistoryCapacity,
+		portalwire.Beacon.Name():  config.BeaconCapacity,
+		portalwire.State.Name():   config.StateCapacity,
+	}
+	budgets := make(map[string]storage.NetworkBudget)
+	for _, network := range config.Networks {
+		budgets[network] = storage.NetworkBudget{
+			Weight:     config.StorageWeights[network],
+			CapacityMB: caps[network],
+		}
+	}
+	return storage.NewStorageCoordinator(config.DataCapacity, budgets)
+}
+
+// networkCapacity returns the capacity in MB the storage of a network is
+// created with. Without a coordinator the network may use the data capacity
+// up to its cap.
+func networkCapacity(config Config, network string) uint64 {
+	if config.Storage != nil {
+		return config.Storage.CapacityMB(network)
+	}
+	var capacity uint64
+	switch network {
+	case portalwire.History.Name():
+		capacity = config.HistoryCapacity
+	case portalwire.Beacon.Name():
+		capacity = config.BeaconCapacity
+	case portalwire.State.Name():
+		capacity = config.StateCapacity
+	}
+	if capacity == 0 {
+		return config.DataCapacity
+	}
+	return min(capacity, config.DataCapacity)
+}
+
+// cacheStorage puts the content cache in front of the storage of a network.
+func cacheStorage(config Config, network string, contentStorage storage.ContentStorage) storage.ContentStorage {
+	if config.StorageCache == 0 {
+		return contentStorage
+	}
+	return storage.NewCachedStorage(contentStorage, config.StorageCache*storage.BytesInMB, network)
+}
+
+// attachStorage hands the storage of a network to the coordinator.
+func attachStorage(config Config, network string, contentStorage storage.ContentStorage) error {
+	if config.Storage == nil {
+		return nil
+	}
+	budgeted, ok := contentStorage.(storage.BudgetedStorage)
+	if !ok {
+		return fmt.Errorf("%s storage has no adjustable capacity", network)
+	}
+	return config.Storage.Attach(network, budgeted)
+}
+
+// parseStorageWeights parses weights in the form history=2,state=1.
+func parseStorageWeights(value string) (map[string]uint64, error) {
+	weights := make(map[string]uint64)
+	if value == "" {
+		return weights, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		network, weight, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		switch network {
+		case portalwire.History.Name(), portalwire.Beacon.Name(), portalwire.State.Name():
+		default:
+			ok = false
+		}
+		w, err := strconv.ParseUint(weight, 10, 64)
+		if !ok || err != nil || w == 0 {
+			return nil, fmt.Errorf("invalid --%s entry %q", utils.PortalStorageWeightsFlag.Name, entry)
+		}
+		weights[network] = w
+	}
+	return weights, nil
+}
+
+// getLockedPortalConfig locks the data directory and then reads the config,
+// which loads the private key from the data directory. The lock is released
+// by the caller.
+func getLockedPortalConfig(ctx *cli.Context) (*Config, *flock.Flock, error) {
+	dirLock, err := lockDataDir(ctx.String(utils.PortalDataDirFlag.Name))
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := getPortalConfig(ctx)
+	if err != nil {
+		dirLock.Unlock()
+		return nil, nil, err
+	}
+	return config, dirLock, nil
+}
+
+// lockDataDir takes the exclusive lock of the data directory like the node of
+// geth does, so that no two processes open its databases and private key.
+func lockDataDir(dataDir string) (*flock.Flock, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	dirLock := flock.New(filepath.Join(dataDir, dataDirLockName))
+	locked, err := dirLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return nil, fmt.Errorf("%w: %s", node.ErrDatadirUsed, dataDir)
+	}
+	return dirLock, nil
 }
 
 func getPortalConfig(ctx *cli.Context) (*Config, error) {
@@ -517,6 +906,23 @@ func getPortalConfig(ctx *cli.Context) (*Config, error) {
 	config.RpcAddr = net.JoinHostPort(httpAddr, httpPort)
 	config.DataDir = ctx.String(utils.PortalDataDirFlag.Name)
 	config.DataCapacity = ctx.Uint64(utils.PortalDataCapacityFlag.Name)
+	config.HistoryCapacity = ctx.Uint64(utils.PortalHistoryCapacityFlag.Name)
+	config.BeaconCapacity = ctx.Uint64(utils.PortalBeaconCapacityFlag.Name)
+	config.StateCapacity = ctx.Uint64(utils.PortalStateCapacityFlag.Name)
+	config.StorageCache = ctx.Uint64(utils.PortalStorageCacheFlag.Name)
+	config.StorageScrub = ctx.Bool(utils.PortalStorageScrubFlag.Name)
+	weights, err := parseStorageWeights(ctx.String(utils.PortalStorageWeightsFlag.Name))
+	if err != nil {
+		return config, err
+	}
+	config.StorageWeights = weights
+	switch config.StorageBackend = ctx.String(utils.PortalStorageBackendFlag.Name); config.StorageBackend {
+	case "":
+		config.StorageBackend = storage.BackendSqlite
+	case storage.BackendSqlite, storage.BackendPebble:
+	default:
+		return config, fmt.Errorf("invalid --%s %q", utils.PortalStorageBackendFlag.Name, config.StorageBackend)
+	}
 	config.LogLevel = ctx.Int(utils.PortalLogLevelFlag.Name)
 	port := ctx.String(utils.PortalUDPPortFlag.Name)
 	if !strings.HasPrefix(port, ":") {
@@ -525,7 +931,7 @@ func getPortalConfig(ctx *cli.Context) (*Config, error) {
 		config.Protocol.ListenAddr = port
 	}
 
-	err := setPrivateKey(ctx, config)
+	err = setPrivateKey(ctx, config)
 	if err != nil {
 		return config, err
 	}
@@ -541,6 +947,22 @@ func getPortalConfig(ctx *cli.Context) (*Config, error) {
 
 	setPortalBootstrapNodes(ctx, config)
 	config.Networks = ctx.StringSlice(utils.PortalNetworksFlag.Name)
+
+	if checkpoint := ctx.String(utils.PortalBeaconCheckpointFlag.Name); checkpoint != "" {
+		root, err := hexutil.Decode(checkpoint)
+		if err != nil || len(root) != len(config.BeaconCheckpoint) {
+			return config, fmt.Errorf("invalid beacon checkpoint %q", checkpoint)
+		}
+		config.BeaconCheckpoint = common.Root(root)
+	}
+	config.BeaconCheckpointPeers = ctx.Int(utils.PortalBeaconCheckpointPeersFlag.Name)
+	config.FinalityUpdateTTL = ctx.Uint64(utils.PortalBeaconFinalityTTLFlag.Name)
+	config.OptimisticUpdateTTL = ctx.Uint64(utils.PortalBeaconOptimisticTTLFlag.Name)
+	config.EngineAPI = ctx.String(utils.PortalBeaconEngineAPIFlag.Name)
+	config.EngineJWTSecret = ctx.String(utils.PortalBeaconJWTSecretFlag.Name)
+	if config.EngineAPI != "" && config.EngineJWTSecret == "" {
+		return config, fmt.Errorf("--%s is required with --%s", utils.PortalBeaconJWTSecretFlag.Name, utils.PortalBeaconEngineAPIFlag.Name)
+	}
 	return config, nil
 }
 
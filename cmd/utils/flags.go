@@ -953,11 +953,55 @@ Please note that --` + MetricsHTTPFlag.Name + ` must be set to start the server.
 
 	PortalDataCapacityFlag = &cli.Uint64Flag{
 		Name:     "data.capacity",
-		Usage:    "The capacity of the data stored, the unit is MB",
+		Usage:    "The capacity of the data stored by all networks together, the unit is MB",
 		Value:    1000 * 10, // 10 GB
 		Category: flags.PortalNetworkCategory,
 	}
 
+	PortalHistoryCapacityFlag = &cli.Uint64Flag{
+		Name:     "history.capacity",
+		Usage:    "The most of data.capacity the history network storage may use, the unit is MB (0 = no cap)",
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalBeaconCapacityFlag = &cli.Uint64Flag{
+		Name:     "beacon.capacity",
+		Usage:    "The most of data.capacity the beacon network storage may use, the unit is MB (0 = no cap)",
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalStateCapacityFlag = &cli.Uint64Flag{
+		Name:     "state.capacity",
+		Usage:    "The most of data.capacity the state network storage may use, the unit is MB (0 = no cap)",
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalStorageWeightsFlag = &cli.StringFlag{
+		Name:     "storage.weights",
+		Usage:    "Weights splitting data.capacity between the networks, e.g. history=2,state=1 (default weight = 1)",
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalStorageCacheFlag = &cli.Uint64Flag{
+		Name:     "storage.cache",
+		Usage:    "Memory used to cache the content read from the history and state network storages, the unit is MB per network (0 = no cache)",
+		Value:    64,
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalStorageScrubFlag = &cli.BoolFlag{
+		Name:     "storage.scrub",
+		Usage:    "Revalidate the stored content in the background, invalid content is moved into the quarantine directory of the network",
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalStorageBackendFlag = &cli.StringFlag{
+		Name:     "storage.backend",
+		Usage:    "Backend of the history network content storage (sqlite|pebble)",
+		Value:    "sqlite",
+		Category: flags.PortalNetworkCategory,
+	}
+
 	PortalNATFlag = &cli.StringFlag{
 		Name:     "nat",
 		Usage:    "NAT port mapping mechanism (any|none|upnp|pmp|stun|pmp:<IP>|extip:<IP>|stun:<IP>)",
@@ -1010,6 +1054,44 @@ Please note that --` + MetricsHTTPFlag.Name + ` must be set to start the server.
 		Category: flags.PortalNetworkCategory,
 		Value:    cli.NewStringSlice(portalwire.History.Name()),
 	}
+
+	PortalBeaconCheckpointFlag = &cli.StringFlag{
+		Name:     "beacon.checkpoint",
+		Usage:    "Trusted block root to bootstrap the beacon light client from, used even if it is older than the weak subjectivity period",
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalBeaconCheckpointPeersFlag = &cli.IntFlag{
+		Name:     "beacon.checkpoint.peers",
+		Usage:    "Number of peers that must agree on the finalized block before it is used as a new beacon checkpoint (0 = disabled)",
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalBeaconFinalityTTLFlag = &cli.Uint64Flag{
+		Name:     "beacon.finality.ttl",
+		Usage:    "Number of slots after which a light client finality update is no longer served",
+		Value:    64,
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalBeaconOptimisticTTLFlag = &cli.Uint64Flag{
+		Name:     "beacon.optimistic.ttl",
+		Usage:    "Number of slots after which a light client optimistic update is no longer served",
+		Value:    32,
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalBeaconEngineAPIFlag = &cli.StringFlag{
+		Name:     "beacon.engine.api",
+		Usage:    "Engine API URL of an execution client to drive with the head of the beacon light client",
+		Category: flags.PortalNetworkCategory,
+	}
+
+	PortalBeaconJWTSecretFlag = &flags.DirectoryFlag{
+		Name:     "beacon.engine.jwtsecret",
+		Usage:    "Path to the JWT secret of the engine API",
+		Category: flags.PortalNetworkCategory,
+	}
 )
 
 var (
@@ -111,6 +111,10 @@ func NewPortalStorageMetrics(network string, db *sql.DB) (*PortalStorageMetrics,
 		countSql = strings.Replace(countEntrySql, "kvstore", "beacon", 1)
 		contentSql = strings.Replace(contentStorageUsageSql, "kvstore", "beacon", 1)
 		contentSql = strings.Replace(contentSql, "value", "content_value", 1)
+	} else if network == portalwire.State.Name() {
+		countSql = strings.Replace(countEntrySql, "kvstore", "state", 1)
+		contentSql = strings.Replace(contentStorageUsageSql, "kvstore", "state", 1)
+		contentSql = strings.Replace(contentSql, "length(value)", "size", 1)
 	} else {
 		countSql = countEntrySql
 		contentSql = contentStorageUsageSql
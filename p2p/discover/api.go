@@ -2,13 +2,20 @@ package discover
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/p2p/discover/portalwire"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
 	"github.com/holiman/uint256"
 )
 
+// maxLocalContentKeys is the most content keys returned by one LocalContentKeys
+// call.
+const maxLocalContentKeys = 1000
+
 // DiscV5API json-rpc spec
 // https://playground.open-rpc.org/?schemaUrl=https://raw.githubusercontent.com/ethereum/portal-network-specs/assembled-spec/jsonrpc/openrpc.json&uiSchema%5BappBar%5D%5Bui:splitView%5D=false&uiSchema%5BappBar%5D%5Bui:input%5D=false&uiSchema%5BappBar%5D%5Bui:examplesDropdown%5D=false
 type DiscV5API struct {
@@ -67,6 +74,27 @@ type Trace struct {
 	Cancelled    []string                 `json:"cancelled"`    // the node ids which are send but cancelled
 }
 
+type ContentTypeStats struct {
+	ContentType uint8  `json:"contentType"`
+	Count       uint64 `json:"count"`
+	Size        uint64 `json:"size"`
+}
+
+type StorageStats struct {
+	ContentTypes     []ContentTypeStats `json:"contentTypes"`
+	UnindexedCount   uint64             `json:"unindexedCount"` // content stored without its content key
+	UnindexedSize    uint64             `json:"unindexedSize"`
+	FarthestDistance string             `json:"farthestDistance,omitempty"` // unset if content is kept regardless of its distance
+	Radius           string             `json:"radius"`
+}
+
+type ContentProvenance struct {
+	Source            string `json:"source"`
+	NodeId            string `json:"nodeId,omitempty"` // the node the content was received from
+	Received          int64  `json:"received"`         // unix time in milliseconds
+	ValidationVersion uint64 `json:"validationVersion"`
+}
+
 type NodeMetadata struct {
 	Enr      string `json:"enr"`
 	Distance string `json:"distance"`
@@ -512,6 +540,91 @@ func (p *PortalProtocolAPI) LocalContent(contentKeyHex string) (string, error) {
 	return hexutil.Encode(content), nil
 }
 
+// LocalContentKeys returns a page of the content keys in the local storage,
+// only the keys of the content type if it is set.
+func (p *PortalProtocolAPI) LocalContentKeys(offset, limit uint64, contentType *uint8) ([]string, error) {
+	index, ok := p.portalProtocol.storage.(storage.ContentIndex)
+	if !ok {
+		return nil, errors.New("the storage doesn't keep content keys")
+	}
+	if limit == 0 || limit > maxLocalContentKeys {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxLocalContentKeys)
+	}
+	contentKeys, err := index.LocalContentKeys(offset, limit, contentType)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(contentKeys))
+	for _, contentKey := range contentKeys {
+		res = append(res, hexutil.Encode(contentKey))
+	}
+	return res, nil
+}
+
+// StorageStats returns the number and size of the stored content by content
+// type.
+func (p *PortalProtocolAPI) StorageStats() (*StorageStats, error) {
+	index, ok := p.portalProtocol.storage.(storage.ContentIndex)
+	if !ok {
+		return nil, errors.New("the storage doesn't keep content keys")
+	}
+	stats, err := index.Stats()
+	if err != nil {
+		return nil, err
+	}
+	res := &StorageStats{
+		ContentTypes:   make([]ContentTypeStats, 0, len(stats.ContentTypes)),
+		UnindexedCount: stats.Unindexed.Count,
+		UnindexedSize:  stats.Unindexed.Size,
+		Radius:         stats.Radius.Hex(),
+	}
+	if stats.FarthestDistance != nil {
+		res.FarthestDistance = stats.FarthestDistance.Hex()
+	}
+	for contentType, typeStats := range stats.ContentTypes {
+		res.ContentTypes = append(res.ContentTypes, ContentTypeStats{
+			ContentType: contentType,
+			Count:       typeStats.Count,
+			Size:        typeStats.Size,
+		})
+	}
+	slices.SortFunc(res.ContentTypes, func(a, b ContentTypeStats) int {
+		return int(a.ContentType) - int(b.ContentType)
+	})
+	return res, nil
+}
+
+// ContentProvenance returns where the stored content came from.
+func (p *PortalProtocolAPI) ContentProvenance(contentKeyHex string) (*ContentProvenance, error) {
+	contentKey, err := hexutil.Decode(contentKeyHex)
+	if err != nil {
+		return nil, err
+	}
+	provenance, err := p.portalProtocol.ContentProvenance(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	res := &ContentProvenance{
+		Source:            string(provenance.Source),
+		Received:          provenance.Received.UnixMilli(),
+		ValidationVersion: provenance.ValidationVersion,
+	}
+	if provenance.Source != storage.SourceLocal {
+		res.NodeId = "0x" + provenance.Node.String()
+	}
+	return res, nil
+}
+
+// PurgeContentFrom deletes all stored content received from the node and
+// returns the number of purged items.
+func (p *PortalProtocolAPI) PurgeContentFrom(nodeId string) (int, error) {
+	id, err := enode.ParseID(nodeId)
+	if err != nil {
+		return 0, err
+	}
+	return p.portalProtocol.PurgeContentFrom(id)
+}
+
 func (p *PortalProtocolAPI) Store(contentKeyHex string, contextHex string) (bool, error) {
 	contentKey, err := hexutil.Decode(contentKeyHex)
 	if err != nil {
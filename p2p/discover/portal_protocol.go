@@ -75,6 +75,15 @@ const (
 	// And then there are still limits to be applied also for FindContent and the
 	// incoming directions.
 	concurrentOffers = 50
+
+	// Number of content keys purged at once by PurgeContentFrom.
+	purgeBatchSize = 1000
+
+	// The storage can be pruned without a put, when its capacity shrinks, so
+	// the radius is also checked periodically to prune the provenance.
+	provenancePruneInterval = time.Minute
+
+	drainPollInterval = 100 * time.Millisecond
 )
 
 const (
@@ -100,6 +109,15 @@ type ContentElement struct {
 	Node        enode.ID
 	ContentKeys [][]byte
 	Contents    [][]byte
+	done        func()
+}
+
+// Done tells the protocol that the network has processed the offered content,
+// a draining protocol waits for the received content to be processed.
+func (c *ContentElement) Done() {
+	if c.done != nil {
+		c.done()
+	}
 }
 
 type ContentEntry struct {
@@ -128,6 +146,8 @@ type OfferRequestWithNode struct {
 type ContentInfoResp struct {
 	Content     []byte
 	UtpTransfer bool
+	// Node is the node that returned the content.
+	Node enode.ID
 }
 
 type traceContentInfoResp struct {
@@ -139,6 +159,14 @@ type traceContentInfoResp struct {
 
 type PortalProtocolOption func(p *PortalProtocol)
 
+// WithProvenance records the provenance of the stored content, the protocol
+// closes the store when it stops.
+func WithProvenance(provenance *storage.ProvenanceStore) PortalProtocolOption {
+	return func(p *PortalProtocol) {
+		p.provenance = provenance
+	}
+}
+
 type PortalProtocolConfig struct {
 	BootstrapNodes []*enode.Node
 	// NodeIP          net.IP
@@ -184,10 +212,19 @@ type PortalProtocol struct {
 	closeCtx       context.Context
 	cancelCloseCtx context.CancelFunc
 	storage        storage.ContentStorage
+	provenance     *storage.ProvenanceStore
 	toContentId    func(contentKey []byte) []byte
 
+	// provenanceRadius is the radius the provenance was last pruned to
+	provenanceLock   sync.Mutex
+	provenanceRadius *uint256.Int
+
 	contentQueue chan *ContentElement
 	offerQueue   chan *OfferRequestWithNode
+	// draining declines new offers and sends no more offers, inFlight counts
+	// the uTP transfers and the received content the network hasn't processed
+	draining atomic.Bool
+	inFlight atomic.Int64
 
 	portMappingRegister chan *portMapping
 	clock               mclock.Clock
@@ -260,6 +297,9 @@ func (p *PortalProtocol) Start() error {
 	for i := 0; i < concurrentOffers; i++ {
 		go p.offerWorker()
 	}
+	if p.provenance != nil {
+		go p.provenanceLoop()
+	}
 
 	// wait for both initialization processes to complete
 	<-p.DiscV5.tab.initDone
@@ -274,6 +314,33 @@ func (p *PortalProtocol) Stop() {
 	if p.Utp != nil {
 		p.Utp.Stop()
 	}
+	if p.provenance != nil {
+		if err := p.provenance.Close(); err != nil {
+			p.Log.Error("failed to close the provenance store", "err", err)
+		}
+	}
+	if closer, ok := p.storage.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			p.Log.Error("failed to close the storage", "err", err)
+		}
+	}
+}
+
+// Drain stops accepting and sending offers and waits until the uTP transfers
+// in flight are done and the network has processed the received content, or
+// until ctx is done.
+func (p *PortalProtocol) Drain(ctx context.Context) error {
+	p.draining.Store(true)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for p.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d transfers in flight: %w", p.inFlight.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 func (p *PortalProtocol) RoutingTableInfo() [][]string {
 	p.table.mutex.Lock()
@@ -538,9 +605,11 @@ func (p *PortalProtocol) processOffer(target *enode.Node, resp []byte, request *
 	}
 
 	connId := binary.BigEndian.Uint16(accept.ConnectionId[:])
+	p.inFlight.Add(1)
 	go func(ctx context.Context) {
 		var conn net.Conn
 		defer func() {
+			p.inFlight.Add(-1)
 			if conn == nil {
 				return
 			}
@@ -1091,11 +1160,13 @@ func (p *PortalProtocol) handleFindContent(id enode.ID, addr *net.UDPAddr, reque
 	} else {
 		connectionId := p.connIdGen.GenCid(id, false)
 
+		p.inFlight.Add(1)
 		go func(bctx context.Context, connId *libutp.ConnId) {
 			var conn *utp.Conn
 			var connectCtx context.Context
 			var cancel context.CancelFunc
 			defer func() {
+				p.inFlight.Add(-1)
 				p.connIdGen.Remove(connectionId)
 				if conn == nil {
 					return
@@ -1181,7 +1252,7 @@ func (p *PortalProtocol) handleFindContent(id enode.ID, addr *net.UDPAddr, reque
 func (p *PortalProtocol) handleOffer(id enode.ID, addr *net.UDPAddr, request *portalwire.Offer) ([]byte, error) {
 	var err error
 	contentKeyBitlist := bitfield.NewBitlist(uint64(len(request.ContentKeys)))
-	if len(p.contentQueue) >= cap(p.contentQueue) {
+	if p.draining.Load() || len(p.contentQueue) >= cap(p.contentQueue) {
 		acceptMsg := &portalwire.Accept{
 			ConnectionId: []byte{0, 0},
 			ContentKeys:  contentKeyBitlist,
@@ -1223,11 +1294,13 @@ func (p *PortalProtocol) handleOffer(id enode.ID, addr *net.UDPAddr, request *po
 	if contentKeyBitlist.Count() != 0 {
 		connectionId := p.connIdGen.GenCid(id, false)
 
+		p.inFlight.Add(1)
 		go func(bctx context.Context, connId *libutp.ConnId) {
 			var conn *utp.Conn
 			var connectCtx context.Context
 			var cancel context.CancelFunc
 			defer func() {
+				p.inFlight.Add(-1)
 				p.connIdGen.Remove(connectionId)
 				if conn == nil {
 					return
@@ -1336,10 +1409,16 @@ func (p *PortalProtocol) handleOfferedContents(id enode.ID, keys [][]byte, paylo
 		return fmt.Errorf("content keys len %d doesn't match content values len %d", keyLen, contentLen)
 	}
 
+	// the content stays in flight until the network has processed it
+	p.inFlight.Add(1)
+	var once sync.Once
 	contentElement := &ContentElement{
 		Node:        id,
 		ContentKeys: keys,
 		Contents:    contents,
+		done: func() {
+			once.Do(func() { p.inFlight.Add(-1) })
+		},
 	}
 
 	p.contentQueue <- contentElement
@@ -1449,6 +1528,9 @@ func (p *PortalProtocol) offerWorker() {
 		case <-p.closeCtx.Done():
 			return
 		case offerRequestWithNode := <-p.offerQueue:
+			if p.draining.Load() {
+				continue
+			}
 			p.Log.Trace("offerWorker", "offerRequestWithNode", offerRequestWithNode)
 			_, err := p.offer(offerRequestWithNode.Node, offerRequestWithNode.Request)
 			if err != nil {
@@ -1478,6 +1560,29 @@ func (p *PortalProtocol) truncateNodes(nodes []*enode.Node, maxSize int, enrOver
 	return res
 }
 
+// NodeList returns the nodes of the routing table.
+func (p *PortalProtocol) NodeList() []*enode.Node {
+	return p.table.NodeList()
+}
+
+// FindContentFrom asks a single node for the content of contentKey. It
+// returns ContentNotFound if the node answers with closer nodes instead.
+func (p *PortalProtocol) FindContentFrom(node *enode.Node, contentKey []byte) ([]byte, error) {
+	flag, content, err := p.findContent(node, contentKey)
+	if err != nil {
+		return nil, err
+	}
+	switch flag {
+	case portalwire.ContentRawSelector, portalwire.ContentConnIdSelector:
+		res, ok := content.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("failed to assert to raw content, value is: %v", content)
+		}
+		return res, nil
+	}
+	return nil, ContentNotFound
+}
+
 func (p *PortalProtocol) findNodesCloseToContent(contentId []byte, limit int) []*enode.Node {
 	allNodes := p.table.NodeList()
 	sort.Slice(allNodes, func(i, j int) bool {
@@ -1580,6 +1685,16 @@ func (p *PortalProtocol) collectTableNodes(rip net.IP, distances []uint, limit i
 }
 
 func (p *PortalProtocol) ContentLookup(contentKey, contentId []byte) ([]byte, bool, error) {
+	res, err := p.LookupContent(contentKey, contentId)
+	if err != nil {
+		return nil, false, err
+	}
+	return res.Content, res.UtpTransfer, nil
+}
+
+// LookupContent looks up the content like ContentLookup and also returns the
+// node that returned it.
+func (p *PortalProtocol) LookupContent(contentKey, contentId []byte) (*ContentInfoResp, error) {
 	lookupContext, cancel := context.WithCancel(context.Background())
 
 	resChan := make(chan *traceContentInfoResp, alpha)
@@ -1596,6 +1711,7 @@ func (p *PortalProtocol) ContentLookup(contentKey, contentId []byte) ([]byte, bo
 			if res.Flag != portalwire.ContentEnrsSelector {
 				result.Content = res.Content.([]byte)
 				result.UtpTransfer = res.UtpTransfer
+				result.Node = res.Node.ID()
 			}
 		}
 	}()
@@ -1607,10 +1723,10 @@ func (p *PortalProtocol) ContentLookup(contentKey, contentId []byte) ([]byte, bo
 
 	wg.Wait()
 	if hasResult == 1 {
-		return result.Content, result.UtpTransfer, nil
+		return &result, nil
 	}
 	defer cancel()
-	return nil, false, ContentNotFound
+	return nil, ContentNotFound
 }
 
 func (p *PortalProtocol) TraceContentLookup(contentKey, contentId []byte) (*TraceContentResult, error) {
@@ -1772,12 +1888,105 @@ func (p *PortalProtocol) Get(contentKey []byte, contentId []byte) ([]byte, error
 	return content, err
 }
 
+// Put stores content of the node itself.
 func (p *PortalProtocol) Put(contentKey []byte, contentId []byte, content []byte) error {
+	return p.PutFrom(contentKey, contentId, content, storage.LocalProvenance())
+}
+
+// PutFrom stores content and records its provenance.
+func (p *PortalProtocol) PutFrom(contentKey []byte, contentId []byte, content []byte, provenance storage.Provenance) error {
 	err := p.storage.Put(contentKey, contentId, content)
-	p.Log.Trace("put local storage", "contentId", hexutil.Encode(contentId), "content", hexutil.Encode(content), "err", err)
+	p.Log.Trace("put local storage", "contentId", hexutil.Encode(contentId), "content", hexutil.Encode(content), "source", provenance.Source, "err", err)
+	if err == nil {
+		p.recordProvenance(contentKey, contentId, provenance)
+	}
 	return err
 }
 
+// ContentProvenance returns the provenance of the stored content.
+func (p *PortalProtocol) ContentProvenance(contentKey []byte) (*storage.Provenance, error) {
+	if p.provenance == nil {
+		return nil, errors.New("the provenance of the content isn't recorded")
+	}
+	provenance, err := p.provenance.Get(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = p.storage.Get(contentKey, p.toContentId(contentKey)); err != nil {
+		return nil, err
+	}
+	return provenance, nil
+}
+
+// PurgeContentFrom deletes all stored content received from the node and
+// returns the number of purged items, including the items already pruned.
+func (p *PortalProtocol) PurgeContentFrom(node enode.ID) (int, error) {
+	if p.provenance == nil {
+		return 0, errors.New("the provenance of the content isn't recorded")
+	}
+	deleter, ok := p.storage.(storage.ContentDeleter)
+	if !ok {
+		return 0, errors.New("the storage can't delete content")
+	}
+	var purged int
+	for {
+		contentKeys, err := p.provenance.ContentKeysFrom(node, purgeBatchSize)
+		if err != nil || len(contentKeys) == 0 {
+			return purged, err
+		}
+		for _, contentKey := range contentKeys {
+			if err = deleter.Delete(contentKey, p.toContentId(contentKey)); err != nil {
+				return purged, err
+			}
+			if err = p.provenance.Delete(contentKey); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		p.Log.Debug("purged content", "node", node, "count", purged)
+	}
+}
+
+func (p *PortalProtocol) recordProvenance(contentKey []byte, contentId []byte, provenance storage.Provenance) {
+	if p.provenance == nil {
+		return
+	}
+	if err := p.provenance.Put(contentKey, contentId, provenance); err != nil {
+		p.Log.Error("failed to record the provenance of content", "contentKey", hexutil.Encode(contentKey), "err", err)
+	}
+	// storing the content may have pruned the storage
+	p.pruneProvenance()
+}
+
+func (p *PortalProtocol) provenanceLoop() {
+	ticker := time.NewTicker(provenancePruneInterval)
+	defer ticker.Stop()
+	for {
+		p.pruneProvenance()
+		select {
+		case <-p.closeCtx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneProvenance drops the provenance of the content the storage pruned,
+// the content beyond the radius, once the radius shrank.
+func (p *PortalProtocol) pruneProvenance() {
+	radius := p.storage.Radius()
+	p.provenanceLock.Lock()
+	defer p.provenanceLock.Unlock()
+	if p.provenanceRadius != nil && radius.Cmp(p.provenanceRadius) >= 0 {
+		return
+	}
+	if err := p.provenance.Prune(radius); err != nil {
+		p.Log.Error("failed to prune the provenance of content", "radius", radius.Hex(), "err", err)
+		return
+	}
+	p.provenanceRadius = new(uint256.Int).Set(radius)
+}
+
 func (p *PortalProtocol) GetContent() chan *ContentElement {
 	return p.contentQueue
 }
@@ -1867,7 +2076,7 @@ func (p *PortalProtocol) ShouldStore(contentKey []byte, content []byte) (bool, e
 		return false, nil
 	}
 
-	err := p.storage.Put(contentKey, p.toContentId(contentKey), content)
+	err := p.Put(contentKey, p.toContentId(contentKey), content)
 	if err != nil {
 		return false, err
 	}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/holiman/uint256"
 	"github.com/optimism-java/utp-go"
 	"github.com/optimism-java/utp-go/libutp"
 	"github.com/prysmaticlabs/go-bitfield"
@@ -426,6 +427,10 @@ func TestContentLookup(t *testing.T) {
 	res, _, err := node1.ContentLookup(contentKey, contentId)
 	assert.NoError(t, err)
 	assert.Equal(t, res, content)
+	found, err := node1.LookupContent(contentKey, contentId)
+	assert.NoError(t, err)
+	assert.Equal(t, content, found.Content)
+	assert.Equal(t, node3.Self().ID(), found.Node)
 
 	nonExist := []byte{0x2, 0x4}
 	res, _, err = node1.ContentLookup(nonExist, node1.toContentId(nonExist))
@@ -433,6 +438,113 @@ func TestContentLookup(t *testing.T) {
 	assert.Nil(t, res)
 }
 
+// prunedStorage is a mock storage whose radius can shrink.
+type prunedStorage struct {
+	*storage.MockStorage
+	radius *uint256.Int
+}
+
+func (s *prunedStorage) Radius() *uint256.Int {
+	return s.radius
+}
+
+func TestPurgeContentFrom(t *testing.T) {
+	key := newkey()
+	provenance, err := storage.OpenProvenanceStore(t.TempDir(), "history", enode.PubkeyToIDV4(&key.PublicKey))
+	assert.NoError(t, err)
+	defer provenance.Close()
+	contentStorage := &prunedStorage{MockStorage: &storage.MockStorage{Db: make(map[string][]byte)}, radius: storage.MaxDistance}
+	p, err := NewPortalProtocol(DefaultPortalProtocolConfig(), portalwire.History, key, nil, nil, nil, nil, contentStorage, nil, WithProvenance(provenance))
+	assert.NoError(t, err)
+
+	bad, good := enode.ID{0x1}, enode.ID{0x2}
+	puts := []struct {
+		contentKey []byte
+		provenance storage.Provenance
+	}{
+		{[]byte{0x0, 0x1}, storage.Provenance{Source: storage.SourceOffer, Node: bad, ValidationVersion: 1}},
+		{[]byte{0x0, 0x2}, storage.Provenance{Source: storage.SourceLookup, Node: bad, ValidationVersion: 1}},
+		{[]byte{0x0, 0x3}, storage.Provenance{Source: storage.SourceOffer, Node: good, ValidationVersion: 1}},
+	}
+	for _, put := range puts {
+		assert.NoError(t, p.PutFrom(put.contentKey, p.toContentId(put.contentKey), []byte{0xff}, put.provenance))
+	}
+	local := []byte{0x0, 0x4}
+	assert.NoError(t, p.Put(local, p.toContentId(local), []byte{0xff}))
+
+	res, err := p.ContentProvenance(puts[1].contentKey)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.SourceLookup, res.Source)
+	assert.Equal(t, bad, res.Node)
+	res, err = p.ContentProvenance(local)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.SourceLocal, res.Source)
+
+	purged, err := p.PurgeContentFrom(bad)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	for _, put := range puts[:2] {
+		_, err = p.Get(put.contentKey, p.toContentId(put.contentKey))
+		assert.ErrorIs(t, err, storage.ErrContentNotFound)
+		_, err = p.ContentProvenance(put.contentKey)
+		assert.ErrorIs(t, err, storage.ErrContentNotFound)
+	}
+	_, err = p.Get(puts[2].contentKey, p.toContentId(puts[2].contentKey))
+	assert.NoError(t, err)
+	_, err = p.Get(local, p.toContentId(local))
+	assert.NoError(t, err)
+
+	// looking up the provenance of content no longer stored keeps it
+	delete(contentStorage.Db, string(p.toContentId(puts[2].contentKey)))
+	_, err = p.ContentProvenance(puts[2].contentKey)
+	assert.ErrorIs(t, err, storage.ErrContentNotFound)
+	_, err = provenance.Get(puts[2].contentKey)
+	assert.NoError(t, err)
+
+	// the provenance of pruned content is dropped once the radius shrinks
+	contentStorage.radius = uint256.NewInt(0)
+	p.pruneProvenance()
+	for _, contentKey := range [][]byte{puts[2].contentKey, local} {
+		_, err = provenance.Get(contentKey)
+		assert.ErrorIs(t, err, storage.ErrContentNotFound)
+	}
+}
+
+func TestDrainAcceptedOffer(t *testing.T) {
+	node, err := setupLocalPortalNode(":17797", nil)
+	assert.NoError(t, err)
+	node.Log = testlog.Logger(t, log.LvlTrace)
+	err = node.Start()
+	assert.NoError(t, err)
+	defer node.Stop()
+
+	remote := newkey()
+	offer := &portalwire.Offer{ContentKeys: [][]byte{{0x1, 0x2}}}
+	_, err = node.handleOffer(enode.PubkeyToIDV4(&remote.PublicKey), &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 17798}, offer)
+	assert.NoError(t, err)
+
+	// the accepted transfer is in flight until the uTP connection is done
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = node.Drain(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// offers are declined while draining
+	resp, err := node.handleOffer(enode.PubkeyToIDV4(&remote.PublicKey), &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 17798}, offer)
+	assert.NoError(t, err)
+	accept := &portalwire.Accept{}
+	err = accept.UnmarshalSSZ(resp[1:])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0}, accept.ConnectionId)
+
+	// the transfer is given up once the protocol closes
+	node.cancelCloseCtx()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = node.Drain(ctx)
+	assert.NoError(t, err)
+}
+
 func TestTraceContentLookup(t *testing.T) {
 	node1, err := setupLocalPortalNode(":17787", nil)
 	assert.NoError(t, err)
@@ -1,11 +1,38 @@
 package beacon
 
 import (
+	"time"
+
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/tree"
 )
 
 type API struct {
 	*discover.PortalProtocolAPI
+	beaconNetwork *BeaconNetwork
+}
+
+// LightClientHeader is a header of the light client store together with the
+// execution block it commits to, if known.
+type LightClientHeader struct {
+	Root                 common.Root              `json:"root"`
+	Beacon               common.BeaconBlockHeader `json:"beacon"`
+	ExecutionBlockHash   *common.Hash32           `json:"executionBlockHash,omitempty"`
+	ExecutionBlockNumber *uint64                  `json:"executionBlockNumber,omitempty"`
+}
+
+// LightClientSyncStatus describes how far the light client has synced.
+type LightClientSyncStatus struct {
+	Synced             bool   `json:"synced"`
+	CurrentPeriod      uint64 `json:"currentPeriod"`
+	ExpectedPeriod     uint64 `json:"expectedPeriod"`
+	NextCommitteeKnown bool   `json:"nextCommitteeKnown"`
+	FinalizedSlot      uint64 `json:"finalizedSlot"`
+	OptimisticSlot     uint64 `json:"optimisticSlot"`
+	// LastUpdateAge is the number of seconds since the slot of the optimistic
+	// header, the latest update the light client applied.
+	LastUpdateAge uint64 `json:"lastUpdateAge"`
 }
 
 func (p *API) BeaconRoutingTableInfo() *discover.RoutingTableInfo {
@@ -56,6 +83,22 @@ func (p *API) BeaconLocalContent(contentKeyHex string) (string, error) {
 	return p.LocalContent(contentKeyHex)
 }
 
+func (p *API) BeaconLocalContentKeys(offset, limit uint64, contentType *uint8) ([]string, error) {
+	return p.LocalContentKeys(offset, limit, contentType)
+}
+
+func (p *API) BeaconStorageStats() (*discover.StorageStats, error) {
+	return p.StorageStats()
+}
+
+func (p *API) BeaconContentProvenance(contentKeyHex string) (*discover.ContentProvenance, error) {
+	return p.ContentProvenance(contentKeyHex)
+}
+
+func (p *API) BeaconPurgeContentFrom(nodeId string) (int, error) {
+	return p.PurgeContentFrom(nodeId)
+}
+
 func (p *API) BeaconStore(contentKeyHex string, contextHex string) (bool, error) {
 	return p.Store(contentKeyHex, contextHex)
 }
@@ -73,8 +116,75 @@ func (p *API) BeaconTraceGetContent(contentKeyHex string) (*discover.TraceConten
 	return p.TraceRecursiveFindContent(contentKeyHex)
 }
 
-func NewBeaconNetworkAPI(BeaconAPI *discover.PortalProtocolAPI) *API {
+// BeaconFinalizedHeader returns the finalized header of the light client.
+func (p *API) BeaconFinalizedHeader() (*LightClientHeader, error) {
+	lightClient, err := p.beaconNetwork.lightClientSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return newLightClientHeader(lightClient.Store.FinalizedHeader, lightClient.Store.FinalizedExecution), nil
+}
+
+// BeaconOptimisticHeader returns the optimistic header of the light client.
+func (p *API) BeaconOptimisticHeader() (*LightClientHeader, error) {
+	lightClient, err := p.beaconNetwork.lightClientSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return newLightClientHeader(lightClient.Store.OptimisticHeader, lightClient.Store.OptimisticExecution), nil
+}
+
+// BeaconFinalizedStateRoot returns the state root of the finalized header.
+func (p *API) BeaconFinalizedStateRoot() (common.Root, error) {
+	lightClient, err := p.beaconNetwork.lightClientSnapshot()
+	if err != nil {
+		return common.Root{}, err
+	}
+	return lightClient.Store.FinalizedHeader.StateRoot, nil
+}
+
+// BeaconSyncStatus returns the sync status of the light client. A light client
+// that has not bootstrapped yet is reported as not synced.
+func (p *API) BeaconSyncStatus() *LightClientSyncStatus {
+	spec := p.beaconNetwork.spec
+	currentSlot := spec.TimeToSlot(common.Timestamp(time.Now().Unix()), common.Timestamp(BeaconGenesisTime))
+	status := &LightClientSyncStatus{
+		ExpectedPeriod: CalcSyncPeriod(uint64(currentSlot)),
+	}
+	lightClient, err := p.beaconNetwork.lightClientSnapshot()
+	if err != nil {
+		return status
+	}
+	store := lightClient.Store
+	status.Synced = true
+	status.CurrentPeriod = CalcSyncPeriod(uint64(store.FinalizedHeader.Slot))
+	status.NextCommitteeKnown = store.NextSyncCommittee != nil
+	status.FinalizedSlot = uint64(store.FinalizedHeader.Slot)
+	if store.OptimisticHeader != nil {
+		status.OptimisticSlot = uint64(store.OptimisticHeader.Slot)
+	}
+	updateTime := BeaconGenesisTime + status.OptimisticSlot*uint64(spec.SECONDS_PER_SLOT)
+	if now := uint64(time.Now().Unix()); now > updateTime {
+		status.LastUpdateAge = now - updateTime
+	}
+	return status
+}
+
+func newLightClientHeader(header *common.BeaconBlockHeader, execution *GenericExecution) *LightClientHeader {
+	res := &LightClientHeader{
+		Root:   header.HashTreeRoot(tree.GetHashFn()),
+		Beacon: *header,
+	}
+	if execution != nil && execution.BlockHash != (common.Hash32{}) {
+		res.ExecutionBlockHash = &execution.BlockHash
+		res.ExecutionBlockNumber = &execution.BlockNumber
+	}
+	return res
+}
+
+func NewBeaconNetworkAPI(BeaconAPI *discover.PortalProtocolAPI, beaconNetwork *BeaconNetwork) *API {
 	return &API{
 		BeaconAPI,
+		beaconNetwork,
 	}
 }
@@ -0,0 +1,50 @@
+package beacon
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLightClientAPI(t *testing.T) {
+	bn := NewBeaconNetwork(nil)
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("portal", NewBeaconNetworkAPI(nil, bn)))
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	var status LightClientSyncStatus
+	require.NoError(t, client.Call(&status, "portal_beaconSyncStatus"))
+	require.False(t, status.Synced)
+	require.NotZero(t, status.ExpectedPeriod)
+	var header LightClientHeader
+	require.ErrorContains(t, client.Call(&header, "portal_beaconFinalizedHeader"), ErrLightClientNotSynced.Error())
+
+	lightClient, err := getClient(false, t)
+	require.NoError(t, err)
+	bn.SetLightClient(lightClient)
+	store := lightClient.Store
+
+	require.NoError(t, client.Call(&header, "portal_beaconFinalizedHeader"))
+	require.Equal(t, *store.FinalizedHeader, header.Beacon)
+	require.Equal(t, store.FinalizedExecution.BlockHash, *header.ExecutionBlockHash)
+	var stateRoot common.Root
+	require.NoError(t, client.Call(&stateRoot, "portal_beaconFinalizedStateRoot"))
+	require.Equal(t, store.FinalizedHeader.StateRoot, stateRoot)
+	require.NoError(t, client.Call(&status, "portal_beaconSyncStatus"))
+	require.True(t, status.Synced)
+	require.False(t, status.NextCommitteeKnown)
+	require.Equal(t, CalcSyncPeriod(uint64(store.FinalizedHeader.Slot)), status.CurrentPeriod)
+	require.NotZero(t, status.LastUpdateAge)
+
+	// the answers follow the light client as it advances
+	require.NoError(t, lightClient.Sync())
+	bn.SetLightClient(lightClient)
+	require.NoError(t, client.Call(&header, "portal_beaconOptimisticHeader"))
+	require.Equal(t, *lightClient.Store.OptimisticHeader, header.Beacon)
+	require.NotEqual(t, *store.OptimisticHeader, header.Beacon)
+	require.NoError(t, client.Call(&status, "portal_beaconSyncStatus"))
+	require.Equal(t, uint64(lightClient.Store.OptimisticHeader.Slot), status.OptimisticSlot)
+}
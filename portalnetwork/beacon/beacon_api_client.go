@@ -0,0 +1,164 @@
+package beacon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+const beaconAPITimeout = 30 * time.Second
+
+// BeaconAPIClient fetches light client data from the REST API of a beacon
+// node. All objects are requested SSZ encoded and returned wrapped in their
+// Forked* type.
+type BeaconAPIClient struct {
+	url    string
+	client *http.Client
+	spec   *common.Spec
+}
+
+func NewBeaconAPIClient(url string, spec *common.Spec) *BeaconAPIClient {
+	return &BeaconAPIClient{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: beaconAPITimeout},
+		spec:   spec,
+	}
+}
+
+func (c *BeaconAPIClient) GetBootstrap(blockRoot tree.Root) (*ForkedLightClientBootstrap, error) {
+	data, err := c.getForked(LightClientRestPath + "bootstrap/" + blockRoot.String())
+	if err != nil {
+		return nil, err
+	}
+	bootstrap := &ForkedLightClientBootstrap{}
+	err = bootstrap.Deserialize(c.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))))
+	return bootstrap, err
+}
+
+func (c *BeaconAPIClient) GetUpdates(startPeriod, count uint64) (LightClientUpdateRange, error) {
+	query := url.Values{}
+	query.Set("start_period", strconv.FormatUint(startPeriod, 10))
+	query.Set("count", strconv.FormatUint(count, 10))
+	data, _, err := c.get(LightClientRestPath + "updates?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	// The response is a sequence of chunks, each prefixed with the length of
+	// its fork digest and SSZ payload. The fork digest followed by the payload
+	// is exactly the encoding of ForkedLightClientUpdate.
+	updates := make(LightClientUpdateRange, 0, count)
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated light client update chunk")
+		}
+		length := binary.LittleEndian.Uint64(data[:8])
+		data = data[8:]
+		if length < 4 || uint64(len(data)) < length {
+			return nil, fmt.Errorf("invalid light client update chunk length %d", length)
+		}
+		var update ForkedLightClientUpdate
+		err = update.Deserialize(c.spec, codec.NewDecodingReader(bytes.NewReader(data[:length]), length))
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, update)
+		data = data[length:]
+	}
+	return updates, nil
+}
+
+func (c *BeaconAPIClient) GetFinalityUpdate() (*ForkedLightClientFinalityUpdate, error) {
+	data, err := c.getForked(LightClientRestPath + "finality_update")
+	if err != nil {
+		return nil, err
+	}
+	update := &ForkedLightClientFinalityUpdate{}
+	err = update.Deserialize(c.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))))
+	return update, err
+}
+
+func (c *BeaconAPIClient) GetOptimisticUpdate() (*ForkedLightClientOptimisticUpdate, error) {
+	data, err := c.getForked(LightClientRestPath + "optimistic_update")
+	if err != nil {
+		return nil, err
+	}
+	update := &ForkedLightClientOptimisticUpdate{}
+	err = update.Deserialize(c.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))))
+	return update, err
+}
+
+// GetFinalizedState returns the finalized beacon state along with the digest
+// of the fork it belongs to. The state is a *deneb.BeaconState or an
+// *ElectraBeaconState, earlier forks have no historical summaries.
+func (c *BeaconAPIClient) GetFinalizedState() (common.SpecObj, common.ForkDigest, error) {
+	data, version, err := c.get("/eth/v2/debug/beacon/states/finalized")
+	if err != nil {
+		return nil, common.ForkDigest{}, err
+	}
+	digest, err := forkDigest(version)
+	if err != nil {
+		return nil, common.ForkDigest{}, err
+	}
+	var state common.SpecObj
+	switch digest {
+	case Deneb:
+		state = &deneb.BeaconState{}
+	case Electra:
+		state = &ElectraBeaconState{}
+	default:
+		return nil, common.ForkDigest{}, fmt.Errorf("unsupported beacon state fork %s", version)
+	}
+	err = state.Deserialize(c.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))))
+	return state, digest, err
+}
+
+// getForked fetches a single versioned object and prefixes it with the digest
+// of the fork named in the Eth-Consensus-Version header.
+func (c *BeaconAPIClient) getForked(path string) ([]byte, error) {
+	data, version, err := c.get(path)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := forkDigest(version)
+	if err != nil {
+		return nil, err
+	}
+	return append(digest[:], data...), nil
+}
+
+func (c *BeaconAPIClient) get(path string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url+path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", sszContentType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, resp.Header.Get(consensusVersionHeader), nil
+	case http.StatusNotFound:
+		return nil, "", storage.ErrContentNotFound
+	default:
+		return nil, "", fmt.Errorf("beacon api %s: %s: %s", path, resp.Status, bytes.TrimSpace(data))
+	}
+}
@@ -5,13 +5,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/portalnetwork/storage"
 	ssz "github.com/ferranbt/fastssz"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/protolambda/zrnt/eth2/configs"
 	"github.com/protolambda/zrnt/eth2/util/merkle"
@@ -27,13 +30,21 @@ const (
 	HistoricalSummaries         storage.ContentType = 0x14
 )
 
+var (
+	ErrLightClientNotSynced             = errors.New("light client is not synced, content can not be verified yet")
+	ErrInvalidCurrentSyncCommitteeProof = errors.New("invalid current sync committee proof")
+)
+
 type BeaconNetwork struct {
-	portalProtocol *discover.PortalProtocol
-	spec           *common.Spec
-	log            log.Logger
-	closeCtx       context.Context
-	closeFunc      context.CancelFunc
-	lightClient    *ConsensusLightClient
+	portalProtocol  *discover.PortalProtocol
+	scrubber        *storage.Scrubber
+	spec            *common.Spec
+	forks           *ForkSchedule
+	log             log.Logger
+	closeCtx        context.Context
+	closeFunc       context.CancelFunc
+	lightClient     *ConsensusLightClient
+	lightClientLock sync.RWMutex
 }
 
 func NewBeaconNetwork(portalProtocol *discover.PortalProtocol) *BeaconNetwork {
@@ -42,6 +53,7 @@ func NewBeaconNetwork(portalProtocol *discover.PortalProtocol) *BeaconNetwork {
 	return &BeaconNetwork{
 		portalProtocol: portalProtocol,
 		spec:           configs.Mainnet,
+		forks:          MainnetForks,
 		closeCtx:       ctx,
 		closeFunc:      cancel,
 		log:            log.New("sub-protocol", "beacon"),
@@ -60,10 +72,123 @@ func (bn *BeaconNetwork) Start() error {
 
 func (bn *BeaconNetwork) Stop() {
 	bn.closeFunc()
+	if bn.scrubber != nil {
+		bn.scrubber.Stop()
+	}
 	bn.portalProtocol.Stop()
 }
 
+// StartScrubber scrubs the storage in the background until the network stops.
+func (bn *BeaconNetwork) StartScrubber(scrubber *storage.Scrubber) {
+	bn.scrubber = scrubber
+	scrubber.Start()
+}
+
+// Drain stops accepting offered content and waits until the transfers in
+// flight are done and the received content is validated, or until ctx is done.
+func (bn *BeaconNetwork) Drain(ctx context.Context) error {
+	return bn.portalProtocol.Drain(ctx)
+}
+
+// SetLightClient sets the light client whose store the gossiped updates are
+// verified against.
+func (bn *BeaconNetwork) SetLightClient(lightClient *ConsensusLightClient) {
+	bn.lightClientLock.Lock()
+	defer bn.lightClientLock.Unlock()
+	bn.lightClient = lightClient
+}
+
+// lightClientSnapshot returns a copy of the light client that updates can be
+// applied to without affecting the light client itself.
+func (bn *BeaconNetwork) lightClientSnapshot() (*ConsensusLightClient, error) {
+	bn.lightClientLock.RLock()
+	defer bn.lightClientLock.RUnlock()
+	if bn.lightClient == nil {
+		return nil, ErrLightClientNotSynced
+	}
+	lightClient := bn.lightClient.Snapshot()
+	if lightClient.Store.FinalizedHeader == nil || lightClient.Store.CurrentSyncCommittee == nil {
+		return nil, ErrLightClientNotSynced
+	}
+	lightClient.Logger = log.NewLogger(log.DiscardHandler())
+	return lightClient, nil
+}
+
 func (bn *BeaconNetwork) GetUpdates(firstPeriod, count uint64) ([]common.SpecObj, error) {
+	lightClientUpdateRange, err := bn.getUpdateRange(firstPeriod, count)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]common.SpecObj, len(lightClientUpdateRange))
+
+	for i, item := range lightClientUpdateRange {
+		res[i] = item.LightClientUpdate
+	}
+	return res, nil
+}
+
+func (bn *BeaconNetwork) GetCheckpointData(checkpointHash tree.Root) (common.SpecObj, error) {
+	forkedLightClientBootstrap, err := bn.getBootstrap(checkpointHash)
+	if err != nil {
+		return nil, err
+	}
+	return forkedLightClientBootstrap.Bootstrap, nil
+}
+
+func (bn *BeaconNetwork) GetFinalityUpdate(finalizedSlot uint64) (common.SpecObj, error) {
+	forkedLightClientFinalityUpdate, err := bn.getFinalityUpdate(finalizedSlot)
+	if err != nil {
+		return nil, err
+	}
+	return forkedLightClientFinalityUpdate.LightClientFinalityUpdate, nil
+}
+
+func (bn *BeaconNetwork) GetOptimisticUpdate(optimisticSlot uint64) (common.SpecObj, error) {
+	forkedLightClientOptimisticUpdate, err := bn.getOptimisticUpdate(optimisticSlot)
+	if err != nil {
+		return nil, err
+	}
+	return forkedLightClientOptimisticUpdate.LightClientOptimisticUpdate, nil
+}
+
+// GetHistoricalSummaries returns the historical summaries of the beacon
+// state at the given epoch. Summaries that are not stored locally are looked
+// up in the network and only returned and stored once verified.
+func (bn *BeaconNetwork) GetHistoricalSummaries(epoch uint64) (capella.HistoricalSummaries, error) {
+	var key bytes.Buffer
+	err := HistoricalSummariesWithProofKey{Epoch: epoch}.Serialize(codec.NewEncodingWriter(&key))
+	if err != nil {
+		return nil, err
+	}
+	contentKey := storage.NewContentKey(HistoricalSummaries, key.Bytes()).Encode()
+	contentId := bn.portalProtocol.ToContentId(contentKey)
+
+	data, err := bn.portalProtocol.Get(contentKey, contentId)
+	if errors.Is(err, storage.ErrContentNotFound) {
+		found, err := bn.portalProtocol.LookupContent(contentKey, contentId)
+		if err != nil {
+			return nil, err
+		}
+		data = found.Content
+		if err = bn.validateContent(contentKey, data); err != nil {
+			return nil, err
+		}
+		if err = bn.portalProtocol.PutFrom(contentKey, contentId, data, storage.ValidatedProvenance(storage.SourceLookup, found.Node)); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	var summaries ForkedHistoricalSummariesWithProof
+	err = summaries.Deserialize(bn.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))))
+	if err != nil {
+		return nil, err
+	}
+	return summaries.HistoricalSummariesWithProof.HistoricalSummaries, nil
+}
+
+func (bn *BeaconNetwork) getUpdateRange(firstPeriod, count uint64) (LightClientUpdateRange, error) {
 	lightClientUpdateKey := &LightClientUpdateKey{
 		StartPeriod: firstPeriod,
 		Count:       count,
@@ -78,15 +203,10 @@ func (bn *BeaconNetwork) GetUpdates(firstPeriod, count uint64) ([]common.SpecObj
 	if err != nil {
 		return nil, err
 	}
-	res := make([]common.SpecObj, len(lightClientUpdateRange))
-
-	for i, item := range lightClientUpdateRange {
-		res[i] = item.LightClientUpdate
-	}
-	return res, nil
+	return lightClientUpdateRange, nil
 }
 
-func (bn *BeaconNetwork) GetCheckpointData(checkpointHash tree.Root) (common.SpecObj, error) {
+func (bn *BeaconNetwork) getBootstrap(checkpointHash tree.Root) (*ForkedLightClientBootstrap, error) {
 	bootstrapKey := &LightClientBootstrapKey{
 		BlockHash: checkpointHash[:],
 	}
@@ -96,15 +216,15 @@ func (bn *BeaconNetwork) GetCheckpointData(checkpointHash tree.Root) (common.Spe
 		return nil, err
 	}
 
-	var forkedLightClientBootstrap *ForkedLightClientBootstrap
+	forkedLightClientBootstrap := &ForkedLightClientBootstrap{}
 	err = forkedLightClientBootstrap.Deserialize(bn.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))))
 	if err != nil {
 		return nil, err
 	}
-	return forkedLightClientBootstrap.Bootstrap, nil
+	return forkedLightClientBootstrap, nil
 }
 
-func (bn *BeaconNetwork) GetFinalityUpdate(finalizedSlot uint64) (common.SpecObj, error) {
+func (bn *BeaconNetwork) getFinalityUpdate(finalizedSlot uint64) (*ForkedLightClientFinalityUpdate, error) {
 	finalityUpdateKey := &LightClientFinalityUpdateKey{
 		FinalizedSlot: finalizedSlot,
 	}
@@ -113,16 +233,15 @@ func (bn *BeaconNetwork) GetFinalityUpdate(finalizedSlot uint64) (common.SpecObj
 		return nil, err
 	}
 
-	var forkedLightClientFinalityUpdate *ForkedLightClientFinalityUpdate
+	forkedLightClientFinalityUpdate := &ForkedLightClientFinalityUpdate{}
 	err = forkedLightClientFinalityUpdate.Deserialize(bn.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))))
 	if err != nil {
 		return nil, err
 	}
-
-	return forkedLightClientFinalityUpdate.LightClientFinalityUpdate, nil
+	return forkedLightClientFinalityUpdate, nil
 }
 
-func (bn *BeaconNetwork) GetOptimisticUpdate(optimisticSlot uint64) (common.SpecObj, error) {
+func (bn *BeaconNetwork) getOptimisticUpdate(optimisticSlot uint64) (*ForkedLightClientOptimisticUpdate, error) {
 	optimisticUpdateKey := &LightClientOptimisticUpdateKey{
 		OptimisticSlot: optimisticSlot,
 	}
@@ -132,13 +251,12 @@ func (bn *BeaconNetwork) GetOptimisticUpdate(optimisticSlot uint64) (common.Spec
 		return nil, err
 	}
 
-	var forkedLightClientOptimisticUpdate *ForkedLightClientOptimisticUpdate
+	forkedLightClientOptimisticUpdate := &ForkedLightClientOptimisticUpdate{}
 	err = forkedLightClientOptimisticUpdate.Deserialize(bn.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))))
 	if err != nil {
 		return nil, err
 	}
-
-	return forkedLightClientOptimisticUpdate.LightClientOptimisticUpdate, nil
+	return forkedLightClientOptimisticUpdate, nil
 }
 
 func (bn *BeaconNetwork) getContent(contentType storage.ContentType, beaconContentKey ssz.Marshaler) ([]byte, error) {
@@ -184,19 +302,14 @@ func (bn *BeaconNetwork) validateContent(contentKey []byte, content []byte) erro
 		if lightClientUpdateKey.Count != uint64(len(lightClientUpdateRange)) {
 			return fmt.Errorf("light client updates count does not match the content key count: %d != %d", len(lightClientUpdateRange), lightClientUpdateKey.Count)
 		}
-		return nil
+		return bn.verifyUpdateRange(lightClientUpdateKey.StartPeriod, lightClientUpdateRange)
 	case LightClientBootstrap:
-		var forkedLightClientBootstrap ForkedLightClientBootstrap
-		err := forkedLightClientBootstrap.Deserialize(bn.spec, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content))))
+		genericBootstrap, err := validateBootstrap(bn.spec, bn.forks, contentKey, content)
 		if err != nil {
 			return err
 		}
-		currentSlot := bn.spec.TimeToSlot(common.Timestamp(time.Now().Unix()), common.Timestamp(BeaconGenesisTime))
 
-		genericBootstrap, err := FromBootstrap(forkedLightClientBootstrap.Bootstrap)
-		if err != nil {
-			return err
-		}
+		currentSlot := bn.spec.TimeToSlot(common.Timestamp(time.Now().Unix()), common.Timestamp(BeaconGenesisTime))
 		fourMonth := time.Hour * 24 * 30 * 4
 		fourMonthInSlots := common.Timestamp(fourMonth.Seconds()) / (bn.spec.SECONDS_PER_SLOT)
 		fourMonthAgoSlot := currentSlot - common.Slot(fourMonthInSlots)
@@ -216,9 +329,6 @@ func (bn *BeaconNetwork) validateContent(contentKey []byte, content []byte) erro
 		if err != nil {
 			return err
 		}
-		if forkedLightClientFinalityUpdate.ForkDigest != Deneb {
-			return fmt.Errorf("light client finality update is not from the recent fork. Expected deneb, got %v", forkedLightClientFinalityUpdate.ForkDigest)
-		}
 		finalizedSlot := lightClientFinalityUpdateKey.FinalizedSlot
 		genericUpdate, err := FromLightClientFinalityUpdate(forkedLightClientFinalityUpdate.LightClientFinalityUpdate)
 		if err != nil {
@@ -227,7 +337,10 @@ func (bn *BeaconNetwork) validateContent(contentKey []byte, content []byte) erro
 		if finalizedSlot != uint64(genericUpdate.FinalizedHeader.Slot) {
 			return fmt.Errorf("light client finality update finalized slot does not match the content key finalized slot: %d != %d", genericUpdate.FinalizedHeader.Slot, finalizedSlot)
 		}
-		return nil
+		if err = bn.forks.CheckDigest(forkedLightClientFinalityUpdate.ForkDigest, genericUpdate.AttestedHeader.Slot); err != nil {
+			return err
+		}
+		return bn.verifyUpdate(genericUpdate)
 	case LightClientOptimisticUpdate:
 		lightClientOptimisticUpdateKey := &LightClientOptimisticUpdateKey{}
 		err := lightClientOptimisticUpdateKey.UnmarshalSSZ(contentKey[1:])
@@ -239,9 +352,6 @@ func (bn *BeaconNetwork) validateContent(contentKey []byte, content []byte) erro
 		if err != nil {
 			return err
 		}
-		if forkedLightClientOptimisticUpdate.ForkDigest != Deneb {
-			return fmt.Errorf("light client optimistic update is not from the recent fork. Expected deneb, got %v", forkedLightClientOptimisticUpdate.ForkDigest)
-		}
 		genericUpdate, err := FromLightClientOptimisticUpdate(forkedLightClientOptimisticUpdate.LightClientOptimisticUpdate)
 		if err != nil {
 			return err
@@ -250,18 +360,27 @@ func (bn *BeaconNetwork) validateContent(contentKey []byte, content []byte) erro
 		if lightClientOptimisticUpdateKey.OptimisticSlot != uint64(genericUpdate.SignatureSlot) {
 			return fmt.Errorf("light client optimistic update signature slot does not match the content key signature slot: %d != %d", genericUpdate.SignatureSlot, lightClientOptimisticUpdateKey.OptimisticSlot)
 		}
-		return nil
-	// TODO: VERIFY
+		if err = bn.forks.CheckDigest(forkedLightClientOptimisticUpdate.ForkDigest, genericUpdate.AttestedHeader.Slot); err != nil {
+			return err
+		}
+		return bn.verifyUpdate(genericUpdate)
 	case HistoricalSummaries:
 		forkedHistoricalSummariesWithProof, err := bn.generalSummariesValidation(contentKey, content)
 		if err != nil {
 			return err
 		}
-		// TODO get root from light client
-		header := bn.lightClient.GetFinalityHeader()
-		latestFinalizedRoot := header.StateRoot
-
-		valid := bn.stateSummariesValidation(*forkedHistoricalSummariesWithProof, latestFinalizedRoot)
+		lightClient, err := bn.lightClientSnapshot()
+		if err != nil {
+			return err
+		}
+		// The summaries are proven against the state of the latest finalized
+		// header, summaries of other epochs can't be checked.
+		finalizedHeader := lightClient.GetFinalityHeader()
+		finalizedEpoch := bn.spec.SlotToEpoch(finalizedHeader.Slot)
+		if epoch := forkedHistoricalSummariesWithProof.HistoricalSummariesWithProof.EPOCH; epoch != finalizedEpoch {
+			return fmt.Errorf("historical summaries epoch %d is not the finalized epoch %d", epoch, finalizedEpoch)
+		}
+		valid := bn.stateSummariesValidation(*forkedHistoricalSummariesWithProof, finalizedHeader.StateRoot)
 		if !valid {
 			return errors.New("merkle proof validation failed for HistoricalSummariesProof")
 		}
@@ -271,7 +390,79 @@ func (bn *BeaconNetwork) validateContent(contentKey []byte, content []byte) erro
 	}
 }
 
-func (bn *BeaconNetwork) validateContents(contentKeys [][]byte, contents [][]byte) error {
+// validateBootstrap checks a bootstrap against the block hash of its content
+// key and its current sync committee against the state root of its header.
+func validateBootstrap(spec *common.Spec, forks *ForkSchedule, contentKey []byte, content []byte) (*GenericBootstrap, error) {
+	var forkedLightClientBootstrap ForkedLightClientBootstrap
+	err := forkedLightClientBootstrap.Deserialize(spec, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content))))
+	if err != nil {
+		return nil, err
+	}
+	lightClientBootstrapKey := &LightClientBootstrapKey{}
+	err = lightClientBootstrapKey.UnmarshalSSZ(contentKey[1:])
+	if err != nil {
+		return nil, err
+	}
+	genericBootstrap, err := FromBootstrap(forkedLightClientBootstrap.Bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	headerRoot := genericBootstrap.Header.HashTreeRoot(tree.GetHashFn())
+	if !bytes.Equal(headerRoot[:], lightClientBootstrapKey.BlockHash) {
+		return nil, fmt.Errorf("light client bootstrap header root does not match the content key block hash: %s != %#x", headerRoot, lightClientBootstrapKey.BlockHash)
+	}
+	if err = forks.CheckDigest(forkedLightClientBootstrap.ForkDigest, genericBootstrap.Header.Slot); err != nil {
+		return nil, err
+	}
+	if !IsCurrentCommitteeProofValid(spec, *genericBootstrap.Header, genericBootstrap.CurrentSyncCommittee, genericBootstrap.CurrentSyncCommitteeBranch) {
+		return nil, ErrInvalidCurrentSyncCommitteeProof
+	}
+	return genericBootstrap, nil
+}
+
+// verifyUpdate verifies the sync committee signature and the branches of a
+// finality or optimistic update against the light client store. Updates that
+// are valid but older than the store are accepted as well.
+func (bn *BeaconNetwork) verifyUpdate(update *GenericUpdate) error {
+	lightClient, err := bn.lightClientSnapshot()
+	if err != nil {
+		return err
+	}
+	if err = lightClient.VerifyGenericUpdate(update); err != nil && !errors.Is(err, ErrNotRelevant) {
+		return err
+	}
+	return nil
+}
+
+// verifyUpdateRange verifies the updates of a range in order, applying each
+// of them to a copy of the light client so that the sync committee of the
+// next period is known when verifying the following update.
+func (bn *BeaconNetwork) verifyUpdateRange(startPeriod uint64, updates LightClientUpdateRange) error {
+	lightClient, err := bn.lightClientSnapshot()
+	if err != nil {
+		return err
+	}
+	for i, update := range updates {
+		genericUpdate, err := FromLightClientUpdate(update.LightClientUpdate)
+		if err != nil {
+			return err
+		}
+		period := CalcSyncPeriod(uint64(genericUpdate.AttestedHeader.Slot))
+		if period != startPeriod+uint64(i) {
+			return fmt.Errorf("light client update %d is from period %d, expected %d", i, period, startPeriod+uint64(i))
+		}
+		if err = bn.forks.CheckDigest(update.ForkDigest, genericUpdate.AttestedHeader.Slot); err != nil {
+			return err
+		}
+		if err = lightClient.VerifyGenericUpdate(genericUpdate); err != nil && !errors.Is(err, ErrNotRelevant) {
+			return err
+		}
+		lightClient.ApplyGenericUpdate(genericUpdate)
+	}
+	return nil
+}
+
+func (bn *BeaconNetwork) validateContents(node enode.ID, contentKeys [][]byte, contents [][]byte) error {
 	for i, content := range contents {
 		contentKey := contentKeys[i]
 		err := bn.validateContent(contentKey, content)
@@ -280,7 +471,7 @@ func (bn *BeaconNetwork) validateContents(contentKeys [][]byte, contents [][]byt
 			return fmt.Errorf("content validate failed with content key %x and content %x", contentKey, content)
 		}
 		contentId := bn.portalProtocol.ToContentId(contentKey)
-		err = bn.portalProtocol.Put(contentKey, contentId, content)
+		err = bn.portalProtocol.PutFrom(contentKey, contentId, content, storage.ValidatedProvenance(storage.SourceOffer, node))
 		if err != nil {
 			bn.log.Error("put content failed", "contentKey", hexutil.Encode(contentKey), "content", hexutil.Encode(content), "err", err)
 			return err
@@ -296,7 +487,8 @@ func (bn *BeaconNetwork) processContentLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case contentElement := <-contentChan:
-			err := bn.validateContents(contentElement.ContentKeys, contentElement.Contents)
+			err := bn.validateContents(contentElement.Node, contentElement.ContentKeys, contentElement.Contents)
+			contentElement.Done()
 			if err != nil {
 				bn.log.Error("validate content failed", "err", err)
 				continue
@@ -320,13 +512,19 @@ func (bn *BeaconNetwork) processContentLoop(ctx context.Context) {
 }
 
 func (bn *BeaconNetwork) generalSummariesValidation(contentKey, content []byte) (*ForkedHistoricalSummariesWithProof, error) {
+	return decodeHistoricalSummaries(bn.spec, contentKey, content)
+}
+
+// decodeHistoricalSummaries decodes the historical summaries and checks that
+// they are of the epoch of their content key.
+func decodeHistoricalSummaries(spec *common.Spec, contentKey, content []byte) (*ForkedHistoricalSummariesWithProof, error) {
 	key := &HistoricalSummariesWithProofKey{}
 	err := key.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey[1:]), uint64(len(contentKey[1:]))))
 	if err != nil {
 		return nil, err
 	}
 	forkedHistoricalSummariesWithProof := &ForkedHistoricalSummariesWithProof{}
-	err = forkedHistoricalSummariesWithProof.Deserialize(bn.spec, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content))))
+	err = forkedHistoricalSummariesWithProof.Deserialize(spec, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content))))
 	if err != nil {
 		return nil, err
 	}
@@ -340,6 +538,9 @@ func (bn *BeaconNetwork) stateSummariesValidation(f ForkedHistoricalSummariesWit
 	proof := f.HistoricalSummariesWithProof.Proof
 	summariesRoot := f.HistoricalSummariesWithProof.HistoricalSummaries.HashTreeRoot(bn.spec, tree.GetHashFn())
 
-	gIndex := 59
-	return merkle.VerifyMerkleBranch(summariesRoot, proof.Proof[:], 5, uint64(gIndex), latestFinalizedRoot)
+	gIndex, depth := historicalSummariesGIndex(f.ForkDigest)
+	if len(proof.Proof) != depth {
+		return false
+	}
+	return merkle.VerifyMerkleBranch(summariesRoot, proof.Proof, uint64(depth), gIndex, latestFinalizedRoot)
 }
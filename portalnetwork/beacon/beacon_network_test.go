@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"testing"
 
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
 	"github.com/protolambda/ztyp/codec"
 	"github.com/stretchr/testify/require"
 )
@@ -14,22 +17,38 @@ type Entry struct {
 }
 
 func TestLightClientBootstrapValidation(t *testing.T) {
-	bootstrap, err := GetLightClientBootstrap(0)
-	require.NoError(t, err)
-	contentKey := make([]byte, 33)
-	contentKey[0] = byte(LightClientBootstrap)
+	contentKey, content := readPortalFixture(t, "light_client_bootstrap.json")
 	bn := NewBeaconNetwork(nil)
+	// the fixture is a valid mainnet bootstrap, but too old to be accepted
+	err := bn.validateContent(contentKey, content)
+	require.ErrorContains(t, err, "too old")
+
+	wrongKey := bytes.Clone(contentKey)
+	wrongKey[1] ^= 0xff
+	err = bn.validateContent(wrongKey, content)
+	require.ErrorContains(t, err, "does not match the content key")
+
+	var bootstrap ForkedLightClientBootstrap
+	err = bootstrap.Deserialize(bn.spec, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content))))
+	require.NoError(t, err)
+	bootstrap.Bootstrap.(*capella.LightClientBootstrap).CurrentSyncCommittee.Pubkeys[0] = common.BLSPubkey{}
 	var buf bytes.Buffer
-	bootstrap.Serialize(bn.spec, codec.NewEncodingWriter(&buf))
+	require.NoError(t, bootstrap.Serialize(bn.spec, codec.NewEncodingWriter(&buf)))
 	err = bn.validateContent(contentKey, buf.Bytes())
-	require.NoError(t, err)
+	require.ErrorIs(t, err, ErrInvalidCurrentSyncCommitteeProof)
 }
 
 func TestLightClienUpdateValidation(t *testing.T) {
-	update, err := GetClientUpdate(0)
+	client, err := getClient(false, t)
+	require.NoError(t, err)
+	updates, err := client.API.GetUpdates(0, 1)
 	require.NoError(t, err)
+	update := ForkedLightClientUpdate{ForkDigest: Capella, LightClientUpdate: updates[0]}
+	genericUpdate, err := FromLightClientUpdate(updates[0])
+	require.NoError(t, err)
+
 	key := &LightClientUpdateKey{
-		StartPeriod: 0,
+		StartPeriod: CalcSyncPeriod(uint64(genericUpdate.AttestedHeader.Slot)),
 		Count:       1,
 	}
 	updateRange := LightClientUpdateRange([]ForkedLightClientUpdate{update})
@@ -41,8 +60,28 @@ func TestLightClienUpdateValidation(t *testing.T) {
 	bn := NewBeaconNetwork(nil)
 	var buf bytes.Buffer
 	updateRange.Serialize(bn.spec, codec.NewEncodingWriter(&buf))
+
+	err = bn.validateContent(contentKey, buf.Bytes())
+	require.ErrorIs(t, err, ErrLightClientNotSynced)
+
+	bn.SetLightClient(client)
 	err = bn.validateContent(contentKey, buf.Bytes())
 	require.NoError(t, err)
+	// verification must not touch the store of the light client
+	require.Nil(t, client.Store.NextSyncCommittee)
+
+	// the key and the updates must agree on the period
+	key.StartPeriod++
+	keyData, err = key.MarshalSSZ()
+	require.NoError(t, err)
+	err = bn.validateContent(append([]byte{byte(LightClientUpdate)}, keyData...), buf.Bytes())
+	require.ErrorContains(t, err, "expected")
+
+	genericUpdate.SyncAggregate.SyncCommitteeSignature[1] ^= 0xff
+	buf.Reset()
+	updateRange.Serialize(bn.spec, codec.NewEncodingWriter(&buf))
+	err = bn.validateContent(contentKey, buf.Bytes())
+	require.Error(t, err)
 }
 
 func TestLightClientFinalityUpdateValidation(t *testing.T) {
@@ -60,7 +99,30 @@ func TestLightClientFinalityUpdateValidation(t *testing.T) {
 	var buf bytes.Buffer
 	update.Serialize(bn.spec, codec.NewEncodingWriter(&buf))
 	err = bn.validateContent(contentKey, buf.Bytes())
+	require.ErrorContains(t, err, "does not match the electra fork")
+
+	// the deneb update is accepted up to the light client check once its
+	// attested slot lies in deneb
+	update.LightClientFinalityUpdate.(*deneb.LightClientFinalityUpdate).AttestedHeader.Beacon.Slot = bn.spec.SLOTS_PER_EPOCH * common.Slot(bn.spec.DENEB_FORK_EPOCH)
+	buf.Reset()
+	update.Serialize(bn.spec, codec.NewEncodingWriter(&buf))
+	err = bn.validateContent(contentKey, buf.Bytes())
+	require.ErrorIs(t, err, ErrLightClientNotSynced)
+
+	client, err := getClient(false, t)
+	require.NoError(t, err)
+	bn.SetLightClient(client)
+	// the random update is not signed by the committee of the light client
+	err = bn.validateContent(contentKey, buf.Bytes())
+	require.Error(t, err)
+
+	finalityUpdate, err := client.API.GetFinalityUpdate()
+	require.NoError(t, err)
+	genericUpdate, err := FromLightClientFinalityUpdate(finalityUpdate)
 	require.NoError(t, err)
+	require.NoError(t, bn.verifyUpdate(genericUpdate))
+	genericUpdate.FinalizedHeader.Slot++
+	require.ErrorIs(t, bn.verifyUpdate(genericUpdate), ErrInvalidFinalityProof)
 }
 
 func TestLightClientOptimisticUpdateValidation(t *testing.T) {
@@ -78,7 +140,26 @@ func TestLightClientOptimisticUpdateValidation(t *testing.T) {
 	var buf bytes.Buffer
 	update.Serialize(bn.spec, codec.NewEncodingWriter(&buf))
 	err = bn.validateContent(contentKey, buf.Bytes())
+	require.ErrorContains(t, err, "does not match the electra fork")
+
+	// the deneb update is accepted up to the light client check once its
+	// attested slot lies in deneb
+	update.LightClientOptimisticUpdate.(*deneb.LightClientOptimisticUpdate).AttestedHeader.Beacon.Slot = bn.spec.SLOTS_PER_EPOCH * common.Slot(bn.spec.DENEB_FORK_EPOCH)
+	buf.Reset()
+	update.Serialize(bn.spec, codec.NewEncodingWriter(&buf))
+	err = bn.validateContent(contentKey, buf.Bytes())
+	require.ErrorIs(t, err, ErrLightClientNotSynced)
+
+	client, err := getClient(false, t)
 	require.NoError(t, err)
+	bn.SetLightClient(client)
+	optimisticUpdate, err := client.API.GetOptimisticUpdate()
+	require.NoError(t, err)
+	genericUpdate, err := FromLightClientOptimisticUpdate(optimisticUpdate)
+	require.NoError(t, err)
+	require.NoError(t, bn.verifyUpdate(genericUpdate))
+	genericUpdate.SyncAggregate.SyncCommitteeSignature[1] ^= 0xff
+	require.Error(t, bn.verifyUpdate(genericUpdate))
 }
 
 func TestHistorySummariesWithProofValidation(t *testing.T) {
@@ -107,4 +188,20 @@ func TestHistorySummariesWithProofValidation(t *testing.T) {
 	require.NoError(t, err)
 	valid := bn.stateSummariesValidation(*forkedHistorySummaries, root)
 	require.True(t, valid)
+
+	err = bn.validateContent(contentKey, content)
+	require.ErrorIs(t, err, ErrLightClientNotSynced)
+	setFinalizedHeader := func(header common.BeaconBlockHeader) {
+		bn.SetLightClient(&ConsensusLightClient{Store: LightClientStore{
+			FinalizedHeader:      &header,
+			CurrentSyncCommittee: &common.SyncCommittee{},
+		}})
+	}
+	finalizedSlot := common.Slot(key.Epoch) * bn.spec.SLOTS_PER_EPOCH
+	setFinalizedHeader(common.BeaconBlockHeader{Slot: finalizedSlot, StateRoot: root})
+	require.NoError(t, bn.validateContent(contentKey, content))
+	setFinalizedHeader(common.BeaconBlockHeader{Slot: finalizedSlot + bn.spec.SLOTS_PER_EPOCH, StateRoot: root})
+	require.ErrorContains(t, bn.validateContent(contentKey, content), "is not the finalized epoch")
+	setFinalizedHeader(common.BeaconBlockHeader{Slot: finalizedSlot})
+	require.Error(t, bn.validateContent(contentKey, content))
 }
@@ -0,0 +1,249 @@
+package beacon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+type specSerializable interface {
+	Serialize(spec *common.Spec, w *codec.EncodingWriter) error
+}
+
+// BeaconBridge polls the light client endpoints of a beacon node and gossips
+// the data into the beacon network: finality and optimistic updates every
+// slot, bootstraps and historical summaries for every newly finalized epoch
+// and the update of every completed sync committee period.
+type BeaconBridge struct {
+	api           *BeaconAPIClient
+	beaconNetwork *BeaconNetwork
+	spec          *common.Spec
+	log           log.Logger
+	closeCtx      context.Context
+	closeFunc     context.CancelFunc
+
+	lastOptimisticSlot common.Slot
+	lastFinalizedSlot  common.Slot
+	lastBootstrapEpoch common.Epoch
+	lastSummaryEpoch   common.Epoch
+	lastUpdatePeriod   uint64
+}
+
+func NewBeaconBridge(api *BeaconAPIClient, beaconNetwork *BeaconNetwork) *BeaconBridge {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BeaconBridge{
+		api:           api,
+		beaconNetwork: beaconNetwork,
+		spec:          beaconNetwork.spec,
+		log:           log.New("sub-protocol", "beacon", "bridge", "beacon-api"),
+		closeCtx:      ctx,
+		closeFunc:     cancel,
+	}
+}
+
+func (b *BeaconBridge) Start() {
+	go b.loop()
+}
+
+func (b *BeaconBridge) Stop() {
+	b.closeFunc()
+}
+
+// loop bridges once right away and then a third into every slot, when the
+// beacon node has processed the block of the slot.
+func (b *BeaconBridge) loop() {
+	slotDuration := time.Duration(b.spec.SECONDS_PER_SLOT) * time.Second
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-b.closeCtx.Done():
+			return
+		case <-timer.C:
+			if err := b.bridge(); err != nil {
+				b.log.Warn("failed to bridge beacon content", "err", err)
+			}
+			genesis := time.Unix(int64(BeaconGenesisTime), 0)
+			next := genesis.Add((time.Since(genesis)/slotDuration + 1) * slotDuration).Add(slotDuration / 3)
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// bridge gossips all content that became available since the last call. A
+// failing step is retried on the next call without holding back the others.
+func (b *BeaconBridge) bridge() error {
+	return errors.Join(b.bridgeOptimisticUpdate(), b.bridgeFinalityUpdate())
+}
+
+func (b *BeaconBridge) bridgeOptimisticUpdate() error {
+	update, err := b.api.GetOptimisticUpdate()
+	if err != nil {
+		return err
+	}
+	genericUpdate, err := FromLightClientOptimisticUpdate(update.LightClientOptimisticUpdate)
+	if err != nil {
+		return err
+	}
+	if genericUpdate.SignatureSlot <= b.lastOptimisticSlot {
+		return nil
+	}
+	key, err := (&LightClientOptimisticUpdateKey{OptimisticSlot: uint64(genericUpdate.SignatureSlot)}).MarshalSSZ()
+	if err != nil {
+		return err
+	}
+	if err = b.gossip(LightClientOptimisticUpdate, key, update); err != nil {
+		return err
+	}
+	b.lastOptimisticSlot = genericUpdate.SignatureSlot
+	return nil
+}
+
+func (b *BeaconBridge) bridgeFinalityUpdate() error {
+	update, err := b.api.GetFinalityUpdate()
+	if err != nil {
+		return err
+	}
+	genericUpdate, err := FromLightClientFinalityUpdate(update.LightClientFinalityUpdate)
+	if err != nil {
+		return err
+	}
+	finalizedHeader := genericUpdate.FinalizedHeader
+	if finalizedHeader.Slot > b.lastFinalizedSlot {
+		key, err := (&LightClientFinalityUpdateKey{FinalizedSlot: uint64(finalizedHeader.Slot)}).MarshalSSZ()
+		if err != nil {
+			return err
+		}
+		if err = b.gossip(LightClientFinalityUpdate, key, update); err != nil {
+			return err
+		}
+		b.lastFinalizedSlot = finalizedHeader.Slot
+	}
+
+	var errs []error
+	epoch := b.spec.SlotToEpoch(finalizedHeader.Slot)
+	if epoch > b.lastBootstrapEpoch {
+		if err = b.bridgeBootstrap(finalizedHeader.HashTreeRoot(tree.GetHashFn())); err != nil {
+			errs = append(errs, err)
+		} else {
+			b.lastBootstrapEpoch = epoch
+		}
+	}
+	if epoch > b.lastSummaryEpoch {
+		if err = b.bridgeHistoricalSummaries(); err != nil {
+			errs = append(errs, err)
+		} else {
+			b.lastSummaryEpoch = epoch
+		}
+	}
+	// The update of a sync committee period is only final once the chain
+	// finalized past the end of the period.
+	if period := CalcSyncPeriod(uint64(finalizedHeader.Slot)); period > 0 && period-1 > b.lastUpdatePeriod {
+		if err = b.bridgeUpdate(period - 1); err != nil {
+			errs = append(errs, err)
+		} else {
+			b.lastUpdatePeriod = period - 1
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *BeaconBridge) bridgeBootstrap(blockRoot tree.Root) error {
+	bootstrap, err := b.api.GetBootstrap(blockRoot)
+	if err != nil {
+		return err
+	}
+	key, err := (&LightClientBootstrapKey{BlockHash: blockRoot[:]}).MarshalSSZ()
+	if err != nil {
+		return err
+	}
+	return b.gossip(LightClientBootstrap, key, bootstrap)
+}
+
+func (b *BeaconBridge) bridgeUpdate(period uint64) error {
+	updates, err := b.api.GetUpdates(period, 1)
+	if err != nil {
+		return err
+	}
+	if len(updates) != 1 {
+		return storage.ErrContentNotFound
+	}
+	key, err := (&LightClientUpdateKey{StartPeriod: period, Count: 1}).MarshalSSZ()
+	if err != nil {
+		return err
+	}
+	return b.gossip(LightClientUpdate, key, &updates)
+}
+
+func (b *BeaconBridge) bridgeHistoricalSummaries() error {
+	state, digest, err := b.api.GetFinalizedState()
+	if err != nil {
+		return err
+	}
+	var (
+		proof     [][]byte
+		slot      common.Slot
+		summaries capella.HistoricalSummaries
+	)
+	switch state := state.(type) {
+	case *deneb.BeaconState:
+		proof, err = BuildHistoricalSummariesProof(*state)
+		slot, summaries = state.Slot, state.HistoricalSummaries
+	case *ElectraBeaconState:
+		proof, err = BuildElectraHistoricalSummariesProof(*state)
+		slot, summaries = state.Slot, state.HistoricalSummaries
+	default:
+		err = fmt.Errorf("unsupported beacon state %T", state)
+	}
+	if err != nil {
+		return err
+	}
+	content := &ForkedHistoricalSummariesWithProof{
+		ForkDigest: digest,
+		HistoricalSummariesWithProof: HistoricalSummariesWithProof{
+			EPOCH:               b.spec.SlotToEpoch(slot),
+			HistoricalSummaries: summaries,
+			Proof:               HistoricalSummariesProof{Proof: make([]common.Bytes32, len(proof))},
+		},
+	}
+	for i := range proof {
+		copy(content.HistoricalSummariesWithProof.Proof.Proof[i][:], proof[i])
+	}
+	var key bytes.Buffer
+	err = HistoricalSummariesWithProofKey{Epoch: uint64(content.HistoricalSummariesWithProof.EPOCH)}.Serialize(codec.NewEncodingWriter(&key))
+	if err != nil {
+		return err
+	}
+	return b.gossip(HistoricalSummaries, key.Bytes(), content)
+}
+
+// gossip stores the content locally and offers it to the peers interested in
+// it.
+func (b *BeaconBridge) gossip(contentType storage.ContentType, key []byte, content specSerializable) error {
+	var buf bytes.Buffer
+	if err := content.Serialize(b.spec, codec.NewEncodingWriter(&buf)); err != nil {
+		return err
+	}
+	contentKey := storage.NewContentKey(contentType, key).Encode()
+	contentId := b.beaconNetwork.portalProtocol.ToContentId(contentKey)
+	if err := b.beaconNetwork.portalProtocol.Put(contentKey, contentId, buf.Bytes()); err != nil {
+		return err
+	}
+	peers, err := b.beaconNetwork.portalProtocol.Gossip(nil, [][]byte{contentKey}, [][]byte{buf.Bytes()})
+	if err != nil {
+		return err
+	}
+	b.log.Info("Gossiped beacon content", "type", contentType, "contentKey", hexutil.Encode(contentKey), "peers", peers)
+	return nil
+}
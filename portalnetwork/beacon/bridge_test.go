@@ -0,0 +1,211 @@
+package beacon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/golang/snappy"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// readPortalFixture returns the content key and value of the single entry of
+// a fixture in testdata/types.
+func readPortalFixture(t *testing.T, name string) ([]byte, []byte) {
+	data, err := os.ReadFile("testdata/types/" + name)
+	require.NoError(t, err)
+	var entries map[string]struct {
+		ContentKey   string `json:"content_key"`
+		ContentValue string `json:"content_value"`
+	}
+	require.NoError(t, json.Unmarshal(data, &entries))
+	require.Len(t, entries, 1)
+	for _, entry := range entries {
+		return hexutil.MustDecode(entry.ContentKey), hexutil.MustDecode(entry.ContentValue)
+	}
+	return nil, nil
+}
+
+// beaconAPIStandIn serves the portal fixtures the way a beacon node serves
+// its light client endpoints.
+type beaconAPIStandIn struct {
+	bootstrapKey, bootstrap []byte
+	updateRangeKey          []byte
+	updateRange             LightClientUpdateRange
+	finalityUpdate          []byte
+	optimisticUpdate        []byte
+	state                   []byte
+	stateVersion            string
+
+	stateRequests atomic.Int32
+}
+
+func newBeaconAPIStandIn(t *testing.T) *beaconAPIStandIn {
+	s := &beaconAPIStandIn{}
+	s.bootstrapKey, s.bootstrap = readPortalFixture(t, "light_client_bootstrap.json")
+	_, s.finalityUpdate = readPortalFixture(t, "light_client_finality_update.json")
+	_, s.optimisticUpdate = readPortalFixture(t, "light_client_optimistic_update.json")
+
+	var updateRange []byte
+	s.updateRangeKey, updateRange = readPortalFixture(t, "light_client_updates_by_range.json")
+	err := s.updateRange.Deserialize(configs.Mainnet, codec.NewDecodingReader(bytes.NewReader(updateRange), uint64(len(updateRange))))
+	require.NoError(t, err)
+
+	file, err := os.ReadFile("testdata/beacon/BeaconState/ssz_random/case_0/serialized.ssz_snappy")
+	require.NoError(t, err)
+	s.state, err = snappy.Decode(nil, file)
+	require.NoError(t, err)
+	s.stateVersion = "deneb"
+	return s
+}
+
+func (s *beaconAPIStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeForked := func(content []byte) {
+		version, err := forkName(common.ForkDigest(content[:4]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(consensusVersionHeader, version)
+		w.Write(content[4:])
+	}
+	switch r.URL.Path {
+	case LightClientRestPath + "bootstrap/" + hexutil.Encode(s.bootstrapKey[1:]):
+		writeForked(s.bootstrap)
+	case LightClientRestPath + "finality_update":
+		writeForked(s.finalityUpdate)
+	case LightClientRestPath + "optimistic_update":
+		writeForked(s.optimisticUpdate)
+	case LightClientRestPath + "updates":
+		var key LightClientUpdateKey
+		if err := key.UnmarshalSSZ(s.updateRangeKey[1:]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		start, _ := strconv.ParseUint(r.URL.Query().Get("start_period"), 10, 64)
+		count, _ := strconv.ParseUint(r.URL.Query().Get("count"), 10, 64)
+		for period := start; period < start+count; period++ {
+			if period < key.StartPeriod || period >= key.StartPeriod+key.Count {
+				continue
+			}
+			update := s.updateRange[period-key.StartPeriod]
+			var buf bytes.Buffer
+			if err := update.Serialize(configs.Mainnet, codec.NewEncodingWriter(&buf)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(binary.LittleEndian.AppendUint64(nil, uint64(buf.Len())))
+			w.Write(buf.Bytes())
+		}
+	case "/eth/v2/debug/beacon/states/finalized":
+		s.stateRequests.Add(1)
+		w.Header().Set(consensusVersionHeader, s.stateVersion)
+		w.Write(s.state)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestBeaconBridge(t *testing.T) {
+	bn, err := SetupBeaconNetwork(":7897", nil)
+	require.NoError(t, err)
+	require.NoError(t, bn.Start())
+	defer bn.Stop()
+
+	standIn := newBeaconAPIStandIn(t)
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	bridge := NewBeaconBridge(NewBeaconAPIClient(server.URL, bn.spec), bn)
+	require.NoError(t, bridge.bridge())
+
+	requireStored := func(contentKey, content []byte) {
+		t.Helper()
+		res, err := bn.portalProtocol.Get(contentKey, bn.portalProtocol.ToContentId(contentKey))
+		require.NoError(t, err)
+		require.Equal(t, hexutil.Encode(content), hexutil.Encode(res))
+	}
+	for _, name := range []string{"light_client_bootstrap.json", "light_client_finality_update.json", "light_client_optimistic_update.json"} {
+		requireStored(readPortalFixture(t, name))
+	}
+
+	// the finalized slot of the fixture lies in period 820, the last
+	// complete period is part of the fixture update range
+	updateKey, err := (&LightClientUpdateKey{StartPeriod: 819, Count: 1}).MarshalSSZ()
+	require.NoError(t, err)
+	var update bytes.Buffer
+	require.NoError(t, LightClientUpdateRange{standIn.updateRange[3]}.Serialize(configs.Mainnet, codec.NewEncodingWriter(&update)))
+	requireStored(append([]byte{byte(LightClientUpdate)}, updateKey...), update.Bytes())
+
+	data, err := os.ReadFile("testdata/types/historical_summaries_with_proof.yaml")
+	require.NoError(t, err)
+	summaries := TestProof{}
+	require.NoError(t, yaml.Unmarshal(data, &summaries))
+	requireStored(hexutil.MustDecode(summaries.ContentKey), hexutil.MustDecode(summaries.ContentValue))
+	historicalSummaries, err := bn.GetHistoricalSummaries(summaries.Epoch)
+	require.NoError(t, err)
+	var forkedSummaries ForkedHistoricalSummariesWithProof
+	content := hexutil.MustDecode(summaries.ContentValue)
+	require.NoError(t, forkedSummaries.Deserialize(configs.Mainnet, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content)))))
+	require.Equal(t, forkedSummaries.HistoricalSummariesWithProof.HistoricalSummaries, historicalSummaries)
+
+	// nothing new to bridge, the beacon state is not fetched again
+	require.NoError(t, bridge.bridge())
+	require.Equal(t, int32(1), standIn.stateRequests.Load())
+}
+
+func TestBeaconBridgeElectraState(t *testing.T) {
+	bn, err := SetupBeaconNetwork(":7904", nil)
+	require.NoError(t, err)
+	require.NoError(t, bn.Start())
+	defer bn.Stop()
+
+	standIn := newBeaconAPIStandIn(t)
+	var denebState deneb.BeaconState
+	require.NoError(t, denebState.Deserialize(configs.Mainnet, codec.NewDecodingReader(bytes.NewReader(standIn.state), uint64(len(standIn.state)))))
+	state := &ElectraBeaconState{
+		BeaconState:               denebState,
+		DepositRequestsStartIndex: 12,
+		EarliestExitEpoch:         34,
+		PendingDeposits:           PendingDeposits{{Amount: 32_000_000_000, Slot: 5}},
+		PendingPartialWithdrawals: PendingPartialWithdrawals{{ValidatorIndex: 1, Amount: 2, WithdrawableEpoch: 3}},
+		PendingConsolidations:     PendingConsolidations{{SourceIndex: 4, TargetIndex: 5}},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, state.Serialize(configs.Mainnet, codec.NewEncodingWriter(&buf)))
+	require.Equal(t, state.ByteLength(configs.Mainnet), uint64(buf.Len()))
+	standIn.state, standIn.stateVersion = buf.Bytes(), "electra"
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	bridge := NewBeaconBridge(NewBeaconAPIClient(server.URL, bn.spec), bn)
+	require.NoError(t, bridge.bridgeHistoricalSummaries())
+
+	var key bytes.Buffer
+	epoch := bn.spec.SlotToEpoch(state.Slot)
+	require.NoError(t, HistoricalSummariesWithProofKey{Epoch: uint64(epoch)}.Serialize(codec.NewEncodingWriter(&key)))
+	contentKey := append([]byte{byte(HistoricalSummaries)}, key.Bytes()...)
+	content, err := bn.portalProtocol.Get(contentKey, bn.portalProtocol.ToContentId(contentKey))
+	require.NoError(t, err)
+
+	forkedSummaries, err := bn.generalSummariesValidation(contentKey, content)
+	require.NoError(t, err)
+	require.Equal(t, Electra, forkedSummaries.ForkDigest)
+	require.Len(t, forkedSummaries.HistoricalSummariesWithProof.Proof.Proof, 6)
+	root := state.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
+	require.True(t, bn.stateSummariesValidation(*forkedSummaries, root))
+	require.False(t, bn.stateSummariesValidation(*forkedSummaries, denebState.HashTreeRoot(configs.Mainnet, tree.GetHashFn())))
+}
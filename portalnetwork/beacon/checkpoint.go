@@ -0,0 +1,140 @@
+package beacon
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+var (
+	ErrNoCheckpoint           = errors.New("no checkpoint stored")
+	ErrCheckpointNotConfirmed = errors.New("not enough peers agree on the finalized checkpoint")
+)
+
+// CheckpointStore persists the root of the latest finalized header of the
+// light client, it is the start checkpoint of the next run.
+type CheckpointStore struct {
+	db *sql.DB
+}
+
+func NewCheckpointStore(db *sql.DB) (*CheckpointStore, error) {
+	if err := storage.Migrate(db, "beacon", migrations); err != nil {
+		return nil, err
+	}
+	return &CheckpointStore{db: db}, nil
+}
+
+// Load returns the stored checkpoint and the slot of its header.
+func (s *CheckpointStore) Load() (common.Root, common.Slot, error) {
+	var (
+		root []byte
+		slot uint64
+	)
+	err := s.db.QueryRow(CheckpointLookupQuery).Scan(&root, &slot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Root{}, 0, ErrNoCheckpoint
+	}
+	if err != nil {
+		return common.Root{}, 0, err
+	}
+	return common.Root(root), common.Slot(slot), nil
+}
+
+// Save replaces the stored checkpoint.
+func (s *CheckpointStore) Save(root common.Root, slot common.Slot) error {
+	_, err := s.db.Exec(InsertCheckpointQuery, root[:], uint64(slot))
+	return err
+}
+
+// isWithinWeakSubjectivityPeriod reports whether a checkpoint at slot is
+// younger than maxAge seconds. Older checkpoints may have been finalized on a
+// chain that the validators that left since can rewrite without being slashed.
+func isWithinWeakSubjectivityPeriod(spec *common.Spec, slot common.Slot, maxAge uint64) bool {
+	slotTime := BeaconGenesisTime + uint64(slot)*uint64(spec.SECONDS_PER_SLOT)
+	now := uint64(time.Now().Unix())
+	return now < slotTime || now-slotTime < maxAge
+}
+
+// DiscoverCheckpoint asks the peers of the routing table for their latest
+// finality update and returns the finalized header once peers of them agree on
+// it. The routing table already limits the number of nodes per IP range, so
+// the answers come from independent nodes. The updates can not be verified
+// without a trusted sync committee, the agreement of the peers is what the
+// checkpoint is trusted for.
+func (bn *BeaconNetwork) DiscoverCheckpoint(peers int) (common.Root, common.Slot, error) {
+	key, err := (&LightClientFinalityUpdateKey{FinalizedSlot: 0}).MarshalSSZ()
+	if err != nil {
+		return common.Root{}, 0, err
+	}
+	contentKey := storage.NewContentKey(LightClientFinalityUpdate, key).Encode()
+	nodes := bn.portalProtocol.NodeList()
+	rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	header, err := crossCheckFinality(nodes, peers, func(node *enode.Node) (*common.BeaconBlockHeader, error) {
+		content, err := bn.portalProtocol.FindContentFrom(node, contentKey)
+		if err != nil {
+			return nil, err
+		}
+		return bn.finalizedHeader(content)
+	})
+	if err != nil {
+		return common.Root{}, 0, err
+	}
+	return header.HashTreeRoot(tree.GetHashFn()), header.Slot, nil
+}
+
+// finalizedHeader decodes a finality update and returns its finalized header
+// if the update proves it against the attested header.
+func (bn *BeaconNetwork) finalizedHeader(content []byte) (*common.BeaconBlockHeader, error) {
+	var forkedUpdate ForkedLightClientFinalityUpdate
+	err := forkedUpdate.Deserialize(bn.spec, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content))))
+	if err != nil {
+		return nil, err
+	}
+	update, err := FromLightClientFinalityUpdate(forkedUpdate.LightClientFinalityUpdate)
+	if err != nil {
+		return nil, err
+	}
+	if err = bn.forks.CheckDigest(forkedUpdate.ForkDigest, update.AttestedHeader.Slot); err != nil {
+		return nil, err
+	}
+	if !IsFinalityProofValid(*update.AttestedHeader, *update.FinalizedHeader, update.FinalityBranch) {
+		return nil, ErrInvalidFinalityProof
+	}
+	return update.FinalizedHeader, nil
+}
+
+// crossCheckFinality queries the nodes one by one until peers of them report
+// the same finalized header. The header must also be reported by the majority
+// of the nodes that answered, so that a group of colluding nodes can not
+// outvote the honest ones that were asked first.
+func crossCheckFinality(nodes []*enode.Node, peers int, fetch func(*enode.Node) (*common.BeaconBlockHeader, error)) (*common.BeaconBlockHeader, error) {
+	if peers < 1 {
+		return nil, fmt.Errorf("invalid number of peers %d", peers)
+	}
+	var (
+		answers int
+		votes   = make(map[common.Root]int)
+	)
+	for _, node := range nodes {
+		header, err := fetch(node)
+		if err != nil {
+			continue
+		}
+		answers++
+		root := header.HashTreeRoot(tree.GetHashFn())
+		votes[root]++
+		if votes[root] >= peers && votes[root]*2 > answers {
+			return header, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d of %d peers answered", ErrCheckpointNotConfirmed, answers, len(nodes))
+}
@@ -0,0 +1,111 @@
+package beacon
+
+import (
+	"database/sql"
+	"errors"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/tree"
+	"github.com/stretchr/testify/require"
+)
+
+func newCheckpointStore(t *testing.T) *CheckpointStore {
+	db, err := sql.Open("sqlite3", path.Join(t.TempDir(), dbName))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	checkpoints, err := NewCheckpointStore(db)
+	require.NoError(t, err)
+	return checkpoints
+}
+
+func TestCheckpointStore(t *testing.T) {
+	checkpoints := newCheckpointStore(t)
+	_, _, err := checkpoints.Load()
+	require.ErrorIs(t, err, ErrNoCheckpoint)
+
+	require.NoError(t, checkpoints.Save(common.Root{1}, 100))
+	require.NoError(t, checkpoints.Save(common.Root{2}, 200))
+	root, slot, err := checkpoints.Load()
+	require.NoError(t, err)
+	require.Equal(t, common.Root{2}, root)
+	require.Equal(t, common.Slot(200), slot)
+}
+
+func TestWeakSubjectivityPeriod(t *testing.T) {
+	spec := configs.Mainnet
+	maxAge := Mainnet().MaxCheckpointAge
+	now := spec.TimeToSlot(common.Timestamp(time.Now().Unix()), common.Timestamp(BeaconGenesisTime))
+	require.True(t, isWithinWeakSubjectivityPeriod(spec, now, maxAge))
+	require.True(t, isWithinWeakSubjectivityPeriod(spec, now+10, maxAge))
+	period := common.Slot(maxAge / uint64(spec.SECONDS_PER_SLOT))
+	require.True(t, isWithinWeakSubjectivityPeriod(spec, now-period+1, maxAge))
+	require.False(t, isWithinWeakSubjectivityPeriod(spec, now-period-1, maxAge))
+}
+
+func TestCrossCheckFinality(t *testing.T) {
+	nodes := make([]*enode.Node, 6)
+	for i := range nodes {
+		nodes[i] = enode.SignNull(new(enr.Record), enode.ID{byte(i)})
+	}
+	honest := &common.BeaconBlockHeader{Slot: 64}
+	forged := &common.BeaconBlockHeader{Slot: 96}
+	fetcher := func(answers ...*common.BeaconBlockHeader) func(*enode.Node) (*common.BeaconBlockHeader, error) {
+		return func(n *enode.Node) (*common.BeaconBlockHeader, error) {
+			if header := answers[n.ID()[0]]; header != nil {
+				return header, nil
+			}
+			return nil, errors.New("timeout")
+		}
+	}
+
+	header, err := crossCheckFinality(nodes, 2, fetcher(nil, honest, nil, honest, forged, forged))
+	require.NoError(t, err)
+	require.Equal(t, honest, header)
+
+	// two forged answers first are outvoted before they reach the quorum
+	header, err = crossCheckFinality(nodes, 2, fetcher(forged, honest, honest, forged, honest, forged))
+	require.NoError(t, err)
+	require.Equal(t, honest, header)
+
+	_, err = crossCheckFinality(nodes, 3, fetcher(honest, forged, honest, forged, nil, nil))
+	require.ErrorIs(t, err, ErrCheckpointNotConfirmed)
+
+	_, err = crossCheckFinality(nodes, 0, fetcher())
+	require.Error(t, err)
+}
+
+func TestLightClientSyncer(t *testing.T) {
+	api, err := NewMockConsensusAPI("testdata/mockdata")
+	require.NoError(t, err)
+	checkpoint := common.Root(hexutil.MustDecode("0xc62aa0de55e6f21230fa63713715e1a6c13e73005e89f6389da271955d819bde"))
+
+	// the stored and default checkpoints are refused: the mock bootstrap is
+	// older than the weak subjectivity period and not the default checkpoint
+	bn := NewBeaconNetwork(nil)
+	checkpoints := newCheckpointStore(t)
+	require.NoError(t, checkpoints.Save(checkpoint, 0))
+	syncer := NewLightClientSyncer(api, bn, checkpoints, common.Root{}, 0)
+	require.Error(t, syncer.sync())
+	require.Nil(t, syncer.client)
+
+	// a user provided checkpoint is trusted regardless of its age
+	syncer = NewLightClientSyncer(api, bn, checkpoints, checkpoint, 0)
+	require.NoError(t, syncer.sync())
+	syncer.publish()
+
+	lightClient, err := bn.lightClientSnapshot()
+	require.NoError(t, err)
+	finalized := syncer.client.Store.FinalizedHeader
+	require.Equal(t, finalized, lightClient.Store.FinalizedHeader)
+	root, slot, err := checkpoints.Load()
+	require.NoError(t, err)
+	require.Equal(t, finalized.HashTreeRoot(tree.GetHashFn()), root)
+	require.Equal(t, finalized.Slot, slot)
+}
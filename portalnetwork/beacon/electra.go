@@ -0,0 +1,496 @@
+package beacon
+
+import (
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// The light client types of Electra are not part of zrnt yet. Electra keeps
+// the Deneb light client header and optimistic update, but the beacon state
+// grew past 32 fields, so every state proof is one level deeper: the finalized
+// root moves to gindex 169 and the sync committees to gindices 86 and 87.
+// Their index within the proof depth is the same as before Electra.
+const (
+	electraSyncCommitteeProofLen = 6
+	electraFinalizedRootProofLen = 7
+)
+
+type ElectraSyncCommitteeProofBranch [electraSyncCommitteeProofLen]common.Root
+
+func (sb *ElectraSyncCommitteeProofBranch) Deserialize(dr *codec.DecodingReader) error {
+	roots := sb[:]
+	return tree.ReadRoots(dr, &roots, electraSyncCommitteeProofLen)
+}
+
+func (sb ElectraSyncCommitteeProofBranch) Serialize(w *codec.EncodingWriter) error {
+	return tree.WriteRoots(w, sb[:])
+}
+
+func (sb ElectraSyncCommitteeProofBranch) ByteLength() uint64 {
+	return electraSyncCommitteeProofLen * 32
+}
+
+func (sb *ElectraSyncCommitteeProofBranch) FixedLength() uint64 {
+	return electraSyncCommitteeProofLen * 32
+}
+
+func (sb ElectraSyncCommitteeProofBranch) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.ComplexVectorHTR(func(i uint64) tree.HTR {
+		if i < electraSyncCommitteeProofLen {
+			return &sb[i]
+		}
+		return nil
+	}, electraSyncCommitteeProofLen)
+}
+
+type ElectraFinalizedRootProofBranch [electraFinalizedRootProofLen]common.Root
+
+func (fb *ElectraFinalizedRootProofBranch) Deserialize(dr *codec.DecodingReader) error {
+	roots := fb[:]
+	return tree.ReadRoots(dr, &roots, electraFinalizedRootProofLen)
+}
+
+func (fb ElectraFinalizedRootProofBranch) Serialize(w *codec.EncodingWriter) error {
+	return tree.WriteRoots(w, fb[:])
+}
+
+func (fb ElectraFinalizedRootProofBranch) ByteLength() uint64 {
+	return electraFinalizedRootProofLen * 32
+}
+
+func (fb *ElectraFinalizedRootProofBranch) FixedLength() uint64 {
+	return electraFinalizedRootProofLen * 32
+}
+
+func (fb ElectraFinalizedRootProofBranch) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.ComplexVectorHTR(func(i uint64) tree.HTR {
+		if i < electraFinalizedRootProofLen {
+			return &fb[i]
+		}
+		return nil
+	}, electraFinalizedRootProofLen)
+}
+
+type ElectraLightClientBootstrap struct {
+	Header                     deneb.LightClientHeader         `yaml:"header" json:"header"`
+	CurrentSyncCommittee       common.SyncCommittee            `yaml:"current_sync_committee" json:"current_sync_committee"`
+	CurrentSyncCommitteeBranch ElectraSyncCommitteeProofBranch `yaml:"current_sync_committee_branch" json:"current_sync_committee_branch"`
+}
+
+func (lcb *ElectraLightClientBootstrap) FixedLength(_ *common.Spec) uint64 {
+	return 0
+}
+
+func (lcb *ElectraLightClientBootstrap) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.Container(&lcb.Header, spec.Wrap(&lcb.CurrentSyncCommittee), &lcb.CurrentSyncCommitteeBranch)
+}
+
+func (lcb *ElectraLightClientBootstrap) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.Container(&lcb.Header, spec.Wrap(&lcb.CurrentSyncCommittee), &lcb.CurrentSyncCommitteeBranch)
+}
+
+func (lcb *ElectraLightClientBootstrap) ByteLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(&lcb.Header, spec.Wrap(&lcb.CurrentSyncCommittee), &lcb.CurrentSyncCommitteeBranch)
+}
+
+func (lcb *ElectraLightClientBootstrap) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&lcb.Header, spec.Wrap(&lcb.CurrentSyncCommittee), &lcb.CurrentSyncCommitteeBranch)
+}
+
+type ElectraLightClientUpdate struct {
+	AttestedHeader          deneb.LightClientHeader         `yaml:"attested_header" json:"attested_header"`
+	NextSyncCommittee       common.SyncCommittee            `yaml:"next_sync_committee" json:"next_sync_committee"`
+	NextSyncCommitteeBranch ElectraSyncCommitteeProofBranch `yaml:"next_sync_committee_branch" json:"next_sync_committee_branch"`
+	FinalizedHeader         deneb.LightClientHeader         `yaml:"finalized_header" json:"finalized_header"`
+	FinalityBranch          ElectraFinalizedRootProofBranch `yaml:"finality_branch" json:"finality_branch"`
+	SyncAggregate           altair.SyncAggregate            `yaml:"sync_aggregate" json:"sync_aggregate"`
+	SignatureSlot           common.Slot                     `yaml:"signature_slot" json:"signature_slot"`
+}
+
+func (lcu *ElectraLightClientUpdate) FixedLength(_ *common.Spec) uint64 {
+	return 0
+}
+
+func (lcu *ElectraLightClientUpdate) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.Container(
+		&lcu.AttestedHeader,
+		spec.Wrap(&lcu.NextSyncCommittee),
+		&lcu.NextSyncCommitteeBranch,
+		&lcu.FinalizedHeader,
+		&lcu.FinalityBranch,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *ElectraLightClientUpdate) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.Container(
+		&lcu.AttestedHeader,
+		spec.Wrap(&lcu.NextSyncCommittee),
+		&lcu.NextSyncCommitteeBranch,
+		&lcu.FinalizedHeader,
+		&lcu.FinalityBranch,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *ElectraLightClientUpdate) ByteLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(
+		&lcu.AttestedHeader,
+		spec.Wrap(&lcu.NextSyncCommittee),
+		&lcu.NextSyncCommitteeBranch,
+		&lcu.FinalizedHeader,
+		&lcu.FinalityBranch,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+func (lcu *ElectraLightClientUpdate) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(
+		&lcu.AttestedHeader,
+		spec.Wrap(&lcu.NextSyncCommittee),
+		&lcu.NextSyncCommitteeBranch,
+		&lcu.FinalizedHeader,
+		&lcu.FinalityBranch,
+		spec.Wrap(&lcu.SyncAggregate),
+		&lcu.SignatureSlot,
+	)
+}
+
+type ElectraLightClientFinalityUpdate struct {
+	AttestedHeader  deneb.LightClientHeader         `yaml:"attested_header" json:"attested_header"`
+	FinalizedHeader deneb.LightClientHeader         `yaml:"finalized_header" json:"finalized_header"`
+	FinalityBranch  ElectraFinalizedRootProofBranch `yaml:"finality_branch" json:"finality_branch"`
+	SyncAggregate   altair.SyncAggregate            `yaml:"sync_aggregate" json:"sync_aggregate"`
+	SignatureSlot   common.Slot                     `yaml:"signature_slot" json:"signature_slot"`
+}
+
+func (lcfu *ElectraLightClientFinalityUpdate) FixedLength(_ *common.Spec) uint64 {
+	return 0
+}
+
+func (lcfu *ElectraLightClientFinalityUpdate) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.Container(&lcfu.AttestedHeader, &lcfu.FinalizedHeader, &lcfu.FinalityBranch, spec.Wrap(&lcfu.SyncAggregate), &lcfu.SignatureSlot)
+}
+
+func (lcfu *ElectraLightClientFinalityUpdate) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.Container(&lcfu.AttestedHeader, &lcfu.FinalizedHeader, &lcfu.FinalityBranch, spec.Wrap(&lcfu.SyncAggregate), &lcfu.SignatureSlot)
+}
+
+func (lcfu *ElectraLightClientFinalityUpdate) ByteLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(&lcfu.AttestedHeader, &lcfu.FinalizedHeader, &lcfu.FinalityBranch, spec.Wrap(&lcfu.SyncAggregate), &lcfu.SignatureSlot)
+}
+
+func (lcfu *ElectraLightClientFinalityUpdate) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&lcfu.AttestedHeader, &lcfu.FinalizedHeader, &lcfu.FinalityBranch, spec.Wrap(&lcfu.SyncAggregate), &lcfu.SignatureSlot)
+}
+
+// Electra appends the balance churn and the queues of pending deposits,
+// withdrawals and consolidations to the Deneb beacon state. Only the mainnet
+// list limits are used.
+const (
+	pendingDepositsLimit           = 1 << 27
+	pendingPartialWithdrawalsLimit = 1 << 27
+	pendingConsolidationsLimit     = 1 << 18
+
+	pendingDepositSize           = 48 + 32 + 8 + 96 + 8
+	pendingPartialWithdrawalSize = 8 + 8 + 8
+	pendingConsolidationSize     = 8 + 8
+)
+
+type PendingDeposit struct {
+	Pubkey                common.BLSPubkey    `json:"pubkey" yaml:"pubkey"`
+	WithdrawalCredentials common.Root         `json:"withdrawal_credentials" yaml:"withdrawal_credentials"`
+	Amount                common.Gwei         `json:"amount" yaml:"amount"`
+	Signature             common.BLSSignature `json:"signature" yaml:"signature"`
+	Slot                  common.Slot         `json:"slot" yaml:"slot"`
+}
+
+func (d *PendingDeposit) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&d.Pubkey, &d.WithdrawalCredentials, &d.Amount, &d.Signature, &d.Slot)
+}
+
+func (d *PendingDeposit) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&d.Pubkey, &d.WithdrawalCredentials, &d.Amount, &d.Signature, &d.Slot)
+}
+
+func (d *PendingDeposit) ByteLength() uint64 {
+	return pendingDepositSize
+}
+
+func (d *PendingDeposit) FixedLength() uint64 {
+	return pendingDepositSize
+}
+
+func (d *PendingDeposit) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&d.Pubkey, &d.WithdrawalCredentials, &d.Amount, &d.Signature, &d.Slot)
+}
+
+type PendingDeposits []PendingDeposit
+
+func (li *PendingDeposits) Deserialize(dr *codec.DecodingReader) error {
+	return dr.List(func() codec.Deserializable {
+		i := len(*li)
+		*li = append(*li, PendingDeposit{})
+		return &(*li)[i]
+	}, pendingDepositSize, pendingDepositsLimit)
+}
+
+func (li PendingDeposits) Serialize(w *codec.EncodingWriter) error {
+	return w.List(func(i uint64) codec.Serializable {
+		return &li[i]
+	}, pendingDepositSize, uint64(len(li)))
+}
+
+func (li PendingDeposits) ByteLength() uint64 {
+	return uint64(len(li)) * pendingDepositSize
+}
+
+func (li *PendingDeposits) FixedLength() uint64 {
+	return 0
+}
+
+func (li PendingDeposits) HashTreeRoot(hFn tree.HashFn) common.Root {
+	length := uint64(len(li))
+	return hFn.ComplexListHTR(func(i uint64) tree.HTR {
+		if i < length {
+			return &li[i]
+		}
+		return nil
+	}, length, pendingDepositsLimit)
+}
+
+type PendingPartialWithdrawal struct {
+	ValidatorIndex    common.ValidatorIndex `json:"validator_index" yaml:"validator_index"`
+	Amount            common.Gwei           `json:"amount" yaml:"amount"`
+	WithdrawableEpoch common.Epoch          `json:"withdrawable_epoch" yaml:"withdrawable_epoch"`
+}
+
+func (pw *PendingPartialWithdrawal) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&pw.ValidatorIndex, &pw.Amount, &pw.WithdrawableEpoch)
+}
+
+func (pw *PendingPartialWithdrawal) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&pw.ValidatorIndex, &pw.Amount, &pw.WithdrawableEpoch)
+}
+
+func (pw *PendingPartialWithdrawal) ByteLength() uint64 {
+	return pendingPartialWithdrawalSize
+}
+
+func (pw *PendingPartialWithdrawal) FixedLength() uint64 {
+	return pendingPartialWithdrawalSize
+}
+
+func (pw *PendingPartialWithdrawal) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&pw.ValidatorIndex, &pw.Amount, &pw.WithdrawableEpoch)
+}
+
+type PendingPartialWithdrawals []PendingPartialWithdrawal
+
+func (li *PendingPartialWithdrawals) Deserialize(dr *codec.DecodingReader) error {
+	return dr.List(func() codec.Deserializable {
+		i := len(*li)
+		*li = append(*li, PendingPartialWithdrawal{})
+		return &(*li)[i]
+	}, pendingPartialWithdrawalSize, pendingPartialWithdrawalsLimit)
+}
+
+func (li PendingPartialWithdrawals) Serialize(w *codec.EncodingWriter) error {
+	return w.List(func(i uint64) codec.Serializable {
+		return &li[i]
+	}, pendingPartialWithdrawalSize, uint64(len(li)))
+}
+
+func (li PendingPartialWithdrawals) ByteLength() uint64 {
+	return uint64(len(li)) * pendingPartialWithdrawalSize
+}
+
+func (li *PendingPartialWithdrawals) FixedLength() uint64 {
+	return 0
+}
+
+func (li PendingPartialWithdrawals) HashTreeRoot(hFn tree.HashFn) common.Root {
+	length := uint64(len(li))
+	return hFn.ComplexListHTR(func(i uint64) tree.HTR {
+		if i < length {
+			return &li[i]
+		}
+		return nil
+	}, length, pendingPartialWithdrawalsLimit)
+}
+
+type PendingConsolidation struct {
+	SourceIndex common.ValidatorIndex `json:"source_index" yaml:"source_index"`
+	TargetIndex common.ValidatorIndex `json:"target_index" yaml:"target_index"`
+}
+
+func (c *PendingConsolidation) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&c.SourceIndex, &c.TargetIndex)
+}
+
+func (c *PendingConsolidation) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&c.SourceIndex, &c.TargetIndex)
+}
+
+func (c *PendingConsolidation) ByteLength() uint64 {
+	return pendingConsolidationSize
+}
+
+func (c *PendingConsolidation) FixedLength() uint64 {
+	return pendingConsolidationSize
+}
+
+func (c *PendingConsolidation) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&c.SourceIndex, &c.TargetIndex)
+}
+
+type PendingConsolidations []PendingConsolidation
+
+func (li *PendingConsolidations) Deserialize(dr *codec.DecodingReader) error {
+	return dr.List(func() codec.Deserializable {
+		i := len(*li)
+		*li = append(*li, PendingConsolidation{})
+		return &(*li)[i]
+	}, pendingConsolidationSize, pendingConsolidationsLimit)
+}
+
+func (li PendingConsolidations) Serialize(w *codec.EncodingWriter) error {
+	return w.List(func(i uint64) codec.Serializable {
+		return &li[i]
+	}, pendingConsolidationSize, uint64(len(li)))
+}
+
+func (li PendingConsolidations) ByteLength() uint64 {
+	return uint64(len(li)) * pendingConsolidationSize
+}
+
+func (li *PendingConsolidations) FixedLength() uint64 {
+	return 0
+}
+
+func (li PendingConsolidations) HashTreeRoot(hFn tree.HashFn) common.Root {
+	length := uint64(len(li))
+	return hFn.ComplexListHTR(func(i uint64) tree.HTR {
+		if i < length {
+			return &li[i]
+		}
+		return nil
+	}, length, pendingConsolidationsLimit)
+}
+
+// ElectraBeaconState is the Deneb beacon state followed by the fields added in
+// Electra.
+type ElectraBeaconState struct {
+	deneb.BeaconState
+	DepositRequestsStartIndex     common.DepositIndex       `json:"deposit_requests_start_index" yaml:"deposit_requests_start_index"`
+	DepositBalanceToConsume       common.Gwei               `json:"deposit_balance_to_consume" yaml:"deposit_balance_to_consume"`
+	ExitBalanceToConsume          common.Gwei               `json:"exit_balance_to_consume" yaml:"exit_balance_to_consume"`
+	EarliestExitEpoch             common.Epoch              `json:"earliest_exit_epoch" yaml:"earliest_exit_epoch"`
+	ConsolidationBalanceToConsume common.Gwei               `json:"consolidation_balance_to_consume" yaml:"consolidation_balance_to_consume"`
+	EarliestConsolidationEpoch    common.Epoch              `json:"earliest_consolidation_epoch" yaml:"earliest_consolidation_epoch"`
+	PendingDeposits               PendingDeposits           `json:"pending_deposits" yaml:"pending_deposits"`
+	PendingPartialWithdrawals     PendingPartialWithdrawals `json:"pending_partial_withdrawals" yaml:"pending_partial_withdrawals"`
+	PendingConsolidations         PendingConsolidations     `json:"pending_consolidations" yaml:"pending_consolidations"`
+}
+
+func (v *ElectraBeaconState) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.Container(&v.GenesisTime, &v.GenesisValidatorsRoot,
+		&v.Slot, &v.Fork, &v.LatestBlockHeader,
+		spec.Wrap(&v.BlockRoots), spec.Wrap(&v.StateRoots), spec.Wrap(&v.HistoricalRoots),
+		&v.Eth1Data, spec.Wrap(&v.Eth1DataVotes), &v.Eth1DepositIndex,
+		spec.Wrap(&v.Validators), spec.Wrap(&v.Balances),
+		spec.Wrap(&v.RandaoMixes), spec.Wrap(&v.Slashings),
+		spec.Wrap(&v.PreviousEpochParticipation), spec.Wrap(&v.CurrentEpochParticipation),
+		&v.JustificationBits,
+		&v.PreviousJustifiedCheckpoint, &v.CurrentJustifiedCheckpoint,
+		&v.FinalizedCheckpoint,
+		spec.Wrap(&v.InactivityScores),
+		spec.Wrap(&v.CurrentSyncCommittee), spec.Wrap(&v.NextSyncCommittee),
+		&v.LatestExecutionPayloadHeader,
+		&v.NextWithdrawalIndex, &v.NextWithdrawalValidatorIndex,
+		spec.Wrap(&v.HistoricalSummaries),
+		&v.DepositRequestsStartIndex, &v.DepositBalanceToConsume,
+		&v.ExitBalanceToConsume, &v.EarliestExitEpoch,
+		&v.ConsolidationBalanceToConsume, &v.EarliestConsolidationEpoch,
+		&v.PendingDeposits, &v.PendingPartialWithdrawals, &v.PendingConsolidations,
+	)
+}
+
+func (v *ElectraBeaconState) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.Container(&v.GenesisTime, &v.GenesisValidatorsRoot,
+		&v.Slot, &v.Fork, &v.LatestBlockHeader,
+		spec.Wrap(&v.BlockRoots), spec.Wrap(&v.StateRoots), spec.Wrap(&v.HistoricalRoots),
+		&v.Eth1Data, spec.Wrap(&v.Eth1DataVotes), &v.Eth1DepositIndex,
+		spec.Wrap(&v.Validators), spec.Wrap(&v.Balances),
+		spec.Wrap(&v.RandaoMixes), spec.Wrap(&v.Slashings),
+		spec.Wrap(&v.PreviousEpochParticipation), spec.Wrap(&v.CurrentEpochParticipation),
+		&v.JustificationBits,
+		&v.PreviousJustifiedCheckpoint, &v.CurrentJustifiedCheckpoint,
+		&v.FinalizedCheckpoint,
+		spec.Wrap(&v.InactivityScores),
+		spec.Wrap(&v.CurrentSyncCommittee), spec.Wrap(&v.NextSyncCommittee),
+		&v.LatestExecutionPayloadHeader,
+		&v.NextWithdrawalIndex, &v.NextWithdrawalValidatorIndex,
+		spec.Wrap(&v.HistoricalSummaries),
+		&v.DepositRequestsStartIndex, &v.DepositBalanceToConsume,
+		&v.ExitBalanceToConsume, &v.EarliestExitEpoch,
+		&v.ConsolidationBalanceToConsume, &v.EarliestConsolidationEpoch,
+		&v.PendingDeposits, &v.PendingPartialWithdrawals, &v.PendingConsolidations,
+	)
+}
+
+func (v *ElectraBeaconState) ByteLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(&v.GenesisTime, &v.GenesisValidatorsRoot,
+		&v.Slot, &v.Fork, &v.LatestBlockHeader,
+		spec.Wrap(&v.BlockRoots), spec.Wrap(&v.StateRoots), spec.Wrap(&v.HistoricalRoots),
+		&v.Eth1Data, spec.Wrap(&v.Eth1DataVotes), &v.Eth1DepositIndex,
+		spec.Wrap(&v.Validators), spec.Wrap(&v.Balances),
+		spec.Wrap(&v.RandaoMixes), spec.Wrap(&v.Slashings),
+		spec.Wrap(&v.PreviousEpochParticipation), spec.Wrap(&v.CurrentEpochParticipation),
+		&v.JustificationBits,
+		&v.PreviousJustifiedCheckpoint, &v.CurrentJustifiedCheckpoint,
+		&v.FinalizedCheckpoint,
+		spec.Wrap(&v.InactivityScores),
+		spec.Wrap(&v.CurrentSyncCommittee), spec.Wrap(&v.NextSyncCommittee),
+		&v.LatestExecutionPayloadHeader,
+		&v.NextWithdrawalIndex, &v.NextWithdrawalValidatorIndex,
+		spec.Wrap(&v.HistoricalSummaries),
+		&v.DepositRequestsStartIndex, &v.DepositBalanceToConsume,
+		&v.ExitBalanceToConsume, &v.EarliestExitEpoch,
+		&v.ConsolidationBalanceToConsume, &v.EarliestConsolidationEpoch,
+		&v.PendingDeposits, &v.PendingPartialWithdrawals, &v.PendingConsolidations,
+	)
+}
+
+func (*ElectraBeaconState) FixedLength(*common.Spec) uint64 {
+	return 0
+}
+
+func (v *ElectraBeaconState) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&v.GenesisTime, &v.GenesisValidatorsRoot,
+		&v.Slot, &v.Fork, &v.LatestBlockHeader,
+		spec.Wrap(&v.BlockRoots), spec.Wrap(&v.StateRoots), spec.Wrap(&v.HistoricalRoots),
+		&v.Eth1Data, spec.Wrap(&v.Eth1DataVotes), &v.Eth1DepositIndex,
+		spec.Wrap(&v.Validators), spec.Wrap(&v.Balances),
+		spec.Wrap(&v.RandaoMixes), spec.Wrap(&v.Slashings),
+		spec.Wrap(&v.PreviousEpochParticipation), spec.Wrap(&v.CurrentEpochParticipation),
+		&v.JustificationBits,
+		&v.PreviousJustifiedCheckpoint, &v.CurrentJustifiedCheckpoint,
+		&v.FinalizedCheckpoint,
+		spec.Wrap(&v.InactivityScores),
+		spec.Wrap(&v.CurrentSyncCommittee), spec.Wrap(&v.NextSyncCommittee),
+		&v.LatestExecutionPayloadHeader,
+		&v.NextWithdrawalIndex, &v.NextWithdrawalValidatorIndex,
+		spec.Wrap(&v.HistoricalSummaries),
+		&v.DepositRequestsStartIndex, &v.DepositBalanceToConsume,
+		&v.ExitBalanceToConsume, &v.EarliestExitEpoch,
+		&v.ConsolidationBalanceToConsume, &v.EarliestConsolidationEpoch,
+		&v.PendingDeposits, &v.PendingPartialWithdrawals, &v.PendingConsolidations,
+	)
+}
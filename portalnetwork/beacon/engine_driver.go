@@ -0,0 +1,177 @@
+package beacon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/beacon/engine"
+	gethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const engineCallTimeout = 5 * time.Second
+
+// BlockSource provides the execution blocks that are passed to the execution
+// client, the history network is one.
+type BlockSource interface {
+	GetBlockHeader(blockHash []byte) (*types.Header, error)
+	GetBlockBody(blockHash []byte) (*types.Body, error)
+}
+
+// EngineDriver drives an execution client through the engine API like
+// blsync does, following the head of the light client. Every new head is
+// passed to engine_newPayload if its block can be fetched from the block
+// source, engine_forkchoiceUpdated then moves the head and the finalized
+// block of the execution client. A head whose payload can not be provided is
+// still set as the head, the execution client syncs it from its own peers.
+type EngineDriver struct {
+	engine    *rpc.Client
+	blocks    BlockSource
+	forks     *ForkSchedule
+	log       log.Logger
+	closeCtx  context.Context
+	closeFunc context.CancelFunc
+}
+
+// NewEngineDriver creates a driver that calls the engine API through an
+// authenticated client, blocks may be nil.
+func NewEngineDriver(engine *rpc.Client, blocks BlockSource) *EngineDriver {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EngineDriver{
+		engine:    engine,
+		blocks:    blocks,
+		forks:     MainnetForks,
+		log:       log.New("sub-protocol", "beacon", "component", "engine"),
+		closeCtx:  ctx,
+		closeFunc: cancel,
+	}
+}
+
+// Start follows the heads of the light client kept by syncer.
+func (d *EngineDriver) Start(syncer *LightClientSyncer) {
+	headCh := make(chan HeadEvent, 16)
+	sub := syncer.SubscribeHead(headCh)
+	go d.loop(headCh, sub)
+}
+
+func (d *EngineDriver) Stop() {
+	d.closeFunc()
+}
+
+func (d *EngineDriver) loop(headCh <-chan HeadEvent, sub event.Subscription) {
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-d.closeCtx.Done():
+			return
+		case head := <-headCh:
+			d.update(head)
+		}
+	}
+}
+
+// update hands a new head to the execution client.
+func (d *EngineDriver) update(head HeadEvent) {
+	fork := d.forks.AtSlot(head.Beacon.Slot)
+	hash := gethcommon.Hash(head.Execution.BlockHash)
+	if d.blocks != nil {
+		if status, err := d.newPayload(fork.Name, head); err != nil {
+			d.log.Warn("Failed NewPayload", "number", head.Execution.BlockNumber, "hash", hash, "err", err)
+		} else {
+			d.log.Info("Successful NewPayload", "number", head.Execution.BlockNumber, "hash", hash, "status", status)
+		}
+	}
+	if status, err := d.forkchoiceUpdated(fork.Name, head); err != nil {
+		d.log.Warn("Failed ForkchoiceUpdated", "head", hash, "err", err)
+	} else {
+		d.log.Info("Successful ForkchoiceUpdated", "head", hash, "status", status)
+	}
+}
+
+func (d *EngineDriver) newPayload(fork string, head HeadEvent) (string, error) {
+	block, err := d.block(gethcommon.Hash(head.Execution.BlockHash))
+	if err != nil {
+		return "", err
+	}
+	var (
+		method string
+		params = []any{engine.BlockToExecutableData(block, nil, nil, nil).ExecutionPayload}
+	)
+	switch fork {
+	case "electra":
+		// The execution requests are not part of the block body, only their
+		// hash is committed to in the header.
+		return "", errors.New("electra payloads can not be built from the history network")
+	case "deneb":
+		method = "engine_newPayloadV3"
+		params = append(params, blobHashes(block), gethcommon.Hash(head.Beacon.ParentRoot))
+	case "capella":
+		method = "engine_newPayloadV2"
+	default:
+		method = "engine_newPayloadV1"
+	}
+	ctx, cancel := context.WithTimeout(d.closeCtx, engineCallTimeout)
+	defer cancel()
+	var resp engine.PayloadStatusV1
+	if err = d.engine.CallContext(ctx, &resp, method, params...); err != nil {
+		return "", err
+	}
+	if resp.Status == engine.INVALID {
+		return resp.Status, fmt.Errorf("invalid payload: %v", resp.ValidationError)
+	}
+	return resp.Status, nil
+}
+
+func (d *EngineDriver) forkchoiceUpdated(fork string, head HeadEvent) (string, error) {
+	update := engine.ForkchoiceStateV1{
+		HeadBlockHash:      gethcommon.Hash(head.Execution.BlockHash),
+		SafeBlockHash:      gethcommon.Hash(head.Finalized.BlockHash),
+		FinalizedBlockHash: gethcommon.Hash(head.Finalized.BlockHash),
+	}
+	var method string
+	switch fork {
+	case "electra", "deneb":
+		method = "engine_forkchoiceUpdatedV3"
+	case "capella":
+		method = "engine_forkchoiceUpdatedV2"
+	default:
+		method = "engine_forkchoiceUpdatedV1"
+	}
+	ctx, cancel := context.WithTimeout(d.closeCtx, engineCallTimeout)
+	defer cancel()
+	var resp engine.ForkChoiceResponse
+	if err := d.engine.CallContext(ctx, &resp, method, update, nil); err != nil {
+		return "", err
+	}
+	return resp.PayloadStatus.Status, nil
+}
+
+// block fetches the execution block of hash from the block source.
+func (d *EngineDriver) block(hash gethcommon.Hash) (*types.Block, error) {
+	header, err := d.blocks.GetBlockHeader(hash[:])
+	if err != nil {
+		return nil, err
+	}
+	body, err := d.blocks.GetBlockBody(hash[:])
+	if err != nil {
+		return nil, err
+	}
+	block := types.NewBlockWithHeader(header).WithBody(*body)
+	if block.Hash() != hash {
+		return nil, fmt.Errorf("block hash %s does not match the head %s", block.Hash(), hash)
+	}
+	return block, nil
+}
+
+func blobHashes(block *types.Block) []gethcommon.Hash {
+	hashes := make([]gethcommon.Hash, 0)
+	for _, tx := range block.Transactions() {
+		hashes = append(hashes, tx.BlobHashes()...)
+	}
+	return hashes
+}
@@ -0,0 +1,121 @@
+package beacon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	beaconConsensus "github.com/ethereum/go-ethereum/consensus/beacon"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/eth/catalyst"
+	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/stretchr/testify/require"
+)
+
+type blockMap map[gethcommon.Hash]*types.Block
+
+func (m blockMap) GetBlockHeader(blockHash []byte) (*types.Header, error) {
+	if block, ok := m[gethcommon.BytesToHash(blockHash)]; ok {
+		return block.Header(), nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (m blockMap) GetBlockBody(blockHash []byte) (*types.Body, error) {
+	if block, ok := m[gethcommon.BytesToHash(blockHash)]; ok {
+		return block.Body(), nil
+	}
+	return nil, errors.New("not found")
+}
+
+// startEngine starts an execution client that serves the engine API behind
+// JWT authentication.
+func startEngine(t *testing.T, genesis *core.Genesis, jwtSecret [32]byte) (*node.Node, *eth.Ethereum) {
+	secretFile := filepath.Join(t.TempDir(), "jwtsecret")
+	require.NoError(t, os.WriteFile(secretFile, []byte(hexutil.Encode(jwtSecret[:])), 0600))
+	n, err := node.New(&node.Config{
+		AuthAddr:  "127.0.0.1",
+		JWTSecret: secretFile,
+		P2P: p2p.Config{
+			ListenAddr:  "127.0.0.1:0",
+			NoDiscovery: true,
+		},
+	})
+	require.NoError(t, err)
+	ethservice, err := eth.New(n, &ethconfig.Config{Genesis: genesis, SyncMode: downloader.FullSync, TrieTimeout: time.Minute, TrieDirtyCache: 256, TrieCleanCache: 256})
+	require.NoError(t, err)
+	require.NoError(t, catalyst.Register(n, ethservice))
+	require.NoError(t, n.Start())
+	t.Cleanup(func() { n.Close() })
+	ethservice.SetSynced()
+	return n, ethservice
+}
+
+func TestEngineDriver(t *testing.T) {
+	config := *params.MergedTestChainConfig
+	config.PragueTime = nil
+	genesis := &core.Genesis{Config: &config, Difficulty: gethcommon.Big0, Timestamp: 9000, GasLimit: params.GenesisGasLimit}
+	_, blocks, _ := core.GenerateChainWithGenesis(genesis, beaconConsensus.NewFaker(), 3, nil)
+
+	var jwtSecret [32]byte
+	jwtSecret[0] = 1
+	n, ethservice := startEngine(t, genesis, jwtSecret)
+	engineClient, err := rpc.DialOptions(context.Background(), n.HTTPAuthEndpoint(), rpc.WithHTTPAuth(node.NewJWTAuth(jwtSecret)))
+	require.NoError(t, err)
+	defer engineClient.Close()
+
+	denebSlot := common.Slot(configs.Mainnet.DENEB_FORK_EPOCH) * configs.Mainnet.SLOTS_PER_EPOCH
+	headOf := func(block *types.Block) HeadEvent {
+		return HeadEvent{
+			Beacon:    common.BeaconBlockHeader{Slot: denebSlot + common.Slot(block.NumberU64()), ParentRoot: common.Root(*block.BeaconRoot())},
+			Execution: GenericExecution{BlockHash: common.Hash32(block.Hash()), BlockNumber: block.NumberU64()},
+			Finalized: GenericExecution{BlockHash: common.Hash32(ethservice.BlockChain().Genesis().Hash())},
+		}
+	}
+
+	// the payloads are taken from the block source and set as the head
+	source := blockMap{}
+	for _, block := range blocks {
+		source[block.Hash()] = block
+	}
+	driver := NewEngineDriver(engineClient, source)
+	defer driver.Stop()
+	for _, block := range blocks[:2] {
+		status, err := driver.newPayload("deneb", headOf(block))
+		require.NoError(t, err)
+		require.Equal(t, "VALID", status)
+		status, err = driver.forkchoiceUpdated("deneb", headOf(block))
+		require.NoError(t, err)
+		require.Equal(t, "VALID", status)
+		require.Equal(t, block.Hash(), ethservice.BlockChain().CurrentBlock().Hash())
+	}
+
+	// a head that the block source does not know is only announced
+	delete(source, blocks[2].Hash())
+	_, err = driver.newPayload("deneb", headOf(blocks[2]))
+	require.Error(t, err)
+	status, err := driver.forkchoiceUpdated("deneb", headOf(blocks[2]))
+	require.NoError(t, err)
+	require.Equal(t, "SYNCING", status)
+
+	// the engine API refuses calls with a different secret
+	unauthorized, err := rpc.DialOptions(context.Background(), n.HTTPAuthEndpoint(), rpc.WithHTTPAuth(node.NewJWTAuth([32]byte{2})))
+	require.NoError(t, err)
+	defer unauthorized.Close()
+	_, err = NewEngineDriver(unauthorized, nil).forkchoiceUpdated("deneb", headOf(blocks[1]))
+	require.Error(t, err)
+}
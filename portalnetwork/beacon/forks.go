@@ -0,0 +1,89 @@
+package beacon
+
+import (
+	"fmt"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Fork is a consensus fork of a chain, identified in portal content by its
+// digest.
+type Fork struct {
+	Name    string
+	Version common.Version
+	Epoch   common.Epoch
+	Digest  common.ForkDigest
+}
+
+// ForkSchedule is the list of forks of a chain in activation order. Every
+// piece of light client content is prefixed with the digest of the fork
+// active at its slot, the schedule maps between slots, digests and the fork
+// names used by the beacon-API.
+type ForkSchedule struct {
+	spec  *common.Spec
+	forks []Fork
+}
+
+// NewForkSchedule derives the fork schedule from the fork versions and epochs
+// of the spec. Forks that are not part of the spec yet are taken from the
+// chain config, a fork without version is not scheduled.
+func NewForkSchedule(spec *common.Spec, chain ChainConfig) *ForkSchedule {
+	forks := []Fork{
+		{Name: "phase0", Version: spec.GENESIS_FORK_VERSION, Epoch: common.GENESIS_EPOCH},
+		{Name: "altair", Version: spec.ALTAIR_FORK_VERSION, Epoch: spec.ALTAIR_FORK_EPOCH},
+		{Name: "bellatrix", Version: spec.BELLATRIX_FORK_VERSION, Epoch: spec.BELLATRIX_FORK_EPOCH},
+		{Name: "capella", Version: spec.CAPELLA_FORK_VERSION, Epoch: spec.CAPELLA_FORK_EPOCH},
+		{Name: "deneb", Version: spec.DENEB_FORK_VERSION, Epoch: spec.DENEB_FORK_EPOCH},
+	}
+	if chain.ElectraForkVersion != (common.Version{}) {
+		forks = append(forks, Fork{Name: "electra", Version: chain.ElectraForkVersion, Epoch: chain.ElectraForkEpoch})
+	}
+	for i := range forks {
+		forks[i].Digest = common.ComputeForkDigest(forks[i].Version, chain.GenesisRoot)
+	}
+	return &ForkSchedule{spec: spec, forks: forks}
+}
+
+// AtEpoch returns the fork active at the given epoch.
+func (s *ForkSchedule) AtEpoch(epoch common.Epoch) Fork {
+	for i := len(s.forks) - 1; i > 0; i-- {
+		if epoch >= s.forks[i].Epoch {
+			return s.forks[i]
+		}
+	}
+	return s.forks[0]
+}
+
+// AtSlot returns the fork active at the given slot.
+func (s *ForkSchedule) AtSlot(slot common.Slot) Fork {
+	return s.AtEpoch(s.spec.SlotToEpoch(slot))
+}
+
+// ByDigest returns the fork identified by digest.
+func (s *ForkSchedule) ByDigest(digest common.ForkDigest) (Fork, error) {
+	for _, fork := range s.forks {
+		if fork.Digest == digest {
+			return fork, nil
+		}
+	}
+	return Fork{}, fmt.Errorf("unknown fork digest %s", digest)
+}
+
+// ByName returns the fork with the given beacon-API name.
+func (s *ForkSchedule) ByName(name string) (Fork, error) {
+	for _, fork := range s.forks {
+		if fork.Name == name {
+			return fork, nil
+		}
+	}
+	return Fork{}, fmt.Errorf("unknown fork %q", name)
+}
+
+// CheckDigest returns an error if digest is not the digest of the fork
+// active at slot.
+func (s *ForkSchedule) CheckDigest(digest common.ForkDigest, slot common.Slot) error {
+	if fork := s.AtSlot(slot); fork.Digest != digest {
+		return fmt.Errorf("fork digest %s does not match the %s fork active at slot %d", digest, fork.Name, slot)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package beacon
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMainnetForkDigests(t *testing.T) {
+	for name, digest := range map[string]string{
+		"altair":    "0xafcaaba0",
+		"bellatrix": "0x4a26c58b",
+		"capella":   "0xbba4da96",
+		"deneb":     "0x6a95a1a9",
+		"electra":   "0xad532ceb",
+	} {
+		fork, err := MainnetForks.ByName(name)
+		require.NoError(t, err)
+		require.Equal(t, digest, fork.Digest.String(), name)
+	}
+
+	slotsPerEpoch := configs.Mainnet.SLOTS_PER_EPOCH
+	require.Equal(t, "deneb", MainnetForks.AtSlot(364032*slotsPerEpoch-1).Name)
+	require.Equal(t, "electra", MainnetForks.AtSlot(364032*slotsPerEpoch).Name)
+	require.Equal(t, "phase0", MainnetForks.AtEpoch(0).Name)
+	require.NoError(t, MainnetForks.CheckDigest(Capella, 194048*slotsPerEpoch))
+	require.Error(t, MainnetForks.CheckDigest(Deneb, 194048*slotsPerEpoch))
+}
+
+func TestElectraLightClientBootstrap(t *testing.T) {
+	// Build a bootstrap whose current sync committee is proven at gindex 86 of
+	// the header state root.
+	bootstrap := &ElectraLightClientBootstrap{}
+	bootstrap.Header.Beacon.Slot = 364032 * configs.Mainnet.SLOTS_PER_EPOCH
+	bootstrap.CurrentSyncCommittee.Pubkeys = make([]common.BLSPubkey, configs.Mainnet.SYNC_COMMITTEE_SIZE)
+	for i := range bootstrap.CurrentSyncCommitteeBranch {
+		bootstrap.CurrentSyncCommitteeBranch[i] = common.Root{byte(i + 1)}
+	}
+	hFn := tree.GetHashFn()
+	node := bootstrap.CurrentSyncCommittee.HashTreeRoot(configs.Mainnet, hFn)
+	for i, sibling := range bootstrap.CurrentSyncCommitteeBranch {
+		if (22>>i)&1 == 1 {
+			node = hFn(sibling, node)
+		} else {
+			node = hFn(node, sibling)
+		}
+	}
+	bootstrap.Header.Beacon.StateRoot = node
+
+	var buf bytes.Buffer
+	forked := &ForkedLightClientBootstrap{ForkDigest: Electra, Bootstrap: bootstrap}
+	require.NoError(t, forked.Serialize(configs.Mainnet, codec.NewEncodingWriter(&buf)))
+	var decoded ForkedLightClientBootstrap
+	require.NoError(t, decoded.Deserialize(configs.Mainnet, codec.NewDecodingReader(bytes.NewReader(buf.Bytes()), uint64(buf.Len()))))
+	require.Equal(t, bootstrap, decoded.Bootstrap)
+
+	generic, err := FromBootstrap(decoded.Bootstrap)
+	require.NoError(t, err)
+	require.Len(t, generic.CurrentSyncCommitteeBranch, 6)
+	require.True(t, IsCurrentCommitteeProofValid(configs.Mainnet, *generic.Header, generic.CurrentSyncCommittee, generic.CurrentSyncCommitteeBranch))
+	// the pre-Electra depth does not reach the state root
+	require.False(t, IsCurrentCommitteeProofValid(configs.Mainnet, *generic.Header, generic.CurrentSyncCommittee, generic.CurrentSyncCommitteeBranch[:5]))
+	require.NoError(t, MainnetForks.CheckDigest(decoded.ForkDigest, generic.Header.Slot))
+}
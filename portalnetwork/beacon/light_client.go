@@ -3,6 +3,7 @@ package beacon
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
@@ -26,6 +27,7 @@ var (
 	ErrNotRelevant                   = errors.New("update not relevant")
 	ErrInvalidFinalityProof          = errors.New("invalid finality proof")
 	ErrInvalidNextSyncCommitteeProof = errors.New("invalid next sync committee proof")
+	ErrInvalidExecutionProof         = errors.New("invalid execution payload proof")
 	ErrInvalidSignature              = errors.New("invalid sync committee signature")
 )
 
@@ -43,6 +45,8 @@ type LightClientStore struct {
 	CurrentSyncCommittee          *common.SyncCommittee
 	NextSyncCommittee             *common.SyncCommittee
 	OptimisticHeader              *common.BeaconBlockHeader
+	FinalizedExecution            *GenericExecution
+	OptimisticExecution           *GenericExecution
 	PreviousMaxActiveParticipants view.Uint64View
 	CurrentMaxActiveParticipants  view.Uint64View
 }
@@ -54,6 +58,14 @@ type ConsensusLightClient struct {
 	LastCheckpoint    common.Root
 	Config            *Config
 	Logger            log.Logger
+
+	// forks is the fork schedule of the config, it selects the fork version
+	// sync committee signatures are checked with.
+	forks *ForkSchedule
+
+	// lock guards the store and the last checkpoint, they are written by the
+	// goroutine that applies updates while snapshots are taken.
+	lock sync.RWMutex
 }
 
 type Config struct {
@@ -74,43 +86,85 @@ type ChainConfig struct {
 	ChainID     uint64
 	GenesisTime uint64
 	GenesisRoot common.Root
+	// The spec predates Electra, its schedule is part of the chain config.
+	ElectraForkVersion common.Version
+	ElectraForkEpoch   common.Epoch
+}
+
+// GenericExecution is the execution payload header that a Capella or later
+// light client header proves against the body root of its beacon block.
+type GenericExecution struct {
+	BlockHash   common.Hash32
+	BlockNumber uint64
+	Root        common.Root
+	Branch      []common.Root
+}
+
+func fromCapellaHeader(header *capella.LightClientHeader) *GenericExecution {
+	return &GenericExecution{
+		BlockHash:   header.Execution.BlockHash,
+		BlockNumber: uint64(header.Execution.BlockNumber),
+		Root:        header.Execution.HashTreeRoot(tree.GetHashFn()),
+		Branch:      header.ExecutionBranch[:],
+	}
+}
+
+func fromDenebHeader(header *deneb.LightClientHeader) *GenericExecution {
+	return &GenericExecution{
+		BlockHash:   header.Execution.BlockHash,
+		BlockNumber: uint64(header.Execution.BlockNumber),
+		Root:        header.Execution.HashTreeRoot(tree.GetHashFn()),
+		Branch:      header.ExecutionBranch[:],
+	}
 }
 
 type GenericUpdate struct {
 	AttestedHeader          *common.BeaconBlockHeader
+	AttestedExecution       *GenericExecution
 	SyncAggregate           *altair.SyncAggregate
 	SignatureSlot           common.Slot
 	NextSyncCommittee       *common.SyncCommittee
-	NextSyncCommitteeBranch *altair.SyncCommitteeProofBranch
+	NextSyncCommitteeBranch []common.Root
 	FinalizedHeader         *common.BeaconBlockHeader
-	FinalityBranch          *altair.FinalizedRootProofBranch
+	FinalizedExecution      *GenericExecution
+	FinalityBranch          []common.Root
 }
 
 type GenericBootstrap struct {
 	Header                     *common.BeaconBlockHeader
+	Execution                  *GenericExecution
 	CurrentSyncCommittee       common.SyncCommittee
-	CurrentSyncCommitteeBranch altair.SyncCommitteeProofBranch
+	CurrentSyncCommitteeBranch []common.Root
 }
 
 func FromBootstrap(commonBootstrap common.SpecObj) (*GenericBootstrap, error) {
 	switch bootstrap := commonBootstrap.(type) {
+	case *ElectraLightClientBootstrap:
+		return &GenericBootstrap{
+			Header:                     &bootstrap.Header.Beacon,
+			Execution:                  fromDenebHeader(&bootstrap.Header),
+			CurrentSyncCommittee:       bootstrap.CurrentSyncCommittee,
+			CurrentSyncCommitteeBranch: bootstrap.CurrentSyncCommitteeBranch[:],
+		}, nil
 	case *deneb.LightClientBootstrap:
 		return &GenericBootstrap{
 			Header:                     &bootstrap.Header.Beacon,
+			Execution:                  fromDenebHeader(&bootstrap.Header),
 			CurrentSyncCommittee:       bootstrap.CurrentSyncCommittee,
-			CurrentSyncCommitteeBranch: bootstrap.CurrentSyncCommitteeBranch,
+			CurrentSyncCommitteeBranch: bootstrap.CurrentSyncCommitteeBranch[:],
 		}, nil
 	case *capella.LightClientBootstrap:
 		return &GenericBootstrap{
 			Header:                     &bootstrap.Header.Beacon,
+			Execution:                  fromCapellaHeader(&bootstrap.Header),
 			CurrentSyncCommittee:       bootstrap.CurrentSyncCommittee,
-			CurrentSyncCommitteeBranch: bootstrap.CurrentSyncCommitteeBranch,
+			CurrentSyncCommitteeBranch: bootstrap.CurrentSyncCommitteeBranch[:],
 		}, nil
 	case *altair.LightClientBootstrap:
 		return &GenericBootstrap{
 			Header:                     &bootstrap.Header.Beacon,
 			CurrentSyncCommittee:       bootstrap.CurrentSyncCommittee,
-			CurrentSyncCommitteeBranch: bootstrap.CurrentSyncCommitteeBranch,
+			CurrentSyncCommitteeBranch: bootstrap.CurrentSyncCommitteeBranch[:],
 		}, nil
 	}
 	return nil, errors.New("unknown bootstrap type")
@@ -122,6 +176,7 @@ func NewConsensusLightClient(api ConsensusAPI, config *Config, checkpointBlockRo
 		Config:            config,
 		Logger:            logger,
 		InitialCheckpoint: checkpointBlockRoot,
+		forks:             NewForkSchedule(config.Spec, config.Chain),
 	}
 
 	err := client.bootstrap()
@@ -257,6 +312,10 @@ func (c *ConsensusLightClient) bootstrap() error {
 		}
 	}
 
+	if bootstrap.Execution != nil && !IsExecutionProofValid(c.Config.Spec, *bootstrap.Header, bootstrap.Execution) {
+		return ErrInvalidExecutionProof
+	}
+
 	committeeValid := c.isCurrentCommitteeProofValid(*bootstrap.Header, bootstrap.CurrentSyncCommittee, bootstrap.CurrentSyncCommitteeBranch)
 
 	headerHash := bootstrap.Header.HashTreeRoot(tree.GetHashFn()).String()
@@ -272,10 +331,14 @@ func (c *ConsensusLightClient) bootstrap() error {
 		return errors.New("committee proof is invalid")
 	}
 
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	c.Store = LightClientStore{
 		FinalizedHeader:               bootstrap.Header,
 		CurrentSyncCommittee:          &bootstrap.CurrentSyncCommittee,
 		OptimisticHeader:              bootstrap.Header,
+		FinalizedExecution:            bootstrap.Execution,
+		OptimisticExecution:           bootstrap.Execution,
 		PreviousMaxActiveParticipants: view.Uint64View(0),
 		CurrentMaxActiveParticipants:  view.Uint64View(0),
 	}
@@ -325,20 +388,20 @@ func (c *ConsensusLightClient) VerifyGenericUpdate(update *GenericUpdate) error
 		return ErrInvalidPeriod
 	}
 
-	updateAttestedPeriod := CalcSyncPeriod(uint64(update.AttestedHeader.Slot))
-	updateHasNextCommittee := c.Store.NextSyncCommittee == nil && update.NextSyncCommittee != nil && updateAttestedPeriod == storePeriod
-
-	if update.AttestedHeader.Slot <= c.Store.FinalizedHeader.Slot && !updateHasNextCommittee {
-		return ErrNotRelevant
-	}
 	if update.FinalizedHeader != nil && update.FinalityBranch != nil {
-		isValid := IsFinalityProofValid(*update.AttestedHeader, *update.FinalizedHeader, *update.FinalityBranch)
+		isValid := IsFinalityProofValid(*update.AttestedHeader, *update.FinalizedHeader, update.FinalityBranch)
 		if !isValid {
 			return ErrInvalidFinalityProof
 		}
 	}
+	if update.AttestedExecution != nil && !IsExecutionProofValid(c.Config.Spec, *update.AttestedHeader, update.AttestedExecution) {
+		return ErrInvalidExecutionProof
+	}
+	if update.FinalizedHeader != nil && update.FinalizedExecution != nil && !IsExecutionProofValid(c.Config.Spec, *update.FinalizedHeader, update.FinalizedExecution) {
+		return ErrInvalidExecutionProof
+	}
 	if update.NextSyncCommittee != nil && update.NextSyncCommitteeBranch != nil {
-		isValid := IsNextCommitteeProofValid(*update.AttestedHeader, *update.NextSyncCommittee, *update.NextSyncCommitteeBranch)
+		isValid := IsNextCommitteeProofValid(*update.AttestedHeader, *update.NextSyncCommittee, update.NextSyncCommitteeBranch)
 		if !isValid {
 			return ErrInvalidNextSyncCommitteeProof
 		}
@@ -360,6 +423,15 @@ func (c *ConsensusLightClient) VerifyGenericUpdate(update *GenericUpdate) error
 	if !isValidSig {
 		return ErrInvalidSignature
 	}
+
+	// Relevance is checked last, so that ErrNotRelevant implies the update
+	// itself is valid.
+	updateAttestedPeriod := CalcSyncPeriod(uint64(update.AttestedHeader.Slot))
+	updateHasNextCommittee := c.Store.NextSyncCommittee == nil && update.NextSyncCommittee != nil && updateAttestedPeriod == storePeriod
+
+	if update.AttestedHeader.Slot <= c.Store.FinalizedHeader.Slot && !updateHasNextCommittee {
+		return ErrNotRelevant
+	}
 	return nil
 }
 
@@ -387,7 +459,27 @@ func (c *ConsensusLightClient) VerifyOptimisticUpdate(update common.SpecObj) err
 	return c.VerifyGenericUpdate(genericUpdate)
 }
 
+// Snapshot returns a copy of the light client. The store only ever has its
+// headers and committees replaced, so the copy is not affected when the light
+// client applies further updates.
+func (c *ConsensusLightClient) Snapshot() *ConsensusLightClient {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return &ConsensusLightClient{
+		Store:             c.Store,
+		API:               c.API,
+		InitialCheckpoint: c.InitialCheckpoint,
+		LastCheckpoint:    c.LastCheckpoint,
+		Config:            c.Config,
+		Logger:            c.Logger,
+		forks:             c.forks,
+	}
+}
+
 func (c *ConsensusLightClient) ApplyGenericUpdate(update *GenericUpdate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	commiteeBits := c.getBits(update.SyncAggregate.SyncCommitteeBits)
 
 	if c.Store.CurrentMaxActiveParticipants < view.Uint64View(commiteeBits) {
@@ -398,6 +490,7 @@ func (c *ConsensusLightClient) ApplyGenericUpdate(update *GenericUpdate) {
 
 	if shouldUpdateOptimistic {
 		c.Store.OptimisticHeader = update.AttestedHeader
+		c.Store.OptimisticExecution = update.AttestedExecution
 		c.logFinalityUpdate(update)
 	}
 
@@ -436,6 +529,7 @@ func (c *ConsensusLightClient) ApplyGenericUpdate(update *GenericUpdate) {
 
 		if updateFinalizedSlot > c.Store.FinalizedHeader.Slot {
 			c.Store.FinalizedHeader = update.FinalizedHeader
+			c.Store.FinalizedExecution = update.FinalizedExecution
 			c.logFinalityUpdate(update)
 
 			if c.Store.FinalizedHeader.Slot%32 == 0 {
@@ -445,6 +539,7 @@ func (c *ConsensusLightClient) ApplyGenericUpdate(update *GenericUpdate) {
 
 			if c.Store.FinalizedHeader.Slot > c.Store.OptimisticHeader.Slot {
 				c.Store.OptimisticHeader = c.Store.FinalizedHeader
+				c.Store.OptimisticExecution = c.Store.FinalizedExecution
 			}
 		}
 	}
@@ -498,7 +593,9 @@ func (c *ConsensusLightClient) VerifySyncCommitteeSignature(pks []common.BLSPubk
 func (c *ConsensusLightClient) ComputeCommitteeSignRoot(headerRoot tree.Root, slot common.Slot) common.Root {
 	genesisRoot := c.Config.Chain.GenesisRoot
 	domainType := hexutil.MustDecode("0x07000000")
-	forkVersion := c.Config.Spec.ForkVersion(slot)
+	// The committee signs with the fork version of the slot before the
+	// signature slot.
+	forkVersion := c.forks.AtSlot(max(slot, 1) - 1).Version
 	domain := common.ComputeDomain(common.BLSDomainType(domainType), forkVersion, genesisRoot)
 	return ComputeSigningRoot(headerRoot, domain)
 }
@@ -516,8 +613,8 @@ func (c *ConsensusLightClient) slotTimestamp(slot common.Slot) (common.Timestamp
 	return atSlot, nil
 }
 
-func (c *ConsensusLightClient) isCurrentCommitteeProofValid(attestedHeader common.BeaconBlockHeader, currentCommittee common.SyncCommittee, currentCommitteeBranch altair.SyncCommitteeProofBranch) bool {
-	return merkle.VerifyMerkleBranch(currentCommittee.HashTreeRoot(c.Config.Spec, tree.GetHashFn()), currentCommitteeBranch[:], 5, 22, attestedHeader.StateRoot)
+func (c *ConsensusLightClient) isCurrentCommitteeProofValid(attestedHeader common.BeaconBlockHeader, currentCommittee common.SyncCommittee, currentCommitteeBranch []common.Root) bool {
+	return IsCurrentCommitteeProofValid(c.Config.Spec, attestedHeader, currentCommittee, currentCommitteeBranch)
 }
 
 func (c *ConsensusLightClient) safetyThreshold() uint64 {
@@ -568,13 +665,7 @@ func (c *ConsensusLightClient) age(slot common.Slot) (time.Duration, error) {
 }
 
 func (c *ConsensusLightClient) getBits(sync altair.SyncCommitteeBits) uint64 {
-	res := 0
-	for i := 0; i < int(c.Config.Spec.SYNC_COMMITTEE_SIZE); i++ {
-		if sync.GetBit(uint64(i)) {
-			res++
-		}
-	}
-	return uint64(res)
+	return countParticipants(c.Config.Spec, sync)
 }
 
 func (c *ConsensusLightClient) getParticipatingKeys(committee common.SyncCommittee, syncBits altair.SyncCommitteeBits) []common.BLSPubkey {
@@ -589,25 +680,41 @@ func (c *ConsensusLightClient) getParticipatingKeys(committee common.SyncCommitt
 
 func FromLightClientUpdate(commonUpdate common.SpecObj) (*GenericUpdate, error) {
 	switch update := commonUpdate.(type) {
+	case *ElectraLightClientUpdate:
+		return &GenericUpdate{
+			AttestedHeader:          &update.AttestedHeader.Beacon,
+			AttestedExecution:       fromDenebHeader(&update.AttestedHeader),
+			SyncAggregate:           &update.SyncAggregate,
+			SignatureSlot:           update.SignatureSlot,
+			NextSyncCommittee:       &update.NextSyncCommittee,
+			NextSyncCommitteeBranch: update.NextSyncCommitteeBranch[:],
+			FinalizedHeader:         &update.FinalizedHeader.Beacon,
+			FinalizedExecution:      fromDenebHeader(&update.FinalizedHeader),
+			FinalityBranch:          update.FinalityBranch[:],
+		}, nil
 	case *deneb.LightClientUpdate:
 		return &GenericUpdate{
 			AttestedHeader:          &update.AttestedHeader.Beacon,
+			AttestedExecution:       fromDenebHeader(&update.AttestedHeader),
 			SyncAggregate:           &update.SyncAggregate,
 			SignatureSlot:           update.SignatureSlot,
 			NextSyncCommittee:       &update.NextSyncCommittee,
-			NextSyncCommitteeBranch: &update.NextSyncCommitteeBranch,
+			NextSyncCommitteeBranch: update.NextSyncCommitteeBranch[:],
 			FinalizedHeader:         &update.FinalizedHeader.Beacon,
-			FinalityBranch:          &update.FinalityBranch,
+			FinalizedExecution:      fromDenebHeader(&update.FinalizedHeader),
+			FinalityBranch:          update.FinalityBranch[:],
 		}, nil
 	case *capella.LightClientUpdate:
 		return &GenericUpdate{
 			AttestedHeader:          &update.AttestedHeader.Beacon,
+			AttestedExecution:       fromCapellaHeader(&update.AttestedHeader),
 			SyncAggregate:           &update.SyncAggregate,
 			SignatureSlot:           update.SignatureSlot,
 			NextSyncCommittee:       &update.NextSyncCommittee,
-			NextSyncCommitteeBranch: &update.NextSyncCommitteeBranch,
+			NextSyncCommitteeBranch: update.NextSyncCommitteeBranch[:],
 			FinalizedHeader:         &update.FinalizedHeader.Beacon,
-			FinalityBranch:          &update.FinalityBranch,
+			FinalizedExecution:      fromCapellaHeader(&update.FinalizedHeader),
+			FinalityBranch:          update.FinalityBranch[:],
 		}, nil
 	case *altair.LightClientUpdate:
 		return &GenericUpdate{
@@ -615,9 +722,9 @@ func FromLightClientUpdate(commonUpdate common.SpecObj) (*GenericUpdate, error)
 			SyncAggregate:           &update.SyncAggregate,
 			SignatureSlot:           update.SignatureSlot,
 			NextSyncCommittee:       &update.NextSyncCommittee,
-			NextSyncCommitteeBranch: &update.NextSyncCommitteeBranch,
+			NextSyncCommitteeBranch: update.NextSyncCommitteeBranch[:],
 			FinalizedHeader:         &update.FinalizedHeader.Beacon,
-			FinalityBranch:          &update.FinalityBranch,
+			FinalityBranch:          update.FinalityBranch[:],
 		}, nil
 	}
 	return nil, errors.New("unknown update type")
@@ -625,21 +732,35 @@ func FromLightClientUpdate(commonUpdate common.SpecObj) (*GenericUpdate, error)
 
 func FromLightClientFinalityUpdate(commonFinalityUpdate common.SpecObj) (*GenericUpdate, error) {
 	switch update := commonFinalityUpdate.(type) {
+	case *ElectraLightClientFinalityUpdate:
+		return &GenericUpdate{
+			AttestedHeader:     &update.AttestedHeader.Beacon,
+			AttestedExecution:  fromDenebHeader(&update.AttestedHeader),
+			SyncAggregate:      &update.SyncAggregate,
+			SignatureSlot:      update.SignatureSlot,
+			FinalizedHeader:    &update.FinalizedHeader.Beacon,
+			FinalizedExecution: fromDenebHeader(&update.FinalizedHeader),
+			FinalityBranch:     update.FinalityBranch[:],
+		}, nil
 	case *deneb.LightClientFinalityUpdate:
 		return &GenericUpdate{
-			AttestedHeader:  &update.AttestedHeader.Beacon,
-			SyncAggregate:   &update.SyncAggregate,
-			SignatureSlot:   update.SignatureSlot,
-			FinalizedHeader: &update.FinalizedHeader.Beacon,
-			FinalityBranch:  &update.FinalityBranch,
+			AttestedHeader:     &update.AttestedHeader.Beacon,
+			AttestedExecution:  fromDenebHeader(&update.AttestedHeader),
+			SyncAggregate:      &update.SyncAggregate,
+			SignatureSlot:      update.SignatureSlot,
+			FinalizedHeader:    &update.FinalizedHeader.Beacon,
+			FinalizedExecution: fromDenebHeader(&update.FinalizedHeader),
+			FinalityBranch:     update.FinalityBranch[:],
 		}, nil
 	case *capella.LightClientFinalityUpdate:
 		return &GenericUpdate{
-			AttestedHeader:  &update.AttestedHeader.Beacon,
-			SyncAggregate:   &update.SyncAggregate,
-			SignatureSlot:   update.SignatureSlot,
-			FinalizedHeader: &update.FinalizedHeader.Beacon,
-			FinalityBranch:  &update.FinalityBranch,
+			AttestedHeader:     &update.AttestedHeader.Beacon,
+			AttestedExecution:  fromCapellaHeader(&update.AttestedHeader),
+			SyncAggregate:      &update.SyncAggregate,
+			SignatureSlot:      update.SignatureSlot,
+			FinalizedHeader:    &update.FinalizedHeader.Beacon,
+			FinalizedExecution: fromCapellaHeader(&update.FinalizedHeader),
+			FinalityBranch:     update.FinalityBranch[:],
 		}, nil
 	case *altair.LightClientFinalityUpdate:
 		return &GenericUpdate{
@@ -647,7 +768,7 @@ func FromLightClientFinalityUpdate(commonFinalityUpdate common.SpecObj) (*Generi
 			SyncAggregate:   &update.SyncAggregate,
 			SignatureSlot:   update.SignatureSlot,
 			FinalizedHeader: &update.FinalizedHeader,
-			FinalityBranch:  &update.FinalityBranch,
+			FinalityBranch:  update.FinalityBranch[:],
 		}, nil
 	}
 	return nil, errors.New("unknown finality update type")
@@ -657,15 +778,17 @@ func FromLightClientOptimisticUpdate(commonOptimisticUpdate common.SpecObj) (*Ge
 	switch update := commonOptimisticUpdate.(type) {
 	case *deneb.LightClientOptimisticUpdate:
 		return &GenericUpdate{
-			AttestedHeader: &update.AttestedHeader.Beacon,
-			SyncAggregate:  &update.SyncAggregate,
-			SignatureSlot:  update.SignatureSlot,
+			AttestedHeader:    &update.AttestedHeader.Beacon,
+			AttestedExecution: fromDenebHeader(&update.AttestedHeader),
+			SyncAggregate:     &update.SyncAggregate,
+			SignatureSlot:     update.SignatureSlot,
 		}, nil
 	case *capella.LightClientOptimisticUpdate:
 		return &GenericUpdate{
-			AttestedHeader: &update.AttestedHeader.Beacon,
-			SyncAggregate:  &update.SyncAggregate,
-			SignatureSlot:  update.SignatureSlot,
+			AttestedHeader:    &update.AttestedHeader.Beacon,
+			AttestedExecution: fromCapellaHeader(&update.AttestedHeader),
+			SyncAggregate:     &update.SyncAggregate,
+			SignatureSlot:     update.SignatureSlot,
 		}, nil
 	case *altair.LightClientOptimisticUpdate:
 		return &GenericUpdate{
@@ -690,14 +813,108 @@ func CalcSyncPeriod(slot uint64) uint64 {
 	return epoch / 256 // 256 epochs per sync committee
 }
 
-func IsFinalityProofValid(attestedHeader common.BeaconBlockHeader, finalityHeader common.BeaconBlockHeader, finalityBranch altair.FinalizedRootProofBranch) bool {
+// The state proofs of Electra are one level deeper than before but prove the
+// leaf at the same index within their depth, see electra.go. The length of a
+// branch is fixed by the fork of the content, so it determines the depth.
+
+func IsFinalityProofValid(attestedHeader common.BeaconBlockHeader, finalityHeader common.BeaconBlockHeader, finalityBranch []common.Root) bool {
 	leaf := finalityHeader.HashTreeRoot(tree.GetHashFn())
 	root := attestedHeader.StateRoot
-	return merkle.VerifyMerkleBranch(leaf, finalityBranch[:], 6, 41, root)
+	return merkle.VerifyMerkleBranch(leaf, finalityBranch, uint64(len(finalityBranch)), 41, root)
 }
 
-func IsNextCommitteeProofValid(attestedHeader common.BeaconBlockHeader, nextCommittee common.SyncCommittee, nextCommitteeBranch altair.SyncCommitteeProofBranch) bool {
+func IsCurrentCommitteeProofValid(spec *common.Spec, attestedHeader common.BeaconBlockHeader, currentCommittee common.SyncCommittee, currentCommitteeBranch []common.Root) bool {
+	leaf := currentCommittee.HashTreeRoot(spec, tree.GetHashFn())
+	return merkle.VerifyMerkleBranch(leaf, currentCommitteeBranch, uint64(len(currentCommitteeBranch)), 22, attestedHeader.StateRoot)
+}
+
+func IsNextCommitteeProofValid(attestedHeader common.BeaconBlockHeader, nextCommittee common.SyncCommittee, nextCommitteeBranch []common.Root) bool {
 	leaf := nextCommittee.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
 	root := attestedHeader.StateRoot
-	return merkle.VerifyMerkleBranch(leaf, nextCommitteeBranch[:], 5, 23, root)
+	return merkle.VerifyMerkleBranch(leaf, nextCommitteeBranch, uint64(len(nextCommitteeBranch)), 23, root)
+}
+
+// IsExecutionProofValid checks the execution payload header of a light client
+// header against the body root of its beacon block. Headers before Capella
+// carry an empty execution payload header and branch.
+func IsExecutionProofValid(spec *common.Spec, header common.BeaconBlockHeader, execution *GenericExecution) bool {
+	if spec.SlotToEpoch(header.Slot) < spec.CAPELLA_FORK_EPOCH {
+		return execution.BlockHash == (common.Hash32{}) && isZeroBranch(execution.Branch)
+	}
+	return merkle.VerifyMerkleBranch(execution.Root, execution.Branch, uint64(len(execution.Branch)), 9, header.BodyRoot)
+}
+
+func countParticipants(spec *common.Spec, sync altair.SyncCommitteeBits) uint64 {
+	res := 0
+	for i := 0; i < int(spec.SYNC_COMMITTEE_SIZE); i++ {
+		if sync.GetBit(uint64(i)) {
+			res++
+		}
+	}
+	return uint64(res)
+}
+
+func isZeroBranch(branch []common.Root) bool {
+	for _, root := range branch {
+		if root != (common.Root{}) {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateScore ranks an update by the criteria of is_better_update in the
+// consensus specs, except for the slot tiebreakers. The criteria are packed
+// into the bits of the score from the most to the least significant, so that
+// a better update has a higher score:
+//
+//	supermajority | participants without supermajority | relevant sync committee |
+//	finality | sync committee finality | participants
+func UpdateScore(spec *common.Spec, update *GenericUpdate) int64 {
+	participants := int64(countParticipants(spec, update.SyncAggregate.SyncCommitteeBits))
+	hasSupermajority := participants*3 >= int64(spec.SYNC_COMMITTEE_SIZE)*2
+
+	attestedPeriod := CalcSyncPeriod(uint64(update.AttestedHeader.Slot))
+	hasSyncCommittee := update.NextSyncCommittee != nil && !isZeroBranch(update.NextSyncCommitteeBranch)
+	hasRelevantSyncCommittee := hasSyncCommittee && attestedPeriod == CalcSyncPeriod(uint64(update.SignatureSlot))
+	hasFinality := update.FinalizedHeader != nil && !isZeroBranch(update.FinalityBranch)
+	hasSyncCommitteeFinality := hasFinality && CalcSyncPeriod(uint64(update.FinalizedHeader.Slot)) == attestedPeriod
+
+	const participantBits = 16
+	var score int64
+	push := func(value int64, bits int) {
+		score = score<<bits | value
+	}
+	flag := func(b bool) int64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	push(flag(hasSupermajority), 1)
+	if hasSupermajority {
+		push(0, participantBits)
+	} else {
+		push(participants, participantBits)
+	}
+	push(flag(hasRelevantSyncCommittee), 1)
+	push(flag(hasFinality), 1)
+	push(flag(hasSyncCommitteeFinality), 1)
+	push(participants, participantBits)
+	return score
+}
+
+// IsBetterUpdate implements is_better_update of the consensus specs: it
+// reports whether newUpdate should replace oldUpdate as the best update of
+// its sync committee period.
+func IsBetterUpdate(spec *common.Spec, newUpdate, oldUpdate *GenericUpdate) bool {
+	newScore, oldScore := UpdateScore(spec, newUpdate), UpdateScore(spec, oldUpdate)
+	if newScore != oldScore {
+		return newScore > oldScore
+	}
+	// Prefer older data, which causes fewer changes to the best update.
+	if newUpdate.AttestedHeader.Slot != oldUpdate.AttestedHeader.Slot {
+		return newUpdate.AttestedHeader.Slot < oldUpdate.AttestedHeader.Slot
+	}
+	return newUpdate.SignatureSlot < oldUpdate.SignatureSlot
 }
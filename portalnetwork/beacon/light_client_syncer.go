@@ -0,0 +1,223 @@
+package beacon
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// checkpointSource is a way to obtain a start checkpoint of the light client.
+// A strict source is refused once its checkpoint is older than the weak
+// subjectivity period.
+type checkpointSource struct {
+	name   string
+	strict bool
+	root   func() (common.Root, error)
+}
+
+// HeadEvent is sent when the optimistic header of the light client moves to a
+// new execution block.
+type HeadEvent struct {
+	Beacon    common.BeaconBlockHeader
+	Execution GenericExecution
+	Finalized GenericExecution
+}
+
+// LightClientSyncer keeps a light client in sync with the beacon network and
+// hands it to the network to verify gossiped content against. It starts from
+// the first checkpoint that bootstraps of, in order: the user provided
+// checkpoint, the finalized header stored by the previous run, a checkpoint
+// that enough peers agree on and the default checkpoint. The latest finalized
+// header is stored as the checkpoint of the next run.
+type LightClientSyncer struct {
+	api             ConsensusAPI
+	beaconNetwork   *BeaconNetwork
+	checkpoints     *CheckpointStore
+	config          *Config
+	checkpoint      common.Root
+	checkpointPeers int
+	log             log.Logger
+	closeCtx        context.Context
+	closeFunc       context.CancelFunc
+
+	client    *ConsensusLightClient
+	savedRoot common.Root
+	headFeed  event.Feed
+	lastHead  common.Hash32
+}
+
+// NewLightClientSyncer creates a syncer that fetches light client data through
+// api. A non-zero checkpoint is trusted even if it is older than the weak
+// subjectivity period, a positive checkpointPeers enables the discovery of a
+// new checkpoint from that many agreeing peers.
+func NewLightClientSyncer(api ConsensusAPI, beaconNetwork *BeaconNetwork, checkpoints *CheckpointStore, checkpoint common.Root, checkpointPeers int) *LightClientSyncer {
+	baseConfig := Mainnet()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LightClientSyncer{
+		api:           api,
+		beaconNetwork: beaconNetwork,
+		checkpoints:   checkpoints,
+		config: &Config{
+			ConsensusAPI:      api.Name(),
+			DefaultCheckpoint: baseConfig.DefaultCheckpoint,
+			Checkpoint:        checkpoint,
+			Chain:             baseConfig.Chain,
+			Spec:              baseConfig.Spec,
+			MaxCheckpointAge:  baseConfig.MaxCheckpointAge,
+		},
+		checkpoint:      checkpoint,
+		checkpointPeers: checkpointPeers,
+		log:             log.New("sub-protocol", "beacon", "component", "light-client"),
+		closeCtx:        ctx,
+		closeFunc:       cancel,
+	}
+}
+
+func (s *LightClientSyncer) Start() {
+	go s.loop()
+}
+
+func (s *LightClientSyncer) Stop() {
+	s.closeFunc()
+}
+
+// loop syncs the light client right away and then advances it two thirds into
+// every slot, after the updates of the slot have been gossiped. Failed syncs
+// are retried every epoch.
+func (s *LightClientSyncer) loop() {
+	slotDuration := time.Duration(s.config.Spec.SECONDS_PER_SLOT) * time.Second
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-timer.C:
+			if s.client == nil {
+				if err := s.sync(); err != nil {
+					s.log.Warn("failed to sync light client", "err", err)
+					timer.Reset(time.Duration(s.config.Spec.SLOTS_PER_EPOCH) * slotDuration)
+					continue
+				}
+			} else if err := s.client.Advance(); err != nil {
+				s.log.Debug("failed to advance light client", "err", err)
+			}
+			s.publish()
+			genesis := time.Unix(int64(BeaconGenesisTime), 0)
+			next := genesis.Add((time.Since(genesis)/slotDuration + 1) * slotDuration).Add(slotDuration * 2 / 3)
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// sync bootstraps the light client from the first usable checkpoint and syncs
+// it to the head of the chain.
+func (s *LightClientSyncer) sync() error {
+	var errs []error
+	for _, source := range s.sources() {
+		root, err := source.root()
+		if err != nil {
+			if !errors.Is(err, ErrNoCheckpoint) {
+				s.log.Warn("failed to get checkpoint", "source", source.name, "err", err)
+			}
+			continue
+		}
+		config := *s.config
+		config.StrictCheckpointAge = source.strict
+		client, err := NewConsensusLightClient(s.api, &config, root, s.log)
+		if err == nil {
+			err = client.Sync()
+		}
+		if err != nil {
+			s.log.Warn("failed to sync from checkpoint", "source", source.name, "checkpoint", root, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		s.log.Info("light client synced", "source", source.name, "checkpoint", root, "finalized", client.Store.FinalizedHeader.Slot)
+		s.client = client
+		return nil
+	}
+	if len(errs) == 0 {
+		return errors.New("no recent checkpoint, set one with --beacon.checkpoint or enable discovery with --beacon.checkpoint.peers")
+	}
+	return errors.Join(errs...)
+}
+
+func (s *LightClientSyncer) sources() []checkpointSource {
+	sources := make([]checkpointSource, 0, 4)
+	if s.checkpoint != (common.Root{}) {
+		sources = append(sources, checkpointSource{
+			name: "user",
+			root: func() (common.Root, error) { return s.checkpoint, nil },
+		})
+	}
+	sources = append(sources, checkpointSource{
+		name:   "stored",
+		strict: true,
+		root: func() (common.Root, error) {
+			root, slot, err := s.checkpoints.Load()
+			if err != nil {
+				return common.Root{}, err
+			}
+			if !isWithinWeakSubjectivityPeriod(s.config.Spec, slot, s.config.MaxCheckpointAge) {
+				s.log.Warn("stored checkpoint is older than the weak subjectivity period", "checkpoint", root, "slot", slot)
+				return common.Root{}, ErrNoCheckpoint
+			}
+			return root, nil
+		},
+	})
+	if s.checkpointPeers > 0 {
+		sources = append(sources, checkpointSource{
+			name:   "peers",
+			strict: true,
+			root: func() (common.Root, error) {
+				root, _, err := s.beaconNetwork.DiscoverCheckpoint(s.checkpointPeers)
+				return root, err
+			},
+		})
+	}
+	sources = append(sources, checkpointSource{
+		name:   "default",
+		strict: true,
+		root:   func() (common.Root, error) { return s.config.DefaultCheckpoint, nil },
+	})
+	return sources
+}
+
+// SubscribeHead subscribes to the execution heads of the light client.
+func (s *LightClientSyncer) SubscribeHead(ch chan<- HeadEvent) event.Subscription {
+	return s.headFeed.Subscribe(ch)
+}
+
+// publish hands a copy of the light client to the beacon network, announces
+// a new execution head and stores the finalized header as the next start
+// checkpoint.
+func (s *LightClientSyncer) publish() {
+	s.beaconNetwork.SetLightClient(s.client.Snapshot())
+
+	store := s.client.Store
+	if store.OptimisticExecution != nil && store.FinalizedExecution != nil && store.OptimisticExecution.BlockHash != s.lastHead {
+		s.lastHead = store.OptimisticExecution.BlockHash
+		s.headFeed.Send(HeadEvent{
+			Beacon:    *store.OptimisticHeader,
+			Execution: *store.OptimisticExecution,
+			Finalized: *store.FinalizedExecution,
+		})
+	}
+
+	finalized := s.client.Store.FinalizedHeader
+	root := finalized.HashTreeRoot(tree.GetHashFn())
+	if root == s.savedRoot {
+		return
+	}
+	if err := s.checkpoints.Save(root, finalized.Slot); err != nil {
+		s.log.Warn("failed to store checkpoint", "err", err)
+		return
+	}
+	s.savedRoot = root
+}
@@ -196,3 +196,37 @@ func TestSync(t *testing.T) {
 	finalizedHead := client.GetFinalityHeader()
 	require.Equal(t, finalizedHead.Slot, common.Slot(7358656))
 }
+
+func TestSnapshot(t *testing.T) {
+	client, err := getClient(false, t)
+	require.NoError(t, err)
+	snapshot := client.Snapshot()
+	bootstrapSlot := snapshot.GetFinalityHeader().Slot
+
+	// snapshots are taken while the light client applies updates
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			client.Snapshot()
+		}
+	}()
+	require.NoError(t, client.Sync())
+	<-done
+
+	require.Equal(t, bootstrapSlot, snapshot.GetFinalityHeader().Slot)
+	require.Equal(t, common.Slot(7358656), client.Snapshot().GetFinalityHeader().Slot)
+}
+
+func TestExecutionProof(t *testing.T) {
+	client, err := getClient(false, t)
+	require.NoError(t, err)
+	execution := client.Store.FinalizedExecution
+	require.NotNil(t, execution)
+	require.NotZero(t, execution.BlockNumber)
+	require.True(t, IsExecutionProofValid(client.Config.Spec, *client.Store.FinalizedHeader, execution))
+
+	forged := *execution
+	forged.Root[0] ^= 0xff
+	require.False(t, IsExecutionProofValid(client.Config.Spec, *client.Store.FinalizedHeader, &forged))
+}
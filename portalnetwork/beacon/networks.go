@@ -28,6 +28,9 @@ func Mainnet() *BaseConfig {
 			ChainID:     1,
 			GenesisTime: 1606824023,
 			GenesisRoot: common.Root(hexutil.MustDecode("0x4b363db94e286120d76eb905340fdd4e54bfe9f06bf33ff6cf5ad27f511bfe95")),
+
+			ElectraForkVersion: common.Version{0x05, 0x00, 0x00, 0x00},
+			ElectraForkEpoch:   364032,
 		},
 		Spec:             configs.Mainnet,
 		MaxCheckpointAge: 1_209_600,
@@ -21,8 +21,11 @@ type PortalLightApi struct {
 	spec           *common.Spec
 }
 
-func NewPortalLightApi() *PortalLightApi {
-	return &PortalLightApi{}
+func NewPortalLightApi(portalProtocol *discover.PortalProtocol, spec *common.Spec) *PortalLightApi {
+	return &PortalLightApi{
+		portalProtocol: portalProtocol,
+		spec:           spec,
+	}
 }
 
 // ChainID implements ConsensusAPI.
@@ -0,0 +1,226 @@
+package beacon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+)
+
+// LightClientRestPath is the path prefix of the beacon-API light client
+// endpoints served by LightClientRestAPI.
+const LightClientRestPath = "/eth/v1/beacon/light_client/"
+
+const (
+	sszContentType  = "application/octet-stream"
+	jsonContentType = "application/json"
+
+	consensusVersionHeader = "Eth-Consensus-Version"
+)
+
+// LightClientRestAPI serves the beacon-API light client endpoints out of the
+// portal beacon network, so that standard consensus light clients can use it
+// as their data source. Responses are SSZ encoded when the request accepts
+// application/octet-stream and JSON encoded otherwise.
+type LightClientRestAPI struct {
+	beaconNetwork *BeaconNetwork
+	mux           *http.ServeMux
+	log           log.Logger
+}
+
+func NewLightClientRestAPI(beaconNetwork *BeaconNetwork) *LightClientRestAPI {
+	api := &LightClientRestAPI{
+		beaconNetwork: beaconNetwork,
+		mux:           http.NewServeMux(),
+		log:           log.New("sub-protocol", "beacon", "api", "rest"),
+	}
+	api.mux.HandleFunc("GET "+LightClientRestPath+"bootstrap/{block_root}", api.handleBootstrap)
+	api.mux.HandleFunc("GET "+LightClientRestPath+"updates", api.handleUpdates)
+	api.mux.HandleFunc("GET "+LightClientRestPath+"finality_update", api.handleFinalityUpdate)
+	api.mux.HandleFunc("GET "+LightClientRestPath+"optimistic_update", api.handleOptimisticUpdate)
+	return api
+}
+
+func (api *LightClientRestAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mux.ServeHTTP(w, r)
+}
+
+type versionedResponse struct {
+	Version string      `json:"version"`
+	Data    interface{} `json:"data"`
+}
+
+type errorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (api *LightClientRestAPI) handleBootstrap(w http.ResponseWriter, r *http.Request) {
+	rootBytes, err := hexutil.Decode(r.PathValue("block_root"))
+	if err != nil || len(rootBytes) != 32 {
+		api.writeError(w, http.StatusBadRequest, "invalid block root")
+		return
+	}
+	bootstrap, err := api.beaconNetwork.getBootstrap(tree.Root(rootBytes))
+	if err != nil {
+		api.writeContentError(w, err)
+		return
+	}
+	api.writeObject(w, r, bootstrap.ForkDigest, bootstrap.Bootstrap)
+}
+
+func (api *LightClientRestAPI) handleUpdates(w http.ResponseWriter, r *http.Request) {
+	startPeriod, err := strconv.ParseUint(r.URL.Query().Get("start_period"), 10, 64)
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "invalid start_period")
+		return
+	}
+	count, err := strconv.ParseUint(r.URL.Query().Get("count"), 10, 64)
+	if err != nil {
+		api.writeError(w, http.StatusBadRequest, "invalid count")
+		return
+	}
+	count = min(count, MaxRequestLightClientUpdates)
+	if count == 0 {
+		api.writeError(w, http.StatusBadRequest, "count must be greater than zero")
+		return
+	}
+	updates, err := api.beaconNetwork.getUpdateRange(startPeriod, count)
+	if err != nil {
+		api.writeContentError(w, err)
+		return
+	}
+
+	if acceptsSSZ(r) {
+		// Every update is a response chunk prefixed with the length of
+		// its fork digest and SSZ payload.
+		var buf bytes.Buffer
+		for _, update := range updates {
+			var chunk bytes.Buffer
+			err = update.LightClientUpdate.Serialize(api.beaconNetwork.spec, codec.NewEncodingWriter(&chunk))
+			if err != nil {
+				api.writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(4+chunk.Len())))
+			buf.Write(update.ForkDigest[:])
+			buf.Write(chunk.Bytes())
+		}
+		w.Header().Set("Content-Type", sszContentType)
+		w.Write(buf.Bytes())
+		return
+	}
+
+	res := make([]versionedResponse, 0, len(updates))
+	for _, update := range updates {
+		version, err := forkName(update.ForkDigest)
+		if err != nil {
+			api.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		res = append(res, versionedResponse{Version: version, Data: update.LightClientUpdate})
+	}
+	api.writeJSON(w, http.StatusOK, res)
+}
+
+func (api *LightClientRestAPI) handleFinalityUpdate(w http.ResponseWriter, r *http.Request) {
+	// The most recent finality update is served for any finalized slot, see
+	// PortalLightApi.GetFinalityUpdate.
+	update, err := api.beaconNetwork.getFinalityUpdate(0)
+	if err != nil {
+		api.writeContentError(w, err)
+		return
+	}
+	api.writeObject(w, r, update.ForkDigest, update.LightClientFinalityUpdate)
+}
+
+func (api *LightClientRestAPI) handleOptimisticUpdate(w http.ResponseWriter, r *http.Request) {
+	spec := api.beaconNetwork.spec
+	currentSlot := spec.TimeToSlot(common.Timestamp(time.Now().Unix()), common.Timestamp(BeaconGenesisTime))
+	update, err := api.beaconNetwork.getOptimisticUpdate(uint64(currentSlot))
+	if err != nil {
+		api.writeContentError(w, err)
+		return
+	}
+	api.writeObject(w, r, update.ForkDigest, update.LightClientOptimisticUpdate)
+}
+
+// writeObject writes a single light client object in the encoding requested
+// by the client, with its fork in the Eth-Consensus-Version header.
+func (api *LightClientRestAPI) writeObject(w http.ResponseWriter, r *http.Request, digest common.ForkDigest, obj common.SpecObj) {
+	version, err := forkName(digest)
+	if err != nil {
+		api.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set(consensusVersionHeader, version)
+	if !acceptsSSZ(r) {
+		api.writeJSON(w, http.StatusOK, versionedResponse{Version: version, Data: obj})
+		return
+	}
+	var buf bytes.Buffer
+	if err = obj.Serialize(api.beaconNetwork.spec, codec.NewEncodingWriter(&buf)); err != nil {
+		api.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", sszContentType)
+	w.Write(buf.Bytes())
+}
+
+func (api *LightClientRestAPI) writeContentError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrContentNotFound) {
+		api.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	api.log.Debug("failed to get light client content", "err", err)
+	api.writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func (api *LightClientRestAPI) writeError(w http.ResponseWriter, code int, message string) {
+	api.writeJSON(w, code, errorResponse{Code: code, Message: message})
+}
+
+func (api *LightClientRestAPI) writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		api.log.Debug("failed to write response", "err", err)
+	}
+}
+
+// acceptsSSZ reports whether the request prefers SSZ over JSON, honouring
+// the quality values of the Accept header.
+func acceptsSSZ(r *http.Request) bool {
+	var sszQuality, jsonQuality float64
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case sszContentType:
+			sszQuality = max(sszQuality, quality)
+		case jsonContentType:
+			jsonQuality = max(jsonQuality, quality)
+		}
+	}
+	return sszQuality > 0 && sszQuality >= jsonQuality
+}
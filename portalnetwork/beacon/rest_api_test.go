@@ -0,0 +1,143 @@
+package beacon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	ssz "github.com/ferranbt/fastssz"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+	"github.com/stretchr/testify/require"
+)
+
+func putBeaconContent(t *testing.T, bn *BeaconNetwork, contentType storage.ContentType, key ssz.Marshaler, content common.SpecObj) {
+	keyBytes, err := key.MarshalSSZ()
+	require.NoError(t, err)
+	contentKey := storage.NewContentKey(contentType, keyBytes).Encode()
+	var buf bytes.Buffer
+	require.NoError(t, content.Serialize(bn.spec, codec.NewEncodingWriter(&buf)))
+	require.NoError(t, bn.portalProtocol.Put(contentKey, bn.portalProtocol.ToContentId(contentKey), buf.Bytes()))
+}
+
+func restGet(t *testing.T, url string, accept string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestLightClientRestAPI(t *testing.T) {
+	bn, err := SetupBeaconNetwork(":7896", nil)
+	require.NoError(t, err)
+	require.NoError(t, bn.Start())
+	defer bn.Stop()
+
+	bootstrap, err := GetLightClientBootstrap(0)
+	require.NoError(t, err)
+	genericBootstrap, err := FromBootstrap(bootstrap.Bootstrap)
+	require.NoError(t, err)
+	blockRoot := genericBootstrap.Header.HashTreeRoot(tree.GetHashFn())
+	putBeaconContent(t, bn, LightClientBootstrap, &LightClientBootstrapKey{BlockHash: blockRoot[:]}, &bootstrap)
+
+	update, err := GetClientUpdate(0)
+	require.NoError(t, err)
+	updateRange := LightClientUpdateRange{update, update}
+	putBeaconContent(t, bn, LightClientUpdate, &LightClientUpdateKey{StartPeriod: 10, Count: 2}, &updateRange)
+
+	finalityUpdate, err := GetLightClientFinalityUpdate(0)
+	require.NoError(t, err)
+	putBeaconContent(t, bn, LightClientFinalityUpdate, &LightClientFinalityUpdateKey{FinalizedSlot: 0}, &finalityUpdate)
+
+	optimisticUpdate, err := GetLightClientOptimisticUpdate(0)
+	require.NoError(t, err)
+	currentSlot := bn.spec.TimeToSlot(common.Timestamp(time.Now().Unix()), common.Timestamp(BeaconGenesisTime))
+	putBeaconContent(t, bn, LightClientOptimisticUpdate, &LightClientOptimisticUpdateKey{OptimisticSlot: uint64(currentSlot)}, &optimisticUpdate)
+
+	server := httptest.NewServer(NewLightClientRestAPI(bn))
+	defer server.Close()
+	baseURL := server.URL + LightClientRestPath
+
+	t.Run("bootstrap", func(t *testing.T) {
+		resp, body := restGet(t, baseURL+"bootstrap/"+blockRoot.String(), sszContentType)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "capella", resp.Header.Get(consensusVersionHeader))
+		var want bytes.Buffer
+		require.NoError(t, bootstrap.Bootstrap.Serialize(bn.spec, codec.NewEncodingWriter(&want)))
+		require.Equal(t, want.Bytes(), body)
+
+		resp, body = restGet(t, baseURL+"bootstrap/"+blockRoot.String(), "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var res struct {
+			Version string `json:"version"`
+			Data    struct {
+				Header struct {
+					Beacon common.BeaconBlockHeader `json:"beacon"`
+				} `json:"header"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(body, &res))
+		require.Equal(t, "capella", res.Version)
+		require.Equal(t, *genericBootstrap.Header, res.Data.Header.Beacon)
+
+		resp, _ = restGet(t, baseURL+"bootstrap/0x1234", "")
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("updates", func(t *testing.T) {
+		resp, body := restGet(t, baseURL+"updates?start_period=10&count=2", sszContentType)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var payload bytes.Buffer
+		require.NoError(t, update.LightClientUpdate.Serialize(bn.spec, codec.NewEncodingWriter(&payload)))
+		for i := 0; i < 2; i++ {
+			require.Equal(t, uint64(4+payload.Len()), binary.LittleEndian.Uint64(body[:8]))
+			require.Equal(t, Capella[:], body[8:12])
+			require.Equal(t, payload.Bytes(), body[12:12+payload.Len()])
+			body = body[12+payload.Len():]
+		}
+		require.Empty(t, body)
+
+		resp, body = restGet(t, baseURL+"updates?start_period=10&count=2", jsonContentType)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var res []versionedResponse
+		require.NoError(t, json.Unmarshal(body, &res))
+		require.Len(t, res, 2)
+		require.Equal(t, "capella", res[0].Version)
+
+		resp, _ = restGet(t, baseURL+"updates?start_period=10", "")
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("finality update", func(t *testing.T) {
+		resp, body := restGet(t, baseURL+"finality_update", sszContentType)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "deneb", resp.Header.Get(consensusVersionHeader))
+		var want bytes.Buffer
+		require.NoError(t, finalityUpdate.LightClientFinalityUpdate.Serialize(bn.spec, codec.NewEncodingWriter(&want)))
+		require.Equal(t, want.Bytes(), body)
+	})
+
+	t.Run("optimistic update", func(t *testing.T) {
+		resp, body := restGet(t, baseURL+"optimistic_update", "application/json;q=0.9, application/octet-stream")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "deneb", resp.Header.Get(consensusVersionHeader))
+		var want bytes.Buffer
+		require.NoError(t, optimisticUpdate.LightClientOptimisticUpdate.Serialize(bn.spec, codec.NewEncodingWriter(&want)))
+		require.Equal(t, hexutil.Encode(want.Bytes()), hexutil.Encode(body))
+	})
+}
@@ -0,0 +1,93 @@
+package beacon
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/codec"
+)
+
+// NewScrubber creates a scrubber of the beacon storage, which checks the
+// branches of the stored bootstraps and light client updates against the
+// state roots of their headers. A scrub doesn't run a light client, so the
+// sync committee signatures and the historical summaries, which are proven
+// against the latest finalized state, can't be checked.
+func NewScrubber(contentStorage storage.ContentStorage, config storage.ScrubConfig) (*storage.Scrubber, error) {
+	config.NetworkName = "beacon"
+	config.ContentTypes = []byte{byte(LightClientBootstrap), byte(LightClientUpdate), byte(HistoricalSummaries)}
+	config.Validate = func(_ storage.ContentStorage, contentKey []byte, content []byte) error {
+		return validateStoredContent(configs.Mainnet, MainnetForks, contentKey, content)
+	}
+	return storage.NewScrubber(contentStorage, config)
+}
+
+func validateStoredContent(spec *common.Spec, forks *ForkSchedule, contentKey []byte, content []byte) error {
+	switch storage.ContentType(contentKey[0]) {
+	case LightClientBootstrap:
+		bootstrap, err := validateBootstrap(spec, forks, contentKey, content)
+		if err != nil {
+			return err
+		}
+		if bootstrap.Execution != nil && !IsExecutionProofValid(spec, *bootstrap.Header, bootstrap.Execution) {
+			return ErrInvalidExecutionProof
+		}
+		return nil
+	case LightClientUpdate:
+		lightClientUpdateKey := &LightClientUpdateKey{}
+		if err := lightClientUpdateKey.UnmarshalSSZ(contentKey[1:]); err != nil {
+			return err
+		}
+		var updates LightClientUpdateRange = make([]ForkedLightClientUpdate, 0)
+		if err := updates.Deserialize(spec, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content)))); err != nil {
+			return err
+		}
+		if lightClientUpdateKey.Count != uint64(len(updates)) {
+			return fmt.Errorf("light client updates count does not match the content key count: %d != %d", len(updates), lightClientUpdateKey.Count)
+		}
+		for i, update := range updates {
+			genericUpdate, err := FromLightClientUpdate(update.LightClientUpdate)
+			if err != nil {
+				return err
+			}
+			period := CalcSyncPeriod(uint64(genericUpdate.AttestedHeader.Slot))
+			if period != lightClientUpdateKey.StartPeriod+uint64(i) {
+				return fmt.Errorf("light client update %d is from period %d, expected %d", i, period, lightClientUpdateKey.StartPeriod+uint64(i))
+			}
+			if err = forks.CheckDigest(update.ForkDigest, genericUpdate.AttestedHeader.Slot); err != nil {
+				return err
+			}
+			if err = validateUpdateBranches(spec, genericUpdate); err != nil {
+				return err
+			}
+		}
+		return nil
+	case HistoricalSummaries:
+		if _, err := decodeHistoricalSummaries(spec, contentKey, content); err != nil {
+			return err
+		}
+		return storage.ErrContentNotFound
+	}
+	return fmt.Errorf("unknown content type %v", contentKey[0])
+}
+
+// validateUpdateBranches checks the branches of an update against the state
+// and body roots of its headers, the checks of VerifyGenericUpdate that don't
+// need the sync committee.
+func validateUpdateBranches(spec *common.Spec, update *GenericUpdate) error {
+	if update.FinalizedHeader != nil && update.FinalityBranch != nil && !IsFinalityProofValid(*update.AttestedHeader, *update.FinalizedHeader, update.FinalityBranch) {
+		return ErrInvalidFinalityProof
+	}
+	if update.AttestedExecution != nil && !IsExecutionProofValid(spec, *update.AttestedHeader, update.AttestedExecution) {
+		return ErrInvalidExecutionProof
+	}
+	if update.FinalizedHeader != nil && update.FinalizedExecution != nil && !IsExecutionProofValid(spec, *update.FinalizedHeader, update.FinalizedExecution) {
+		return ErrInvalidExecutionProof
+	}
+	if update.NextSyncCommittee != nil && update.NextSyncCommitteeBranch != nil && !IsNextCommitteeProofValid(*update.AttestedHeader, *update.NextSyncCommittee, update.NextSyncCommitteeBranch) {
+		return ErrInvalidNextSyncCommitteeProof
+	}
+	return nil
+}
@@ -24,6 +24,19 @@ const TotalEntryCountQueryBeacon = "SELECT COUNT(*) FROM beacon"
 
 const ContentSizeLookupQueryBeacon = "SELECT content_size FROM beacon WHERE content_id = (?1)"
 
+// LocalContentKeysQueryBeacon pages through the bootstraps, which are stored
+// with their content key, and the periods and epochs of the stored updates
+// and historical summaries, whose keys are built from them. The content types
+// are those of LightClientBootstrap, LightClientUpdate and HistoricalSummaries.
+const LocalContentKeysQueryBeacon = `SELECT content_type, content_key, number FROM (
+	SELECT 16 AS content_type, content_key, 0 AS number FROM beacon
+	UNION ALL SELECT 17, NULL, period FROM lc_update
+	UNION ALL SELECT 20, NULL, epoch FROM historical_summaries
+) WHERE (?1) IS NULL OR content_type = (?1)
+ORDER BY content_type, content_key, number LIMIT (?2) OFFSET (?3)`
+
+const ContentStatsQueryBeacon = "SELECT COUNT(*), TOTAL(content_size) FROM beacon"
+
 const LCUpdateCreateTable = `CREATE TABLE IF NOT EXISTS lc_update (
 	period INTEGER PRIMARY KEY,
 	value BLOB NOT NULL,
@@ -33,13 +46,55 @@ const LCUpdateCreateTable = `CREATE TABLE IF NOT EXISTS lc_update (
 CREATE INDEX IF NOT EXISTS update_size_idx ON lc_update(update_size);
 DROP INDEX IF EXISTS period_idx;`
 
-const InsertLCUpdateQuery = `INSERT OR IGNORE INTO lc_update (period, value, score, update_size)
+const InsertLCUpdateQuery = `INSERT OR REPLACE INTO lc_update (period, value, score, update_size)
 				  VALUES (?1, ?2, ?3, ?4)`
 
 const LCUpdateLookupQuery = `SELECT value FROM lc_update WHERE period = (?1) LIMIT 1`
 
-const LCUpdateLookupQueryByRange = `SELECT value FROM lc_update WHERE period >= (?1) AND period < (?2)`
+const LCUpdateScoreLookupQuery = `SELECT value, score FROM lc_update WHERE period = (?1) LIMIT 1`
+
+const LCUpdateLookupQueryByRange = `SELECT value FROM lc_update WHERE period >= (?1) AND period < (?2) ORDER BY period`
+
+const LCUpdateUnscoredQuery = `SELECT period, value FROM lc_update WHERE score = 0`
+
+const LCUpdateScoreUpdateQuery = `UPDATE lc_update SET score = (?2) WHERE period = (?1)`
 
 const LCUpdatePeriodLookupQuery = `SELECT period FROM lc_update WHERE period = (?1) LIMIT 1`
 
+const LCUpdateDeleteRangeQuery = `DELETE FROM lc_update WHERE period >= (?1) AND period < (?2)`
+
 const LCUpdateTotalSizeQuery = `SELECT TOTAL(update_size) FROM lc_update`
+
+const LCUpdateStatsQuery = `SELECT COUNT(*), TOTAL(update_size) FROM lc_update`
+
+const HistoricalSummariesCreateTable = `CREATE TABLE IF NOT EXISTS historical_summaries (
+	epoch INTEGER PRIMARY KEY,
+	value BLOB NOT NULL,
+	size INTEGER NOT NULL
+);`
+
+const HistoricalSummariesLookupQuery = `SELECT value FROM historical_summaries WHERE epoch = (?1) LIMIT 1`
+
+const HistoricalSummariesLatestEpochQuery = `SELECT MAX(epoch) FROM historical_summaries`
+
+const HistoricalSummariesStaleSizeQuery = `SELECT COUNT(*), TOTAL(size) FROM historical_summaries WHERE epoch <= (?1)`
+
+const HistoricalSummariesDeleteStaleQuery = `DELETE FROM historical_summaries WHERE epoch <= (?1)`
+
+const HistoricalSummariesDeleteQuery = `DELETE FROM historical_summaries WHERE epoch = (?1)`
+
+const HistoricalSummariesTotalSizeQuery = `SELECT TOTAL(size) FROM historical_summaries`
+
+const HistoricalSummariesStatsQuery = `SELECT COUNT(*), TOTAL(size) FROM historical_summaries`
+
+const InsertHistoricalSummariesQuery = `INSERT INTO historical_summaries (epoch, value, size) VALUES (?1, ?2, ?3)`
+
+const CheckpointCreateTable = `CREATE TABLE IF NOT EXISTS checkpoint (
+	id INTEGER PRIMARY KEY CHECK (id = 0),
+	root BLOB NOT NULL,
+	slot INTEGER NOT NULL
+);`
+
+const CheckpointLookupQuery = `SELECT root, slot FROM checkpoint WHERE id = 0`
+
+const InsertCheckpointQuery = `INSERT OR REPLACE INTO checkpoint (id, root, slot) VALUES (0, ?1, ?2)`
@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
@@ -16,22 +20,55 @@ import (
 
 const BytesInMB uint64 = 1000 * 1000
 
+const (
+	DefaultFinalityUpdateTTL   uint64 = 64
+	DefaultOptimisticUpdateTTL uint64 = 32
+)
+
+var (
+	ErrUpdateNotNewer   = errors.New("light client update is not newer than the stored one")
+	ErrUpdateFromFuture = errors.New("light client update is signed in a future slot")
+	ErrUpdateExpired    = errors.New("light client update is expired")
+)
+
 type BeaconStorage struct {
 	storageCapacityInBytes uint64
 	db                     *sql.DB
 	log                    log.Logger
 	spec                   *common.Spec
 	cache                  *beaconStorageCache
+	finalityUpdateTTL      uint64
+	optimisticUpdateTTL    uint64
+	now                    func() time.Time
 }
 
 var portalStorageMetrics *metrics.PortalStorageMetrics
 
+// beaconStorageCache keeps the newest finality and optimistic update in
+// memory, they are only useful for a few slots.
 type beaconStorageCache struct {
-	OptimisticUpdate []byte
-	FinalityUpdate   []byte
+	lock             sync.Mutex
+	optimisticUpdate *cachedUpdate
+	finalityUpdate   *cachedUpdate
+}
+
+// cachedUpdate is a finality or optimistic update with the slot of its content
+// key, the finalized slot respectively the signature slot.
+type cachedUpdate struct {
+	content       []byte
+	slot          common.Slot
+	signatureSlot common.Slot
+}
+
+// isNewer orders updates by the slot of their key and then by the slot they
+// were signed in.
+func (u *cachedUpdate) isNewer(other *cachedUpdate) bool {
+	return u.slot > other.slot || (u.slot == other.slot && u.signatureSlot > other.signatureSlot)
 }
 
 var _ storage.ContentStorage = &BeaconStorage{}
+var _ storage.ContentIndex = &BeaconStorage{}
+var _ storage.ContentDeleter = &BeaconStorage{}
 
 func NewBeaconStorage(config storage.PortalStorageConfig) (storage.ContentStorage, error) {
 	bs := &BeaconStorage{
@@ -40,6 +77,15 @@ func NewBeaconStorage(config storage.PortalStorageConfig) (storage.ContentStorag
 		log:                    log.New("beacon_storage"),
 		spec:                   config.Spec,
 		cache:                  &beaconStorageCache{},
+		finalityUpdateTTL:      config.FinalityUpdateTTL,
+		optimisticUpdateTTL:    config.OptimisticUpdateTTL,
+		now:                    time.Now,
+	}
+	if bs.finalityUpdateTTL == 0 {
+		bs.finalityUpdateTTL = DefaultFinalityUpdateTTL
+	}
+	if bs.optimisticUpdateTTL == 0 {
+		bs.optimisticUpdateTTL = DefaultOptimisticUpdateTTL
 	}
 	if err := bs.setup(); err != nil {
 		return nil, err
@@ -54,13 +100,51 @@ func NewBeaconStorage(config storage.PortalStorageConfig) (storage.ContentStorag
 	return bs, nil
 }
 
+// migrations are the schema versions of the beacon database, which the
+// storage shares with the checkpoint store, see storage.Migrate.
+var migrations = []storage.Migration{
+	storage.ExecMigration("create the content, update, summary and checkpoint tables",
+		CreateQueryDBBeacon, LCUpdateCreateTable, HistoricalSummariesCreateTable, CheckpointCreateTable),
+}
+
 func (bs *BeaconStorage) setup() error {
-	if _, err := bs.db.Exec(CreateQueryDBBeacon); err != nil {
+	if err := storage.Migrate(bs.db, "beacon", migrations); err != nil {
+		return err
+	}
+	return bs.scoreLcUpdates()
+}
+
+// scoreLcUpdates scores the updates stored before updates were ranked. A
+// valid update has at least one participant, so its score is never zero.
+func (bs *BeaconStorage) scoreLcUpdates() error {
+	rows, err := bs.db.Query(LCUpdateUnscoredQuery)
+	if err != nil {
 		return err
 	}
-	if _, err := bs.db.Exec(LCUpdateCreateTable); err != nil {
+	scores := make(map[uint64]int64)
+	for rows.Next() {
+		var period uint64
+		var value []byte
+		if err = rows.Scan(&period, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		update, err := bs.decodeLcUpdate(value)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		scores[period] = UpdateScore(bs.spec, update)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
 		return err
 	}
+	for period, score := range scores {
+		if _, err = bs.db.Exec(LCUpdateScoreUpdateQuery, period, score); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -76,15 +160,24 @@ func (bs *BeaconStorage) Get(contentKey []byte, contentId []byte) ([]byte, error
 		}
 		return bs.getLcUpdateValueByRange(lightClientUpdateKey.StartPeriod, lightClientUpdateKey.StartPeriod+lightClientUpdateKey.Count)
 	case LightClientFinalityUpdate:
-		if bs.cache.FinalityUpdate == nil {
-			return nil, storage.ErrContentNotFound
+		key := new(LightClientFinalityUpdateKey)
+		if err := key.UnmarshalSSZ(contentKey[1:]); err != nil {
+			return nil, err
 		}
-		return bs.cache.FinalityUpdate, nil
+		return bs.getUpdate(&bs.cache.finalityUpdate, common.Slot(key.FinalizedSlot), bs.finalityUpdateTTL)
 	case LightClientOptimisticUpdate:
-		if bs.cache.OptimisticUpdate == nil {
-			return nil, storage.ErrContentNotFound
+		key := new(LightClientOptimisticUpdateKey)
+		if err := key.UnmarshalSSZ(contentKey[1:]); err != nil {
+			return nil, err
 		}
-		return bs.cache.OptimisticUpdate, nil
+		return bs.getUpdate(&bs.cache.optimisticUpdate, common.Slot(key.OptimisticSlot), bs.optimisticUpdateTTL)
+	case HistoricalSummaries:
+		key := new(HistoricalSummariesWithProofKey)
+		err := key.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey[1:]), uint64(len(contentKey[1:]))))
+		if err != nil {
+			return nil, err
+		}
+		return bs.getHistoricalSummaries(key.Epoch)
 	}
 	return nil, nil
 }
@@ -106,33 +199,234 @@ func (bs *BeaconStorage) Put(contentKey []byte, contentId []byte, content []byte
 			return err
 		}
 		for index, update := range *lightClientUpdateRange {
-			var buf bytes.Buffer
-			writer := codec.NewEncodingWriter(&buf)
-			err := update.Serialize(bs.spec, writer)
-			if err != nil {
-				return err
-			}
 			period := lightClientUpdateKey.StartPeriod + uint64(index)
-			err = bs.putLcUpdate(period, buf.Bytes())
+			err = bs.putLcUpdate(period, update)
 			if err != nil {
 				return err
 			}
 		}
 		return nil
 	case LightClientFinalityUpdate:
-		bs.cache.FinalityUpdate = content
+		var forkedUpdate ForkedLightClientFinalityUpdate
+		if err := forkedUpdate.Deserialize(bs.spec, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content)))); err != nil {
+			return err
+		}
+		update, err := FromLightClientFinalityUpdate(forkedUpdate.LightClientFinalityUpdate)
+		if err != nil {
+			return err
+		}
+		return bs.putUpdate(&bs.cache.finalityUpdate, &cachedUpdate{
+			content:       content,
+			slot:          update.FinalizedHeader.Slot,
+			signatureSlot: update.SignatureSlot,
+		}, bs.finalityUpdateTTL)
+	case LightClientOptimisticUpdate:
+		var forkedUpdate ForkedLightClientOptimisticUpdate
+		if err := forkedUpdate.Deserialize(bs.spec, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content)))); err != nil {
+			return err
+		}
+		update, err := FromLightClientOptimisticUpdate(forkedUpdate.LightClientOptimisticUpdate)
+		if err != nil {
+			return err
+		}
+		return bs.putUpdate(&bs.cache.optimisticUpdate, &cachedUpdate{
+			content:       content,
+			slot:          update.SignatureSlot,
+			signatureSlot: update.SignatureSlot,
+		}, bs.optimisticUpdateTTL)
+	case HistoricalSummaries:
+		key := new(HistoricalSummariesWithProofKey)
+		err := key.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey[1:]), uint64(len(contentKey[1:]))))
+		if err != nil {
+			return err
+		}
+		return bs.putHistoricalSummaries(key.Epoch, content)
+	}
+	return nil
+}
+
+// Delete deletes the content. The updates of all periods of an update range
+// are deleted, a finality or optimistic update is dropped from the cache.
+func (bs *BeaconStorage) Delete(contentKey []byte, contentId []byte) error {
+	switch storage.ContentType(contentKey[0]) {
+	case LightClientBootstrap:
+		_, err := bs.db.Exec(DeleteQueryBeacon, contentId)
+		return err
+	case LightClientUpdate:
+		key := new(LightClientUpdateKey)
+		if err := key.UnmarshalSSZ(contentKey[1:]); err != nil {
+			return err
+		}
+		_, err := bs.db.Exec(LCUpdateDeleteRangeQuery, key.StartPeriod, key.StartPeriod+key.Count)
+		return err
+	case LightClientFinalityUpdate:
+		bs.cache.lock.Lock()
+		defer bs.cache.lock.Unlock()
+		bs.cache.finalityUpdate = nil
 		return nil
 	case LightClientOptimisticUpdate:
-		bs.cache.OptimisticUpdate = content
+		bs.cache.lock.Lock()
+		defer bs.cache.lock.Unlock()
+		bs.cache.optimisticUpdate = nil
 		return nil
+	case HistoricalSummaries:
+		key := new(HistoricalSummariesWithProofKey)
+		err := key.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey[1:]), uint64(len(contentKey[1:]))))
+		if err != nil {
+			return err
+		}
+		_, err = bs.db.Exec(HistoricalSummariesDeleteQuery, key.Epoch)
+		return err
 	}
 	return nil
 }
 
+func (bs *BeaconStorage) currentSlot() common.Slot {
+	return bs.spec.TimeToSlot(common.Timestamp(bs.now().Unix()), common.Timestamp(BeaconGenesisTime))
+}
+
+func isExpired(update *cachedUpdate, ttl uint64, currentSlot common.Slot) bool {
+	return uint64(update.signatureSlot)+ttl < uint64(currentSlot)
+}
+
+// putUpdate replaces the held update if update is newer. Updates signed
+// more than a slot ahead of the slot clock or older than ttl slots are
+// refused, the error keeps them from being gossiped further.
+func (bs *BeaconStorage) putUpdate(held **cachedUpdate, update *cachedUpdate, ttl uint64) error {
+	currentSlot := bs.currentSlot()
+	if update.signatureSlot > currentSlot+1 {
+		return fmt.Errorf("%w: slot %d, current slot %d", ErrUpdateFromFuture, update.signatureSlot, currentSlot)
+	}
+	if isExpired(update, ttl, currentSlot) {
+		return fmt.Errorf("%w: slot %d, current slot %d", ErrUpdateExpired, update.signatureSlot, currentSlot)
+	}
+	bs.cache.lock.Lock()
+	defer bs.cache.lock.Unlock()
+	if *held != nil && !isExpired(*held, ttl, currentSlot) && !update.isNewer(*held) {
+		return ErrUpdateNotNewer
+	}
+	*held = update
+	return nil
+}
+
+// getUpdate returns the held update unless it expired or the key asks for a
+// newer slot than the update is for.
+func (bs *BeaconStorage) getUpdate(held **cachedUpdate, slot common.Slot, ttl uint64) ([]byte, error) {
+	bs.cache.lock.Lock()
+	defer bs.cache.lock.Unlock()
+	if *held == nil {
+		return nil, storage.ErrContentNotFound
+	}
+	if isExpired(*held, ttl, bs.currentSlot()) {
+		*held = nil
+		return nil, storage.ErrContentNotFound
+	}
+	if slot > (*held).slot {
+		return nil, storage.ErrContentNotFound
+	}
+	return (*held).content, nil
+}
+
 func (bs *BeaconStorage) Radius() *uint256.Int {
 	return storage.MaxDistance
 }
 
+// UsedSize returns the size of the stored bootstraps, light client updates and
+// historical summaries.
+func (bs *BeaconStorage) UsedSize() (uint64, error) {
+	var total uint64
+	for _, query := range []string{TotalDataSizeQueryBeacon, LCUpdateTotalSizeQuery, HistoricalSummariesTotalSizeQuery} {
+		var size float64
+		if err := bs.db.QueryRow(query).Scan(&size); err != nil {
+			return 0, err
+		}
+		total += uint64(size)
+	}
+	return total, nil
+}
+
+// SetCapacity changes the capacity of the storage. The beacon network keeps
+// all of its content, which is small, so nothing is pruned.
+func (bs *BeaconStorage) SetCapacity(capacity uint64) error {
+	bs.storageCapacityInBytes = capacity
+	return nil
+}
+
+// Close closes the database of the storage.
+func (bs *BeaconStorage) Close() error {
+	return bs.db.Close()
+}
+
+// LocalContentKeys returns a page of the keys of the stored bootstraps, light
+// client updates and historical summaries, ordered by content type. Updates
+// are listed by the key of their period alone. The finality and optimistic
+// updates are only kept in memory and not listed.
+func (bs *BeaconStorage) LocalContentKeys(offset, limit uint64, contentType *byte) ([][]byte, error) {
+	var selector any
+	if contentType != nil {
+		selector = *contentType
+	}
+	rows, err := bs.db.Query(LocalContentKeysQueryBeacon, selector, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([][]byte, 0, limit)
+	for rows.Next() {
+		var (
+			keyType    byte
+			contentKey []byte
+			number     uint64
+		)
+		if err = rows.Scan(&keyType, &contentKey, &number); err != nil {
+			return nil, err
+		}
+		switch storage.ContentType(keyType) {
+		case LightClientUpdate:
+			key, err := (&LightClientUpdateKey{StartPeriod: number, Count: 1}).MarshalSSZ()
+			if err != nil {
+				return nil, err
+			}
+			contentKey = storage.NewContentKey(LightClientUpdate, key).Encode()
+		case HistoricalSummaries:
+			var key bytes.Buffer
+			if err = (HistoricalSummariesWithProofKey{Epoch: number}).Serialize(codec.NewEncodingWriter(&key)); err != nil {
+				return nil, err
+			}
+			contentKey = storage.NewContentKey(HistoricalSummaries, key.Bytes()).Encode()
+		}
+		keys = append(keys, contentKey)
+	}
+	return keys, rows.Err()
+}
+
+// Stats returns the number and size of the stored bootstraps, light client
+// updates and historical summaries. The beacon network keeps its content
+// regardless of the distance, so there is no farthest distance.
+func (bs *BeaconStorage) Stats() (*storage.StorageStats, error) {
+	stats := &storage.StorageStats{
+		ContentTypes: make(map[byte]storage.ContentTypeStats),
+		Radius:       bs.Radius(),
+	}
+	for contentType, query := range map[storage.ContentType]string{
+		LightClientBootstrap: ContentStatsQueryBeacon,
+		LightClientUpdate:    LCUpdateStatsQuery,
+		HistoricalSummaries:  HistoricalSummariesStatsQuery,
+	} {
+		var (
+			count uint64
+			size  float64
+		)
+		if err := bs.db.QueryRow(query).Scan(&count, &size); err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			stats.ContentTypes[byte(contentType)] = storage.ContentTypeStats{Count: count, Size: uint64(size)}
+		}
+	}
+	return stats, nil
+}
+
 func (bs *BeaconStorage) getContentValue(contentId []byte) ([]byte, error) {
 	res := make([]byte, 0)
 	err := bs.db.QueryRowContext(context.Background(), ContentValueLookupQueryBeacon, contentId).Scan(&res)
@@ -142,14 +436,14 @@ func (bs *BeaconStorage) getContentValue(contentId []byte) ([]byte, error) {
 	return res, err
 }
 
+// getLcUpdateValueByRange returns the best known update of every period in
+// [start, end). Ranges with a missing period are not served at all.
 func (bs *BeaconStorage) getLcUpdateValueByRange(start, end uint64) ([]byte, error) {
-	// LightClientUpdateRange := make([]ForkedLightClientUpdate, 0)
 	var lightClientUpdateRange LightClientUpdateRange
 	rows, err := bs.db.QueryContext(context.Background(), LCUpdateLookupQueryByRange, start, end)
 	if err != nil {
 		return nil, err
 	}
-	hasData := false
 	defer func(rows *sql.Rows) {
 		err = rows.Close()
 		if err != nil {
@@ -157,7 +451,6 @@ func (bs *BeaconStorage) getLcUpdateValueByRange(start, end uint64) ([]byte, err
 		}
 	}(rows)
 	for rows.Next() {
-		hasData = true
 		var val []byte
 		err = rows.Scan(&val)
 		if err != nil {
@@ -171,7 +464,10 @@ func (bs *BeaconStorage) getLcUpdateValueByRange(start, end uint64) ([]byte, err
 		}
 		lightClientUpdateRange = append(lightClientUpdateRange, *update)
 	}
-	if !hasData {
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if uint64(len(lightClientUpdateRange)) != end-start {
 		return nil, storage.ErrContentNotFound
 	}
 	var buf bytes.Buffer
@@ -192,11 +488,110 @@ func (bs *BeaconStorage) putContentValue(contentId, contentKey, value []byte) er
 	return err
 }
 
-func (bs *BeaconStorage) putLcUpdate(period uint64, value []byte) error {
-	_, err := bs.db.ExecContext(context.Background(), InsertLCUpdateQuery, period, value, 0, len(value))
-	if metrics.Enabled && err == nil {
-		portalStorageMetrics.EntriesCount.Inc(1)
-		portalStorageMetrics.ContentStorageUsage.Inc(int64(len(value)))
+// putLcUpdate stores the update of a period unless a better one is stored
+// already, see IsBetterUpdate.
+func (bs *BeaconStorage) putLcUpdate(period uint64, update ForkedLightClientUpdate) error {
+	genericUpdate, err := FromLightClientUpdate(update.LightClientUpdate)
+	if err != nil {
+		return err
 	}
-	return err
+	score := UpdateScore(bs.spec, genericUpdate)
+	var buf bytes.Buffer
+	if err = update.Serialize(bs.spec, codec.NewEncodingWriter(&buf)); err != nil {
+		return err
+	}
+	value := buf.Bytes()
+
+	tx, err := bs.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldValue []byte
+	var oldScore int64
+	err = tx.QueryRow(LCUpdateScoreLookupQuery, period).Scan(&oldValue, &oldScore)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case score < oldScore:
+		return nil
+	case score == oldScore:
+		oldUpdate, err := bs.decodeLcUpdate(oldValue)
+		if err != nil {
+			return err
+		}
+		if !IsBetterUpdate(bs.spec, genericUpdate, oldUpdate) {
+			return nil
+		}
+	}
+	if _, err = tx.Exec(InsertLCUpdateQuery, period, value, score, len(value)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	if metrics.Enabled {
+		if oldValue == nil {
+			portalStorageMetrics.EntriesCount.Inc(1)
+		}
+		portalStorageMetrics.ContentStorageUsage.Inc(int64(len(value) - len(oldValue)))
+	}
+	return nil
+}
+
+func (bs *BeaconStorage) decodeLcUpdate(value []byte) (*GenericUpdate, error) {
+	var update ForkedLightClientUpdate
+	err := update.Deserialize(bs.spec, codec.NewDecodingReader(bytes.NewReader(value), uint64(len(value))))
+	if err != nil {
+		return nil, err
+	}
+	return FromLightClientUpdate(update.LightClientUpdate)
+}
+
+func (bs *BeaconStorage) getHistoricalSummaries(epoch uint64) ([]byte, error) {
+	var res []byte
+	err := bs.db.QueryRowContext(context.Background(), HistoricalSummariesLookupQuery, epoch).Scan(&res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrContentNotFound
+	}
+	return res, err
+}
+
+// putHistoricalSummaries stores the historical summaries of an epoch. The
+// summaries only grow over time, so only those of the latest epoch are kept.
+func (bs *BeaconStorage) putHistoricalSummaries(epoch uint64, value []byte) error {
+	tx, err := bs.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var latestEpoch sql.NullInt64
+	if err = tx.QueryRow(HistoricalSummariesLatestEpochQuery).Scan(&latestEpoch); err != nil {
+		return err
+	}
+	if latestEpoch.Valid && uint64(latestEpoch.Int64) > epoch {
+		return nil
+	}
+	var staleCount int64
+	var staleSize float64
+	if err = tx.QueryRow(HistoricalSummariesStaleSizeQuery, epoch).Scan(&staleCount, &staleSize); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(HistoricalSummariesDeleteStaleQuery, epoch); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(InsertHistoricalSummariesQuery, epoch, value, len(value)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	if metrics.Enabled {
+		portalStorageMetrics.EntriesCount.Inc(1 - staleCount)
+		portalStorageMetrics.ContentStorageUsage.Inc(int64(len(value)) - int64(staleSize))
+	}
+	return nil
 }
@@ -1,20 +1,28 @@
 package beacon
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/portalnetwork/storage"
 	"github.com/holiman/uint256"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/ztyp/codec"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,6 +59,13 @@ func TestGetAndPut(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, value, res)
 	}
+
+	// the portal protocol closes the storage when it stops
+	closer, ok := beaconStorage.(io.Closer)
+	require.True(t, ok)
+	require.NoError(t, closer.Close())
+	_, err = beaconStorage.(*BeaconStorage).UsedSize()
+	require.Error(t, err)
 }
 
 func genStorage(testDir string) (storage.ContentStorage, error) {
@@ -113,3 +128,325 @@ func clearNodeData(nodeDataDir string) {
 		fmt.Println(err)
 	}
 }
+
+func TestBestLightClientUpdate(t *testing.T) {
+	testDir := "./"
+	beaconStorage, err := genStorage(testDir)
+	require.NoError(t, err)
+	defer clearNodeData(testDir)
+
+	// newUpdate returns an update of period 10 signed by the given number of
+	// participants.
+	newUpdate := func(participants int, attestedSlot common.Slot) ForkedLightClientUpdate {
+		update, err := GetClientUpdate(0)
+		require.NoError(t, err)
+		capellaUpdate := update.LightClientUpdate.(*capella.LightClientUpdate)
+		bits := make(altair.SyncCommitteeBits, len(capellaUpdate.SyncAggregate.SyncCommitteeBits))
+		for i := 0; i < participants/8; i++ {
+			bits[i] = 0xff
+		}
+		capellaUpdate.SyncAggregate.SyncCommitteeBits = bits
+		capellaUpdate.AttestedHeader.Beacon.Slot = attestedSlot
+		capellaUpdate.SignatureSlot = attestedSlot + 1
+		capellaUpdate.FinalizedHeader.Beacon.Slot = attestedSlot - 64
+		return update
+	}
+	put := func(key *LightClientUpdateKey, updates ...ForkedLightClientUpdate) {
+		t.Helper()
+		keyData, err := key.MarshalSSZ()
+		require.NoError(t, err)
+		contentKey := append([]byte{byte(LightClientUpdate)}, keyData...)
+		var buf bytes.Buffer
+		require.NoError(t, LightClientUpdateRange(updates).Serialize(configs.Mainnet, codec.NewEncodingWriter(&buf)))
+		require.NoError(t, beaconStorage.Put(contentKey, defaultContentIdFunc(contentKey), buf.Bytes()))
+	}
+	get := func(key *LightClientUpdateKey) (LightClientUpdateRange, error) {
+		t.Helper()
+		keyData, err := key.MarshalSSZ()
+		require.NoError(t, err)
+		contentKey := append([]byte{byte(LightClientUpdate)}, keyData...)
+		data, err := beaconStorage.Get(contentKey, defaultContentIdFunc(contentKey))
+		if err != nil {
+			return nil, err
+		}
+		var updates LightClientUpdateRange
+		require.NoError(t, updates.Deserialize(configs.Mainnet, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))))
+		return updates, nil
+	}
+	attestedSlot := func(updates LightClientUpdateRange, i int) common.Slot {
+		return updates[i].LightClientUpdate.(*capella.LightClientUpdate).AttestedHeader.Beacon.Slot
+	}
+	participants := func(updates LightClientUpdateRange, i int) uint64 {
+		return countParticipants(configs.Mainnet, updates[i].LightClientUpdate.(*capella.LightClientUpdate).SyncAggregate.SyncCommitteeBits)
+	}
+
+	periodStart := common.Slot(10 * 8192)
+	key := &LightClientUpdateKey{StartPeriod: 10, Count: 1}
+
+	put(key, newUpdate(296, periodStart+200))
+	updates, err := get(key)
+	require.NoError(t, err)
+	require.Equal(t, uint64(296), participants(updates, 0))
+
+	// a supermajority replaces the stored update
+	put(key, newUpdate(400, periodStart+300))
+	updates, err = get(key)
+	require.NoError(t, err)
+	require.Equal(t, uint64(400), participants(updates, 0))
+
+	// a worse update does not
+	put(key, newUpdate(296, periodStart+100))
+	updates, err = get(key)
+	require.NoError(t, err)
+	require.Equal(t, uint64(400), participants(updates, 0))
+
+	// with the same score the older update wins
+	put(key, newUpdate(400, periodStart+250))
+	updates, err = get(key)
+	require.NoError(t, err)
+	require.Equal(t, periodStart+250, attestedSlot(updates, 0))
+	put(key, newUpdate(400, periodStart+260))
+	updates, err = get(key)
+	require.NoError(t, err)
+	require.Equal(t, periodStart+250, attestedSlot(updates, 0))
+
+	// partial ranges are not served
+	put(&LightClientUpdateKey{StartPeriod: 11, Count: 1}, newUpdate(400, periodStart+8192+100))
+	updates, err = get(&LightClientUpdateKey{StartPeriod: 10, Count: 2})
+	require.NoError(t, err)
+	require.Len(t, updates, 2)
+	_, err = get(&LightClientUpdateKey{StartPeriod: 10, Count: 3})
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+	_, err = get(&LightClientUpdateKey{StartPeriod: 9, Count: 2})
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+}
+
+func TestIsBetterUpdate(t *testing.T) {
+	spec := configs.Mainnet
+	bits := func(participants int) altair.SyncCommitteeBits {
+		bits := make(altair.SyncCommitteeBits, spec.SYNC_COMMITTEE_SIZE/8)
+		for i := 0; i < participants/8; i++ {
+			bits[i] = 0xff
+		}
+		return bits
+	}
+	branch := []common.Root{{1}}
+	update := func(participants int, finality bool) *GenericUpdate {
+		u := &GenericUpdate{
+			AttestedHeader: &common.BeaconBlockHeader{Slot: 8192*10 + 100},
+			SyncAggregate:  &altair.SyncAggregate{SyncCommitteeBits: bits(participants)},
+			SignatureSlot:  8192*10 + 101,
+		}
+		if finality {
+			u.FinalizedHeader = &common.BeaconBlockHeader{Slot: 8192*10 + 36}
+			u.FinalityBranch = branch
+		}
+		return u
+	}
+	// supermajority comes first
+	require.True(t, IsBetterUpdate(spec, update(344, false), update(336, true)))
+	// without supermajority participation decides
+	require.True(t, IsBetterUpdate(spec, update(200, false), update(160, true)))
+	// with equal participation finality decides
+	require.True(t, IsBetterUpdate(spec, update(200, true), update(200, false)))
+	// with supermajority finality beats participation
+	require.True(t, IsBetterUpdate(spec, update(400, true), update(512, false)))
+	require.False(t, IsBetterUpdate(spec, update(400, true), update(400, true)))
+}
+
+func TestHistoricalSummariesStorage(t *testing.T) {
+	testDir := "./"
+	beaconStorage, err := genStorage(testDir)
+	require.NoError(t, err)
+	defer clearNodeData(testDir)
+
+	contentKey := func(epoch uint64) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, HistoricalSummariesWithProofKey{Epoch: epoch}.Serialize(codec.NewEncodingWriter(&buf)))
+		return append([]byte{byte(HistoricalSummaries)}, buf.Bytes()...)
+	}
+	put := func(epoch uint64, content []byte) {
+		key := contentKey(epoch)
+		require.NoError(t, beaconStorage.Put(key, defaultContentIdFunc(key), content))
+	}
+	get := func(epoch uint64) ([]byte, error) {
+		key := contentKey(epoch)
+		return beaconStorage.Get(key, defaultContentIdFunc(key))
+	}
+
+	_, err = get(100)
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+	put(100, []byte{1})
+	res, err := get(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, res)
+
+	// a newer epoch replaces the stored summaries
+	put(101, []byte{2})
+	res, err = get(101)
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, res)
+	_, err = get(100)
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+
+	// an older one is ignored, the same epoch is replaced
+	put(100, []byte{3})
+	_, err = get(100)
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+	put(101, []byte{4})
+	res, err = get(101)
+	require.NoError(t, err)
+	require.Equal(t, []byte{4}, res)
+}
+
+func TestLightClientUpdateFreshness(t *testing.T) {
+	testDir := "./"
+	contentStorage, err := genStorage(testDir)
+	require.NoError(t, err)
+	defer clearNodeData(testDir)
+	beaconStorage := contentStorage.(*BeaconStorage)
+
+	_, content := readPortalFixture(t, "light_client_optimistic_update.json")
+	var forkedUpdate ForkedLightClientOptimisticUpdate
+	require.NoError(t, forkedUpdate.Deserialize(configs.Mainnet, codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content)))))
+	update := forkedUpdate.LightClientOptimisticUpdate.(*capella.LightClientOptimisticUpdate)
+	signatureSlot := update.SignatureSlot
+
+	// withSlot re-encodes the fixture as if it was signed in slot
+	withSlot := func(slot common.Slot) []byte {
+		update.SignatureSlot = slot
+		var buf bytes.Buffer
+		require.NoError(t, forkedUpdate.Serialize(configs.Mainnet, codec.NewEncodingWriter(&buf)))
+		return buf.Bytes()
+	}
+	setSlot := func(slot common.Slot) {
+		beaconStorage.now = func() time.Time {
+			return time.Unix(int64(BeaconGenesisTime)+int64(slot)*int64(configs.Mainnet.SECONDS_PER_SLOT), 0)
+		}
+	}
+	contentKey := func(slot common.Slot) []byte {
+		key, err := (&LightClientOptimisticUpdateKey{OptimisticSlot: uint64(slot)}).MarshalSSZ()
+		require.NoError(t, err)
+		return storage.NewContentKey(LightClientOptimisticUpdate, key).Encode()
+	}
+	put := func(content []byte) error {
+		key := contentKey(0)
+		return beaconStorage.Put(key, defaultContentIdFunc(key), content)
+	}
+	get := func(slot common.Slot) ([]byte, error) {
+		key := contentKey(slot)
+		return beaconStorage.Get(key, defaultContentIdFunc(key))
+	}
+
+	setSlot(signatureSlot)
+	require.NoError(t, put(withSlot(signatureSlot)))
+	res, err := get(signatureSlot)
+	require.NoError(t, err)
+	require.Equal(t, withSlot(signatureSlot), res)
+
+	// a key asking for a newer slot than the stored update is not answered
+	_, err = get(signatureSlot + 1)
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+
+	// older, repeated and future updates are refused
+	require.ErrorIs(t, put(withSlot(signatureSlot-1)), ErrUpdateNotNewer)
+	require.ErrorIs(t, put(withSlot(signatureSlot)), ErrUpdateNotNewer)
+	require.ErrorIs(t, put(withSlot(signatureSlot+2)), ErrUpdateFromFuture)
+	require.NoError(t, put(withSlot(signatureSlot+1)))
+	res, err = get(signatureSlot + 1)
+	require.NoError(t, err)
+	require.Equal(t, withSlot(signatureSlot+1), res)
+
+	// the update expires after the time to live
+	setSlot(signatureSlot + 1 + common.Slot(DefaultOptimisticUpdateTTL))
+	_, err = get(0)
+	require.NoError(t, err)
+	setSlot(signatureSlot + 2 + common.Slot(DefaultOptimisticUpdateTTL))
+	_, err = get(0)
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+	require.ErrorIs(t, put(withSlot(signatureSlot+1)), ErrUpdateExpired)
+}
+
+func TestLocalContentKeys(t *testing.T) {
+	testDir := "./"
+	contentStorage, err := genStorage(testDir)
+	require.NoError(t, err)
+	defer clearNodeData(testDir)
+	beaconStorage := contentStorage.(*BeaconStorage)
+
+	for _, name := range []string{"light_client_bootstrap.json", "light_client_updates_by_range.json"} {
+		key, value := readPortalFixture(t, name)
+		require.NoError(t, beaconStorage.Put(key, defaultContentIdFunc(key), value))
+	}
+	var summariesKey bytes.Buffer
+	require.NoError(t, HistoricalSummariesWithProofKey{Epoch: 100}.Serialize(codec.NewEncodingWriter(&summariesKey)))
+	key := append([]byte{byte(HistoricalSummaries)}, summariesKey.Bytes()...)
+	require.NoError(t, beaconStorage.Put(key, defaultContentIdFunc(key), []byte{1}))
+
+	keys, err := beaconStorage.LocalContentKeys(0, 100, nil)
+	require.NoError(t, err)
+	stats, err := beaconStorage.Stats()
+	require.NoError(t, err)
+	require.Nil(t, stats.FarthestDistance)
+	require.Len(t, stats.ContentTypes, 3)
+	counts := make(map[byte]uint64)
+	for _, contentKey := range keys {
+		counts[contentKey[0]]++
+		// every listed key can be looked up
+		_, err = beaconStorage.Get(contentKey, defaultContentIdFunc(contentKey))
+		require.NoError(t, err)
+	}
+	require.Equal(t, key, keys[len(keys)-1])
+	require.Len(t, counts, len(stats.ContentTypes))
+	for contentType, typeStats := range stats.ContentTypes {
+		require.Equal(t, typeStats.Count, counts[contentType])
+		require.NotZero(t, typeStats.Size)
+	}
+
+	// the keys are paged and filtered by content type
+	page, err := beaconStorage.LocalContentKeys(1, 2, nil)
+	require.NoError(t, err)
+	require.Equal(t, keys[1:3], page)
+	updateType := byte(LightClientUpdate)
+	page, err = beaconStorage.LocalContentKeys(0, 100, &updateType)
+	require.NoError(t, err)
+	require.Len(t, page, int(counts[updateType]))
+	for _, contentKey := range page {
+		require.Equal(t, updateType, contentKey[0])
+	}
+}
+
+func TestScrubber(t *testing.T) {
+	testDir := "./"
+	contentStorage, err := genStorage(testDir)
+	require.NoError(t, err)
+	defer clearNodeData(testDir)
+	beaconStorage := contentStorage.(*BeaconStorage)
+
+	var valid [][]byte
+	for _, name := range []string{"light_client_bootstrap.json", "light_client_updates_by_range.json"} {
+		key, value := readPortalFixture(t, name)
+		require.NoError(t, beaconStorage.Put(key, defaultContentIdFunc(key), value))
+		valid = append(valid, key)
+	}
+	// a bootstrap stored under the block hash of another header
+	bootstrapKey, bootstrap := readPortalFixture(t, "light_client_bootstrap.json")
+	wrongKey := append([]byte{}, bootstrapKey...)
+	wrongKey[len(wrongKey)-1] ^= 1
+	require.NoError(t, beaconStorage.Put(wrongKey, defaultContentIdFunc(wrongKey), bootstrap))
+	keys, err := beaconStorage.LocalContentKeys(0, 100, nil)
+	require.NoError(t, err)
+
+	scrubber, err := NewScrubber(beaconStorage, storage.ScrubConfig{})
+	require.NoError(t, err)
+	res, err := scrubber.Scrub(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, len(keys), res.Checked)
+	require.Equal(t, 1, res.Invalid)
+	_, err = beaconStorage.Get(wrongKey, defaultContentIdFunc(wrongKey))
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+	for _, key := range valid {
+		_, err = beaconStorage.Get(key, defaultContentIdFunc(key))
+		require.NoError(t, err)
+	}
+}
@@ -180,7 +180,10 @@ func GetHistorySummariesWithProof() (HistoricalSummariesWithProof, common.Root,
 	if err != nil {
 		return HistoricalSummariesWithProof{}, common.Root{}, err
 	}
-	summariesProof := [5]common.Bytes32{tree.Root(proof[0]), tree.Root(proof[1]), tree.Root(proof[2]), tree.Root(proof[3]), tree.Root(proof[4])}
+	summariesProof := make([]common.Bytes32, len(proof))
+	for i := range proof {
+		copy(summariesProof[i][:], proof[i])
+	}
 	return HistoricalSummariesWithProof{
 		EPOCH:               common.Epoch(uint64(beaconState.Slot) / 32),
 		HistoricalSummaries: beaconState.HistoricalSummaries,
@@ -191,52 +194,82 @@ func GetHistorySummariesWithProof() (HistoricalSummariesWithProof, common.Root,
 }
 
 func BuildHistoricalSummariesProof(beaconState deneb.BeaconState) ([][]byte, error) {
-	leaves := make([][32]byte, 32)
-	leaves[0] = beaconState.GenesisTime.HashTreeRoot(tree.GetHashFn())
-	leaves[1] = beaconState.GenesisValidatorsRoot.HashTreeRoot(tree.GetHashFn())
-	leaves[2] = beaconState.Slot.HashTreeRoot(tree.GetHashFn())
-	leaves[3] = beaconState.Fork.HashTreeRoot(tree.GetHashFn())
-	leaves[4] = beaconState.LatestBlockHeader.HashTreeRoot(tree.GetHashFn())
-	leaves[5] = beaconState.BlockRoots.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[6] = beaconState.StateRoots.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[7] = beaconState.HistoricalRoots.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[8] = beaconState.Eth1Data.HashTreeRoot(tree.GetHashFn())
-	leaves[9] = beaconState.Eth1DataVotes.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[10] = beaconState.Eth1DepositIndex.HashTreeRoot(tree.GetHashFn())
-	leaves[11] = beaconState.Validators.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[12] = beaconState.Balances.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[13] = beaconState.RandaoMixes.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[14] = beaconState.Slashings.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[15] = beaconState.PreviousEpochParticipation.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[16] = beaconState.CurrentEpochParticipation.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[17] = beaconState.JustificationBits.HashTreeRoot(tree.GetHashFn())
-	leaves[18] = beaconState.PreviousJustifiedCheckpoint.HashTreeRoot(tree.GetHashFn())
-	leaves[19] = beaconState.CurrentJustifiedCheckpoint.HashTreeRoot(tree.GetHashFn())
-	leaves[20] = beaconState.FinalizedCheckpoint.HashTreeRoot(tree.GetHashFn())
-	leaves[21] = beaconState.InactivityScores.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[22] = beaconState.CurrentSyncCommittee.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[23] = beaconState.NextSyncCommittee.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[24] = beaconState.LatestExecutionPayloadHeader.HashTreeRoot(tree.GetHashFn())
-	leaves[25] = beaconState.NextWithdrawalIndex.HashTreeRoot(tree.GetHashFn())
-	leaves[26] = beaconState.NextWithdrawalValidatorIndex.HashTreeRoot(tree.GetHashFn())
-	leaves[27] = beaconState.HistoricalSummaries.HashTreeRoot(configs.Mainnet, tree.GetHashFn())
-	leaves[28] = tree.Root{}
-	leaves[29] = tree.Root{}
-	leaves[30] = tree.Root{}
-	leaves[31] = tree.Root{}
-
-	leavesBytes := make([][]byte, 0)
-	for _, item := range leaves {
-		dest := make([]byte, len(item))
-		copy(dest, item[:])
-		leavesBytes = append(leavesBytes, dest)
+	return proveStateField(denebStateLeaves(&beaconState), 59)
+}
+
+// BuildElectraHistoricalSummariesProof proves the historical summaries of an
+// Electra state, the state has more than 32 fields so the proof is one level
+// deeper than before Electra.
+func BuildElectraHistoricalSummariesProof(beaconState ElectraBeaconState) ([][]byte, error) {
+	leaves := append(denebStateLeaves(&beaconState.BeaconState),
+		beaconState.DepositRequestsStartIndex.HashTreeRoot(tree.GetHashFn()),
+		beaconState.DepositBalanceToConsume.HashTreeRoot(tree.GetHashFn()),
+		beaconState.ExitBalanceToConsume.HashTreeRoot(tree.GetHashFn()),
+		beaconState.EarliestExitEpoch.HashTreeRoot(tree.GetHashFn()),
+		beaconState.ConsolidationBalanceToConsume.HashTreeRoot(tree.GetHashFn()),
+		beaconState.EarliestConsolidationEpoch.HashTreeRoot(tree.GetHashFn()),
+		beaconState.PendingDeposits.HashTreeRoot(tree.GetHashFn()),
+		beaconState.PendingPartialWithdrawals.HashTreeRoot(tree.GetHashFn()),
+		beaconState.PendingConsolidations.HashTreeRoot(tree.GetHashFn()),
+	)
+	return proveStateField(leaves, 91)
+}
+
+// denebStateLeaves returns the roots of the fields of a Deneb beacon state, in
+// order.
+func denebStateLeaves(beaconState *deneb.BeaconState) []tree.Root {
+	return []tree.Root{
+		beaconState.GenesisTime.HashTreeRoot(tree.GetHashFn()),
+		beaconState.GenesisValidatorsRoot.HashTreeRoot(tree.GetHashFn()),
+		beaconState.Slot.HashTreeRoot(tree.GetHashFn()),
+		beaconState.Fork.HashTreeRoot(tree.GetHashFn()),
+		beaconState.LatestBlockHeader.HashTreeRoot(tree.GetHashFn()),
+		beaconState.BlockRoots.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.StateRoots.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.HistoricalRoots.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.Eth1Data.HashTreeRoot(tree.GetHashFn()),
+		beaconState.Eth1DataVotes.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.Eth1DepositIndex.HashTreeRoot(tree.GetHashFn()),
+		beaconState.Validators.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.Balances.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.RandaoMixes.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.Slashings.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.PreviousEpochParticipation.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.CurrentEpochParticipation.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.JustificationBits.HashTreeRoot(tree.GetHashFn()),
+		beaconState.PreviousJustifiedCheckpoint.HashTreeRoot(tree.GetHashFn()),
+		beaconState.CurrentJustifiedCheckpoint.HashTreeRoot(tree.GetHashFn()),
+		beaconState.FinalizedCheckpoint.HashTreeRoot(tree.GetHashFn()),
+		beaconState.InactivityScores.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.CurrentSyncCommittee.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.NextSyncCommittee.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+		beaconState.LatestExecutionPayloadHeader.HashTreeRoot(tree.GetHashFn()),
+		beaconState.NextWithdrawalIndex.HashTreeRoot(tree.GetHashFn()),
+		beaconState.NextWithdrawalValidatorIndex.HashTreeRoot(tree.GetHashFn()),
+		beaconState.HistoricalSummaries.HashTreeRoot(configs.Mainnet, tree.GetHashFn()),
+	}
+}
+
+// proveStateField builds the merkle proof of the leaf at gIndex of the state
+// whose field roots are leaves, padding them to a power of two.
+func proveStateField(leaves []tree.Root, gIndex int) ([][]byte, error) {
+	size := 1
+	for size < len(leaves) {
+		size *= 2
+	}
+	leavesBytes := make([][]byte, size)
+	for i := range leavesBytes {
+		leavesBytes[i] = make([]byte, 32)
+		if i < len(leaves) {
+			copy(leavesBytes[i], leaves[i][:])
+		}
 	}
 
 	tree, err := ssz.TreeFromChunks(leavesBytes)
 	if err != nil {
 		return nil, err
 	}
-	proof, err := tree.Prove(59)
+	proof, err := tree.Prove(gIndex)
 	if err != nil {
 		return nil, err
 	}
@@ -8,18 +8,45 @@ import (
 	"github.com/protolambda/zrnt/eth2/beacon/capella"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/configs"
 	"github.com/protolambda/ztyp/codec"
 	"github.com/protolambda/ztyp/tree"
 )
 
 const MaxRequestLightClientUpdates = 128
 
+// MainnetForks is the fork schedule of mainnet, the chain the beacon network
+// serves.
+var MainnetForks = NewForkSchedule(configs.Mainnet, Mainnet().Chain)
+
 var (
-	Bellatrix common.ForkDigest = [4]byte{0x0, 0x0, 0x0, 0x0}
-	Capella   common.ForkDigest = [4]byte{0xbb, 0xa4, 0xda, 0x96}
-	Deneb     common.ForkDigest = [4]byte{0x6a, 0x95, 0xa1, 0xa9}
+	Altair    = mustForkDigest("altair")
+	Bellatrix = mustForkDigest("bellatrix")
+	Capella   = mustForkDigest("capella")
+	Deneb     = mustForkDigest("deneb")
+	Electra   = mustForkDigest("electra")
 )
 
+func mustForkDigest(name string) common.ForkDigest {
+	digest, err := forkDigest(name)
+	if err != nil {
+		panic(err)
+	}
+	return digest
+}
+
+// forkName returns the beacon-API name of the fork identified by digest.
+func forkName(digest common.ForkDigest) (string, error) {
+	fork, err := MainnetForks.ByDigest(digest)
+	return fork.Name, err
+}
+
+// forkDigest is the inverse of forkName.
+func forkDigest(name string) (common.ForkDigest, error) {
+	fork, err := MainnetForks.ByName(name)
+	return fork.Digest, err
+}
+
 // note: We changed the generated file since fastssz issues which can't be passed by the CI, so we commented the go:generate line
 ///go:generate sszgen --path types.go --exclude-objs ForkedLightClientBootstrap,ForkedLightClientUpdate,LightClientUpdateRange,ForkedLightClientOptimisticUpdate,ForkedLightClientFinalityUpdate,HistoricalSummariesProof,HistoricalSummariesWithProof,ForkedHistoricalSummariesWithProof
 
@@ -82,12 +109,14 @@ func (flcb *ForkedLightClientBootstrap) Deserialize(spec *common.Spec, dr *codec
 		return err
 	}
 
-	if flcb.ForkDigest == Bellatrix {
+	if flcb.ForkDigest == Altair || flcb.ForkDigest == Bellatrix {
 		flcb.Bootstrap = &altair.LightClientBootstrap{}
 	} else if flcb.ForkDigest == Capella {
 		flcb.Bootstrap = &capella.LightClientBootstrap{}
 	} else if flcb.ForkDigest == Deneb {
 		flcb.Bootstrap = &deneb.LightClientBootstrap{}
+	} else if flcb.ForkDigest == Electra {
+		flcb.Bootstrap = &ElectraLightClientBootstrap{}
 	} else {
 		return errors.New("unknown fork digest")
 	}
@@ -130,12 +159,14 @@ func (flcu *ForkedLightClientUpdate) Deserialize(spec *common.Spec, dr *codec.De
 		return err
 	}
 
-	if flcu.ForkDigest == Bellatrix {
+	if flcu.ForkDigest == Altair || flcu.ForkDigest == Bellatrix {
 		flcu.LightClientUpdate = &altair.LightClientUpdate{}
 	} else if flcu.ForkDigest == Capella {
 		flcu.LightClientUpdate = &capella.LightClientUpdate{}
 	} else if flcu.ForkDigest == Deneb {
 		flcu.LightClientUpdate = &deneb.LightClientUpdate{}
+	} else if flcu.ForkDigest == Electra {
+		flcu.LightClientUpdate = &ElectraLightClientUpdate{}
 	} else {
 		return errors.New("unknown fork digest")
 	}
@@ -215,12 +246,15 @@ func (flcou *ForkedLightClientOptimisticUpdate) Deserialize(spec *common.Spec, d
 		return err
 	}
 
-	if flcou.ForkDigest == Bellatrix {
+	if flcou.ForkDigest == Altair || flcou.ForkDigest == Bellatrix {
 		flcou.LightClientOptimisticUpdate = &altair.LightClientOptimisticUpdate{}
 	} else if flcou.ForkDigest == Capella {
 		flcou.LightClientOptimisticUpdate = &capella.LightClientOptimisticUpdate{}
 	} else if flcou.ForkDigest == Deneb {
 		flcou.LightClientOptimisticUpdate = &deneb.LightClientOptimisticUpdate{}
+	} else if flcou.ForkDigest == Electra {
+		// The optimistic update is unchanged in Electra.
+		flcou.LightClientOptimisticUpdate = &deneb.LightClientOptimisticUpdate{}
 	} else {
 		return errors.New("unknown fork digest")
 	}
@@ -263,12 +297,14 @@ func (flcfu *ForkedLightClientFinalityUpdate) Deserialize(spec *common.Spec, dr
 		return err
 	}
 
-	if flcfu.ForkDigest == Bellatrix {
+	if flcfu.ForkDigest == Altair || flcfu.ForkDigest == Bellatrix {
 		flcfu.LightClientFinalityUpdate = &altair.LightClientFinalityUpdate{}
 	} else if flcfu.ForkDigest == Capella {
 		flcfu.LightClientFinalityUpdate = &capella.LightClientFinalityUpdate{}
 	} else if flcfu.ForkDigest == Deneb {
 		flcfu.LightClientFinalityUpdate = &deneb.LightClientFinalityUpdate{}
+	} else if flcfu.ForkDigest == Electra {
+		flcfu.LightClientFinalityUpdate = &ElectraLightClientFinalityUpdate{}
 	} else {
 		return errors.New("unknown fork digest")
 	}
@@ -300,34 +336,56 @@ func (flcfu *ForkedLightClientFinalityUpdate) HashTreeRoot(spec *common.Spec, h
 	return h.HashTreeRoot(flcfu.ForkDigest, spec.Wrap(flcfu.LightClientFinalityUpdate))
 }
 
+const historicalSummariesProofLen = 5
+
+// historicalSummariesGIndex returns the generalized index of the historical
+// summaries in the beacon state of the fork and the length of their proof.
+func historicalSummariesGIndex(digest common.ForkDigest) (uint64, int) {
+	if digest == Electra {
+		return 91, historicalSummariesProofLen + 1
+	}
+	return 59, historicalSummariesProofLen
+}
+
+// HistoricalSummariesProof proves the historical summaries against the state
+// root. The proof is one level deeper since Electra, a proof decoded as part
+// of ForkedHistoricalSummariesWithProof gets the length of its fork. Without
+// a length the proof has the length before Electra.
 type HistoricalSummariesProof struct {
-	Proof [5]common.Bytes32
+	Proof []common.Bytes32
+}
+
+func (hsp *HistoricalSummariesProof) length() uint64 {
+	if hsp.Proof == nil {
+		return historicalSummariesProofLen
+	}
+	return uint64(len(hsp.Proof))
 }
 
 func (hsp *HistoricalSummariesProof) Deserialize(dr *codec.DecodingReader) error {
-	roots := hsp.Proof[:]
-	return tree.ReadRoots(dr, &roots, 5)
+	return tree.ReadRoots(dr, &hsp.Proof, hsp.length())
 }
 
 func (hsp *HistoricalSummariesProof) Serialize(w *codec.EncodingWriter) error {
-	return tree.WriteRoots(w, hsp.Proof[:])
+	return tree.WriteRoots(w, hsp.Proof)
 }
 
 func (hsp *HistoricalSummariesProof) ByteLength() uint64 {
-	return 32 * 5
+	return 32 * hsp.length()
 }
 
 func (hsp *HistoricalSummariesProof) FixedLength() uint64 {
-	return 32 * 5
+	return 32 * hsp.length()
 }
 
 func (hsp *HistoricalSummariesProof) HashTreeRoot(hFn tree.HashFn) common.Root {
+	length := uint64(len(hsp.Proof))
 	return hFn.ComplexVectorHTR(func(i uint64) tree.HTR {
-		if i < 5 {
+		if i < length {
 			return &hsp.Proof[i]
 		}
 		return nil
-	}, 5)
+	}, length)
 }
 
 type HistoricalSummariesWithProof struct {
@@ -366,6 +424,8 @@ func (fhswp *ForkedHistoricalSummariesWithProof) Deserialize(spec *common.Spec,
 	if err != nil {
 		return err
 	}
+	_, proofLen := historicalSummariesGIndex(fhswp.ForkDigest)
+	fhswp.HistoricalSummariesWithProof.Proof.Proof = make([]common.Bytes32, proofLen)
 
 	err = fhswp.HistoricalSummariesWithProof.Deserialize(spec, dr)
 	if err != nil {
@@ -2,6 +2,7 @@ package history
 
 import (
 	"bytes"
+	"crypto/sha256"
 	_ "embed"
 	"encoding/binary"
 	"errors"
@@ -19,6 +20,7 @@ import (
 
 const (
 	epochSize                  = 8192
+	headerRecordSize           = 64
 	mergeBlockNumber    uint64 = 15537394
 	shanghaiBlockNumber uint64 = 17_034_870
 	preMergeEpochs             = (mergeBlockNumber + epochSize - 1) / epochSize
@@ -35,7 +37,7 @@ var masterAccumulatorHex string
 //go:embed assets/historical_roots.ssz
 var historicalRootsBytes []byte
 
-var zeroRecordBytes = make([]byte, 64)
+var zeroRecordBytes = make([]byte, headerRecordSize)
 
 type AccumulatorProof [][]byte
 
@@ -166,12 +168,62 @@ func BuildProof(header types.Header, epochAccumulator EpochAccumulator) (Accumul
 	return hashes, err
 }
 
+// headerRecordTree holds every level of the merkle tree of an epoch
+// accumulator, so proofs for many headers of the same epoch can be built
+// without rehashing the whole epoch each time.
+type headerRecordTree struct {
+	records [][]byte
+	levels  [][][32]byte
+}
+
+func newHeaderRecordTree(records [][]byte) *headerRecordTree {
+	// the last pre-merge epoch isn't full, its records are padded with zero
+	// leaves like any ssz list
+	level := make([][32]byte, epochSize)
+	for i, record := range records {
+		level[i] = sha256.Sum256(record)
+	}
+	levels := [][][32]byte{level}
+	for len(level) > 1 {
+		parent := make([][32]byte, len(level)/2)
+		for i := range parent {
+			parent[i] = sha256.Sum256(append(level[2*i][:], level[2*i+1][:]...))
+		}
+		levels = append(levels, parent)
+		level = parent
+	}
+	return &headerRecordTree{records: records, levels: levels}
+}
+
+// root returns the epoch hash of the records, as found in the master
+// accumulator.
+func (t *headerRecordTree) root() []byte {
+	return MixInLength(t.levels[len(t.levels)-1][0], uint64(len(t.records)))
+}
+
+// proof returns the same accumulator proof as BuildProof for the header
+// record at the given index.
+func (t *headerRecordTree) proof(index uint64) AccumulatorProof {
+	// the leaf is the block hash, its sibling the total difficulty of the record
+	proof := make(AccumulatorProof, 0, 15)
+	proof = append(proof, t.records[index][32:])
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := level[index^1]
+		proof = append(proof, sibling[:])
+		index /= 2
+	}
+	// the epoch hash root has mix in with the number of records
+	sizeBytes := make([]byte, 32)
+	binary.LittleEndian.PutUint32(sizeBytes, uint32(len(t.records)))
+	return append(proof, sizeBytes)
+}
+
 func BuildHeaderWithProof(header types.Header, epochAccumulator EpochAccumulator) (*BlockHeaderWithProof, error) {
 	proof, err := BuildProof(header, epochAccumulator)
 	if err != nil {
 		return nil, err
 	}
-	rlpBytes, err := rlp.EncodeToBytes(header)
+	rlpBytes, err := rlp.EncodeToBytes(&header)
 	if err != nil {
 		return nil, err
 	}
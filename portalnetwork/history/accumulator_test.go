@@ -169,3 +169,17 @@ func getHeader(number uint64) (*types.Header, error) {
 	err = rlp.Decode(reader, head)
 	return head, err
 }
+
+func TestHeaderRecordTreeProof(t *testing.T) {
+	epochAccumulator, err := getEpochAccu("0xcddbda3fd6f764602c06803ff083dbfc73f2bb396df17a31e5457329b9a0f38d")
+	require.NoError(t, err)
+	tree := newHeaderRecordTree(epochAccumulator.HeaderRecords)
+
+	for i := 1000001; i < 1000011; i++ {
+		header, err := getHeader(uint64(i))
+		require.NoError(t, err)
+		proof, err := BuildProof(*header, epochAccumulator)
+		require.NoError(t, err)
+		require.Equal(t, proof, tree.proof(GetHeaderRecordIndexByHeader(*header)))
+	}
+}
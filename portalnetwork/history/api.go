@@ -1,11 +1,14 @@
 package history
 
 import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 )
 
 type API struct {
 	*discover.PortalProtocolAPI
+	historyNetwork *HistoryNetwork
 }
 
 func (p *API) HistoryRoutingTableInfo() *discover.RoutingTableInfo {
@@ -56,6 +59,22 @@ func (p *API) HistoryLocalContent(contentKeyHex string) (string, error) {
 	return p.LocalContent(contentKeyHex)
 }
 
+func (p *API) HistoryLocalContentKeys(offset, limit uint64, contentType *uint8) ([]string, error) {
+	return p.LocalContentKeys(offset, limit, contentType)
+}
+
+func (p *API) HistoryStorageStats() (*discover.StorageStats, error) {
+	return p.StorageStats()
+}
+
+func (p *API) HistoryContentProvenance(contentKeyHex string) (*discover.ContentProvenance, error) {
+	return p.ContentProvenance(contentKeyHex)
+}
+
+func (p *API) HistoryPurgeContentFrom(nodeId string) (int, error) {
+	return p.PurgeContentFrom(nodeId)
+}
+
 func (p *API) HistoryStore(contentKeyHex string, contextHex string) (bool, error) {
 	return p.Store(contentKeyHex, contextHex)
 }
@@ -73,8 +92,44 @@ func (p *API) HistoryTraceGetContent(contentKeyHex string) (*discover.TraceConte
 	return p.TraceRecursiveFindContent(contentKeyHex)
 }
 
-func NewHistoryNetworkAPI(historyAPI *discover.PortalProtocolAPI) *API {
+// HistoryBuildHeaderProof builds the header with accumulator proof content of a
+// pre-merge block. The RLP encoded header can be passed in when the node doesn't
+// have it, otherwise it is looked up by the block hash.
+func (p *API) HistoryBuildHeaderProof(blockHash string, headerHex *string) (string, error) {
+	hash, err := hexutil.Decode(blockHash)
+	if err != nil {
+		return "", err
+	}
+	var header *types.Header
+	if headerHex != nil {
+		headerBytes, err := hexutil.Decode(*headerHex)
+		if err != nil {
+			return "", err
+		}
+		header, err = ValidateBlockHeaderBytes(headerBytes, hash)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		header, err = p.historyNetwork.LookupBlockHeader(hash)
+		if err != nil {
+			return "", err
+		}
+	}
+	headerWithProof, err := p.historyNetwork.BuildHeaderProof(header)
+	if err != nil {
+		return "", err
+	}
+	content, err := headerWithProof.MarshalSSZ()
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(content), nil
+}
+
+func NewHistoryNetworkAPI(historyAPI *discover.PortalProtocolAPI, historyNetwork *HistoryNetwork) *API {
 	return &API{
-		historyAPI,
+		PortalProtocolAPI: historyAPI,
+		historyNetwork:    historyNetwork,
 	}
 }
@@ -0,0 +1,465 @@
+package history
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/era"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/holiman/uint256"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// exportBatchSize is the number of blocks fetched before they are written
+	// to the era1 builder, which bounds the memory used by an export.
+	exportBatchSize = 256
+	// exportConcurrency is the number of blocks fetched in parallel.
+	exportConcurrency = 16
+	// importBatchSize is the number of content items written per transaction.
+	importBatchSize = 1024
+	// radiusBuckets is the number of buckets the distance space is split
+	// into when estimating the radius of an import upfront.
+	radiusBuckets = 1 << 16
+)
+
+var (
+	ErrEpochNotPreMerge        = errors.New("epoch is not entirely pre merge")
+	ErrEra1AccumulatorMismatch = errors.New("era1 accumulator root is not equal to the historical epoch")
+	ErrEra1NotFullEpoch        = errors.New("era1 archive doesn't contain exactly one epoch")
+	ErrEpochAccumulatorSize    = errors.New("epoch accumulator has more header records than the epoch")
+)
+
+type exportBlock struct {
+	header     []byte
+	body       []byte
+	receipts   []byte
+	hash       common.Hash
+	number     uint64
+	td         *big.Int
+	difficulty *big.Int
+}
+
+// ExportEpoch assembles all blocks of a pre-merge epoch out of local storage
+// or the network and writes them as an era1 archive to w. The total difficulty
+// of every block is taken from the header records of the epoch accumulator.
+// The accumulator root of the archive is checked against the master
+// accumulator before it is returned.
+//
+// The last pre-merge epoch ends at the merge block, its archive holds only
+// the blocks of the header records of its epoch accumulator.
+func (h *HistoryNetwork) ExportEpoch(w io.Writer, epochIndex uint64, epochAccu *EpochAccumulator) (common.Hash, error) {
+	if epochIndex >= uint64(len(h.masterAccumulator.HistoricalEpochs)) || epochIndex*epochSize >= mergeBlockNumber {
+		return common.Hash{}, ErrEpochNotPreMerge
+	}
+	if len(epochAccu.HeaderRecords) > epochSize {
+		return common.Hash{}, ErrEpochAccumulatorSize
+	}
+	if !bytes.Equal(newHeaderRecordTree(epochAccu.HeaderRecords).root(), h.masterAccumulator.HistoricalEpochs[epochIndex]) {
+		return common.Hash{}, ErrInvalidEpochAccumulator
+	}
+
+	var (
+		builder = era.NewBuilder(w)
+		err     error
+	)
+	for start := 0; start < len(epochAccu.HeaderRecords); start += exportBatchSize {
+		records := epochAccu.HeaderRecords[start:min(start+exportBatchSize, len(epochAccu.HeaderRecords))]
+		blocks := make([]*exportBlock, len(records))
+
+		var g errgroup.Group
+		g.SetLimit(exportConcurrency)
+		for i, record := range records {
+			number := epochIndex*epochSize + uint64(start+i)
+			g.Go(func() error {
+				block, err := h.getExportBlock(number, record)
+				if err != nil {
+					return fmt.Errorf("export failed on #%d: %w", number, err)
+				}
+				blocks[i] = block
+				return nil
+			})
+		}
+		if err = g.Wait(); err != nil {
+			return common.Hash{}, err
+		}
+
+		for _, b := range blocks {
+			err = builder.AddRLP(b.header, b.body, b.receipts, b.number, b.hash, b.td, b.difficulty)
+			if err != nil {
+				return common.Hash{}, err
+			}
+		}
+		h.log.Debug("exported blocks", "epoch", epochIndex, "count", start+len(records))
+	}
+
+	root, err := builder.Finalize()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if !bytes.Equal(root[:], h.masterAccumulator.HistoricalEpochs[epochIndex]) {
+		return common.Hash{}, ErrEra1AccumulatorMismatch
+	}
+	return root, nil
+}
+
+// getExportBlock fetches the header, body and receipts of the block described
+// by the header record and encodes them the way era1 archives expect.
+func (h *HistoryNetwork) getExportBlock(number uint64, record []byte) (*exportBlock, error) {
+	blockHash := record[:32]
+	td := new(uint256.Int)
+	if err := td.UnmarshalSSZ(record[32:]); err != nil {
+		return nil, err
+	}
+
+	header, err := h.LookupBlockHeader(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	if header.Number.Uint64() != number {
+		return nil, ErrInvalidBlockNumber
+	}
+	body, err := h.getBlockBody(blockHash, header)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := h.getReceipts(blockHash, header)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &exportBlock{
+		hash:       common.BytesToHash(blockHash),
+		number:     number,
+		td:         td.ToBig(),
+		difficulty: header.Difficulty,
+	}
+	if block.header, err = rlp.EncodeToBytes(header); err != nil {
+		return nil, err
+	}
+	if block.body, err = rlp.EncodeToBytes(body); err != nil {
+		return nil, err
+	}
+	if block.receipts, err = rlp.EncodeToBytes(types.Receipts(receipts)); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Era1Storage is a history storage that era1 archives can be imported into,
+// the sqlite and the pebble storage are.
+type Era1Storage interface {
+	storage.BudgetedStorage
+	// PutBatch stores the contents without pruning the storage.
+	PutBatch(contentKeys [][]byte, contentIds [][]byte, contents [][]byte) error
+	// ForcePrune deletes the content farther than the radius and shrinks the
+	// radius to it.
+	ForcePrune(radius *uint256.Int) error
+	// Capacity returns the capacity of the storage in bytes.
+	Capacity() uint64
+}
+
+var _ Era1Storage = &ContentStorage{}
+var _ Era1Storage = &storage.PebbleStorage{}
+
+// Era1Importer writes the content of era1 archives straight into the local
+// history storage, without offering or gossiping it to other nodes. The
+// imported content is recorded as stored by the node itself, like content
+// stored over the RPC.
+type Era1Importer struct {
+	storage           Era1Storage
+	nodeId            enode.ID
+	masterAccumulator *MasterAccumulator
+	provenance        *storage.ProvenanceStore
+	log               log.Logger
+}
+
+// NewEra1Importer creates an importer into the storage of the node, the
+// provenance of the imported content is recorded if provenance is set.
+func NewEra1Importer(contentStorage Era1Storage, nodeId enode.ID, accu *MasterAccumulator, provenance *storage.ProvenanceStore) *Era1Importer {
+	return &Era1Importer{
+		storage:           contentStorage,
+		nodeId:            nodeId,
+		masterAccumulator: accu,
+		provenance:        provenance,
+		log:               log.New("import", "era1"),
+	}
+}
+
+// Import stores the in-radius headers with proof, bodies and receipts of the
+// given era1 archives. The radius needed to stay within the storage capacity
+// is computed upfront, so content that would be pruned anyway is never written.
+func (i *Era1Importer) Import(files []string) error {
+	radius, err := i.estimateRadius(files)
+	if err != nil {
+		return err
+	}
+	if radius.Cmp(i.storage.Radius()) < 0 {
+		i.log.Info("Shrinking radius to fit the storage capacity", "radius", radius.Hex())
+		if err = i.storage.ForcePrune(radius); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		if err = i.importFile(file, radius); err != nil {
+			return fmt.Errorf("failed to import %s: %w", file, err)
+		}
+	}
+
+	// the radius is only estimated, content that still doesn't fit is pruned
+	if err = i.storage.SetCapacity(i.storage.Capacity()); err != nil {
+		return err
+	}
+	if i.provenance != nil {
+		return i.provenance.Prune(i.storage.Radius())
+	}
+	return nil
+}
+
+// estimateRadius sums up the size of all content of the archives per distance
+// bucket and returns the largest radius whose content still fits into the
+// free storage capacity.
+func (i *Era1Importer) estimateRadius(files []string) (*uint256.Int, error) {
+	var (
+		buckets    = make([]uint64, radiusBuckets)
+		total      uint64
+		dummyProof = make(AccumulatorProof, 15)
+	)
+	for j := range dummyProof {
+		dummyProof[j] = make([]byte, 32)
+	}
+	for _, file := range files {
+		err := forEachEra1Block(file, func(index uint64, block *types.Block, receipts types.Receipts) error {
+			contents, err := era1BlockContents(block, receipts, dummyProof)
+			if err != nil {
+				return err
+			}
+			for _, c := range contents {
+				distance := xor(c.contentId, i.nodeId[:])
+				buckets[int(distance[0])<<8|int(distance[1])] += uint64(len(c.content))
+				total += uint64(len(c.content))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+	}
+
+	radius := i.storage.Radius()
+	usedSize, err := i.storage.UsedSize()
+	if err != nil {
+		return nil, err
+	}
+	var available uint64
+	if capacity := i.storage.Capacity(); usedSize < capacity {
+		available = capacity - usedSize
+	}
+	if total <= available {
+		return radius, nil
+	}
+
+	var size uint64
+	bucket := 0
+	for ; bucket < radiusBuckets; bucket++ {
+		if size+buckets[bucket] > available {
+			break
+		}
+		size += buckets[bucket]
+	}
+	// the radius covers all buckets in front of the first one that doesn't fit
+	estimated := new(uint256.Int).Lsh(uint256.NewInt(uint64(bucket)), 240)
+	if bucket > 0 {
+		estimated.SubUint64(estimated, 1)
+	}
+	if estimated.Cmp(radius) < 0 {
+		return estimated, nil
+	}
+	return radius, nil
+}
+
+func (i *Era1Importer) importFile(file string, radius *uint256.Int) error {
+	start := time.Now()
+	epochIndex, tree, err := i.headerRecordTree(file)
+	if err != nil {
+		return err
+	}
+
+	var (
+		contentKeys = make([][]byte, 0, importBatchSize)
+		contentIds  = make([][]byte, 0, importBatchSize)
+		values      = make([][]byte, 0, importBatchSize)
+		stored      int
+		skipped     int
+	)
+	flush := func() error {
+		if len(contentIds) == 0 {
+			return nil
+		}
+		err := i.storage.PutBatch(contentKeys, contentIds, values)
+		if err == nil && i.provenance != nil {
+			err = i.provenance.PutBatch(contentKeys, contentIds, storage.LocalProvenance())
+		}
+		contentKeys, contentIds, values = contentKeys[:0], contentIds[:0], values[:0]
+		return err
+	}
+	err = forEachEra1Block(file, func(index uint64, block *types.Block, receipts types.Receipts) error {
+		contents, err := era1BlockContents(block, receipts, tree.proof(index))
+		if err != nil {
+			return err
+		}
+		for _, c := range contents {
+			distance := new(uint256.Int).SetBytes(xor(c.contentId, i.nodeId[:]))
+			if distance.Cmp(radius) > 0 {
+				skipped++
+				continue
+			}
+			contentKeys = append(contentKeys, c.contentKey)
+			contentIds = append(contentIds, c.contentId)
+			values = append(values, c.content)
+			stored++
+		}
+		if len(contentIds) >= importBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+	i.log.Info("Imported era1 epoch", "epoch", epochIndex, "stored", stored, "skipped", skipped, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// headerRecordTree rebuilds the epoch accumulator of a pre-merge era1 archive
+// from its block hashes and total difficulties, and checks it against the
+// master accumulator. Only the archive of the last pre-merge epoch may end
+// before the epoch does, at the merge block.
+func (i *Era1Importer) headerRecordTree(file string) (uint64, *headerRecordTree, error) {
+	e, err := era.Open(file)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer e.Close()
+
+	// the number of blocks is mixed into the epoch hash, an archive that ends
+	// early fails the check against the master accumulator
+	if e.Start()%epochSize != 0 || e.Count() == 0 || e.Count() > epochSize {
+		return 0, nil, ErrEra1NotFullEpoch
+	}
+	epochIndex := GetEpochIndex(e.Start())
+	if epochIndex >= uint64(len(i.masterAccumulator.HistoricalEpochs)) {
+		return 0, nil, ErrEpochNotPreMerge
+	}
+
+	it, err := era.NewRawIterator(e)
+	if err != nil {
+		return 0, nil, err
+	}
+	records := make([][]byte, 0, e.Count())
+	for it.Next() {
+		header, err := io.ReadAll(it.Header)
+		if err != nil {
+			return 0, nil, err
+		}
+		// era1 stores the total difficulty little endian, just like ssz
+		td, err := io.ReadAll(it.TotalDifficulty)
+		if err != nil {
+			return 0, nil, err
+		}
+		records = append(records, append(crypto.Keccak256(header), td...))
+	}
+	if it.Error() != nil {
+		return 0, nil, it.Error()
+	}
+
+	tree := newHeaderRecordTree(records)
+	if !bytes.Equal(tree.root(), i.masterAccumulator.HistoricalEpochs[epochIndex]) {
+		return 0, nil, ErrInvalidEpochAccumulator
+	}
+	return epochIndex, tree, nil
+}
+
+func forEachEra1Block(file string, fn func(index uint64, block *types.Block, receipts types.Receipts) error) error {
+	e, err := era.Open(file)
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+
+	it, err := era.NewIterator(e)
+	if err != nil {
+		return err
+	}
+	for it.Next() {
+		block, receipts, err := it.BlockAndReceipts()
+		if err != nil {
+			return err
+		}
+		if err = fn(GetHeaderRecordIndex(block.NumberU64()), block, receipts); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+type era1Content struct {
+	contentKey []byte
+	contentId  []byte
+	content    []byte
+}
+
+// era1BlockContents converts a block of an era1 archive into the header with
+// proof, body and receipts content of the history network.
+func era1BlockContents(block *types.Block, receipts types.Receipts, proof AccumulatorProof) ([]era1Content, error) {
+	headerBytes, err := rlp.EncodeToBytes(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	headerWithProof, err := (&BlockHeaderWithProof{
+		Header: headerBytes,
+		Proof: &BlockHeaderProof{
+			Selector: accumulatorProof,
+			Proof:    proof,
+		},
+	}).MarshalSSZ()
+	if err != nil {
+		return nil, err
+	}
+	body, err := EncodeBlockBody(block.Body())
+	if err != nil {
+		return nil, err
+	}
+	receiptsBytes, err := EncodeReceipts(receipts)
+	if err != nil {
+		return nil, err
+	}
+
+	blockHash := block.Hash().Bytes()
+	contents := make([]era1Content, 0, 3)
+	for _, c := range []struct {
+		contentType ContentType
+		content     []byte
+	}{{BlockHeaderType, headerWithProof}, {BlockBodyType, body}, {ReceiptsType, receiptsBytes}} {
+		// content ids of the history network are the sha256 of the content key
+		contentKey := newContentKey(c.contentType, blockHash).encode()
+		contentId := sha256.Sum256(contentKey)
+		contents = append(contents, era1Content{contentKey: contentKey, contentId: contentId[:], content: c.content})
+	}
+	return contents, nil
+}
@@ -0,0 +1,280 @@
+package history
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/era"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/params"
+	contentStorage "github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/stretchr/testify/require"
+)
+
+// genEpochChain generates the first count blocks of a chain and the epoch
+// accumulator and master accumulator matching them. Less than epochSize blocks
+// make up a last epoch that ends at the merge.
+func genEpochChain(t *testing.T, count int) ([]*types.Block, []types.Receipts, *EpochAccumulator, *MasterAccumulator) {
+	var (
+		key, _  = crypto.GenerateKey()
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		signer  = types.LatestSigner(params.TestChainConfig)
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  types.GenesisAlloc{address: {Balance: big.NewInt(params.Ether)}},
+		}
+	)
+	_, blocks, receipts := core.GenerateChainWithGenesis(gspec, ethash.NewFaker(), count-1, func(i int, b *core.BlockGen) {
+		if i%1000 == 0 {
+			tx, err := types.SignTx(types.NewTransaction(b.TxNonce(address), common.Address{0x01}, big.NewInt(1), params.TxGas, b.BaseFee(), nil), signer, key)
+			require.NoError(t, err)
+			b.AddTx(tx)
+		}
+	})
+	genesis := gspec.ToBlock()
+	blocks = append([]*types.Block{genesis}, blocks...)
+	receipts = append([]types.Receipts{{}}, receipts...)
+
+	acc := NewAccumulator()
+	for _, block := range blocks {
+		require.NoError(t, acc.Update(*block.Header()))
+	}
+	epochAccu := &EpochAccumulator{HeaderRecords: acc.currentEpoch.records}
+	if count < epochSize {
+		master := &MasterAccumulator{HistoricalEpochs: [][]byte{newHeaderRecordTree(epochAccu.HeaderRecords).root()}}
+		return blocks, receipts, epochAccu, master
+	}
+	master, err := acc.Finish()
+	require.NoError(t, err)
+	return blocks, receipts, epochAccu, master
+}
+
+func TestExportEpoch(t *testing.T) {
+	blocks, receipts, epochAccu, master := genEpochChain(t, epochSize)
+
+	historyNetwork, err := genHistoryNetwork(":7898", nil)
+	require.NoError(t, err)
+	defer historyNetwork.Stop()
+	historyNetwork.masterAccumulator = master
+	putBlocks(t, historyNetwork, blocks, receipts)
+
+	filename := filepath.Join(t.TempDir(), "epoch.era1")
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	root, err := historyNetwork.ExportEpoch(f, 0, epochAccu)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, master.HistoricalEpochs[0], root.Bytes())
+
+	e, err := era.Open(filename)
+	require.NoError(t, err)
+	defer e.Close()
+	require.Equal(t, uint64(0), e.Start())
+	require.Equal(t, uint64(epochSize), e.Count())
+	accumulator, err := e.Accumulator()
+	require.NoError(t, err)
+	require.Equal(t, root, accumulator)
+
+	it, err := era.NewIterator(e)
+	require.NoError(t, err)
+	for it.Next() {
+		block, blockReceipts, err := it.BlockAndReceipts()
+		require.NoError(t, err)
+		require.Equal(t, blocks[block.NumberU64()].Hash(), block.Hash())
+		require.Equal(t, len(receipts[block.NumberU64()]), len(blockReceipts))
+	}
+	require.NoError(t, it.Error())
+
+	// an epoch accumulator of another epoch must be rejected
+	_, err = historyNetwork.ExportEpoch(f, 1, epochAccu)
+	require.Error(t, err)
+}
+
+// TestEra1PartialEpoch round-trips an epoch that ends at the merge, like the
+// last pre-merge epoch does.
+func TestEra1PartialEpoch(t *testing.T) {
+	blocks, receipts, epochAccu, master := genEpochChain(t, 300)
+
+	historyNetwork, err := genHistoryNetwork(":7903", nil)
+	require.NoError(t, err)
+	defer historyNetwork.Stop()
+	historyNetwork.masterAccumulator = master
+	putBlocks(t, historyNetwork, blocks, receipts)
+
+	// the epoch accumulator content holds the records of the blocks only
+	content := bytes.Join(epochAccu.HeaderRecords, nil)
+	decoded, err := historyNetwork.validateEpochAccumulator(content, master.HistoricalEpochs[0])
+	require.NoError(t, err)
+	require.Equal(t, epochAccu.HeaderRecords, decoded.HeaderRecords)
+
+	filename := filepath.Join(t.TempDir(), "epoch.era1")
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	root, err := historyNetwork.ExportEpoch(f, 0, decoded)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, master.HistoricalEpochs[0], root.Bytes())
+
+	e, err := era.Open(filename)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(blocks)), e.Count())
+	require.NoError(t, e.Close())
+
+	storage, err := newContentStorage(100, enode.ID{}, t.TempDir())
+	require.NoError(t, err)
+	defer storage.Close()
+	err = NewEra1Importer(storage, enode.ID{}, master, nil).Import([]string{filename})
+	require.NoError(t, err)
+	count, err := storage.ContentCount()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3*len(blocks)), count)
+
+	block := blocks[len(blocks)-1]
+	contentKey := newContentKey(BlockHeaderType, block.Hash().Bytes()).encode()
+	content, err = storage.Get(contentKey, ContentId(contentKey))
+	require.NoError(t, err)
+	headerWithProof, err := DecodeBlockHeaderWithProof(content)
+	require.NoError(t, err)
+	valid, err := master.VerifyHeader(*block.Header(), *headerWithProof.Proof)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	// the number of blocks is part of the epoch hash, the archive doesn't match
+	// an epoch of the same records that claims to be full
+	tree := newHeaderRecordTree(epochAccu.HeaderRecords)
+	fullMaster := &MasterAccumulator{HistoricalEpochs: [][]byte{MixInLength(tree.levels[len(tree.levels)-1][0], epochSize)}}
+	err = NewEra1Importer(storage, enode.ID{}, fullMaster, nil).Import([]string{filename})
+	require.ErrorIs(t, err, ErrInvalidEpochAccumulator)
+}
+
+// putBlocks stores the headers without proof, bodies and receipts of the
+// blocks in the history network.
+func putBlocks(t *testing.T, historyNetwork *HistoryNetwork, blocks []*types.Block, receipts []types.Receipts) {
+	for i, block := range blocks {
+		headerBytes, err := rlp.EncodeToBytes(block.Header())
+		require.NoError(t, err)
+		headerWithProof, err := (&BlockHeaderWithProof{Header: headerBytes, Proof: &BlockHeaderProof{Selector: none}}).MarshalSSZ()
+		require.NoError(t, err)
+		body, err := EncodeBlockBody(block.Body())
+		require.NoError(t, err)
+		receiptsBytes, err := EncodeReceipts(receipts[i])
+		require.NoError(t, err)
+
+		for contentType, content := range map[ContentType][]byte{BlockHeaderType: headerWithProof, BlockBodyType: body, ReceiptsType: receiptsBytes} {
+			contentKey := newContentKey(contentType, block.Hash().Bytes()).encode()
+			err = historyNetwork.portalProtocol.Put(contentKey, historyNetwork.portalProtocol.ToContentId(contentKey), content)
+			require.NoError(t, err)
+		}
+	}
+}
+
+func writeEra1File(t *testing.T, blocks []*types.Block, receipts []types.Receipts) string {
+	filename := filepath.Join(t.TempDir(), era.Filename("mainnet", 0, common.Hash{}))
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	builder := era.NewBuilder(f)
+	td := new(big.Int)
+	for i, block := range blocks {
+		td.Add(td, block.Difficulty())
+		require.NoError(t, builder.Add(block, receipts[i], new(big.Int).Set(td)))
+	}
+	_, err = builder.Finalize()
+	require.NoError(t, err)
+	return filename
+}
+
+func TestImportEra1(t *testing.T) {
+	blocks, receipts, _, master := genEpochChain(t, epochSize)
+	filename := writeEra1File(t, blocks, receipts)
+
+	t.Run("all content", func(t *testing.T) {
+		dataDir := t.TempDir()
+		storage, err := newContentStorage(100, enode.ID{}, dataDir)
+		require.NoError(t, err)
+		defer storage.Close()
+		provenance, err := contentStorage.OpenProvenanceStore(dataDir, "history", enode.ID{})
+		require.NoError(t, err)
+		defer provenance.Close()
+
+		err = NewEra1Importer(storage, enode.ID{}, master, provenance).Import([]string{filename})
+		require.NoError(t, err)
+		count, err := storage.ContentCount()
+		require.NoError(t, err)
+		require.Equal(t, uint64(3*epochSize), count)
+		contentKey := newContentKey(BlockBodyType, blocks[0].Hash().Bytes()).encode()
+		recorded, err := provenance.Get(contentKey)
+		require.NoError(t, err)
+		require.Equal(t, contentStorage.SourceLocal, recorded.Source)
+
+		for _, block := range []*types.Block{blocks[0], blocks[1000], blocks[epochSize-1]} {
+			contentKey := newContentKey(BlockHeaderType, block.Hash().Bytes()).encode()
+			content, err := storage.Get(contentKey, ContentId(contentKey))
+			require.NoError(t, err)
+			headerWithProof, err := DecodeBlockHeaderWithProof(content)
+			require.NoError(t, err)
+			valid, err := master.VerifyHeader(*block.Header(), *headerWithProof.Proof)
+			require.NoError(t, err)
+			require.True(t, valid)
+
+			contentKey = newContentKey(ReceiptsType, block.Hash().Bytes()).encode()
+			content, err = storage.Get(contentKey, ContentId(contentKey))
+			require.NoError(t, err)
+			_, err = validateReceipts(content, block.Header())
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("limited capacity", func(t *testing.T) {
+		storage, err := newContentStorage(2, enode.ID{}, t.TempDir())
+		require.NoError(t, err)
+		defer storage.Close()
+
+		err = NewEra1Importer(storage, enode.ID{}, master, nil).Import([]string{filename})
+		require.NoError(t, err)
+		radius := storage.Radius()
+		require.True(t, radius.Lt(contentStorage.MaxDistance))
+
+		count, err := storage.ContentCount()
+		require.NoError(t, err)
+		require.NotZero(t, count)
+		require.Less(t, count, uint64(3*epochSize))
+		var outOfRadius int
+		distance := radius.Bytes32()
+		err = storage.sqliteDB.QueryRow("SELECT count(*) FROM kvstore WHERE greater(xor(key, (?1)), (?2)) = 1", storage.nodeId[:], distance[:]).Scan(&outOfRadius)
+		require.NoError(t, err)
+		require.Zero(t, outOfRadius)
+	})
+
+	t.Run("pebble backend", func(t *testing.T) {
+		db, err := contentStorage.NewPebbleDB(t.TempDir(), "history")
+		require.NoError(t, err)
+		storage, err := contentStorage.NewPebbleStorage(contentStorage.PortalStorageConfig{
+			StorageCapacityMB: 2,
+			KVStore:           db,
+			NodeId:            enode.ID{},
+		})
+		require.NoError(t, err)
+		defer storage.Close()
+
+		err = NewEra1Importer(storage, enode.ID{}, master, nil).Import([]string{filename})
+		require.NoError(t, err)
+		require.True(t, storage.Radius().Lt(contentStorage.MaxDistance))
+		count := storage.ContentCount()
+		require.NotZero(t, count)
+		require.Less(t, count, uint64(3*epochSize))
+		usedSize, err := storage.UsedSize()
+		require.NoError(t, err)
+		require.LessOrEqual(t, usedSize, storage.Capacity())
+	})
+}
@@ -7,13 +7,16 @@ import (
 	"fmt"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	ssz "github.com/ferranbt/fastssz"
 	"github.com/protolambda/ztyp/codec"
 	"github.com/protolambda/ztyp/view"
 
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/portalnetwork/storage"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
@@ -26,7 +29,10 @@ const (
 	BlockBodyType         ContentType = 0x01
 	ReceiptsType          ContentType = 0x02
 	BlockHeaderNumberType ContentType = 0x03
-	// EpochAccumulatorType ContentType = 0x03
+	// EpochAccumulatorType shares the selector of the spec with
+	// BlockHeaderNumberType, which took it over later. The content keys are
+	// told apart by their length, see isEpochAccumulatorKey.
+	EpochAccumulatorType ContentType = 0x03
 )
 
 var (
@@ -38,6 +44,7 @@ var (
 	ErrHeaderWithProofIsInvalid = errors.New("header proof is invalid")
 	ErrInvalidBlockHash         = errors.New("invalid block hash")
 	ErrInvalidBlockNumber       = errors.New("invalid block number")
+	ErrInvalidEpochAccumulator  = errors.New("epoch accumulator is not part of the master accumulator")
 )
 
 var emptyReceiptHash = hexutil.MustDecode("0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
@@ -64,6 +71,7 @@ func (c *ContentKey) encode() []byte {
 type HistoryNetwork struct {
 	portalProtocol    *discover.PortalProtocol
 	masterAccumulator *MasterAccumulator
+	scrubber          *storage.Scrubber
 	closeCtx          context.Context
 	closeFunc         context.CancelFunc
 	log               log.Logger
@@ -93,9 +101,24 @@ func (h *HistoryNetwork) Start() error {
 
 func (h *HistoryNetwork) Stop() {
 	h.closeFunc()
+	if h.scrubber != nil {
+		h.scrubber.Stop()
+	}
 	h.portalProtocol.Stop()
 }
 
+// Drain stops accepting offered content and waits until the transfers in
+// flight are done and the received content is validated, or until ctx is done.
+func (h *HistoryNetwork) Drain(ctx context.Context) error {
+	return h.portalProtocol.Drain(ctx)
+}
+
+// StartScrubber scrubs the storage in the background until the network stops.
+func (h *HistoryNetwork) StartScrubber(scrubber *storage.Scrubber) {
+	h.scrubber = scrubber
+	scrubber.Start()
+}
+
 // Currently doing 4 retries on lookups but only when the validation fails.
 const requestRetries = 4
 
@@ -106,8 +129,47 @@ func (h *HistoryNetwork) GetBlockHeader(blockHash []byte) (*types.Header, error)
 	if !h.portalProtocol.InRange(contentId) {
 		return nil, ErrContentOutOfRange
 	}
+	return h.LookupBlockHeader(blockHash)
+}
+
+func (h *HistoryNetwork) GetBlockBody(blockHash []byte) (*types.Body, error) {
+	header, err := h.GetBlockHeader(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	contentKey := newContentKey(BlockBodyType, blockHash).encode()
+	contentId := h.portalProtocol.ToContentId(contentKey)
+
+	if !h.portalProtocol.InRange(contentId) {
+		return nil, ErrContentOutOfRange
+	}
+	return h.getBlockBody(blockHash, header)
+}
+
+func (h *HistoryNetwork) GetReceipts(blockHash []byte) ([]*types.Receipt, error) {
+	header, err := h.GetBlockHeader(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	contentKey := newContentKey(ReceiptsType, blockHash).encode()
+	contentId := h.portalProtocol.ToContentId(contentKey)
+
+	if !h.portalProtocol.InRange(contentId) {
+		return nil, ErrContentOutOfRange
+	}
+	return h.getReceipts(blockHash, header)
+}
+
+// LookupBlockHeader returns the header from local storage or the network.
+// Unlike GetBlockHeader it also looks up headers outside of the node radius,
+// which are returned without being stored, so a lookup-only history network
+// can resolve every header.
+func (h *HistoryNetwork) LookupBlockHeader(blockHash []byte) (*types.Header, error) {
+	contentKey := newContentKey(BlockHeaderType, blockHash).encode()
+	contentId := h.portalProtocol.ToContentId(contentKey)
+	inRange := h.portalProtocol.InRange(contentId)
 
-	res, err := h.portalProtocol.Get(contentKey, contentId)
+	res, err := h.getLocal(contentKey, contentId, inRange)
 	// other error
 	if err != nil && !errors.Is(err, storage.ErrContentNotFound) {
 		return nil, err
@@ -124,11 +186,12 @@ func (h *HistoryNetwork) GetBlockHeader(blockHash []byte) (*types.Header, error)
 	}
 	// no content in local storage
 	for retries := 0; retries < requestRetries; retries++ {
-		content, _, err := h.portalProtocol.ContentLookup(contentKey, contentId)
+		found, err := h.portalProtocol.LookupContent(contentKey, contentId)
 		if err != nil {
-			h.log.Error("getBlockHeader failed", "contentKey", hexutil.Encode(contentKey), "err", err)
+			h.log.Error("LookupBlockHeader failed", "contentKey", hexutil.Encode(contentKey), "err", err)
 			continue
 		}
+		content := found.Content
 
 		headerWithProof, err := DecodeBlockHeaderWithProof(content)
 		if err != nil {
@@ -146,28 +209,25 @@ func (h *HistoryNetwork) GetBlockHeader(blockHash []byte) (*types.Header, error)
 			h.log.Error("verifyHeader failed", "err", err)
 			continue
 		}
-		err = h.portalProtocol.Put(contentKey, contentId, content)
-		if err != nil {
-			h.log.Error("failed to store content in getBlockHeader", "contentKey", hexutil.Encode(contentKey), "content", hexutil.Encode(content))
+		if inRange {
+			err = h.portalProtocol.PutFrom(contentKey, contentId, content, storage.ValidatedProvenance(storage.SourceLookup, found.Node))
+			if err != nil {
+				h.log.Error("failed to store content in LookupBlockHeader", "contentKey", hexutil.Encode(contentKey), "content", hexutil.Encode(content))
+			}
 		}
 		return header, nil
 	}
 	return nil, storage.ErrContentNotFound
 }
 
-func (h *HistoryNetwork) GetBlockBody(blockHash []byte) (*types.Body, error) {
-	header, err := h.GetBlockHeader(blockHash)
-	if err != nil {
-		return nil, err
-	}
+// getBlockBody returns the body of the header from local storage or the
+// network, looking up bodies outside of the node radius like LookupBlockHeader.
+func (h *HistoryNetwork) getBlockBody(blockHash []byte, header *types.Header) (*types.Body, error) {
 	contentKey := newContentKey(BlockBodyType, blockHash).encode()
 	contentId := h.portalProtocol.ToContentId(contentKey)
+	inRange := h.portalProtocol.InRange(contentId)
 
-	if !h.portalProtocol.InRange(contentId) {
-		return nil, ErrContentOutOfRange
-	}
-
-	res, err := h.portalProtocol.Get(contentKey, contentId)
+	res, err := h.getLocal(contentKey, contentId, inRange)
 	// other error
 	// TODO maybe use nil res to replace the ErrContentNotFound
 	if err != nil && !errors.Is(err, storage.ErrContentNotFound) {
@@ -181,11 +241,12 @@ func (h *HistoryNetwork) GetBlockBody(blockHash []byte) (*types.Body, error) {
 	// no content in local storage
 
 	for retries := 0; retries < requestRetries; retries++ {
-		content, _, err := h.portalProtocol.ContentLookup(contentKey, contentId)
+		found, err := h.portalProtocol.LookupContent(contentKey, contentId)
 		if err != nil {
 			h.log.Error("getBlockBody failed", "contentKey", hexutil.Encode(contentKey), "err", err)
 			continue
 		}
+		content := found.Content
 		body, err := DecodePortalBlockBodyBytes(content)
 		if err != nil {
 			h.log.Error("decodePortalBlockBodyBytes failed", "content", hexutil.Encode(content), "err", err)
@@ -197,34 +258,35 @@ func (h *HistoryNetwork) GetBlockBody(blockHash []byte) (*types.Body, error) {
 			h.log.Error("validateBlockBody failed", "header", "err", err)
 			continue
 		}
-		err = h.portalProtocol.Put(contentKey, contentId, content)
-		if err != nil {
-			h.log.Error("failed to store content in getBlockBody", "contentKey", hexutil.Encode(contentKey), "content", hexutil.Encode(content))
+		if inRange {
+			err = h.portalProtocol.PutFrom(contentKey, contentId, content, storage.ValidatedProvenance(storage.SourceLookup, found.Node))
+			if err != nil {
+				h.log.Error("failed to store content in getBlockBody", "contentKey", hexutil.Encode(contentKey), "content", hexutil.Encode(content))
+			}
 		}
 		return body, nil
 	}
 	return nil, storage.ErrContentNotFound
 }
 
-func (h *HistoryNetwork) GetReceipts(blockHash []byte) ([]*types.Receipt, error) {
-	header, err := h.GetBlockHeader(blockHash)
-	if err != nil {
-		return nil, err
-	}
+// getReceipts returns the receipts of the header from local storage or the
+// network, looking up receipts outside of the node radius like LookupBlockHeader.
+func (h *HistoryNetwork) getReceipts(blockHash []byte, header *types.Header) ([]*types.Receipt, error) {
 	contentKey := newContentKey(ReceiptsType, blockHash).encode()
 	contentId := h.portalProtocol.ToContentId(contentKey)
+	inRange := h.portalProtocol.InRange(contentId)
 
-	if !h.portalProtocol.InRange(contentId) {
-		return nil, ErrContentOutOfRange
-	}
-
-	res, err := h.portalProtocol.Get(contentKey, contentId)
+	res, err := h.getLocal(contentKey, contentId, inRange)
 	// other error
 	if err != nil && !errors.Is(err, storage.ErrContentNotFound) {
 		return nil, err
 	}
 	// no error
 	if err == nil {
+		// blocks without receipts are stored as empty content
+		if len(res) == 0 {
+			return []*types.Receipt{}, nil
+		}
 		portalReceipte := new(PortalReceipts)
 		err := portalReceipte.UnmarshalSSZ(res)
 		if err != nil {
@@ -236,29 +298,120 @@ func (h *HistoryNetwork) GetReceipts(blockHash []byte) ([]*types.Receipt, error)
 	// no content in local storage
 
 	for retries := 0; retries < requestRetries; retries++ {
-		content, _, err := h.portalProtocol.ContentLookup(contentKey, contentId)
+		found, err := h.portalProtocol.LookupContent(contentKey, contentId)
 		if err != nil {
 			h.log.Error("getReceipts failed", "contentKey", hexutil.Encode(contentKey), "err", err)
 			continue
 		}
-		receipts, err := ValidatePortalReceiptsBytes(content, header.ReceiptHash.Bytes())
+		content := found.Content
+		receipts, err := validateReceipts(content, header)
 		if err != nil {
 			h.log.Error("getReceipts failed", "err", err)
 			continue
 		}
-		err = h.portalProtocol.Put(contentKey, contentId, content)
-		if err != nil {
-			h.log.Error("failed to store content in getReceipts", "contentKey", hexutil.Encode(contentKey), "content", hexutil.Encode(content))
+		if inRange {
+			err = h.portalProtocol.PutFrom(contentKey, contentId, content, storage.ValidatedProvenance(storage.SourceLookup, found.Node))
+			if err != nil {
+				h.log.Error("failed to store content in getReceipts", "contentKey", hexutil.Encode(contentKey), "content", hexutil.Encode(content))
+			}
 		}
 		return receipts, nil
 	}
 	return nil, storage.ErrContentNotFound
 }
 
+// GetEpochAccumulator returns the epoch accumulator with the given epoch hash,
+// as found in the master accumulator. Unlike the block getters it doesn't fail
+// with ErrContentOutOfRange, proofs are built from epoch accumulators outside
+// of the node radius too. Those are looked up without being stored.
+func (h *HistoryNetwork) GetEpochAccumulator(epochHash []byte) (*EpochAccumulator, error) {
+	contentKey := newContentKey(EpochAccumulatorType, epochHash).encode()
+	contentId := h.portalProtocol.ToContentId(contentKey)
+	inRange := h.portalProtocol.InRange(contentId)
+
+	res, err := h.getLocal(contentKey, contentId, inRange)
+	// other error
+	if err != nil && !errors.Is(err, storage.ErrContentNotFound) {
+		return nil, err
+	}
+	// no error
+	if err == nil {
+		return DecodeEpochAccumulator(res)
+	}
+	// no content in local storage
+	for retries := 0; retries < requestRetries; retries++ {
+		found, err := h.portalProtocol.LookupContent(contentKey, contentId)
+		if err != nil {
+			h.log.Error("getEpochAccumulator failed", "contentKey", hexutil.Encode(contentKey), "err", err)
+			continue
+		}
+		content := found.Content
+		epochAccu, err := h.validateEpochAccumulator(content, epochHash)
+		if err != nil {
+			h.log.Error("validateEpochAccumulator failed", "epochHash", hexutil.Encode(epochHash), "err", err)
+			continue
+		}
+		if inRange {
+			err = h.portalProtocol.PutFrom(contentKey, contentId, content, storage.ValidatedProvenance(storage.SourceLookup, found.Node))
+			if err != nil {
+				h.log.Error("failed to store content in getEpochAccumulator", "contentKey", hexutil.Encode(contentKey))
+			}
+		}
+		return epochAccu, nil
+	}
+	return nil, storage.ErrContentNotFound
+}
+
+// BuildHeaderProof builds the accumulator proof of a pre-merge header with the
+// epoch accumulator taken from local storage or the network.
+func (h *HistoryNetwork) BuildHeaderProof(header *types.Header) (*BlockHeaderWithProof, error) {
+	if header.Number.Uint64() >= mergeBlockNumber {
+		return nil, ErrNotPreMergeHeader
+	}
+	epochHash := h.masterAccumulator.HistoricalEpochs[GetEpochIndexByHeader(*header)]
+	epochAccu, err := h.GetEpochAccumulator(epochHash)
+	if err != nil {
+		return nil, err
+	}
+	record := epochAccu.HeaderRecords[GetHeaderRecordIndexByHeader(*header)]
+	if !bytes.Equal(record[:32], header.Hash().Bytes()) {
+		return nil, ErrInvalidBlockHash
+	}
+	return BuildHeaderWithProof(*header, *epochAccu)
+}
+
+// getLocal reads content from local storage. Content outside of the node
+// radius is never stored, so it is reported as not found right away and the
+// caller falls back to a network lookup.
+func (h *HistoryNetwork) getLocal(contentKey, contentId []byte, inRange bool) ([]byte, error) {
+	if !inRange {
+		return nil, storage.ErrContentNotFound
+	}
+	return h.portalProtocol.Get(contentKey, contentId)
+}
+
 func (h *HistoryNetwork) verifyHeader(header *types.Header, proof BlockHeaderProof) (bool, error) {
 	return h.masterAccumulator.VerifyHeader(*header, proof)
 }
 
+// validateEpochAccumulator checks that the epoch accumulator hashes to the
+// epoch hash of its content key, and that the master accumulator contains it.
+func (h *HistoryNetwork) validateEpochAccumulator(content []byte, epochHash []byte) (*EpochAccumulator, error) {
+	return validateEpochAccumulator(h.masterAccumulator, content, epochHash)
+}
+
+func validateEpochAccumulator(accu *MasterAccumulator, content []byte, epochHash []byte) (*EpochAccumulator, error) {
+	epochAccu, err := DecodeEpochAccumulator(content)
+	if err != nil {
+		return nil, err
+	}
+	epochRoot := newHeaderRecordTree(epochAccu.HeaderRecords).root()
+	if !bytes.Equal(epochRoot, epochHash) || !accu.Contains(epochRoot) {
+		return nil, ErrInvalidEpochAccumulator
+	}
+	return epochAccu, nil
+}
+
 func ValidateBlockBodyBytes(bodyBytes []byte, header *types.Header) (*types.Body, error) {
 	// TODO check shanghai, pos and legacy block
 	body, err := DecodePortalBlockBodyBytes(bodyBytes)
@@ -436,6 +589,18 @@ func ValidatePortalReceiptsBytes(receiptBytes, receiptsRoot []byte) ([]*types.Re
 	return receipts, nil
 }
 
+// validateReceipts checks the receipts content against the receipts root of
+// the header. Blocks without receipts carry empty content.
+func validateReceipts(content []byte, header *types.Header) ([]*types.Receipt, error) {
+	if bytes.Equal(header.ReceiptHash.Bytes(), emptyReceiptHash) {
+		if len(content) > 0 {
+			return nil, fmt.Errorf("content should be empty, but received %v", content)
+		}
+		return []*types.Receipt{}, nil
+	}
+	return ValidatePortalReceiptsBytes(content, header.ReceiptHash.Bytes())
+}
+
 func EncodeReceipts(receipts []*types.Receipt) ([]byte, error) {
 	portalReceipts, err := ToPortalReceipts(receipts)
 	if err != nil {
@@ -464,7 +629,8 @@ func (h *HistoryNetwork) processContentLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case contentElement := <-contentChan:
-			err := h.validateContents(contentElement.ContentKeys, contentElement.Contents)
+			err := h.validateContents(contentElement.Node, contentElement.ContentKeys, contentElement.Contents)
+			contentElement.Done()
 			if err != nil {
 				h.log.Error("validate content failed", "err", err)
 				continue
@@ -489,6 +655,17 @@ func (h *HistoryNetwork) processContentLoop(ctx context.Context) {
 }
 
 func (h *HistoryNetwork) validateContent(contentKey []byte, content []byte) error {
+	return validateContent(h.masterAccumulator, h.GetBlockHeader, contentKey, content)
+}
+
+// validateContent checks the content against the master accumulator, the
+// headers that bodies and receipts are checked against are resolved with
+// headers.
+func validateContent(accu *MasterAccumulator, headers func(blockHash []byte) (*types.Header, error), contentKey []byte, content []byte) error {
+	if isEpochAccumulatorKey(contentKey) {
+		_, err := validateEpochAccumulator(accu, content, contentKey[1:])
+		return err
+	}
 	switch ContentType(contentKey[0]) {
 	case BlockHeaderType:
 		headerWithProof, err := DecodeBlockHeaderWithProof(content)
@@ -502,7 +679,7 @@ func (h *HistoryNetwork) validateContent(contentKey []byte, content []byte) erro
 		if !bytes.Equal(header.Hash().Bytes(), contentKey[1:]) {
 			return ErrInvalidBlockHash
 		}
-		valid, err := h.verifyHeader(header, *headerWithProof.Proof)
+		valid, err := accu.VerifyHeader(*header, *headerWithProof.Proof)
 		if err != nil {
 			return err
 		}
@@ -511,24 +688,18 @@ func (h *HistoryNetwork) validateContent(contentKey []byte, content []byte) erro
 		}
 		return err
 	case BlockBodyType:
-		header, err := h.GetBlockHeader(contentKey[1:])
+		header, err := headers(contentKey[1:])
 		if err != nil {
 			return err
 		}
 		_, err = ValidateBlockBodyBytes(content, header)
 		return err
 	case ReceiptsType:
-		header, err := h.GetBlockHeader(contentKey[1:])
+		header, err := headers(contentKey[1:])
 		if err != nil {
 			return err
 		}
-		if bytes.Equal(header.ReceiptHash.Bytes(), emptyReceiptHash) {
-			if len(content) > 0 {
-				return fmt.Errorf("content should be empty, but received %v", content)
-			}
-			return nil
-		}
-		_, err = ValidatePortalReceiptsBytes(content, header.ReceiptHash.Bytes())
+		_, err = validateReceipts(content, header)
 		return err
 	case BlockHeaderNumberType:
 		headerWithProof, err := DecodeBlockHeaderWithProof(content)
@@ -547,7 +718,7 @@ func (h *HistoryNetwork) validateContent(contentKey []byte, content []byte) erro
 		if header.Number.Cmp(big.NewInt(int64(blockNumber))) != 0 {
 			return ErrInvalidBlockNumber
 		}
-		valid, err := h.verifyHeader(header, *headerWithProof.Proof)
+		valid, err := accu.VerifyHeader(*header, *headerWithProof.Proof)
 		if err != nil {
 			return err
 		}
@@ -559,7 +730,14 @@ func (h *HistoryNetwork) validateContent(contentKey []byte, content []byte) erro
 	return errors.New("unknown content type")
 }
 
-func (h *HistoryNetwork) validateContents(contentKeys [][]byte, contents [][]byte) error {
+// isEpochAccumulatorKey reports whether the content key is the key of an epoch
+// accumulator, which holds a 32 byte epoch hash. The key of a header by number
+// with the same selector holds an 8 byte block number.
+func isEpochAccumulatorKey(contentKey []byte) bool {
+	return ContentType(contentKey[0]) == EpochAccumulatorType && len(contentKey) == 1+common.HashLength
+}
+
+func (h *HistoryNetwork) validateContents(node enode.ID, contentKeys [][]byte, contents [][]byte) error {
 	for i, content := range contents {
 		contentKey := contentKeys[i]
 		err := h.validateContent(contentKey, content)
@@ -568,7 +746,7 @@ func (h *HistoryNetwork) validateContents(contentKeys [][]byte, contents [][]byt
 			return fmt.Errorf("content validate failed with content key %x and content %x", contentKey, content)
 		}
 		contentId := h.portalProtocol.ToContentId(contentKey)
-		_ = h.portalProtocol.Put(contentKey, contentId, content)
+		_ = h.portalProtocol.PutFrom(contentKey, contentId, content, storage.ValidatedProvenance(storage.SourceOffer, node))
 	}
 	return nil
 }
@@ -601,8 +779,20 @@ func DecodeBlockHeaderWithProof(content []byte) (*BlockHeaderWithProof, error) {
 	return headerWithProof, err
 }
 
-func decodeEpochAccumulator(data []byte) (*EpochAccumulator, error) {
+// DecodeEpochAccumulator decodes an epoch accumulator. The accumulator of the
+// last pre-merge epoch holds fewer than epochSize header records.
+func DecodeEpochAccumulator(data []byte) (*EpochAccumulator, error) {
 	epochAccu := new(EpochAccumulator)
-	err := epochAccu.UnmarshalSSZ(data)
-	return epochAccu, err
+	if len(data) == epochAccu.SizeSSZ() {
+		err := epochAccu.UnmarshalSSZ(data)
+		return epochAccu, err
+	}
+	if len(data) == 0 || len(data) > epochAccu.SizeSSZ() || len(data)%headerRecordSize != 0 {
+		return nil, ssz.ErrSize
+	}
+	epochAccu.HeaderRecords = make([][]byte, len(data)/headerRecordSize)
+	for i := range epochAccu.HeaderRecords {
+		epochAccu.HeaderRecords[i] = bytes.Clone(data[i*headerRecordSize : (i+1)*headerRecordSize])
+	}
+	return epochAccu, nil
 }
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	_ "embed"
 	"encoding/json"
+	"fmt"
 	"math/big"
 	"net"
 	"os"
@@ -18,7 +19,9 @@ import (
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/p2p/discover/portalwire"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/portalnetwork/state"
 	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
 )
@@ -75,7 +78,7 @@ func testReceiptsAndBody(entryMap map[string]contentEntry, t *testing.T) {
 
 	headerEntry := entryMap["header"]
 	// validateContents will store the content
-	err = historyNetwork.validateContents([][]byte{headerEntry.key}, [][]byte{headerEntry.value})
+	err = historyNetwork.validateContents(enode.ID{}, [][]byte{headerEntry.key}, [][]byte{headerEntry.value})
 	require.NoError(t, err)
 
 	bodyEntry := entryMap["body"]
@@ -110,7 +113,7 @@ func TestValidateEpochAccu(t *testing.T) {
 	require.NoError(t, err)
 	epochAccuBytes, err := hexutil.Decode(epochAccuHex)
 	require.NoError(t, err)
-	epochAccu, err := decodeEpochAccumulator(epochAccuBytes)
+	epochAccu, err := DecodeEpochAccumulator(epochAccuBytes)
 	require.NoError(t, err)
 	epochRoot, err := epochAccu.HashTreeRoot()
 	require.NoError(t, err)
@@ -119,6 +122,58 @@ func TestValidateEpochAccu(t *testing.T) {
 	require.True(t, historyNetwork.masterAccumulator.Contains(root))
 }
 
+func TestValidateEpochAccumulatorContent(t *testing.T) {
+	master, err := NewMasterAccumulator()
+	require.NoError(t, err)
+	historyNetwork := &HistoryNetwork{
+		masterAccumulator: &master,
+	}
+	epochAccuBytes, err := hexutil.Decode(epochAccuHex)
+	require.NoError(t, err)
+
+	contentKey := newContentKey(EpochAccumulatorType, master.HistoricalEpochs[0]).encode()
+	err = historyNetwork.validateContent(contentKey, epochAccuBytes)
+	require.NoError(t, err)
+
+	contentKey = newContentKey(EpochAccumulatorType, master.HistoricalEpochs[1]).encode()
+	err = historyNetwork.validateContent(contentKey, epochAccuBytes)
+	require.ErrorIs(t, err, ErrInvalidEpochAccumulator)
+
+	// a header by number has the same selector, but isn't an epoch accumulator
+	require.True(t, isEpochAccumulatorKey(contentKey))
+	require.False(t, isEpochAccumulatorKey(hexutil.MustDecode("0x034e61bc0000000000")))
+}
+
+func TestBuildHeaderProof(t *testing.T) {
+	historyNetwork, err := genHistoryNetwork(":7899", nil)
+	require.NoError(t, err)
+	defer historyNetwork.Stop()
+
+	header, err := getHeader(1000003)
+	require.NoError(t, err)
+	// no epoch accumulator available
+	_, err = historyNetwork.BuildHeaderProof(header)
+	require.Error(t, err)
+
+	epochHash := historyNetwork.masterAccumulator.HistoricalEpochs[GetEpochIndexByHeader(*header)]
+	epochAccuBytes, err := os.ReadFile(fmt.Sprintf("./testdata/%s.bin", hexutil.Encode(epochHash)))
+	require.NoError(t, err)
+	contentKey := newContentKey(EpochAccumulatorType, epochHash).encode()
+	err = historyNetwork.portalProtocol.Put(contentKey, historyNetwork.portalProtocol.ToContentId(contentKey), epochAccuBytes)
+	require.NoError(t, err)
+
+	headerWithProof, err := historyNetwork.BuildHeaderProof(header)
+	require.NoError(t, err)
+	valid, err := historyNetwork.verifyHeader(header, *headerWithProof.Proof)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	// the header must match the record of the epoch accumulator
+	header.Extra = []byte("invalid")
+	_, err = historyNetwork.BuildHeaderProof(header)
+	require.ErrorIs(t, err, ErrInvalidBlockHash)
+}
+
 func TestGetContentByKey(t *testing.T) {
 	historyNetwork1, err := genHistoryNetwork(":7895", nil)
 	require.NoError(t, err)
@@ -213,6 +268,77 @@ func TestGetContentByKey(t *testing.T) {
 	require.NotNil(t, header)
 }
 
+// zeroRadiusStorage holds no content, so all content is out of the radius.
+type zeroRadiusStorage struct {
+	storage.MockStorage
+}
+
+func (s *zeroRadiusStorage) Radius() *uint256.Int {
+	return uint256.NewInt(0)
+}
+
+func TestGetContentOutOfRange(t *testing.T) {
+	historyNetwork1, err := genHistoryNetwork(":7901", nil)
+	require.NoError(t, err)
+	defer historyNetwork1.Stop()
+	historyNetwork2, err := genHistoryNetworkWithStorage(":7902", []*enode.Node{historyNetwork1.portalProtocol.Self()}, &zeroRadiusStorage{storage.MockStorage{Db: make(map[string][]byte)}})
+	require.NoError(t, err)
+	defer historyNetwork2.Stop()
+	// wait node start
+	time.Sleep(10 * time.Second)
+
+	entryMap, err := parseDataForBlock("block_14764013.json")
+	require.NoError(t, err)
+	headerEntry := entryMap["header"]
+	contentId := historyNetwork1.portalProtocol.ToContentId(headerEntry.key)
+	err = historyNetwork1.portalProtocol.Put(headerEntry.key, contentId, headerEntry.value)
+	require.NoError(t, err)
+
+	// the getters don't look up content outside of the radius
+	header, err := historyNetwork2.GetBlockHeader(headerEntry.key[1:])
+	require.ErrorIs(t, err, ErrContentOutOfRange)
+	require.Nil(t, header)
+	_, err = historyNetwork2.GetBlockBody(headerEntry.key[1:])
+	require.ErrorIs(t, err, ErrContentOutOfRange)
+	_, err = historyNetwork2.GetReceipts(headerEntry.key[1:])
+	require.ErrorIs(t, err, ErrContentOutOfRange)
+
+	// the export looks it up without storing it
+	header, err = historyNetwork2.LookupBlockHeader(headerEntry.key[1:])
+	require.NoError(t, err)
+	require.Equal(t, headerEntry.key[1:], header.Hash().Bytes())
+	_, err = historyNetwork2.portalProtocol.Get(headerEntry.key, contentId)
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+}
+
+func TestLookupOnlyHeaderProvider(t *testing.T) {
+	historyNetwork1, err := genHistoryNetwork(":7905", nil)
+	require.NoError(t, err)
+	defer historyNetwork1.Stop()
+	// the state network joins the history network with a nop storage if
+	// history is not enabled
+	historyNetwork2, err := genHistoryNetworkWithStorage(":7906", []*enode.Node{historyNetwork1.portalProtocol.Self()}, storage.NewNopStorage())
+	require.NoError(t, err)
+	defer historyNetwork2.Stop()
+	// wait node start
+	time.Sleep(10 * time.Second)
+
+	entryMap, err := parseDataForBlock("block_14764013.json")
+	require.NoError(t, err)
+	headerEntry := entryMap["header"]
+	contentId := historyNetwork1.portalProtocol.ToContentId(headerEntry.key)
+	err = historyNetwork1.portalProtocol.Put(headerEntry.key, contentId, headerEntry.value)
+	require.NoError(t, err)
+
+	_, err = historyNetwork2.GetBlockHeader(headerEntry.key[1:])
+	require.ErrorIs(t, err, ErrContentOutOfRange)
+
+	var headers state.HeaderProvider = historyNetwork2
+	header, err := headers.LookupBlockHeader(headerEntry.key[1:])
+	require.NoError(t, err)
+	require.Equal(t, headerEntry.key[1:], header.Hash().Bytes())
+}
+
 type Entry struct {
 	ContentKey   string `yaml:"content_key"`
 	ContentValue string `yaml:"content_value"`
@@ -234,7 +360,7 @@ func TestValidateContents(t *testing.T) {
 		keys = append(keys, hexutil.MustDecode(entry.ContentKey))
 		values = append(values, hexutil.MustDecode(entry.ContentValue))
 	}
-	err = historyNetwork.validateContents(keys, values)
+	err = historyNetwork.validateContents(enode.ID{}, keys, values)
 	require.NoError(t, err)
 }
 
@@ -288,6 +414,10 @@ func parseBlockHeaderKeyContent() ([]contentEntry, error) {
 }
 
 func genHistoryNetwork(addr string, bootNodes []*enode.Node) (*HistoryNetwork, error) {
+	return genHistoryNetworkWithStorage(addr, bootNodes, &storage.MockStorage{Db: make(map[string][]byte)})
+}
+
+func genHistoryNetworkWithStorage(addr string, bootNodes []*enode.Node, contentStorage storage.ContentStorage) (*HistoryNetwork, error) {
 	glogger := log.NewGlogHandler(log.NewTerminalHandler(os.Stderr, true))
 	slogVerbosity := log.FromLegacyLevel(5)
 	glogger.Verbosity(slogVerbosity)
@@ -336,7 +466,7 @@ func genHistoryNetwork(addr string, bootNodes []*enode.Node) (*HistoryNetwork, e
 
 	contentQueue := make(chan *discover.ContentElement, 50)
 	utpSocket := discover.NewPortalUtp(context.Background(), conf, discV5, conn)
-	portalProtocol, err := discover.NewPortalProtocol(conf, portalwire.History, privKey, conn, localNode, discV5, utpSocket, &storage.MockStorage{Db: make(map[string][]byte)}, contentQueue)
+	portalProtocol, err := discover.NewPortalProtocol(conf, portalwire.History, privKey, conn, localNode, discV5, utpSocket, contentStorage, contentQueue)
 	if err != nil {
 		return nil, err
 	}
@@ -355,7 +485,7 @@ func genHistoryNetwork(addr string, bootNodes []*enode.Node) (*HistoryNetwork, e
 }
 
 func parseDataForBlock(fileName string) (map[string]contentEntry, error) {
-	content, err := os.ReadFile("./testdata/block_14764013.json")
+	content, err := os.ReadFile("./testdata/" + fileName)
 	if err != nil {
 		return nil, err
 	}
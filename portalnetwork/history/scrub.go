@@ -0,0 +1,55 @@
+package history
+
+import (
+	"crypto/sha256"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+)
+
+// The headers are scrubbed first, the bodies and receipts are then checked
+// against the headers that passed. The epoch accumulators are scrubbed with
+// the headers by number, both have the same selector.
+var scrubOrder = []byte{byte(BlockHeaderType), byte(BlockHeaderNumberType), byte(BlockBodyType), byte(ReceiptsType)}
+
+// NewScrubber creates a scrubber of the history storage, which checks the
+// headers against the master accumulator and the bodies and receipts against
+// the stored headers.
+func NewScrubber(contentStorage storage.ContentStorage, accu *MasterAccumulator, config storage.ScrubConfig) (*storage.Scrubber, error) {
+	config.NetworkName = "history"
+	config.ContentTypes = scrubOrder
+	config.Validate = func(reader storage.ContentStorage, contentKey []byte, content []byte) error {
+		headers := func(blockHash []byte) (*types.Header, error) {
+			return localHeader(reader, blockHash)
+		}
+		return validateContent(accu, headers, contentKey, content)
+	}
+	return storage.NewScrubber(contentStorage, config)
+}
+
+// localHeader resolves the headers of bodies and receipts from the storage
+// only, a scrub doesn't look up content in the network. An invalid header is
+// treated as missing, the content is not at fault.
+func localHeader(reader storage.ContentStorage, blockHash []byte) (*types.Header, error) {
+	contentKey := newContentKey(BlockHeaderType, blockHash).encode()
+	content, err := reader.Get(contentKey, historyContentId(contentKey))
+	if err != nil {
+		return nil, err
+	}
+	headerWithProof, err := DecodeBlockHeaderWithProof(content)
+	if err != nil {
+		return nil, storage.ErrContentNotFound
+	}
+	header, err := ValidateBlockHeaderBytes(headerWithProof.Header, blockHash)
+	if err != nil {
+		return nil, storage.ErrContentNotFound
+	}
+	return header, nil
+}
+
+// historyContentId returns the content id of the content key, the sha256 of
+// the key.
+func historyContentId(contentKey []byte) []byte {
+	digest := sha256.Sum256(contentKey)
+	return digest[:]
+}
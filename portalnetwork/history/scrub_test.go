@@ -0,0 +1,67 @@
+package history
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubber(t *testing.T) {
+	zeroNodeId := uint256.NewInt(0).Bytes32()
+	contentStorage, err := newContentStorage(math.MaxUint32, zeroNodeId, nodeDataDir)
+	require.NoError(t, err)
+	defer clearNodeData()
+	defer contentStorage.Close()
+
+	valid, err := parseDataForBlock("block_14764013.json")
+	require.NoError(t, err)
+	for _, entry := range valid {
+		require.NoError(t, contentStorage.Put(entry.key, historyContentId(entry.key), entry.value))
+	}
+	// a header that doesn't match its hash, its body can't be checked then
+	corrupted, err := parseDataForBlock("block_8951059.json")
+	require.NoError(t, err)
+	header := corrupted["header"]
+	header.value = append([]byte{}, header.value...)
+	header.value[20] ^= 1
+	require.NoError(t, contentStorage.Put(header.key, historyContentId(header.key), header.value))
+	body := corrupted["body"]
+	require.NoError(t, contentStorage.Put(body.key, historyContentId(body.key), body.value))
+	require.NoError(t, contentStorage.Put(nil, []byte{1}, []byte{1}))
+
+	accumulator, err := NewMasterAccumulator()
+	require.NoError(t, err)
+	scrubber, err := NewScrubber(contentStorage, &accumulator, storage.ScrubConfig{DryRun: true})
+	require.NoError(t, err)
+	res, err := scrubber.Scrub(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, len(valid)+2, res.Checked)
+	require.Equal(t, 1, res.Invalid)
+	require.Equal(t, 1, res.Skipped)
+	require.Equal(t, uint64(1), res.Unindexed)
+	_, err = contentStorage.Get(header.key, historyContentId(header.key))
+	require.NoError(t, err)
+
+	quarantine := filepath.Join(t.TempDir(), "quarantine")
+	scrubber, err = NewScrubber(contentStorage, &accumulator, storage.ScrubConfig{QuarantineDir: quarantine})
+	require.NoError(t, err)
+	res, err = scrubber.Scrub(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, res.Invalid)
+	_, err = contentStorage.Get(header.key, historyContentId(header.key))
+	require.Error(t, err)
+	quarantined, err := os.ReadFile(filepath.Join(quarantine, hexutil.Encode(header.key)))
+	require.NoError(t, err)
+	require.Equal(t, header.value, quarantined)
+	for _, entry := range valid {
+		_, err = contentStorage.Get(entry.key, historyContentId(entry.key))
+		require.NoError(t, err)
+	}
+}
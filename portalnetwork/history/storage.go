@@ -24,13 +24,15 @@ import (
 const (
 	sqliteName              = "history.sqlite"
 	contentDeletionFraction = 0.05 // 5% of the content will be deleted when the storage capacity is hit and radius gets adjusted.
+	migrationBatchSize      = 1000
 	// SQLite Statements
 	createSql = `CREATE TABLE IF NOT EXISTS kvstore (
 		key BLOB PRIMARY KEY,
 		value BLOB
 	);`
+	addContentKeySql           = "ALTER TABLE kvstore ADD COLUMN content_key BLOB;"
 	getSql                     = "SELECT value FROM kvstore WHERE key = (?1);"
-	putSql                     = "INSERT OR REPLACE INTO kvstore (key, value) VALUES (?1, ?2);"
+	putSql                     = "INSERT OR REPLACE INTO kvstore (key, value, content_key) VALUES (?1, ?2, ?3);"
 	deleteSql                  = "DELETE FROM kvstore WHERE key = (?1);"
 	containSql                 = "SELECT 1 FROM kvstore WHERE key = (?1);"
 	getAllOrderedByDistanceSql = "SELECT key, length(value), xor(key, (?1)) as distance FROM kvstore ORDER BY distance DESC;"
@@ -40,14 +42,27 @@ const (
 		xor(key, (?1)) as distance
 		FROM kvstore
 		ORDER BY distance DESC`
+	localContentKeysSql = `SELECT content_key FROM kvstore
+		WHERE content_key IS NOT NULL AND ((?1) IS NULL OR substr(content_key, 1, 1) = (?1))
+		ORDER BY key LIMIT (?2) OFFSET (?3);`
+	contentTypeStatsSql = `SELECT substr(content_key, 1, 1) as content_type, COUNT(key), SUM(length(value))
+		FROM kvstore GROUP BY content_type;`
 )
 
 var _ storage.ContentStorage = &ContentStorage{}
+var _ storage.ContentIndex = &ContentStorage{}
+var _ storage.ContentDeleter = &ContentStorage{}
 var once sync.Once
 
+// migrations are the schema versions of the storage, see storage.Migrate.
+var migrations = []storage.Migration{
+	storage.ExecMigration("create the content table", createSql),
+	{Description: "add the content key column", Apply: addContentKeyColumn},
+}
+
 type ContentStorage struct {
 	nodeId                 enode.ID
-	storageCapacityInBytes uint64
+	storageCapacityInBytes atomic.Uint64
 	radius                 atomic.Value
 	sqliteDB               *sql.DB
 	getStmt                *sql.Stmt
@@ -105,14 +120,14 @@ func NewDB(dataDir string, network string) (*sql.DB, error) {
 
 func NewHistoryStorage(config storage.PortalStorageConfig) (storage.ContentStorage, error) {
 	hs := &ContentStorage{
-		nodeId:                 config.NodeId,
-		sqliteDB:               config.DB,
-		storageCapacityInBytes: config.StorageCapacityMB * 1000000,
-		log:                    log.New("storage", config.NetworkName),
+		nodeId:   config.NodeId,
+		sqliteDB: config.DB,
+		log:      log.New("storage", config.NetworkName),
 	}
+	hs.storageCapacityInBytes.Store(config.StorageCapacityMB * 1000000)
 	hs.radius.Store(storage.MaxDistance)
 
-	err := hs.createTable()
+	err := storage.Migrate(hs.sqliteDB, config.NetworkName, migrations)
 	if err != nil {
 		return nil, err
 	}
@@ -121,7 +136,7 @@ func NewHistoryStorage(config storage.PortalStorageConfig) (storage.ContentStora
 	// Check whether we already have data, and use it to set radius
 	hs.setRadiusToFarthestDistance()
 
-	// necessary to test NetworkName==history because state also initialize HistoryStorage
+	// the metrics are only registered for the history network, tests use other names
 	if strings.ToLower(config.NetworkName) == "history" {
 		portalStorageMetrics, err = metrics.NewPortalStorageMetrics(config.NetworkName, config.DB)
 		if err != nil {
@@ -174,13 +189,13 @@ func (p *ContentStorage) Radius() *uint256.Int {
 }
 
 func (p *ContentStorage) Put(contentKey []byte, contentId []byte, content []byte) error {
-	res := p.put(contentId, content)
+	res := p.put(contentKey, contentId, content)
 	return res.Err()
 }
 
 // Put saves the contentId and content
-func (p *ContentStorage) put(contentId []byte, content []byte) PutResult {
-	_, err := p.putStmt.Exec(contentId, content)
+func (p *ContentStorage) put(contentKey []byte, contentId []byte, content []byte) PutResult {
+	_, err := p.putStmt.Exec(contentId, content, nullable(contentKey))
 	if err != nil {
 		return newPutResultWithErr(err)
 	}
@@ -189,7 +204,7 @@ func (p *ContentStorage) put(contentId []byte, content []byte) PutResult {
 	if err != nil {
 		return newPutResultWithErr(err)
 	}
-	if dbSize > p.storageCapacityInBytes {
+	if dbSize > p.storageCapacityInBytes.Load() {
 		count, err := p.deleteContentFraction(contentDeletionFraction)
 		//
 		if err != nil {
@@ -206,6 +221,33 @@ func (p *ContentStorage) put(contentId []byte, content []byte) PutResult {
 	return PutResult{}
 }
 
+// PutBatch saves the contents in a single transaction. Unlike Put it doesn't
+// prune the storage, so callers have to keep the content within the radius.
+func (p *ContentStorage) PutBatch(contentKeys [][]byte, contentIds [][]byte, contents [][]byte) error {
+	tx, err := p.sqliteDB.Begin()
+	if err != nil {
+		return err
+	}
+	stmt := tx.Stmt(p.putStmt)
+	size := 0
+	for i, contentId := range contentIds {
+		if _, err = stmt.Exec(contentId, contents[i], nullable(contentKeys[i])); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		size += len(contents[i])
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if metrics.Enabled {
+		portalStorageMetrics.EntriesCount.Inc(int64(len(contentIds)))
+		portalStorageMetrics.ContentStorageUsage.Inc(int64(size))
+	}
+	return nil
+}
+
 func (p *ContentStorage) Close() error {
 	err := p.getStmt.Close()
 	if err != nil {
@@ -226,17 +268,16 @@ func (p *ContentStorage) Close() error {
 	return p.sqliteDB.Close()
 }
 
-func (p *ContentStorage) createTable() error {
-	stmt, err := p.sqliteDB.Prepare(createSql)
-	if err != nil {
+// addContentKeyColumn adds the content key column to a table created before
+// the content keys were stored. The content stored until then has none, as it
+// can't be derived from the content id. Tables of unversioned databases may
+// have the column already.
+func addContentKeyColumn(tx *sql.Tx) error {
+	hasColumn, err := storage.HasColumn(tx, "kvstore", "content_key")
+	if err != nil || hasColumn {
 		return err
 	}
-	defer func(stat *sql.Stmt) {
-		if err = stat.Close(); err != nil {
-			p.log.Error("failed to close statement", "err", err)
-		}
-	}(stmt)
-	_, err = stmt.Exec()
+	_, err = tx.Exec(addContentKeySql)
 	return err
 }
 
@@ -298,13 +339,17 @@ func (p *ContentStorage) ContentSize() (uint64, error) {
 }
 
 func (p *ContentStorage) SizeByKey(contentId []byte) (uint64, error) {
-	sql := "SELECT SUM( length(value) ) FROM kvstore WHERE key = " + string(contentId) + ";"
-	return p.queryRowUint64(sql)
+	sql := "SELECT COALESCE(SUM( length(value) ), 0) FROM kvstore WHERE key = (?1);"
+	return p.queryRowUint64(sql, contentId)
 }
 
 func (p *ContentStorage) SizeByKeys(ids [][]byte) (uint64, error) {
-	sql := "SELECT SUM( length(value) ) FROM kvstore WHERE key IN (?" + strings.Repeat(", ?", len(ids)-1) + ");"
-	return p.queryRowUint64(sql)
+	sql := "SELECT COALESCE(SUM( length(value) ), 0) FROM kvstore WHERE key IN (?" + strings.Repeat(", ?", len(ids)-1) + ");"
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return p.queryRowUint64(sql, args...)
 }
 
 func (p *ContentStorage) SizeOutRadius(radius *uint256.Int) (uint64, error) {
@@ -314,7 +359,7 @@ func (p *ContentStorage) SizeOutRadius(radius *uint256.Int) (uint64, error) {
 	return size, err
 }
 
-func (p *ContentStorage) queryRowUint64(sqlStr string) (uint64, error) {
+func (p *ContentStorage) queryRowUint64(sqlStr string, args ...any) (uint64, error) {
 	// sql := "SELECT SUM(length(value)) FROM kvstore"
 	stmt, err := p.sqliteDB.Prepare(sqlStr)
 	if err != nil {
@@ -326,7 +371,7 @@ func (p *ContentStorage) queryRowUint64(sqlStr string) (uint64, error) {
 		}
 	}(stmt)
 	var res uint64
-	err = stmt.QueryRow().Scan(&res)
+	err = stmt.QueryRow(args...).Scan(&res)
 	return res, err
 }
 
@@ -363,7 +408,7 @@ func (p *ContentStorage) EstimateNewRadius(currentRadius *uint256.Int) (*uint256
 	if err != nil {
 		return nil, err
 	}
-	sizeRatio := currrentSize / p.storageCapacityInBytes
+	sizeRatio := currrentSize / p.storageCapacityInBytes.Load()
 	if sizeRatio > 0 {
 		bigFormat := new(big.Int).SetUint64(sizeRatio)
 		if metrics.Enabled {
@@ -384,7 +429,7 @@ func (p *ContentStorage) setRadiusToFarthestDistance() {
 		return
 	}
 	defer func(rows *sql.Rows) {
-		if rows != nil {
+		if rows == nil {
 			return
 		}
 		err = rows.Close()
@@ -432,7 +477,9 @@ func (p *ContentStorage) deleteContentFraction(fraction float64) (deleteCount in
 		}
 	}(rows)
 	idsToDelete := make([][]byte, 0)
-	for deleteBytes < int(bytesToDelete) && rows.Next() {
+	// at least one item is deleted, a small fraction of little content is
+	// less than a byte
+	for (deleteCount == 0 || deleteBytes < int(bytesToDelete)) && rows.Next() {
 		var contentId []byte
 		var payloadLen int
 		var distance []byte
@@ -549,7 +596,159 @@ func (p *ContentStorage) deleteContentOutOfRadius(radius *uint256.Int) error {
 	return err
 }
 
-// ForcePrune delete the content which distance is further than the given radius
+// SetCapacity changes the capacity of the storage, the farthest content is
+// deleted until the content fits into it. The content size is compared rather
+// than the used size, the pages of the database don't shrink when content is
+// deleted.
+func (p *ContentStorage) SetCapacity(capacity uint64) error {
+	p.storageCapacityInBytes.Store(capacity)
+	for {
+		count, err := p.ContentCount()
+		if err != nil || count == 0 {
+			return err
+		}
+		size, err := p.ContentSize()
+		if err != nil || size <= capacity {
+			return err
+		}
+		deleted, err := p.deleteContentFraction(contentDeletionFraction)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return fmt.Errorf("no content could be pruned, %d bytes of content exceed the capacity of %d bytes", size, capacity)
+		}
+	}
+}
+
+// Delete deletes the content.
+func (p *ContentStorage) Delete(contentKey []byte, contentId []byte) error {
+	return p.del(contentId)
+}
+
+// LocalContentKeys returns a page of the stored content keys ordered by
+// content id, only keys of the content type if it is set.
+func (p *ContentStorage) LocalContentKeys(offset, limit uint64, contentType *byte) ([][]byte, error) {
+	var selector any
+	if contentType != nil {
+		selector = []byte{*contentType}
+	}
+	rows, err := p.sqliteDB.Query(localContentKeysSql, selector, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([][]byte, 0, limit)
+	for rows.Next() {
+		var contentKey []byte
+		if err = rows.Scan(&contentKey); err != nil {
+			return nil, err
+		}
+		keys = append(keys, contentKey)
+	}
+	return keys, rows.Err()
+}
+
+// Stats returns the number and size of the stored content by content type.
+func (p *ContentStorage) Stats() (*storage.StorageStats, error) {
+	rows, err := p.sqliteDB.Query(contentTypeStatsSql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := &storage.StorageStats{
+		ContentTypes: make(map[byte]storage.ContentTypeStats),
+		Radius:       p.Radius(),
+	}
+	for rows.Next() {
+		var (
+			contentType []byte
+			typeStats   storage.ContentTypeStats
+		)
+		if err = rows.Scan(&contentType, &typeStats.Count, &typeStats.Size); err != nil {
+			return nil, err
+		}
+		if len(contentType) == 0 {
+			stats.Unindexed = typeStats
+		} else {
+			stats.ContentTypes[contentType[0]] = typeStats
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+	stats.FarthestDistance, err = p.GetLargestDistance()
+	if errors.Is(err, sql.ErrNoRows) {
+		stats.FarthestDistance, err = uint256.NewInt(0), nil
+	}
+	return stats, err
+}
+
+// ForcePrune delete the content which distance is further than the given
+// radius, and shrinks the radius to it.
 func (p *ContentStorage) ForcePrune(radius *uint256.Int) error {
-	return p.deleteContentOutOfRadius(radius)
+	if err := p.deleteContentOutOfRadius(radius); err != nil {
+		return err
+	}
+	if radius.Cmp(p.Radius()) < 0 {
+		p.radius.Store(radius)
+	}
+	return nil
+}
+
+// Capacity returns the capacity of the storage in bytes.
+func (p *ContentStorage) Capacity() uint64 {
+	return p.storageCapacityInBytes.Load()
+}
+
+// MigrateTo copies the content and the radius into the pebble storage in
+// batches, it returns the number of copied items.
+func (p *ContentStorage) MigrateTo(dst *storage.PebbleStorage) (int, error) {
+	rows, err := p.sqliteDB.Query("SELECT key, value, content_key FROM kvstore;")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var (
+		count       int
+		contentKeys [][]byte
+		contentIds  [][]byte
+		contents    [][]byte
+	)
+	for rows.Next() {
+		var contentId, content, contentKey []byte
+		if err = rows.Scan(&contentId, &content, &contentKey); err != nil {
+			return count, err
+		}
+		contentKeys = append(contentKeys, contentKey)
+		contentIds = append(contentIds, contentId)
+		contents = append(contents, content)
+		if len(contentIds) == migrationBatchSize {
+			if err = dst.PutBatch(contentKeys, contentIds, contents); err != nil {
+				return count, err
+			}
+			count += len(contentIds)
+			contentKeys, contentIds, contents = contentKeys[:0], contentIds[:0], contents[:0]
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return count, err
+	}
+	if err = dst.PutBatch(contentKeys, contentIds, contents); err != nil {
+		return count, err
+	}
+	count += len(contentIds)
+	return count, dst.ForcePrune(p.Radius())
+}
+
+// nullable stores an empty content key as NULL, so content without a key
+// isn't listed.
+func nullable(contentKey []byte) any {
+	if len(contentKey) == 0 {
+		return nil
+	}
+	return contentKey
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/p2p/enode"
@@ -56,7 +57,7 @@ func TestBasicStorage(t *testing.T) {
 	_, err = storage.Get(nil, contentId)
 	assert.Equal(t, contentStorage.ErrContentNotFound, err)
 
-	pt := storage.put(contentId, content)
+	pt := storage.put(nil, contentId, content)
 	assert.NoError(t, pt.Err())
 
 	val, err := storage.Get(nil, contentId)
@@ -90,17 +91,17 @@ func TestDBSize(t *testing.T) {
 
 	size1, err := storage.Size()
 	assert.NoError(t, err)
-	putResult := storage.put(uint256.NewInt(1).Bytes(), genBytes(numBytes))
+	putResult := storage.put(nil, uint256.NewInt(1).Bytes(), genBytes(numBytes))
 	assert.Nil(t, putResult.Err())
 
 	size2, err := storage.Size()
 	assert.NoError(t, err)
-	putResult = storage.put(uint256.NewInt(2).Bytes(), genBytes(numBytes))
+	putResult = storage.put(nil, uint256.NewInt(2).Bytes(), genBytes(numBytes))
 	assert.NoError(t, putResult.Err())
 
 	size3, err := storage.Size()
 	assert.NoError(t, err)
-	putResult = storage.put(uint256.NewInt(2).Bytes(), genBytes(numBytes))
+	putResult = storage.put(nil, uint256.NewInt(2).Bytes(), genBytes(numBytes))
 	assert.NoError(t, putResult.Err())
 
 	size4, err := storage.Size()
@@ -138,6 +139,30 @@ func TestDBSize(t *testing.T) {
 	assert.Equal(t, usedSize2, size6)
 }
 
+func TestSizeByKey(t *testing.T) {
+	zeroNodeId := uint256.NewInt(0).Bytes32()
+	storage, err := newContentStorage(math.MaxUint32, zeroNodeId, nodeDataDir)
+	assert.NoError(t, err)
+	defer clearNodeData()
+	defer storage.Close()
+
+	// the content ids are binary, they can't be spliced into the query
+	ids := [][]byte{uint256.NewInt(1).Bytes(), uint256.NewInt(2).Bytes()}
+	for i, id := range ids {
+		putResult := storage.put(nil, id, genBytes(100*(i+1)))
+		assert.NoError(t, putResult.Err())
+	}
+	size, err := storage.SizeByKey(ids[1])
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(200), size)
+	size, err = storage.SizeByKeys(ids)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(300), size)
+	size, err = storage.SizeByKey(uint256.NewInt(3).Bytes())
+	assert.NoError(t, err)
+	assert.Zero(t, size)
+}
+
 func TestDBPruning(t *testing.T) {
 	storageCapacity := uint64(1)
 
@@ -153,29 +178,29 @@ func TestDBPruning(t *testing.T) {
 
 	numBytes := 100_000
 	// test with private put method
-	pt1 := storage.put(uint256.NewInt(1).Bytes(), genBytes(numBytes))
+	pt1 := storage.put(nil, uint256.NewInt(1).Bytes(), genBytes(numBytes))
 	assert.NoError(t, pt1.Err())
-	pt2 := storage.put(thirdFurthest.Bytes(), genBytes(numBytes))
+	pt2 := storage.put(nil, thirdFurthest.Bytes(), genBytes(numBytes))
 	assert.NoError(t, pt2.Err())
-	pt3 := storage.put(uint256.NewInt(3).Bytes(), genBytes(numBytes))
+	pt3 := storage.put(nil, uint256.NewInt(3).Bytes(), genBytes(numBytes))
 	assert.NoError(t, pt3.Err())
-	pt4 := storage.put(uint256.NewInt(10).Bytes(), genBytes(numBytes))
+	pt4 := storage.put(nil, uint256.NewInt(10).Bytes(), genBytes(numBytes))
 	assert.NoError(t, pt4.Err())
-	pt5 := storage.put(uint256.NewInt(5).Bytes(), genBytes(numBytes))
+	pt5 := storage.put(nil, uint256.NewInt(5).Bytes(), genBytes(numBytes))
 	assert.NoError(t, pt5.Err())
-	pt6 := storage.put(uint256.NewInt(11).Bytes(), genBytes(numBytes))
+	pt6 := storage.put(nil, uint256.NewInt(11).Bytes(), genBytes(numBytes))
 	assert.NoError(t, pt6.Err())
-	pt7 := storage.put(furthestElement.Bytes(), genBytes(40000))
+	pt7 := storage.put(nil, furthestElement.Bytes(), genBytes(40000))
 	assert.NoError(t, pt7.Err())
-	pt8 := storage.put(secondFurthest.Bytes(), genBytes(30000))
+	pt8 := storage.put(nil, secondFurthest.Bytes(), genBytes(30000))
 	assert.NoError(t, pt8.Err())
-	pt9 := storage.put(uint256.NewInt(2).Bytes(), genBytes(numBytes*2))
+	pt9 := storage.put(nil, uint256.NewInt(2).Bytes(), genBytes(numBytes*2))
 	assert.NoError(t, pt9.Err())
 
 	res, _ := storage.GetLargestDistance()
 
 	assert.Equal(t, res, uint256.NewInt(40))
-	pt10 := storage.put(uint256.NewInt(4).Bytes(), genBytes(132000))
+	pt10 := storage.put(nil, uint256.NewInt(4).Bytes(), genBytes(132000))
 	assert.NoError(t, pt10.Err())
 
 	assert.False(t, pt1.Pruned())
@@ -192,7 +217,7 @@ func TestDBPruning(t *testing.T) {
 	assert.Equal(t, pt10.PrunedCount(), 2)
 	usedSize, err := storage.UsedSize()
 	assert.NoError(t, err)
-	assert.True(t, usedSize < storage.storageCapacityInBytes)
+	assert.True(t, usedSize < storage.storageCapacityInBytes.Load())
 
 	_, err = storage.Get(nil, furthestElement.Bytes())
 	assert.Equal(t, contentStorage.ErrContentNotFound, err)
@@ -217,13 +242,13 @@ func TestGetLargestDistance(t *testing.T) {
 	furthestElement := uint256.NewInt(40)
 	secondFurthest := uint256.NewInt(30)
 
-	pt7 := storage.put(furthestElement.Bytes(), genBytes(2000))
+	pt7 := storage.put(nil, furthestElement.Bytes(), genBytes(2000))
 	assert.NoError(t, pt7.Err())
 
 	val, err := storage.Get(nil, furthestElement.Bytes())
 	assert.NoError(t, err)
 	assert.NotNil(t, val)
-	pt8 := storage.put(secondFurthest.Bytes(), genBytes(2000))
+	pt8 := storage.put(nil, secondFurthest.Bytes(), genBytes(2000))
 	assert.NoError(t, pt8.Err())
 	res, err := storage.GetLargestDistance()
 	assert.NoError(t, err)
@@ -243,13 +268,13 @@ func TestSimpleForcePruning(t *testing.T) {
 	secondFurthest := uint256.NewInt(30)
 	third := uint256.NewInt(10)
 
-	pt1 := storage.put(furthestElement.Bytes(), genBytes(2000))
+	pt1 := storage.put(nil, furthestElement.Bytes(), genBytes(2000))
 	assert.NoError(t, pt1.Err())
 
-	pt2 := storage.put(secondFurthest.Bytes(), genBytes(2000))
+	pt2 := storage.put(nil, secondFurthest.Bytes(), genBytes(2000))
 	assert.NoError(t, pt2.Err())
 
-	pt3 := storage.put(third.Bytes(), genBytes(2000))
+	pt3 := storage.put(nil, third.Bytes(), genBytes(2000))
 	assert.NoError(t, pt3.Err())
 	res, err := storage.GetLargestDistance()
 	assert.NoError(t, err)
@@ -283,7 +308,7 @@ func TestForcePruning(t *testing.T) {
 	defer clearNodeData()
 	defer storage.Close()
 
-	storage.storageCapacityInBytes = startCap
+	storage.storageCapacityInBytes.Store(startCap)
 
 	increment := uint256.NewInt(0).Div(maxUint256, uint256.NewInt(amountOfItems))
 	remainder := uint256.NewInt(0).Mod(maxUint256, uint256.NewInt(amountOfItems))
@@ -292,13 +317,13 @@ func TestForcePruning(t *testing.T) {
 	putCount := 0
 	// id < maxUint256 - remainder
 	for id.Cmp(uint256.NewInt(0).Sub(maxUint256, remainder)) == -1 {
-		res := storage.put(id.Bytes(), content)
+		res := storage.put(nil, id.Bytes(), content)
 		assert.NoError(t, res.Err())
 		id = id.Add(id, increment)
 		putCount++
 	}
 
-	storage.storageCapacityInBytes = endCapacity
+	storage.storageCapacityInBytes.Store(endCapacity)
 
 	oldDistance, err := storage.GetLargestDistance()
 	assert.NoError(t, err)
@@ -313,3 +338,148 @@ func TestForcePruning(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(0), total)
 }
+
+func TestMigrateToPebble(t *testing.T) {
+	zeroNodeId := uint256.NewInt(0).Bytes32()
+	sqliteStorage, err := newContentStorage(math.MaxUint32, zeroNodeId, nodeDataDir)
+	assert.NoError(t, err)
+	defer clearNodeData()
+	defer sqliteStorage.Close()
+
+	ids := make([][]byte, 0, 2500)
+	for i := 1; i <= 2500; i++ {
+		id := uint256.NewInt(uint64(i)).Bytes32()
+		ids = append(ids, id[:])
+		pt := sqliteStorage.put(nil, id[:], genBytes(i%100))
+		assert.NoError(t, pt.Err())
+	}
+	radius := uint256.NewInt(5000)
+	sqliteStorage.radius.Store(radius)
+
+	db, err := contentStorage.NewPebbleDB(t.TempDir(), "history")
+	assert.NoError(t, err)
+	pebbleStorage, err := contentStorage.NewPebbleStorage(storage.PortalStorageConfig{
+		StorageCapacityMB: math.MaxUint32,
+		KVStore:           db,
+		NodeId:            zeroNodeId,
+	})
+	assert.NoError(t, err)
+	defer pebbleStorage.Close()
+
+	count, err := sqliteStorage.MigrateTo(pebbleStorage)
+	assert.NoError(t, err)
+	assert.Equal(t, len(ids), count)
+	assert.Equal(t, uint64(len(ids)), pebbleStorage.ContentCount())
+	assert.Equal(t, radius, pebbleStorage.Radius())
+	for i, id := range ids {
+		content, err := pebbleStorage.Get(nil, id)
+		assert.NoError(t, err)
+		assert.Equal(t, genBytes((i+1)%100), content)
+	}
+}
+
+func TestLocalContentKeys(t *testing.T) {
+	zeroNodeId := uint256.NewInt(0).Bytes32()
+	// a table created before the content keys were stored
+	db, err := NewDB(nodeDataDir, "history")
+	assert.NoError(t, err)
+	defer clearNodeData()
+	_, err = db.Exec("CREATE TABLE kvstore (key BLOB PRIMARY KEY, value BLOB);")
+	assert.NoError(t, err)
+	_, err = db.Exec("INSERT INTO kvstore (key, value) VALUES (?1, ?2);", []byte{0xff}, genBytes(10))
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	storage, err := newContentStorage(math.MaxUint32, zeroNodeId, nodeDataDir)
+	assert.NoError(t, err)
+	defer storage.Close()
+	var keys [][]byte
+	for i := byte(1); i <= 6; i++ {
+		contentType := []ContentType{BlockHeaderType, BlockBodyType}[i%2]
+		contentKey := newContentKey(contentType, []byte{i}).encode()
+		keys = append(keys, contentKey)
+		assert.NoError(t, storage.Put(contentKey, []byte{i}, genBytes(int(i))))
+	}
+
+	// the content stored without a key isn't listed
+	page, err := storage.LocalContentKeys(0, 4, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, keys[:4], page)
+	page, err = storage.LocalContentKeys(4, 4, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, keys[4:], page)
+	headerType := byte(BlockHeaderType)
+	page, err = storage.LocalContentKeys(1, 10, &headerType)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{keys[3], keys[5]}, page)
+
+	stats, err := storage.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, map[byte]contentStorage.ContentTypeStats{
+		byte(BlockHeaderType): {Count: 3, Size: 2 + 4 + 6},
+		byte(BlockBodyType):   {Count: 3, Size: 1 + 3 + 5},
+	}, stats.ContentTypes)
+	assert.Equal(t, contentStorage.ContentTypeStats{Count: 1, Size: 10}, stats.Unindexed)
+	assert.Equal(t, uint256.NewInt(0xff), stats.FarthestDistance)
+	// the radius of a storage with content starts at the farthest distance
+	assert.Equal(t, stats.FarthestDistance, stats.Radius)
+}
+
+func TestMigrateUnversionedStorage(t *testing.T) {
+	// a database with the content keys created before the schema was versioned
+	fixture, err := os.ReadFile("testdata/history_unversioned.sqlite")
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Join(nodeDataDir, "history"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(nodeDataDir, "history", sqliteName), fixture, 0644))
+	defer clearNodeData()
+
+	zeroNodeId := uint256.NewInt(0).Bytes32()
+	storage, err := newContentStorage(math.MaxUint32, zeroNodeId, nodeDataDir)
+	assert.NoError(t, err)
+	version, err := contentStorage.SchemaVersion(storage.sqliteDB)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	content, err := storage.Get(nil, []byte{0xff})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xaa, 0xbb, 0xcc}, content)
+	keys, err := storage.LocalContentKeys(0, 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{0x00, 0xff}}, keys)
+	assert.NoError(t, storage.Close())
+
+	// a database of a newer version is refused
+	db, err := NewDB(nodeDataDir, "history")
+	assert.NoError(t, err)
+	_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d;", len(migrations)+1))
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+	_, err = newContentStorage(math.MaxUint32, zeroNodeId, nodeDataDir)
+	assert.ErrorIs(t, err, contentStorage.ErrSchemaTooNew)
+}
+
+func TestSetCapacity(t *testing.T) {
+	zeroNodeId := uint256.NewInt(0).Bytes32()
+	storage, err := newContentStorage(1, zeroNodeId, t.TempDir())
+	assert.NoError(t, err)
+	defer storage.Close()
+
+	for i := uint64(1); i <= 10; i++ {
+		res := storage.put(nil, uint256.NewInt(i).Bytes(), genBytes(10))
+		assert.NoError(t, res.Err())
+	}
+	// the content is far smaller than the database pages, only the farthest
+	// content that doesn't fit is deleted
+	assert.NoError(t, storage.SetCapacity(55))
+	size, err := storage.ContentSize()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(50), size)
+	for i := uint64(1); i <= 10; i++ {
+		_, err = storage.Get(nil, uint256.NewInt(i).Bytes())
+		if i <= 5 {
+			assert.NoError(t, err)
+		} else {
+			assert.Equal(t, contentStorage.ErrContentNotFound, err)
+		}
+	}
+}
@@ -48,6 +48,14 @@ func TestContentKey(t *testing.T) {
 			contentIdU256: "76230538398907151249589044529104962263309222250374376758768131420767496438948",
 			selector:      ReceiptsType,
 		},
+		{
+			name:          "epoch accumulator key",
+			hash:          "e242814b90ed3950e13aac7e56ce116540c71b41d1516605aada26c6c07cc491",
+			contentKey:    "03e242814b90ed3950e13aac7e56ce116540c71b41d1516605aada26c6c07cc491",
+			contentIdHex:  "9fb2175e76c6989e0fdac3ee10c40d2a81eb176af32e1c16193e3904fe56896e",
+			contentIdU256: "72232402989179419196382321898161638871438419016077939952896528930608027961710",
+			selector:      EpochAccumulatorType,
+		},
 	}
 
 	for _, c := range testCases {
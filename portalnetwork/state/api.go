@@ -1,11 +1,38 @@
 package state
 
 import (
+	gethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 )
 
 type API struct {
 	*discover.PortalProtocolAPI
+	stateNetwork *StateNetwork
+}
+
+// AccountState is the decoded state of an account.
+type AccountState struct {
+	Nonce       hexutil.Uint64  `json:"nonce"`
+	Balance     *hexutil.U256   `json:"balance"`
+	StorageRoot gethcommon.Hash `json:"storageRoot"`
+	CodeHash    gethcommon.Hash `json:"codeHash"`
+}
+
+// GetAccountResult is the result of portal_stateGetAccount. Account is null if
+// the account does not exist or a trie node on its path is missing.
+type GetAccountResult struct {
+	Account      *AccountState     `json:"account"`
+	Proof        []hexutil.Bytes   `json:"proof"`
+	MissingNodes []MissingTrieNode `json:"missingNodes"`
+}
+
+// GetStorageResult is the result of portal_stateGetStorage.
+type GetStorageResult struct {
+	Value        gethcommon.Hash   `json:"value"`
+	AccountProof []hexutil.Bytes   `json:"accountProof"`
+	StorageProof []hexutil.Bytes   `json:"storageProof"`
+	MissingNodes []MissingTrieNode `json:"missingNodes"`
 }
 
 func (p *API) StateRoutingTableInfo() *discover.RoutingTableInfo {
@@ -56,6 +83,22 @@ func (p *API) StateLocalContent(contentKeyHex string) (string, error) {
 	return p.LocalContent(contentKeyHex)
 }
 
+func (p *API) StateLocalContentKeys(offset, limit uint64, contentType *uint8) ([]string, error) {
+	return p.LocalContentKeys(offset, limit, contentType)
+}
+
+func (p *API) StateStorageStats() (*discover.StorageStats, error) {
+	return p.StorageStats()
+}
+
+func (p *API) StateContentProvenance(contentKeyHex string) (*discover.ContentProvenance, error) {
+	return p.ContentProvenance(contentKeyHex)
+}
+
+func (p *API) StatePurgeContentFrom(nodeId string) (int, error) {
+	return p.PurgeContentFrom(nodeId)
+}
+
 func (p *API) StateStore(contentKeyHex string, contextHex string) (bool, error) {
 	return p.Store(contentKeyHex, contextHex)
 }
@@ -73,8 +116,60 @@ func (p *API) StateTraceGetContent(contentKeyHex string) (*discover.TraceContent
 	return p.TraceRecursiveFindContent(contentKeyHex)
 }
 
-func NewStateNetworkAPI(portalProtocolAPI *discover.PortalProtocolAPI) *API {
+// StateGetAccount returns the account of address in the state of the block,
+// the account trie is walked from the state root of its header.
+func (p *API) StateGetAccount(address gethcommon.Address, blockHash gethcommon.Hash) (*GetAccountResult, error) {
+	res, err := p.stateNetwork.GetAccount(address, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	result := &GetAccountResult{
+		Proof:        encodeProof(res.Proof),
+		MissingNodes: missingNodes(res.MissingNodes),
+	}
+	if account := res.Account; account != nil {
+		result.Account = &AccountState{
+			Nonce:       hexutil.Uint64(account.Nonce),
+			Balance:     (*hexutil.U256)(account.Balance),
+			StorageRoot: account.Root,
+			CodeHash:    gethcommon.BytesToHash(account.CodeHash),
+		}
+	}
+	return result, nil
+}
+
+// StateGetStorage returns a storage slot of address in the state of the block.
+func (p *API) StateGetStorage(address gethcommon.Address, slot gethcommon.Hash, blockHash gethcommon.Hash) (*GetStorageResult, error) {
+	res, err := p.stateNetwork.GetStorage(address, slot, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	return &GetStorageResult{
+		Value:        res.Value,
+		AccountProof: encodeProof(res.AccountProof),
+		StorageProof: encodeProof(res.StorageProof),
+		MissingNodes: missingNodes(res.MissingNodes),
+	}, nil
+}
+
+func encodeProof(proof TrieProof) []hexutil.Bytes {
+	encoded := make([]hexutil.Bytes, 0, len(proof))
+	for _, node := range proof {
+		encoded = append(encoded, hexutil.Bytes(node))
+	}
+	return encoded
+}
+
+func missingNodes(nodes []MissingTrieNode) []MissingTrieNode {
+	if nodes == nil {
+		return []MissingTrieNode{}
+	}
+	return nodes
+}
+
+func NewStateNetworkAPI(portalProtocolAPI *discover.PortalProtocolAPI, stateNetwork *StateNetwork) *API {
 	return &API{
 		portalProtocolAPI,
+		stateNetwork,
 	}
 }
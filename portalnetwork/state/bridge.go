@@ -0,0 +1,386 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	gethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/ethereum/go-ethereum/triedb/hashdb"
+	"github.com/ethereum/go-ethereum/triedb/pathdb"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+)
+
+// checkpointInterval is the number of accounts after which the progress of
+// the bridge is saved.
+const checkpointInterval = 1000
+
+// ContentGossiper offers content generated by the bridge to the network, the
+// state network is one.
+type ContentGossiper interface {
+	GossipContent(contentKey []byte, content []byte) (int, error)
+}
+
+// GossipContent stores locally generated content if it is within the radius
+// and offers it to the peers interested in it.
+func (h *StateNetwork) GossipContent(contentKey []byte, content []byte) (int, error) {
+	contentId := h.portalProtocol.ToContentId(contentKey)
+	if h.portalProtocol.InRange(contentId) {
+		if err := h.portalProtocol.Put(contentKey, contentId, content); err != nil {
+			return 0, err
+		}
+	}
+	return h.portalProtocol.Gossip(nil, [][]byte{contentKey}, [][]byte{content})
+}
+
+// BridgeCheckpoint is the progress of a bridge run, it is saved to resume an
+// interrupted run. All content up to and including the account with the hash
+// Account has been gossiped.
+type BridgeCheckpoint struct {
+	Block   gethcommon.Hash `json:"block"`
+	From    gethcommon.Hash `json:"from"`
+	Account gethcommon.Hash `json:"account"`
+}
+
+// StateBridge gossips the state of a geth database into the state network.
+// Every trie node of the account trie is offered with the proof from the
+// state root, the trie nodes of the storage tries and the contract bytecode
+// with the proof of their account in addition.
+type StateBridge struct {
+	db             ethdb.Database
+	triedb         *triedb.Database
+	gossiper       ContentGossiper
+	checkpointFile string
+	log            log.Logger
+}
+
+// NewStateBridge creates a bridge reading from the chain database db. The
+// progress is saved to checkpointFile, if it is not empty.
+func NewStateBridge(db ethdb.Database, gossiper ContentGossiper, checkpointFile string) *StateBridge {
+	config := &triedb.Config{HashDB: hashdb.Defaults}
+	if rawdb.ReadStateScheme(db) == rawdb.PathScheme {
+		config = &triedb.Config{PathDB: pathdb.ReadOnly}
+	}
+	return &StateBridge{
+		db:             db,
+		triedb:         triedb.NewDatabase(db, config),
+		gossiper:       gossiper,
+		checkpointFile: checkpointFile,
+		log:            log.New("sub-protocol", "state", "component", "bridge"),
+	}
+}
+
+// Header returns the canonical header of the block number.
+func (b *StateBridge) Header(number uint64) (*types.Header, error) {
+	hash := rawdb.ReadCanonicalHash(b.db, number)
+	if hash == (gethcommon.Hash{}) {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	return rawdb.ReadHeader(b.db, hash, number), nil
+}
+
+// HeadHeader returns the header of the head block.
+func (b *StateBridge) HeadHeader() (*types.Header, error) {
+	hash := rawdb.ReadHeadHeaderHash(b.db)
+	number := rawdb.ReadHeaderNumber(b.db, hash)
+	if number == nil {
+		return nil, errors.New("head block not found")
+	}
+	return rawdb.ReadHeader(b.db, hash, *number), nil
+}
+
+// Bridge gossips the state at block. If from is not nil, only the trie nodes
+// and the bytecode that are new since from are gossiped. A checkpoint saved
+// for the same blocks is resumed.
+func (b *StateBridge) Bridge(ctx context.Context, from, block *types.Header) error {
+	checkpoint := &BridgeCheckpoint{Block: block.Hash()}
+	var fromRoot gethcommon.Hash
+	if from != nil {
+		checkpoint.From = from.Hash()
+		fromRoot = from.Root
+	}
+	var resume []byte
+	if saved, err := b.loadCheckpoint(); err != nil {
+		return err
+	} else if saved != nil && saved.Block == checkpoint.Block && saved.From == checkpoint.From {
+		b.log.Info("Resuming state bridge", "block", block.Number, "account", saved.Account)
+		checkpoint.Account = saved.Account
+		resume = keybytesToHex(saved.Account[:])
+	}
+
+	accountTrie, err := trie.NewStateTrie(trie.StateTrieID(block.Root), b.triedb)
+	if err != nil {
+		return err
+	}
+	it, err := accountTrie.NodeIterator(nil)
+	if err != nil {
+		return err
+	}
+	var fromTrie *trie.StateTrie
+	if from != nil {
+		fromTrie, err = trie.NewStateTrie(trie.StateTrieID(fromRoot), b.triedb)
+		if err != nil {
+			return err
+		}
+		fromIt, err := fromTrie.NodeIterator(nil)
+		if err != nil {
+			return err
+		}
+		it, _ = trie.NewDifferenceIterator(fromIt, it)
+	}
+
+	var (
+		blockHash = common.Bytes32(block.Hash())
+		accounts  int
+		nodes     int
+		start     = time.Now()
+	)
+	err = walkTrie(it, func(path []byte, proof TrieProof) error {
+		if resume != nil && bytes.Compare(path, resume) <= 0 {
+			return nil
+		}
+		nodes++
+		return b.gossipAccountTrieNode(path, proof, blockHash)
+	}, func(key, value []byte, proof TrieProof) error {
+		if resume != nil && bytes.Compare(keybytesToHex(key), resume) <= 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		account, err := types.FullAccount(value)
+		if err != nil {
+			return err
+		}
+		addressHash := gethcommon.BytesToHash(key)
+		var previous *types.StateAccount
+		if fromTrie != nil {
+			if previous, err = fromTrie.GetAccountByHash(addressHash); err != nil {
+				return err
+			}
+		}
+		if err = b.bridgeAccount(block.Root, fromRoot, addressHash, account, previous, proof, blockHash); err != nil {
+			return err
+		}
+		accounts++
+		checkpoint.Account = addressHash
+		if accounts%checkpointInterval == 0 {
+			b.log.Info("Bridging state", "block", block.Number, "accounts", accounts, "accountNodes", nodes, "elapsed", gethcommon.PrettyDuration(time.Since(start)))
+			return b.saveCheckpoint(checkpoint)
+		}
+		return nil
+	})
+	if err != nil {
+		if saveErr := b.saveCheckpoint(checkpoint); saveErr != nil {
+			b.log.Error("Failed to save state bridge checkpoint", "err", saveErr)
+		}
+		return err
+	}
+	b.log.Info("Bridged state", "block", block.Number, "accounts", accounts, "accountNodes", nodes, "elapsed", gethcommon.PrettyDuration(time.Since(start)))
+	return b.removeCheckpoint()
+}
+
+// bridgeAccount gossips the storage trie nodes and the bytecode of an
+// account that are new since the previous state of the account.
+func (b *StateBridge) bridgeAccount(stateRoot, fromRoot, addressHash gethcommon.Hash, account, previous *types.StateAccount, accountProof TrieProof, blockHash common.Bytes32) error {
+	if !bytes.Equal(account.CodeHash, types.EmptyCodeHash[:]) && (previous == nil || !bytes.Equal(previous.CodeHash, account.CodeHash)) {
+		code := rawdb.ReadCode(b.db, gethcommon.BytesToHash(account.CodeHash))
+		if len(code) == 0 {
+			return fmt.Errorf("missing code %x of account %s", account.CodeHash, addressHash)
+		}
+		if err := b.gossipContractBytecode(addressHash, account.CodeHash, code, accountProof, blockHash); err != nil {
+			return err
+		}
+	}
+	if account.Root == types.EmptyRootHash || (previous != nil && previous.Root == account.Root) {
+		return nil
+	}
+	storageTrie, err := trie.New(trie.StorageTrieID(stateRoot, addressHash, account.Root), b.triedb)
+	if err != nil {
+		return err
+	}
+	it, err := storageTrie.NodeIterator(nil)
+	if err != nil {
+		return err
+	}
+	if previous != nil && previous.Root != types.EmptyRootHash {
+		previousTrie, err := trie.New(trie.StorageTrieID(fromRoot, addressHash, previous.Root), b.triedb)
+		if err != nil {
+			return err
+		}
+		previousIt, err := previousTrie.NodeIterator(nil)
+		if err != nil {
+			return err
+		}
+		it, _ = trie.NewDifferenceIterator(previousIt, it)
+	}
+	return walkTrie(it, func(path []byte, proof TrieProof) error {
+		return b.gossipContractStorageTrieNode(addressHash, path, proof, accountProof, blockHash)
+	}, nil)
+}
+
+// walkTrie calls visitNode for every trie node with a hash and visitLeaf for
+// every value, along with the proof from the root of the trie. The proof of
+// a node ends with the node, the proof of a value with the node that contains
+// it.
+func walkTrie(it trie.NodeIterator, visitNode func(path []byte, proof TrieProof) error, visitLeaf func(key, value []byte, proof TrieProof) error) error {
+	type stackEntry struct {
+		path []byte
+		node EncodedTrieNode
+	}
+	var stack []stackEntry
+	proof := func(path []byte) TrieProof {
+		for len(stack) > 0 && !bytes.HasPrefix(path, stack[len(stack)-1].path) {
+			stack = stack[:len(stack)-1]
+		}
+		proof := make(TrieProof, len(stack))
+		for i, entry := range stack {
+			proof[i] = entry.node
+		}
+		return proof
+	}
+	for it.Next(true) {
+		if it.Leaf() {
+			if visitLeaf == nil {
+				continue
+			}
+			if err := visitLeaf(it.LeafKey(), it.LeafBlob(), proof(it.Path())); err != nil {
+				return err
+			}
+			continue
+		}
+		// nodes shorter than a hash are embedded into their parent
+		if it.Hash() == (gethcommon.Hash{}) {
+			continue
+		}
+		path := bytes.Clone(it.Path())
+		nodeProof := append(proof(path), it.NodeBlob())
+		stack = append(stack, stackEntry{path: path, node: it.NodeBlob()})
+		if err := visitNode(path, nodeProof); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+func (b *StateBridge) gossipAccountTrieNode(path []byte, proof TrieProof, blockHash common.Bytes32) error {
+	nibbles, err := FromUnpackedNibbles(path)
+	if err != nil {
+		return err
+	}
+	key := &AccountTrieNodeKey{Path: *nibbles, NodeHash: proof[len(proof)-1].NodeHash()}
+	return b.gossip(AccountTrieNodeType, key, &AccountTrieNodeWithProof{Proof: proof, BlockHash: blockHash})
+}
+
+func (b *StateBridge) gossipContractStorageTrieNode(addressHash gethcommon.Hash, path []byte, proof, accountProof TrieProof, blockHash common.Bytes32) error {
+	nibbles, err := FromUnpackedNibbles(path)
+	if err != nil {
+		return err
+	}
+	key := &ContractStorageTrieNodeKey{
+		AddressHash: common.Bytes32(addressHash),
+		Path:        *nibbles,
+		NodeHash:    proof[len(proof)-1].NodeHash(),
+	}
+	return b.gossip(ContractStorageTrieNodeType, key, &ContractStorageTrieNodeWithProof{
+		StoregeProof: proof,
+		AccountProof: accountProof,
+		BlockHash:    blockHash,
+	})
+}
+
+func (b *StateBridge) gossipContractBytecode(addressHash gethcommon.Hash, codeHash []byte, code []byte, accountProof TrieProof, blockHash common.Bytes32) error {
+	key := &ContractBytecodeKey{AddressHash: common.Bytes32(addressHash), CodeHash: common.Bytes32(codeHash)}
+	return b.gossip(ContractByteCodeType, key, &ContractBytecodeWithProof{
+		Code:         code,
+		AccountProof: accountProof,
+		BlockHash:    blockHash,
+	})
+}
+
+type serializable interface {
+	Serialize(w *codec.EncodingWriter) error
+}
+
+func (b *StateBridge) gossip(contentType byte, key serializable, content serializable) error {
+	contentKey, err := encodeContentKey(contentType, key)
+	if err != nil {
+		return err
+	}
+	var contentBuf bytes.Buffer
+	if err := content.Serialize(codec.NewEncodingWriter(&contentBuf)); err != nil {
+		return err
+	}
+	peers, err := b.gossiper.GossipContent(contentKey, contentBuf.Bytes())
+	if err != nil {
+		return err
+	}
+	b.log.Trace("Gossiped state content", "contentKey", hexutil.Encode(contentKey), "peers", peers)
+	return nil
+}
+
+func (b *StateBridge) loadCheckpoint() (*BridgeCheckpoint, error) {
+	if b.checkpointFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(b.checkpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := new(BridgeCheckpoint)
+	if err = json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid state bridge checkpoint %s: %w", b.checkpointFile, err)
+	}
+	return checkpoint, nil
+}
+
+// saveCheckpoint writes the checkpoint to a temporary file first, so that an
+// interruption leaves the previous checkpoint intact.
+func (b *StateBridge) saveCheckpoint(checkpoint *BridgeCheckpoint) error {
+	if b.checkpointFile == "" || checkpoint.Account == (gethcommon.Hash{}) {
+		return nil
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := b.checkpointFile + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.checkpointFile)
+}
+
+func (b *StateBridge) removeCheckpoint() error {
+	if b.checkpointFile == "" {
+		return nil
+	}
+	if err := os.Remove(b.checkpointFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// keybytesToHex returns the nibbles of a trie key.
+func keybytesToHex(key []byte) []byte {
+	nibbles := make([]byte, len(key)*2)
+	for i, b := range key {
+		nibbles[i*2], nibbles[i*2+1] = unpackNibblePair(b)
+	}
+	return nibbles
+}
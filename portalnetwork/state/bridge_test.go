@@ -0,0 +1,172 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/pebble"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+)
+
+// contentCollector collects the gossiped content, it fails once limit items
+// have been gossiped if limit is positive.
+type contentCollector struct {
+	content map[string][]byte
+	limit   int
+}
+
+func (c *contentCollector) GossipContent(contentKey []byte, content []byte) (int, error) {
+	if c.limit > 0 && len(c.content) == c.limit {
+		return 0, errors.New("gossip failed")
+	}
+	c.content[string(contentKey)] = content
+	return 1, nil
+}
+
+// newBridgeChain writes a chain into a database in dir. A contract stores the
+// number of every block it is called in, and every block funds a new account.
+func newBridgeChain(t *testing.T, dir string) ([]*types.Block, *headerMap) {
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	contract := gethcommon.HexToAddress("0xc0de")
+	genesis := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: types.GenesisAlloc{
+			sender: {Balance: big.NewInt(params.Ether)},
+			// NUMBER NUMBER SSTORE
+			contract: {Balance: big.NewInt(1), Code: []byte{byte(vm.NUMBER), byte(vm.NUMBER), byte(vm.SSTORE)}, Storage: map[gethcommon.Hash]gethcommon.Hash{{}: {1}}},
+		},
+	}
+	signer := types.LatestSigner(genesis.Config)
+	_, blocks, _ := core.GenerateChainWithGenesis(genesis, ethash.NewFaker(), 3, func(i int, gen *core.BlockGen) {
+		for _, to := range []gethcommon.Address{contract, {byte(i + 1)}} {
+			tx := types.MustSignNewTx(key, signer, &types.LegacyTx{Nonce: gen.TxNonce(sender), To: &to, Value: big.NewInt(1000), Gas: 100000, GasPrice: gen.BaseFee()})
+			gen.AddTx(tx)
+		}
+	})
+
+	kvdb, err := pebble.New(dir, 16, 16, "", false)
+	require.NoError(t, err)
+	db := rawdb.NewDatabase(kvdb)
+	chain, err := core.NewBlockChain(db, &core.CacheConfig{TrieDirtyDisabled: true, StateScheme: rawdb.HashScheme}, genesis, nil, ethash.NewFaker(), vm.Config{}, nil)
+	require.NoError(t, err)
+	_, err = chain.InsertChain(blocks)
+	require.NoError(t, err)
+	chain.Stop()
+	require.NoError(t, db.Close())
+
+	headers := &headerMap{headers: make(map[gethcommon.Hash]*types.Header)}
+	for _, block := range blocks {
+		headers.headers[block.Hash()] = block.Header()
+	}
+	return blocks, headers
+}
+
+func openBridgeDatabase(t *testing.T, dir string) *StateBridge {
+	kvdb, err := pebble.New(dir, 16, 16, "", true)
+	require.NoError(t, err)
+	db := rawdb.NewDatabase(kvdb)
+	t.Cleanup(func() { db.Close() })
+	return NewStateBridge(db, nil, filepath.Join(t.TempDir(), "checkpoint.json"))
+}
+
+// countContent returns the number of gossiped items by content type.
+func countContent(content map[string][]byte) map[byte]int {
+	counts := make(map[byte]int)
+	for contentKey := range content {
+		counts[contentKey[0]]++
+	}
+	return counts
+}
+
+func TestStateBridge(t *testing.T) {
+	dir := t.TempDir()
+	blocks, headers := newBridgeChain(t, dir)
+	bridge := openBridgeDatabase(t, dir)
+	validator := NewStateNetwork(nil, headers)
+	validate := func(content map[string][]byte) {
+		for contentKey, value := range content {
+			require.NoError(t, validator.validateContent([]byte(contentKey), value), "content key %x", contentKey)
+		}
+	}
+
+	head, err := bridge.HeadHeader()
+	require.NoError(t, err)
+	require.Equal(t, blocks[2].Hash(), head.Hash())
+
+	// the whole state of the head block
+	full := &contentCollector{content: make(map[string][]byte)}
+	bridge.gossiper = full
+	require.NoError(t, bridge.Bridge(context.Background(), nil, head))
+	validate(full.content)
+	counts := countContent(full.content)
+	require.Equal(t, 1, counts[ContractByteCodeType])
+	require.NotZero(t, counts[AccountTrieNodeType])
+	require.NotZero(t, counts[ContractStorageTrieNodeType])
+
+	// only the changes since the previous block
+	from, err := bridge.Header(1)
+	require.NoError(t, err)
+	diff := &contentCollector{content: make(map[string][]byte)}
+	bridge.gossiper = diff
+	require.NoError(t, bridge.Bridge(context.Background(), from, head))
+	validate(diff.content)
+	diffCounts := countContent(diff.content)
+	require.Zero(t, diffCounts[ContractByteCodeType])
+	require.NotZero(t, diffCounts[AccountTrieNodeType])
+	require.NotZero(t, diffCounts[ContractStorageTrieNodeType])
+	require.Less(t, len(diff.content), len(full.content))
+	for contentKey := range diff.content {
+		require.Contains(t, full.content, contentKey)
+	}
+}
+
+func TestStateBridgeResume(t *testing.T) {
+	dir := t.TempDir()
+	_, headers := newBridgeChain(t, dir)
+	bridge := openBridgeDatabase(t, dir)
+	head, err := bridge.HeadHeader()
+	require.NoError(t, err)
+
+	full := &contentCollector{content: make(map[string][]byte)}
+	bridge.gossiper = full
+	require.NoError(t, bridge.Bridge(context.Background(), nil, head))
+	_, err = os.Stat(bridge.checkpointFile)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// an interrupted run saves the last completed account
+	first := &contentCollector{content: make(map[string][]byte), limit: len(full.content) / 2}
+	bridge.gossiper = first
+	require.Error(t, bridge.Bridge(context.Background(), nil, head))
+	checkpoint, err := bridge.loadCheckpoint()
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	require.Equal(t, head.Hash(), checkpoint.Block)
+
+	// and the next run only gossips the content after it
+	second := &contentCollector{content: make(map[string][]byte)}
+	bridge.gossiper = second
+	require.NoError(t, bridge.Bridge(context.Background(), nil, head))
+	require.Less(t, len(second.content), len(full.content))
+	for contentKey := range full.content {
+		_, inFirst := first.content[contentKey]
+		_, inSecond := second.content[contentKey]
+		require.True(t, inFirst || inSecond, "content key %x", contentKey)
+	}
+	validator := NewStateNetwork(nil, headers)
+	for contentKey, value := range second.content {
+		require.NoError(t, validator.validateContent([]byte(contentKey), value))
+	}
+}
@@ -0,0 +1,257 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	gethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+)
+
+// MissingTrieNode is a trie node that could not be found, it ends the walk
+// of a path.
+type MissingTrieNode struct {
+	ContentKey hexutil.Bytes   `json:"contentKey"`
+	Path       hexutil.Bytes   `json:"path"`
+	Hash       gethcommon.Hash `json:"hash"`
+}
+
+// AccountResult is an account of the state network with the account trie
+// nodes on its path. Account is nil if the account does not exist or a node
+// is missing.
+type AccountResult struct {
+	Account      *types.StateAccount
+	Proof        TrieProof
+	MissingNodes []MissingTrieNode
+}
+
+// StorageResult is a storage slot of the state network with the account and
+// storage trie nodes on its path. Value is zero if the slot is empty.
+type StorageResult struct {
+	Value        gethcommon.Hash
+	AccountProof TrieProof
+	StorageProof TrieProof
+	MissingNodes []MissingTrieNode
+}
+
+// nodeRequest is a trie node being fetched, concurrent walks wait for the
+// first fetch of a node instead of fetching it again.
+type nodeRequest struct {
+	done chan struct{}
+	node EncodedTrieNode
+	err  error
+}
+
+// trieLookup walks account and storage tries by fetching their nodes by
+// content key.
+type trieLookup struct {
+	fetch func(contentKey []byte) (EncodedTrieNode, error)
+	log   log.Logger
+
+	lock  sync.Mutex
+	nodes map[string]*nodeRequest
+}
+
+func newTrieLookup(fetch func(contentKey []byte) (EncodedTrieNode, error), logger log.Logger) *trieLookup {
+	return &trieLookup{
+		fetch: fetch,
+		log:   logger,
+		nodes: make(map[string]*nodeRequest),
+	}
+}
+
+// GetAccount returns the account of address in the state of the block.
+func (h *StateNetwork) GetAccount(address gethcommon.Address, blockHash gethcommon.Hash) (*AccountResult, error) {
+	root, err := h.getStateRoot(common.Bytes32(blockHash))
+	if err != nil {
+		return nil, err
+	}
+	return newTrieLookup(h.getTrieNode, h.log).account(gethcommon.Hash(root), address)
+}
+
+// GetStorage returns the storage slot of address in the state of the block.
+func (h *StateNetwork) GetStorage(address gethcommon.Address, slot gethcommon.Hash, blockHash gethcommon.Hash) (*StorageResult, error) {
+	root, err := h.getStateRoot(common.Bytes32(blockHash))
+	if err != nil {
+		return nil, err
+	}
+	results, err := newTrieLookup(h.getTrieNode, h.log).storage(gethcommon.Hash(root), address, []gethcommon.Hash{slot})
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// getTrieNode returns the trie node of the content key from the local storage
+// or the network.
+func (h *StateNetwork) getTrieNode(contentKey []byte) (EncodedTrieNode, error) {
+	contentId := h.portalProtocol.ToContentId(contentKey)
+	content, err := h.portalProtocol.Get(contentKey, contentId)
+	if errors.Is(err, storage.ErrContentNotFound) {
+		content, _, err = h.portalProtocol.ContentLookup(contentKey, contentId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	trieNode := &TrieNode{}
+	if err = trieNode.Deserialize(codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content)))); err != nil {
+		return nil, err
+	}
+	return trieNode.Node, nil
+}
+
+// account walks the account trie to the account of address.
+func (l *trieLookup) account(root gethcommon.Hash, address gethcommon.Address) (*AccountResult, error) {
+	value, proof, missing, err := l.walk(root, keybytesToHex(crypto.Keccak256(address[:])), func(path []byte, hash common.Bytes32) ([]byte, error) {
+		nibbles, err := FromUnpackedNibbles(path)
+		if err != nil {
+			return nil, err
+		}
+		return encodeContentKey(AccountTrieNodeType, &AccountTrieNodeKey{Path: *nibbles, NodeHash: hash})
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &AccountResult{Proof: proof, MissingNodes: missing}
+	if value != nil {
+		if result.Account, err = types.FullAccount(value); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// storage walks the account trie to address and then the storage trie to
+// every slot, the slots are walked concurrently. The slots of an account that
+// does not exist are empty.
+func (l *trieLookup) storage(root gethcommon.Hash, address gethcommon.Address, slots []gethcommon.Hash) ([]*StorageResult, error) {
+	account, err := l.account(root, address)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*StorageResult, len(slots))
+	for i := range results {
+		results[i] = &StorageResult{AccountProof: account.Proof, MissingNodes: account.MissingNodes}
+	}
+	if account.Account == nil || account.Account.Root == types.EmptyRootHash {
+		return results, nil
+	}
+
+	addressHash := common.Bytes32(crypto.Keccak256Hash(address[:]))
+	contentKey := func(path []byte, hash common.Bytes32) ([]byte, error) {
+		nibbles, err := FromUnpackedNibbles(path)
+		if err != nil {
+			return nil, err
+		}
+		return encodeContentKey(ContractStorageTrieNodeType, &ContractStorageTrieNodeKey{AddressHash: addressHash, Path: *nibbles, NodeHash: hash})
+	}
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(slots))
+	)
+	for i, slot := range slots {
+		wg.Add(1)
+		go func(i int, slot gethcommon.Hash) {
+			defer wg.Done()
+			value, proof, missing, err := l.walk(account.Account.Root, keybytesToHex(crypto.Keccak256(slot[:])), contentKey)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i].StorageProof = proof
+			results[i].MissingNodes = append(results[i].MissingNodes, missing...)
+			if value != nil {
+				_, content, _, err := rlp.Split(value)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				results[i].Value = gethcommon.BytesToHash(content)
+			}
+		}(i, slot)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// walk follows path from the root node and returns the value at path, nil if
+// the trie holds none, and the nodes on the way. A node that can not be
+// fetched ends the walk and is returned as missing.
+func (l *trieLookup) walk(root gethcommon.Hash, path []byte, contentKey func(path []byte, hash common.Bytes32) ([]byte, error)) ([]byte, TrieProof, []MissingTrieNode, error) {
+	var (
+		proof = TrieProof{}
+		hash  = root[:]
+		rest  = path
+	)
+	for {
+		prefix := path[:len(path)-len(rest)]
+		key, err := contentKey(prefix, common.Bytes32(hash))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		encoded, err := l.node(key, hash)
+		if err != nil {
+			l.log.Debug("trie node is missing", "contentKey", hexutil.Encode(key), "err", err)
+			return nil, proof, []MissingTrieNode{{ContentKey: key, Path: prefix, Hash: gethcommon.BytesToHash(hash)}}, nil
+		}
+		proof = append(proof, encoded)
+		n, err := trie.DecodeTrieNode(hash, encoded)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		var value []byte
+		value, hash, rest, err = trie.LookupTrieNode(n, rest)
+		if errors.Is(err, trie.ErrPathNotFound) {
+			return nil, proof, nil, nil
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if value != nil {
+			return value, proof, nil, nil
+		}
+	}
+}
+
+// node fetches the trie node of the content key once and checks its hash.
+func (l *trieLookup) node(contentKey []byte, hash []byte) (EncodedTrieNode, error) {
+	l.lock.Lock()
+	req, ok := l.nodes[string(contentKey)]
+	if !ok {
+		req = &nodeRequest{done: make(chan struct{})}
+		l.nodes[string(contentKey)] = req
+	}
+	l.lock.Unlock()
+
+	if ok {
+		<-req.done
+		return req.node, req.err
+	}
+	defer close(req.done)
+	req.node, req.err = l.fetch(contentKey)
+	if req.err == nil {
+		req.err = checkNodeHash(&req.node, hash)
+	}
+	return req.node, req.err
+}
+
+// encodeContentKey returns the content key of the serialized key.
+func encodeContentKey(contentType byte, key serializable) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := key.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		return nil, err
+	}
+	return storage.NewContentKey(storage.ContentType(contentType), buf.Bytes()).Encode(), nil
+}
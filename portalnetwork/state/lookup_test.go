@@ -0,0 +1,114 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	gethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
+	"github.com/stretchr/testify/require"
+)
+
+// trieNodeMap serves the trie nodes of bridged content by content key.
+type trieNodeMap struct {
+	lock    sync.Mutex
+	nodes   map[string]EncodedTrieNode
+	fetches map[string]int
+}
+
+func newTrieNodeMap(t *testing.T, content map[string][]byte) *trieNodeMap {
+	m := &trieNodeMap{nodes: make(map[string]EncodedTrieNode), fetches: make(map[string]int)}
+	for contentKey, value := range content {
+		reader := codec.NewDecodingReader(bytes.NewReader(value), uint64(len(value)))
+		switch contentKey[0] {
+		case AccountTrieNodeType:
+			offer := &AccountTrieNodeWithProof{}
+			require.NoError(t, offer.Deserialize(reader))
+			m.nodes[contentKey] = offer.Proof[len(offer.Proof)-1]
+		case ContractStorageTrieNodeType:
+			offer := &ContractStorageTrieNodeWithProof{}
+			require.NoError(t, offer.Deserialize(reader))
+			m.nodes[contentKey] = offer.StoregeProof[len(offer.StoregeProof)-1]
+		}
+	}
+	return m
+}
+
+func (m *trieNodeMap) fetch(contentKey []byte) (EncodedTrieNode, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.fetches[string(contentKey)]++
+	if node, ok := m.nodes[string(contentKey)]; ok {
+		return node, nil
+	}
+	return nil, storage.ErrContentNotFound
+}
+
+func TestTrieLookup(t *testing.T) {
+	dir := t.TempDir()
+	blocks, _ := newBridgeChain(t, dir)
+	bridge := openBridgeDatabase(t, dir)
+	head := blocks[2].Header()
+	content := &contentCollector{content: make(map[string][]byte)}
+	bridge.gossiper = content
+	require.NoError(t, bridge.Bridge(context.Background(), nil, head))
+	nodes := newTrieNodeMap(t, content.content)
+
+	contract := gethcommon.HexToAddress("0xc0de")
+	res, err := newTrieLookup(nodes.fetch, log.Root()).account(head.Root, contract)
+	require.NoError(t, err)
+	require.Empty(t, res.MissingNodes)
+	require.NotNil(t, res.Account)
+	require.Equal(t, uint64(3001), res.Account.Balance.Uint64())
+	account, err := validateAccountState(common.Bytes32(head.Root), common.Bytes32(crypto.Keccak256Hash(contract[:])), &res.Proof)
+	require.NoError(t, err)
+	require.Equal(t, res.Account, account)
+
+	// an account that does not exist is proven absent
+	res, err = newTrieLookup(nodes.fetch, log.Root()).account(head.Root, gethcommon.HexToAddress("0xdead"))
+	require.NoError(t, err)
+	require.Nil(t, res.Account)
+	require.Empty(t, res.MissingNodes)
+	require.NotEmpty(t, res.Proof)
+
+	// the slots are walked concurrently and share the fetched nodes
+	nodes.fetches = make(map[string]int)
+	slots := []gethcommon.Hash{{}, gethcommon.BigToHash(gethcommon.Big1), gethcommon.BigToHash(gethcommon.Big3), gethcommon.HexToHash("0x99")}
+	results, err := newTrieLookup(nodes.fetch, log.Root()).storage(head.Root, contract, slots)
+	require.NoError(t, err)
+	for i, want := range []gethcommon.Hash{{1}, gethcommon.BigToHash(gethcommon.Big1), gethcommon.BigToHash(gethcommon.Big3), {}} {
+		require.Equal(t, want, results[i].Value, "slot %d", i)
+		require.Empty(t, results[i].MissingNodes)
+		require.NotEmpty(t, results[i].AccountProof)
+		require.NotEmpty(t, results[i].StorageProof)
+	}
+	for contentKey, count := range nodes.fetches {
+		require.Equal(t, 1, count, "content key %x", contentKey)
+	}
+
+	// a missing node ends the walk and is reported
+	res, err = newTrieLookup(nodes.fetch, log.Root()).account(head.Root, contract)
+	require.NoError(t, err)
+	var leafKey string
+	for contentKey, node := range nodes.nodes {
+		if bytes.Equal(node, res.Proof[len(res.Proof)-1]) {
+			leafKey = contentKey
+		}
+	}
+	delete(nodes.nodes, leafKey)
+	res, err = newTrieLookup(nodes.fetch, log.Root()).account(head.Root, contract)
+	require.NoError(t, err)
+	require.Nil(t, res.Account)
+	require.Len(t, res.MissingNodes, 1)
+	require.Equal(t, leafKey, string(res.MissingNodes[0].ContentKey))
+	results, err = newTrieLookup(nodes.fetch, log.Root()).storage(head.Root, contract, slots[:1])
+	require.NoError(t, err)
+	require.Equal(t, gethcommon.Hash{}, results[0].Value)
+	require.Len(t, results[0].MissingNodes, 1)
+}
@@ -5,31 +5,62 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	gethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/lru"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/discover"
-	"github.com/ethereum/go-ethereum/portalnetwork/history"
-	"github.com/ethereum/go-ethereum/rlp"
-	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
 	"github.com/ethereum/go-ethereum/trie"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/protolambda/zrnt/eth2/configs"
 	"github.com/protolambda/ztyp/codec"
 )
 
+const (
+	stateRootCacheSize = 256
+	// Content whose block header can not be resolved is kept for a few
+	// retries, the header may not have reached the history network yet.
+	maxPendingContent    = 256
+	maxPendingRetries    = 5
+	pendingRetryInterval = 30 * time.Second
+)
+
+var ErrHeaderUnavailable = errors.New("block header is unavailable")
+
+// HeaderProvider resolves the block headers that state content is anchored
+// to, the history network is one. The headers are looked up regardless of
+// the radius of the provider.
+type HeaderProvider interface {
+	LookupBlockHeader(blockHash []byte) (*types.Header, error)
+}
+
+// pendingContent is content that waits for its block header.
+type pendingContent struct {
+	element *discover.ContentElement
+	retries int
+}
+
 type StateNetwork struct {
 	portalProtocol *discover.PortalProtocol
+	scrubber       *storage.Scrubber
 	closeCtx       context.Context
 	closeFunc      context.CancelFunc
 	log            log.Logger
 	spec           *common.Spec
-	client         *rpc.Client
+	headers        HeaderProvider
+	stateRoots     *lru.Cache[common.Bytes32, common.Bytes32]
+
+	pendingLock sync.Mutex
+	pending     []*pendingContent
 }
 
-func NewStateNetwork(portalProtocol *discover.PortalProtocol, client *rpc.Client) *StateNetwork {
+func NewStateNetwork(portalProtocol *discover.PortalProtocol, headers HeaderProvider) *StateNetwork {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &StateNetwork{
 		portalProtocol: portalProtocol,
@@ -37,7 +68,8 @@ func NewStateNetwork(portalProtocol *discover.PortalProtocol, client *rpc.Client
 		closeFunc:      cancel,
 		log:            log.New("sub-protocol", "state"),
 		spec:           configs.Mainnet,
-		client:         client,
+		headers:        headers,
+		stateRoots:     lru.NewCache[common.Bytes32, common.Bytes32](stateRootCacheSize),
 	}
 }
 
@@ -53,50 +85,114 @@ func (h *StateNetwork) Start() error {
 
 func (h *StateNetwork) Stop() {
 	h.closeFunc()
+	if h.scrubber != nil {
+		h.scrubber.Stop()
+	}
 	h.portalProtocol.Stop()
 }
 
+// StartScrubber scrubs the storage in the background until the network stops.
+func (h *StateNetwork) StartScrubber(scrubber *storage.Scrubber) {
+	h.scrubber = scrubber
+	scrubber.Start()
+}
+
+// Drain stops accepting offered content and waits until the transfers in
+// flight are done and the received content is validated, or until ctx is done.
+func (h *StateNetwork) Drain(ctx context.Context) error {
+	return h.portalProtocol.Drain(ctx)
+}
+
 func (h *StateNetwork) processContentLoop(ctx context.Context) {
 	contentChan := h.portalProtocol.GetContent()
+	retry := time.NewTicker(pendingRetryInterval)
+	defer retry.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case contentElement := <-contentChan:
-			err := h.validateContents(contentElement.ContentKeys, contentElement.Contents)
-			if err != nil {
+			err := h.handleContent(ctx, contentElement)
+			// content waiting for its header isn't waited for by a draining
+			// protocol
+			contentElement.Done()
+			if errors.Is(err, ErrHeaderUnavailable) {
+				h.log.Debug("block header is unavailable, retrying content later", "err", err)
+				h.addPending(&pendingContent{element: contentElement})
+			} else if err != nil {
 				h.log.Error("validate content failed", "err", err)
-				continue
 			}
+		case <-retry.C:
+			h.retryPending(ctx)
+		}
+	}
+}
+
+// handleContent validates and stores offered content and gossips it on.
+func (h *StateNetwork) handleContent(ctx context.Context, contentElement *discover.ContentElement) error {
+	err := h.validateContents(contentElement.Node, contentElement.ContentKeys, contentElement.Contents)
+	if err != nil {
+		return err
+	}
+
+	go func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			gossippedNum, err := h.portalProtocol.Gossip(&contentElement.Node, contentElement.ContentKeys, contentElement.Contents)
+			h.log.Trace("gossippedNum", "gossippedNum", gossippedNum)
+			if err != nil {
+				h.log.Error("gossip failed", "err", err)
+				return
+			}
+		}
+	}(ctx)
+	return nil
+}
+
+// addPending queues content for a retry, the oldest content is dropped once
+// the queue is full.
+func (h *StateNetwork) addPending(content *pendingContent) {
+	h.pendingLock.Lock()
+	defer h.pendingLock.Unlock()
+	if len(h.pending) >= maxPendingContent {
+		h.pending = h.pending[1:]
+	}
+	h.pending = append(h.pending, content)
+}
 
-			go func(ctx context.Context) {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					var gossippedNum int
-					gossippedNum, err = h.portalProtocol.Gossip(&contentElement.Node, contentElement.ContentKeys, contentElement.Contents)
-					h.log.Trace("gossippedNum", "gossippedNum", gossippedNum)
-					if err != nil {
-						h.log.Error("gossip failed", "err", err)
-						return
-					}
-				}
-			}(ctx)
+// retryPending validates the queued content again. Content whose header is
+// still unavailable is queued again until it runs out of retries.
+func (h *StateNetwork) retryPending(ctx context.Context) {
+	h.pendingLock.Lock()
+	pending := h.pending
+	h.pending = nil
+	h.pendingLock.Unlock()
+
+	for _, content := range pending {
+		err := h.handleContent(ctx, content.element)
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrHeaderUnavailable) && content.retries+1 < maxPendingRetries:
+			content.retries++
+			h.addPending(content)
+		default:
+			h.log.Debug("dropping pending content", "retries", content.retries, "err", err)
 		}
 	}
 }
 
-func (h *StateNetwork) validateContents(contentKeys [][]byte, contents [][]byte) error {
+func (h *StateNetwork) validateContents(node enode.ID, contentKeys [][]byte, contents [][]byte) error {
 	for i, content := range contents {
 		contentKey := contentKeys[i]
 		err := h.validateContent(contentKey, content)
 		if err != nil {
-			h.log.Error("content validate failed", "contentKey", hexutil.Encode(contentKey), "content", hexutil.Encode(content), "err", err)
-			return fmt.Errorf("content validate failed with content key %x and content %x", contentKey, content)
+			h.log.Debug("content validate failed", "contentKey", hexutil.Encode(contentKey), "content", hexutil.Encode(content), "err", err)
+			return fmt.Errorf("content validate failed with content key %x: %w", contentKey, err)
 		}
 		contentId := h.portalProtocol.ToContentId(contentKey)
-		err = h.portalProtocol.Put(contentKey, contentId, content)
+		err = h.portalProtocol.PutFrom(contentKey, contentId, content, storage.ValidatedProvenance(storage.SourceOffer, node))
 		if err != nil {
 			return err
 		}
@@ -188,33 +284,25 @@ func (h *StateNetwork) validateContractByteCode(contentKey []byte, content []byt
 	return nil
 }
 
+// getStateRoot returns the state root of the block, headers are resolved
+// through the header provider and their state roots cached.
 func (h *StateNetwork) getStateRoot(blockHash common.Bytes32) (common.Bytes32, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
-	defer cancel()
-	contentKey := make([]byte, 0)
-	contentKey = append(contentKey, byte(history.BlockHeaderType))
-	contentKey = append(contentKey, blockHash[:]...)
-
-	arg := hexutil.Encode(contentKey)
-	res := &discover.ContentInfo{}
-	err := h.client.CallContext(ctx, res, "portal_historyGetContent", arg)
-	if err != nil {
-		return common.Bytes32{}, err
+	if root, ok := h.stateRoots.Get(blockHash); ok {
+		return root, nil
 	}
-	data, err := hexutil.Decode(res.Content)
-	if err != nil {
-		return common.Bytes32{}, err
+	if h.headers == nil {
+		return common.Bytes32{}, fmt.Errorf("%w: no header provider", ErrHeaderUnavailable)
 	}
-	headerWithProof, err := history.DecodeBlockHeaderWithProof(data)
+	header, err := h.headers.LookupBlockHeader(blockHash[:])
 	if err != nil {
-		return common.Bytes32{}, err
+		return common.Bytes32{}, fmt.Errorf("%w: block %#x: %v", ErrHeaderUnavailable, blockHash[:], err)
 	}
-	header := new(types.Header)
-	err = rlp.DecodeBytes(headerWithProof.Header, header)
-	if err != nil {
-		return common.Bytes32{}, err
+	if header.Hash() != gethcommon.Hash(blockHash) {
+		return common.Bytes32{}, fmt.Errorf("header hash %s does not match block %#x", header.Hash(), blockHash[:])
 	}
-	return common.Bytes32(header.Root), nil
+	root := common.Bytes32(header.Root)
+	h.stateRoots.Add(blockHash, root)
+	return root, nil
 }
 
 func validateNodeTrieProof(rootHash common.Bytes32, nodeHash common.Bytes32, path *Nibbles, proof *TrieProof) error {
@@ -5,10 +5,13 @@ import (
 	"os"
 	"testing"
 
+	gethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/p2p/discover"
-	"github.com/ethereum/go-ethereum/portalnetwork/history"
-	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
 )
@@ -33,26 +36,28 @@ func getTestCases(filename string) ([]TestCase, error) {
 	return res, nil
 }
 
-type MockAPI struct {
-	header string
+// headerMap serves block headers by hash and counts the lookups.
+type headerMap struct {
+	headers map[gethcommon.Hash]*types.Header
+	lookups int
 }
 
-func (p *MockAPI) HistoryGetContent(contentKeyHex string) (*discover.ContentInfo, error) {
-	headerWithProof := &history.BlockHeaderWithProof{
-		Header: hexutil.MustDecode(p.header),
-		Proof: &history.BlockHeaderProof{
-			Selector: 0,
-			Proof:    [][]byte{},
-		},
+func newHeaderMap(t *testing.T, encodedHeaders ...string) *headerMap {
+	m := &headerMap{headers: make(map[gethcommon.Hash]*types.Header)}
+	for _, encoded := range encodedHeaders {
+		header := new(types.Header)
+		require.NoError(t, rlp.DecodeBytes(hexutil.MustDecode(encoded), header))
+		m.headers[header.Hash()] = header
 	}
-	data, err := headerWithProof.MarshalSSZ()
-	if err != nil {
-		return nil, err
+	return m
+}
+
+func (m *headerMap) LookupBlockHeader(blockHash []byte) (*types.Header, error) {
+	m.lookups++
+	if header, ok := m.headers[gethcommon.BytesToHash(blockHash)]; ok {
+		return header, nil
 	}
-	return &discover.ContentInfo{
-		Content:     hexutil.Encode(data),
-		UtpTransfer: false,
-	}, nil
+	return nil, storage.ErrContentNotFound
 }
 
 func TestValidateAccountTrieNode(t *testing.T) {
@@ -60,13 +65,7 @@ func TestValidateAccountTrieNode(t *testing.T) {
 	require.NoError(t, err)
 
 	for _, tt := range cases {
-		server := rpc.NewServer()
-		api := &MockAPI{
-			header: tt.BlockHeader,
-		}
-		server.RegisterName("portal", api)
-		client := rpc.DialInProc(server)
-		bn := NewStateNetwork(nil, client)
+		bn := NewStateNetwork(nil, newHeaderMap(t, tt.BlockHeader))
 		err = bn.validateContent(hexutil.MustDecode(tt.ContentKey), hexutil.MustDecode(tt.ContentValueOffer))
 		require.NoError(t, err)
 	}
@@ -77,13 +76,7 @@ func TestValidateContractStorage(t *testing.T) {
 	require.NoError(t, err)
 
 	for _, tt := range cases {
-		server := rpc.NewServer()
-		api := &MockAPI{
-			header: tt.BlockHeader,
-		}
-		server.RegisterName("portal", api)
-		client := rpc.DialInProc(server)
-		bn := NewStateNetwork(nil, client)
+		bn := NewStateNetwork(nil, newHeaderMap(t, tt.BlockHeader))
 		err = bn.validateContent(hexutil.MustDecode(tt.ContentKey), hexutil.MustDecode(tt.ContentValueOffer))
 		require.NoError(t, err)
 	}
@@ -94,14 +87,52 @@ func TestValidateContractByte(t *testing.T) {
 	require.NoError(t, err)
 
 	for _, tt := range cases {
-		server := rpc.NewServer()
-		api := &MockAPI{
-			header: tt.BlockHeader,
-		}
-		server.RegisterName("portal", api)
-		client := rpc.DialInProc(server)
-		bn := NewStateNetwork(nil, client)
+		bn := NewStateNetwork(nil, newHeaderMap(t, tt.BlockHeader))
 		err = bn.validateContent(hexutil.MustDecode(tt.ContentKey), hexutil.MustDecode(tt.ContentValueOffer))
 		require.NoError(t, err)
 	}
 }
+
+func TestStateRootCache(t *testing.T) {
+	cases, err := getTestCases("account_trie_node.yaml")
+	require.NoError(t, err)
+	headers := newHeaderMap(t)
+	bn := NewStateNetwork(nil, headers)
+
+	// content is refused with a temporary error while its header is unknown
+	contentKey, content := hexutil.MustDecode(cases[0].ContentKey), hexutil.MustDecode(cases[0].ContentValueOffer)
+	err = bn.validateContent(contentKey, content)
+	require.ErrorIs(t, err, ErrHeaderUnavailable)
+
+	// every state root is looked up once and then taken from the cache
+	encodedHeaders := make([]string, 0, len(cases))
+	for _, tt := range cases {
+		encodedHeaders = append(encodedHeaders, tt.BlockHeader)
+	}
+	headers = newHeaderMap(t, encodedHeaders...)
+	bn.headers = headers
+	for i := 0; i < 2; i++ {
+		for _, tt := range cases {
+			err = bn.validateContent(hexutil.MustDecode(tt.ContentKey), hexutil.MustDecode(tt.ContentValueOffer))
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, len(headers.headers), headers.lookups)
+
+	// a proof against another state root is invalid, not pending
+	for blockHash, header := range headers.headers {
+		bn.stateRoots.Add(common.Bytes32(blockHash), common.Bytes32(header.ParentHash))
+	}
+	err = bn.validateContent(contentKey, content)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrHeaderUnavailable)
+}
+
+func TestPendingContent(t *testing.T) {
+	bn := NewStateNetwork(nil, newHeaderMap(t))
+	for i := 0; i < maxPendingContent+1; i++ {
+		bn.addPending(&pendingContent{element: &discover.ContentElement{ContentKeys: [][]byte{{AccountTrieNodeType, byte(i)}}}})
+	}
+	require.Len(t, bn.pending, maxPendingContent)
+	require.Equal(t, byte(1), bn.pending[0].element.ContentKeys[0][1])
+}
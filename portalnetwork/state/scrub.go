@@ -0,0 +1,72 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/protolambda/ztyp/codec"
+)
+
+// NewScrubber creates a scrubber of the state storage, which checks the
+// stored trie nodes and bytecode against the hash in their content key. The
+// proofs of offered content aren't stored, so they can't be checked again.
+func NewScrubber(contentStorage storage.ContentStorage, config storage.ScrubConfig) (*storage.Scrubber, error) {
+	config.NetworkName = "state"
+	config.ContentTypes = []byte{AccountTrieNodeType, ContractStorageTrieNodeType, ContractByteCodeType}
+	config.Validate = func(_ storage.ContentStorage, contentKey []byte, content []byte) error {
+		return validateStoredContent(contentKey, content)
+	}
+	return storage.NewScrubber(contentStorage, config)
+}
+
+// validateStoredContent checks a stored retrieval value, a trie node or the
+// bytecode of a contract, against the hash in its content key.
+func validateStoredContent(contentKey []byte, content []byte) error {
+	keyReader := codec.NewDecodingReader(bytes.NewReader(contentKey[1:]), uint64(len(contentKey)-1))
+	valueReader := codec.NewDecodingReader(bytes.NewReader(content), uint64(len(content)))
+	switch contentKey[0] {
+	case AccountTrieNodeType:
+		key := &AccountTrieNodeKey{}
+		if err := key.Deserialize(keyReader); err != nil {
+			return err
+		}
+		trieNode := &TrieNode{}
+		if err := trieNode.Deserialize(valueReader); err != nil {
+			return err
+		}
+		if trieNode.Node.NodeHash() != key.NodeHash {
+			return errors.New("hash of the trie node doesn't match key's node_hash")
+		}
+		return nil
+	case ContractStorageTrieNodeType:
+		key := &ContractStorageTrieNodeKey{}
+		if err := key.Deserialize(keyReader); err != nil {
+			return err
+		}
+		trieNode := &TrieNode{}
+		if err := trieNode.Deserialize(valueReader); err != nil {
+			return err
+		}
+		if trieNode.Node.NodeHash() != key.NodeHash {
+			return errors.New("hash of the contract storage node doesn't match key's node hash")
+		}
+		return nil
+	case ContractByteCodeType:
+		key := &ContractBytecodeKey{}
+		if err := key.Deserialize(keyReader); err != nil {
+			return err
+		}
+		container := &ContractBytecodeContainer{}
+		if err := container.Deserialize(valueReader); err != nil {
+			return err
+		}
+		if !bytes.Equal(crypto.Keccak256(container.Code), key.CodeHash[:]) {
+			return errors.New("hash of the contract byte doesn't match key's code hash")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown content type %v", contentKey[0])
+}
@@ -5,49 +5,185 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover/portalwire"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/portalnetwork/storage"
 	"github.com/holiman/uint256"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/protolambda/ztyp/codec"
 )
 
+const (
+	contentDeletionFraction = 0.05 // 5% of the content is deleted when the storage capacity is hit and the radius shrinks.
+	// SQLite Statements
+	createSql = `CREATE TABLE IF NOT EXISTS state (
+		content_id BLOB PRIMARY KEY,
+		content_key BLOB NOT NULL,
+		content_value BLOB NOT NULL,
+		content_type INTEGER NOT NULL,
+		distance BLOB NOT NULL,
+		size INTEGER NOT NULL
+	);`
+	createDistanceIndexSql = "CREATE INDEX IF NOT EXISTS state_distance ON state (distance);"
+	createRadiusSql        = "CREATE TABLE IF NOT EXISTS radius (id INTEGER PRIMARY KEY CHECK (id = 0), radius BLOB NOT NULL);"
+	getRadiusSql           = "SELECT radius FROM radius WHERE id = 0;"
+	putRadiusSql           = "INSERT OR REPLACE INTO radius (id, radius) VALUES (0, ?1);"
+	getSql                 = "SELECT content_value FROM state WHERE content_id = (?1);"
+	putSql                 = "INSERT INTO state (content_id, content_key, content_value, content_type, distance, size) VALUES (?1, ?2, ?3, ?4, ?5, ?6) ON CONFLICT (content_id) DO NOTHING;"
+	usageSql               = "SELECT content_type, COUNT(1), SUM(size) FROM state GROUP BY content_type;"
+	orderedByDistanceSql   = "SELECT distance, size FROM state ORDER BY distance DESC;"
+	deleteOutOfRadiusSql   = "DELETE FROM state WHERE distance > (?1);"
+	deleteSql              = "DELETE FROM state WHERE content_id = (?1) RETURNING content_type, size;"
+	farthestDistanceSql    = "SELECT MAX(distance) FROM state;"
+	countBaselineSql       = "SELECT COUNT(1) FROM kvstore;"
+	dropBaselineSql        = "DROP TABLE kvstore;"
+	localContentKeysSql    = `SELECT content_key FROM state WHERE (?1) IS NULL OR content_type = (?1)
+		ORDER BY content_id LIMIT (?2) OFFSET (?3);`
+)
+
 func defaultContentIdFunc(contentKey []byte) []byte {
 	digest := sha256.Sum256(contentKey)
 	return digest[:]
 }
 
+// NewDB opens the database of the state network in the data directory.
+func NewDB(dataDir string) (*sql.DB, error) {
+	dbPath := path.Join(dataDir, portalwire.State.Name())
+	if err := os.MkdirAll(dbPath, 0755); err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite3", path.Join(dbPath, portalwire.State.Name()+".sqlite"))
+}
+
 var _ storage.ContentStorage = &StateStorage{}
+var _ storage.ContentDeleter = &StateStorage{}
+var _ storage.ContentIndex = &StateStorage{}
+
+// ContentUsage is the number and the size of the stored items of a content
+// type. The size counts the content id, the content key and the stored value.
+type ContentUsage struct {
+	Count uint64
+	Size  uint64
+}
 
+// StateStorage stores the trie nodes and the contract bytecode of the state
+// network. Items are stored by their content id together with the distance
+// of the id to the local node, once the capacity is reached the radius
+// shrinks and the farthest items are pruned. The radius is stored as well, it
+// only grows again if the storage is pruned to a larger radius.
 type StateStorage struct {
-	store storage.ContentStorage
-	db    *sql.DB
-	log   log.Logger
+	nodeId                 enode.ID
+	storageCapacityInBytes uint64
+	radius                 atomic.Pointer[uint256.Int]
+	db                     *sql.DB
+	getStmt                *sql.Stmt
+	putStmt                *sql.Stmt
+	log                    log.Logger
+
+	lock    sync.Mutex
+	usage   map[byte]*ContentUsage
+	metrics *stateStorageMetrics
 }
 
-var portalStorageMetrics *metrics.PortalStorageMetrics
+// stateStorageMetrics are the metrics of the state storage, registered apart
+// from the storage metrics of the other networks.
+type stateStorageMetrics struct {
+	*metrics.PortalStorageMetrics
+	typeUsage map[byte]metrics.Gauge
+}
+
+// migrations are the schema versions of the storage, see storage.Migrate.
+var migrations = []storage.Migration{
+	storage.ExecMigration("create the content and radius tables", createSql, createDistanceIndexSql, createRadiusSql),
+	{Description: "drop the content table of the shared storage", Apply: dropBaselineContent},
+}
 
-func NewStateStorage(store storage.ContentStorage, db *sql.DB) *StateStorage {
-	storage := &StateStorage{
-		store: store,
-		db:    db,
-		log:   log.New("storage", "state"),
+// dropBaselineContent drops the kvstore table the state network shared with
+// the history storage before it had a storage of its own. The table is keyed
+// by content id and lacks the content keys, so neither the content type nor
+// the key of an item can be recovered and the items are fetched again from
+// the network instead.
+func dropBaselineContent(tx *sql.Tx) error {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = 'kvstore';").Scan(&count)
+	if err != nil || count == 0 {
+		return err
+	}
+	if err = tx.QueryRow(countBaselineSql).Scan(&count); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(dropBaselineSql); err != nil {
+		return err
 	}
+	log.Warn("Dropped state content stored without content keys", "items", count)
+	return nil
+}
 
+func NewStateStorage(config storage.PortalStorageConfig) (*StateStorage, error) {
+	s := &StateStorage{
+		nodeId:                 config.NodeId,
+		storageCapacityInBytes: config.StorageCapacityMB * 1000000,
+		db:                     config.DB,
+		log:                    log.New("storage", "state"),
+		usage:                  make(map[byte]*ContentUsage),
+	}
+	if err := storage.Migrate(s.db, "state", migrations); err != nil {
+		return nil, err
+	}
 	var err error
-	portalStorageMetrics, err = metrics.NewPortalStorageMetrics("state", db)
-	if err != nil {
-		return nil
+	if s.getStmt, err = s.db.Prepare(getSql); err != nil {
+		return nil, err
+	}
+	if s.putStmt, err = s.db.Prepare(putSql); err != nil {
+		return nil, err
+	}
+	if err = s.loadUsage(); err != nil {
+		return nil, err
+	}
+	if metrics.Enabled {
+		portalMetrics, err := metrics.NewPortalStorageMetrics(portalwire.State.Name(), s.db)
+		if err != nil {
+			return nil, err
+		}
+		s.metrics = &stateStorageMetrics{
+			PortalStorageMetrics: portalMetrics,
+			typeUsage: map[byte]metrics.Gauge{
+				AccountTrieNodeType:         metrics.NewRegisteredGauge("portal/state/account_trie_node_storage", nil),
+				ContractStorageTrieNodeType: metrics.NewRegisteredGauge("portal/state/contract_storage_trie_node_storage", nil),
+				ContractByteCodeType:        metrics.NewRegisteredGauge("portal/state/contract_bytecode_storage", nil),
+			},
+		}
 	}
 
-	return storage
+	if err = s.loadRadius(); err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err = s.pruneIfFull(); err != nil {
+		return nil, err
+	}
+	s.updateMetrics()
+	return s, nil
 }
 
 // Get implements storage.ContentStorage.
 func (s *StateStorage) Get(contentKey []byte, contentId []byte) ([]byte, error) {
-	return s.store.Get(contentKey, contentId)
+	var res []byte
+	err := s.getStmt.QueryRow(contentId).Scan(&res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, storage.ErrContentNotFound
+	}
+	return res, err
 }
 
 // Put implements storage.ContentStorage.
@@ -55,23 +191,298 @@ func (s *StateStorage) Put(contentKey []byte, contentId []byte, content []byte)
 	keyType := contentKey[0]
 	switch keyType {
 	case AccountTrieNodeType:
-		return s.putAccountTrieNode(contentKey[1:], contentId, content)
+		return s.putAccountTrieNode(contentKey, contentId, content)
 	case ContractStorageTrieNodeType:
-		return s.putContractStorageTrieNode(contentKey[1:], contentId, content)
+		return s.putContractStorageTrieNode(contentKey, contentId, content)
 	case ContractByteCodeType:
-		return s.putContractBytecode(contentKey[1:], contentId, content)
+		return s.putContractBytecode(contentKey, contentId, content)
 	}
 	return errors.New("unknown content type")
 }
 
+// Delete implements storage.ContentDeleter.
+func (s *StateStorage) Delete(contentKey []byte, contentId []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var (
+		contentType byte
+		size        uint64
+	)
+	err := s.db.QueryRow(deleteSql, contentId).Scan(&contentType, &size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if usage := s.usage[contentType]; usage != nil {
+		usage.Count--
+		usage.Size -= size
+	}
+	s.updateMetrics()
+	return nil
+}
+
 // Radius implements storage.ContentStorage.
 func (s *StateStorage) Radius() *uint256.Int {
-	return s.store.Radius()
+	return s.radius.Load()
+}
+
+// Usage returns the number and the size of the stored items by content type.
+func (s *StateStorage) Usage() map[byte]ContentUsage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	usage := make(map[byte]ContentUsage, len(s.usage))
+	for contentType, u := range s.usage {
+		usage[contentType] = *u
+	}
+	return usage
+}
+
+// LocalContentKeys returns a page of the stored content keys ordered by
+// content id, only keys of the content type if it is set.
+func (s *StateStorage) LocalContentKeys(offset, limit uint64, contentType *byte) ([][]byte, error) {
+	var selector any
+	if contentType != nil {
+		selector = *contentType
+	}
+	rows, err := s.db.Query(localContentKeysSql, selector, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([][]byte, 0, limit)
+	for rows.Next() {
+		var contentKey []byte
+		if err = rows.Scan(&contentKey); err != nil {
+			return nil, err
+		}
+		keys = append(keys, contentKey)
+	}
+	return keys, rows.Err()
+}
+
+// Stats returns the number and size of the stored items by content type.
+func (s *StateStorage) Stats() (*storage.StorageStats, error) {
+	stats := &storage.StorageStats{
+		ContentTypes: make(map[byte]storage.ContentTypeStats),
+		Radius:       s.Radius(),
+	}
+	for contentType, usage := range s.Usage() {
+		stats.ContentTypes[contentType] = storage.ContentTypeStats{Count: usage.Count, Size: usage.Size}
+	}
+	var distance []byte
+	if err := s.db.QueryRow(farthestDistanceSql).Scan(&distance); err != nil {
+		return nil, err
+	}
+	stats.FarthestDistance = new(uint256.Int).SetBytes(distance)
+	return stats, nil
+}
+
+// UsedSize returns the size of all stored items.
+func (s *StateStorage) UsedSize() (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.usedSize(), nil
+}
+
+// SetCapacity changes the capacity of the storage, the radius shrinks if the
+// stored items don't fit into it.
+func (s *StateStorage) SetCapacity(capacity uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.storageCapacityInBytes = capacity
+	return s.pruneIfFull()
+}
+
+// ForcePrune deletes the items farther than radius and shrinks the radius to
+// it.
+func (s *StateStorage) ForcePrune(radius *uint256.Int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.prune(radius)
+}
+
+func (s *StateStorage) Close() error {
+	if err := s.getStmt.Close(); err != nil {
+		return err
+	}
+	if err := s.putStmt.Close(); err != nil {
+		return err
+	}
+	return s.db.Close()
+}
+
+// store saves the retrieval value of a validated item, the radius shrinks if
+// the storage is full afterwards.
+func (s *StateStorage) store(contentKey []byte, contentId []byte, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	distance := xor(contentId, s.nodeId[:])
+	size := uint64(len(contentId) + len(contentKey) + len(value))
+	res, err := s.putStmt.Exec(contentId, contentKey, value, contentKey[0], distance, size)
+	if err != nil {
+		s.log.Error("failed to save data after validate", "type", contentKey[0], "key", hexutil.Encode(contentKey[1:]), "err", err)
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil || count == 0 {
+		// the item was stored before
+		return err
+	}
+	usage := s.usage[contentKey[0]]
+	if usage == nil {
+		usage = new(ContentUsage)
+		s.usage[contentKey[0]] = usage
+	}
+	usage.Count++
+	usage.Size += size
+	err = s.pruneIfFull()
+	s.updateMetrics()
+	return err
+}
+
+func (s *StateStorage) usedSize() uint64 {
+	var size uint64
+	for _, usage := range s.usage {
+		size += usage.Size
+	}
+	return size
+}
+
+// pruneTarget is the size the storage is pruned down to once it is full.
+func (s *StateStorage) pruneTarget() uint64 {
+	return uint64(float64(s.storageCapacityInBytes) * (1 - contentDeletionFraction))
+}
+
+// pruneIfFull shrinks the radius until the items within it fit into the
+// prune target, if the capacity is exceeded.
+func (s *StateStorage) pruneIfFull() error {
+	if s.usedSize() <= s.storageCapacityInBytes {
+		return nil
+	}
+	rows, err := s.db.Query(orderedByDistanceSql)
+	if err != nil {
+		return err
+	}
+	var (
+		remaining = s.usedSize()
+		target    = s.pruneTarget()
+		radius    = uint256.NewInt(0)
+	)
+	for rows.Next() {
+		var (
+			distance []byte
+			size     uint64
+		)
+		if err = rows.Scan(&distance, &size); err != nil {
+			rows.Close()
+			return err
+		}
+		if remaining <= target {
+			radius.SetBytes(distance)
+			break
+		}
+		remaining -= size
+	}
+	// the rows must be closed before the table is written to
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	s.log.Debug("storage is full, shrinking radius", "used", s.usedSize(), "capacity", s.storageCapacityInBytes, "radius", radius.Hex())
+	return s.prune(radius)
+}
+
+// prune deletes the items farther than radius and stores the radius.
+func (s *StateStorage) prune(radius *uint256.Int) error {
+	distance := radius.Bytes32()
+	if _, err := s.db.Exec(deleteOutOfRadiusSql, distance[:]); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(putRadiusSql, distance[:]); err != nil {
+		return err
+	}
+	s.radius.Store(new(uint256.Int).Set(radius))
+	if err := s.loadUsage(); err != nil {
+		return err
+	}
+	s.updateMetrics()
+	return nil
+}
+
+// loadRadius restores the radius the storage was last pruned to.
+func (s *StateStorage) loadRadius() error {
+	var radius []byte
+	err := s.db.QueryRow(getRadiusSql).Scan(&radius)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.radius.Store(storage.MaxDistance)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.radius.Store(new(uint256.Int).SetBytes(radius))
+	return nil
+}
+
+// loadUsage counts the stored items by content type.
+func (s *StateStorage) loadUsage() error {
+	rows, err := s.db.Query(usageSql)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	usage := make(map[byte]*ContentUsage)
+	for rows.Next() {
+		var (
+			contentType byte
+			u           ContentUsage
+		)
+		if err = rows.Scan(&contentType, &u.Count, &u.Size); err != nil {
+			return err
+		}
+		usage[contentType] = &u
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	s.usage = usage
+	return nil
+}
+
+func (s *StateStorage) updateMetrics() {
+	if s.metrics == nil {
+		return
+	}
+	var count, size uint64
+	for contentType, gauge := range s.metrics.typeUsage {
+		var usage ContentUsage
+		if u := s.usage[contentType]; u != nil {
+			usage = *u
+		}
+		gauge.Update(int64(usage.Size))
+		count += usage.Count
+		size += usage.Size
+	}
+	s.metrics.EntriesCount.Update(int64(count))
+	s.metrics.ContentStorageUsage.Update(int64(size))
+	s.metrics.RadiusRatio.Update(s.Radius().Float64() / storage.MaxDistance.Float64())
+}
+
+func xor(contentId, nodeId []byte) []byte {
+	res := make([]byte, len(nodeId))
+	for i := range nodeId {
+		if i < len(contentId) {
+			res[i] = contentId[i] ^ nodeId[i]
+		} else {
+			res[i] = nodeId[i]
+		}
+	}
+	return res
 }
 
 func (s *StateStorage) putAccountTrieNode(contentKey []byte, contentId []byte, content []byte) error {
 	accountKey := &AccountTrieNodeKey{}
-	err := accountKey.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey), uint64(len(contentKey))))
+	err := accountKey.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey[1:]), uint64(len(contentKey)-1)))
 	if err != nil {
 		return err
 	}
@@ -95,19 +506,12 @@ func (s *StateStorage) putAccountTrieNode(contentKey []byte, contentId []byte, c
 	if err != nil {
 		return err
 	}
-	err = s.store.Put(contentId, contentId, contentValueBuf.Bytes())
-	if err != nil {
-		s.log.Error("failed to save data after validate", "type", contentKey[0], "key", contentKey[1:], "value", content)
-	} else if metrics.Enabled {
-		portalStorageMetrics.EntriesCount.Inc(1)
-		portalStorageMetrics.ContentStorageUsage.Inc(int64(len(content)))
-	}
-	return nil
+	return s.store(contentKey, contentId, contentValueBuf.Bytes())
 }
 
 func (s *StateStorage) putContractStorageTrieNode(contentKey []byte, contentId []byte, content []byte) error {
 	contractStorageKey := &ContractStorageTrieNodeKey{}
-	err := contractStorageKey.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey), uint64(len(contentKey))))
+	err := contractStorageKey.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey[1:]), uint64(len(contentKey)-1)))
 	if err != nil {
 		return err
 	}
@@ -132,19 +536,12 @@ func (s *StateStorage) putContractStorageTrieNode(contentKey []byte, contentId [
 	if err != nil {
 		return err
 	}
-	err = s.store.Put(contentId, contentId, contentValueBuf.Bytes())
-	if err != nil {
-		s.log.Error("failed to save data after validate", "type", contentKey[0], "key", contentKey[1:], "value", content)
-	} else if metrics.Enabled {
-		portalStorageMetrics.EntriesCount.Inc(1)
-		portalStorageMetrics.ContentStorageUsage.Inc(int64(len(content)))
-	}
-	return nil
+	return s.store(contentKey, contentId, contentValueBuf.Bytes())
 }
 
 func (s *StateStorage) putContractBytecode(contentKey []byte, contentId []byte, content []byte) error {
 	contractByteCodeKey := &ContractBytecodeKey{}
-	err := contractByteCodeKey.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey), uint64(len(contentKey))))
+	err := contractByteCodeKey.Deserialize(codec.NewDecodingReader(bytes.NewReader(contentKey[1:]), uint64(len(contentKey)-1)))
 	if err != nil {
 		return err
 	}
@@ -165,12 +562,5 @@ func (s *StateStorage) putContractBytecode(contentKey []byte, contentId []byte,
 	if err != nil {
 		return err
 	}
-	err = s.store.Put(contentId, contentId, contentValueBuf.Bytes())
-	if err != nil {
-		s.log.Error("failed to save data after validate", "type", contentKey[0], "key", contentKey[1:], "value", content)
-	} else if metrics.Enabled {
-		portalStorageMetrics.EntriesCount.Inc(1)
-		portalStorageMetrics.ContentStorageUsage.Inc(int64(len(content)))
-	}
-	return nil
+	return s.store(contentKey, contentId, contentValueBuf.Bytes())
 }
@@ -1,16 +1,38 @@
 package state
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/portalnetwork/storage"
+	"github.com/holiman/uint256"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/ztyp/codec"
 	"github.com/stretchr/testify/require"
 )
 
+func newStorage(t *testing.T, dir string, capacityMB uint64) *StateStorage {
+	db, err := sql.Open("sqlite3", path.Join(dir, "state.sqlite"))
+	require.NoError(t, err)
+	stateStorage, err := NewStateStorage(storage.PortalStorageConfig{
+		StorageCapacityMB: capacityMB,
+		DB:                db,
+		NodeId:            enode.ID{},
+		NetworkName:       "state",
+	})
+	require.NoError(t, err)
+	return stateStorage
+}
+
 func TestStorage(t *testing.T) {
-	storage := storage.NewMockStorage()
-	stateStorage := NewStateStorage(storage, nil)
+	stateStorage := newStorage(t, t.TempDir(), 1000)
+	defer stateStorage.Close()
 	testfiles := []string{"account_trie_node.yaml", "contract_storage_trie_node.yaml", "contract_bytecode.yaml"}
 	for _, file := range testfiles {
 		cases, err := getTestCases(file)
@@ -25,4 +47,209 @@ func TestStorage(t *testing.T) {
 			require.Equal(t, hexutil.MustDecode(tt.ContentValueRetrieval), res)
 		}
 	}
+	usage := stateStorage.Usage()
+	require.Len(t, usage, 3)
+	for _, contentType := range []byte{AccountTrieNodeType, ContractStorageTrieNodeType, ContractByteCodeType} {
+		require.NotZero(t, usage[contentType].Count)
+		require.NotZero(t, usage[contentType].Size)
+	}
+
+	// storing an item again doesn't change the accounting
+	used := usedSize(t, stateStorage)
+	cases, err := getTestCases("contract_bytecode.yaml")
+	require.NoError(t, err)
+	contentKey := hexutil.MustDecode(cases[0].ContentKey)
+	require.NoError(t, stateStorage.Put(contentKey, defaultContentIdFunc(contentKey), hexutil.MustDecode(cases[0].ContentValueOffer)))
+	require.Equal(t, used, usedSize(t, stateStorage))
+
+	// a deleted item is gone from the storage and the accounting
+	count := stateStorage.Usage()[ContractByteCodeType].Count
+	require.NoError(t, stateStorage.Delete(contentKey, defaultContentIdFunc(contentKey)))
+	_, err = stateStorage.Get(contentKey, defaultContentIdFunc(contentKey))
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+	require.Equal(t, count-1, stateStorage.Usage()[ContractByteCodeType].Count)
+	require.Less(t, usedSize(t, stateStorage), used)
+	require.NoError(t, stateStorage.Delete(contentKey, defaultContentIdFunc(contentKey)))
+}
+
+func usedSize(t *testing.T, s *StateStorage) uint64 {
+	size, err := s.UsedSize()
+	require.NoError(t, err)
+	return size
+}
+
+// bytecodeContent returns the content key and offer of a contract bytecode
+// item with a random code of size bytes.
+func bytecodeContent(t *testing.T, seed int, size int) ([]byte, []byte) {
+	code := bytes.Repeat(crypto.Keccak256([]byte{byte(seed), byte(seed >> 8)}), size/32)
+	key := &ContractBytecodeKey{CodeHash: common.Bytes32(crypto.Keccak256(code))}
+	var keyBuf bytes.Buffer
+	require.NoError(t, key.Serialize(codec.NewEncodingWriter(&keyBuf)))
+	value := &ContractBytecodeWithProof{Code: code, AccountProof: TrieProof{}}
+	var valueBuf bytes.Buffer
+	require.NoError(t, value.Serialize(codec.NewEncodingWriter(&valueBuf)))
+	return append([]byte{ContractByteCodeType}, keyBuf.Bytes()...), valueBuf.Bytes()
+}
+
+func TestStoragePruning(t *testing.T) {
+	dir := t.TempDir()
+	stateStorage := newStorage(t, dir, 1)
+	require.Equal(t, storage.MaxDistance, stateStorage.Radius())
+
+	contentIds := make([][]byte, 0)
+	for i := 0; i < 40; i++ {
+		contentKey, content := bytecodeContent(t, i, 32*1024)
+		contentId := defaultContentIdFunc(contentKey)
+		require.NoError(t, stateStorage.Put(contentKey, contentId, content))
+		contentIds = append(contentIds, contentId)
+	}
+	// the radius shrank and the items out of it were deleted
+	radius := stateStorage.Radius()
+	require.Equal(t, -1, radius.Cmp(storage.MaxDistance))
+	require.LessOrEqual(t, usedSize(t, stateStorage), uint64(1000000))
+	var stored int
+	for _, contentId := range contentIds {
+		_, err := stateStorage.Get(nil, contentId)
+		distance := new(uint256.Int).SetBytes(xor(contentId, make([]byte, 32)))
+		if distance.Cmp(radius) > 0 {
+			require.ErrorIs(t, err, storage.ErrContentNotFound)
+		} else {
+			require.NoError(t, err)
+			stored++
+		}
+	}
+	require.Equal(t, int(stateStorage.Usage()[ContractByteCodeType].Count), stored)
+
+	// the radius and the accounting are restored after a restart
+	used := usedSize(t, stateStorage)
+	require.NoError(t, stateStorage.Close())
+	stateStorage = newStorage(t, dir, 1)
+	defer stateStorage.Close()
+	require.Equal(t, used, usedSize(t, stateStorage))
+	require.Equal(t, radius, stateStorage.Radius())
+
+	// a smaller capacity shrinks the radius further
+	require.NoError(t, stateStorage.SetCapacity(500000))
+	require.LessOrEqual(t, usedSize(t, stateStorage), uint64(500000))
+	require.Equal(t, -1, stateStorage.Radius().Cmp(radius))
+
+	require.NoError(t, stateStorage.ForcePrune(uint256.NewInt(0)))
+	require.Zero(t, usedSize(t, stateStorage))
+	require.True(t, stateStorage.Radius().IsZero())
+}
+
+func TestStorageDropsBaselineContent(t *testing.T) {
+	dir := t.TempDir()
+	// the state content of the baseline was stored in the table of the
+	// history storage, keyed by content id
+	db, err := sql.Open("sqlite3", path.Join(dir, "state.sqlite"))
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE kvstore (key BLOB PRIMARY KEY, value BLOB);")
+	require.NoError(t, err)
+	contentKey, _ := bytecodeContent(t, 0, 64)
+	_, err = db.Exec("INSERT INTO kvstore (key, value) VALUES (?1, ?2);", defaultContentIdFunc(contentKey), []byte{0x04, 0, 0, 0, 0x60})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	stateStorage := newStorage(t, dir, 1000)
+	defer stateStorage.Close()
+	version, err := storage.SchemaVersion(stateStorage.db)
+	require.NoError(t, err)
+	require.Equal(t, len(migrations), version)
+	var tables int
+	require.NoError(t, stateStorage.db.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE name = 'kvstore';").Scan(&tables))
+	require.Zero(t, tables)
+	require.Zero(t, usedSize(t, stateStorage))
+
+	// the dropped item can be stored again
+	contentKey, content := bytecodeContent(t, 0, 64)
+	contentId := defaultContentIdFunc(contentKey)
+	require.NoError(t, stateStorage.Put(contentKey, contentId, content))
+	_, err = stateStorage.Get(contentKey, contentId)
+	require.NoError(t, err)
+}
+
+func TestStorageLocalContentKeys(t *testing.T) {
+	stateStorage := newStorage(t, t.TempDir(), 1000)
+	defer stateStorage.Close()
+	stats, err := stateStorage.Stats()
+	require.NoError(t, err)
+	require.Empty(t, stats.ContentTypes)
+	require.True(t, stats.FarthestDistance.IsZero())
+
+	var keys [][]byte
+	for _, file := range []string{"account_trie_node.yaml", "contract_bytecode.yaml"} {
+		cases, err := getTestCases(file)
+		require.NoError(t, err)
+		for _, tt := range cases {
+			contentKey := hexutil.MustDecode(tt.ContentKey)
+			require.NoError(t, stateStorage.Put(contentKey, defaultContentIdFunc(contentKey), hexutil.MustDecode(tt.ContentValueOffer)))
+			keys = append(keys, contentKey)
+		}
+	}
+
+	page, err := stateStorage.LocalContentKeys(0, uint64(len(keys)), nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, keys, page)
+	page, err = stateStorage.LocalContentKeys(1, uint64(len(keys)), nil)
+	require.NoError(t, err)
+	require.Len(t, page, len(keys)-1)
+	bytecodeType := ContractByteCodeType
+	page, err = stateStorage.LocalContentKeys(0, uint64(len(keys)), &bytecodeType)
+	require.NoError(t, err)
+	require.NotEmpty(t, page)
+	for _, contentKey := range page {
+		require.Equal(t, ContractByteCodeType, contentKey[0])
+	}
+
+	stats, err = stateStorage.Stats()
+	require.NoError(t, err)
+	require.Len(t, stats.ContentTypes, 2)
+	require.Equal(t, uint64(len(page)), stats.ContentTypes[ContractByteCodeType].Count)
+	require.False(t, stats.FarthestDistance.IsZero())
+	require.Equal(t, storage.MaxDistance, stats.Radius)
+}
+
+func TestScrubber(t *testing.T) {
+	stateStorage := newStorage(t, t.TempDir(), 1000)
+	defer stateStorage.Close()
+	var count int
+	for _, file := range []string{"account_trie_node.yaml", "contract_storage_trie_node.yaml", "contract_bytecode.yaml"} {
+		cases, err := getTestCases(file)
+		require.NoError(t, err)
+		for _, tt := range cases {
+			contentKey := hexutil.MustDecode(tt.ContentKey)
+			require.NoError(t, stateStorage.Put(contentKey, defaultContentIdFunc(contentKey), hexutil.MustDecode(tt.ContentValueOffer)))
+			count++
+		}
+	}
+	// a trie node stored before its hash was checked
+	cases, err := getTestCases("account_trie_node.yaml")
+	require.NoError(t, err)
+	corruptKey := hexutil.MustDecode(cases[0].ContentKey)
+	// the node hash follows the selector and the offset of the path
+	corruptKey[5] ^= 1
+	retrieval := hexutil.MustDecode(cases[0].ContentValueRetrieval)
+	require.NoError(t, stateStorage.store(corruptKey, defaultContentIdFunc(corruptKey), retrieval))
+
+	scrubber, err := NewScrubber(stateStorage, storage.ScrubConfig{DryRun: true})
+	require.NoError(t, err)
+	res, err := scrubber.Scrub(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, count+1, res.Checked)
+	require.Equal(t, 1, res.Invalid)
+	_, err = stateStorage.Get(corruptKey, defaultContentIdFunc(corruptKey))
+	require.NoError(t, err)
+
+	scrubber, err = NewScrubber(stateStorage, storage.ScrubConfig{})
+	require.NoError(t, err)
+	res, err = scrubber.Scrub(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, res.Invalid)
+	_, err = stateStorage.Get(corruptKey, defaultContentIdFunc(corruptKey))
+	require.ErrorIs(t, err, storage.ErrContentNotFound)
+	res, err = scrubber.Scrub(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, count, res.Checked)
+	require.Zero(t, res.Invalid)
 }
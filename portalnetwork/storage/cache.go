@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/holiman/uint256"
+)
+
+const (
+	// Number of content ids remembered as not found.
+	negativeCacheItems = 10000
+	// Content can reach the storage without passing the cache, e.g. an era1
+	// import, so content not found is only remembered for a while.
+	negativeCacheTTL = 30 * time.Second
+)
+
+var (
+	_ BudgetedStorage = &CachedStorage{}
+	_ ContentIndex    = &CachedStorage{}
+	_ ContentDeleter  = &CachedStorage{}
+)
+
+// CachedStorage is a read-through cache in front of a ContentStorage. It keeps
+// the recently read content up to a size in bytes and remembers the content
+// that was not found. A Put invalidates the cached lookups of its content and
+// the whole cache is dropped once the radius of the storage shrinks, as the
+// storage pruned content.
+//
+// The content of a key must not change while it is stored, which rules out
+// storages like the beacon network's that replace light client updates.
+type CachedStorage struct {
+	storage ContentStorage
+
+	lock     sync.Mutex
+	content  lru.BasicLRU[string, []byte]
+	size     uint64
+	maxSize  uint64
+	notFound lru.BasicLRU[string, time.Time]
+	radius   *uint256.Int
+	// lookups are the storage reads in flight by key, a read that raced with
+	// a write of its key isn't cached
+	lookups map[string]*cacheLookup
+
+	hitMeter         metrics.Meter
+	notFoundHitMeter metrics.Meter
+	missMeter        metrics.Meter
+	sizeGauge        metrics.Gauge
+}
+
+// cacheLookup is a read of the storage in flight for a missed key.
+type cacheLookup struct {
+	readers int
+	written bool
+}
+
+// NewCachedStorage wraps the storage of a network with a cache of maxSize
+// bytes.
+func NewCachedStorage(storage ContentStorage, maxSize uint64, network string) *CachedStorage {
+	c := &CachedStorage{
+		storage:  storage,
+		content:  lru.NewBasicLRU[string, []byte](math.MaxInt),
+		maxSize:  maxSize,
+		notFound: lru.NewBasicLRU[string, time.Time](negativeCacheItems),
+		radius:   storage.Radius(),
+		lookups:  make(map[string]*cacheLookup),
+	}
+	if metrics.Enabled {
+		network = strings.ToLower(network)
+		c.hitMeter = metrics.GetOrRegisterMeter("portal/"+network+"/cache/hit", nil)
+		c.notFoundHitMeter = metrics.GetOrRegisterMeter("portal/"+network+"/cache/not_found_hit", nil)
+		c.missMeter = metrics.GetOrRegisterMeter("portal/"+network+"/cache/miss", nil)
+		c.sizeGauge = metrics.GetOrRegisterGauge("portal/"+network+"/cache/size", nil)
+	}
+	return c
+}
+
+func (c *CachedStorage) Get(contentKey []byte, contentId []byte) ([]byte, error) {
+	key := cacheKey(contentKey, contentId)
+	c.lock.Lock()
+	c.checkRadius()
+	if content, ok := c.content.Get(key); ok {
+		c.lock.Unlock()
+		mark(c.hitMeter)
+		return content, nil
+	}
+	if cached, ok := c.notFound.Get(key); ok {
+		if time.Since(cached) < negativeCacheTTL {
+			c.lock.Unlock()
+			mark(c.notFoundHitMeter)
+			return nil, ErrContentNotFound
+		}
+		c.notFound.Remove(key)
+	}
+	lookup, ok := c.lookups[key]
+	if !ok {
+		lookup = new(cacheLookup)
+		c.lookups[key] = lookup
+	}
+	lookup.readers++
+	c.lock.Unlock()
+	mark(c.missMeter)
+
+	content, err := c.storage.Get(contentKey, contentId)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if lookup.readers--; lookup.readers == 0 {
+		delete(c.lookups, key)
+	}
+	if lookup.written {
+		return content, err
+	}
+	switch {
+	case errors.Is(err, ErrContentNotFound):
+		c.notFound.Add(key, time.Now())
+	case err == nil:
+		c.add(key, content)
+	}
+	return content, err
+}
+
+// Put stores the content and invalidates its cached lookups, the content is
+// only cached once it is read.
+func (c *CachedStorage) Put(contentKey []byte, contentId []byte, content []byte) error {
+	err := c.storage.Put(contentKey, contentId, content)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.written(cacheKey(contentKey, contentId))
+	c.checkRadius()
+	return err
+}
+
+func (c *CachedStorage) Radius() *uint256.Int {
+	return c.storage.Radius()
+}
+
+// UsedSize returns the used size of the cached storage.
+func (c *CachedStorage) UsedSize() (uint64, error) {
+	budgeted, ok := c.storage.(BudgetedStorage)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return budgeted.UsedSize()
+}
+
+// SetCapacity changes the capacity of the cached storage.
+func (c *CachedStorage) SetCapacity(capacity uint64) error {
+	budgeted, ok := c.storage.(BudgetedStorage)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	err := budgeted.SetCapacity(capacity)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checkRadius()
+	return err
+}
+
+// Delete deletes the content from the cached storage and the cache.
+func (c *CachedStorage) Delete(contentKey []byte, contentId []byte) error {
+	deleter, ok := c.storage.(ContentDeleter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	err := deleter.Delete(contentKey, contentId)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.written(cacheKey(contentKey, contentId))
+	return err
+}
+
+// LocalContentKeys lists the content keys of the cached storage.
+func (c *CachedStorage) LocalContentKeys(offset, limit uint64, contentType *byte) ([][]byte, error) {
+	index, ok := c.storage.(ContentIndex)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return index.LocalContentKeys(offset, limit, contentType)
+}
+
+// Stats returns the stats of the cached storage.
+func (c *CachedStorage) Stats() (*StorageStats, error) {
+	index, ok := c.storage.(ContentIndex)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return index.Stats()
+}
+
+// Close closes the cached storage.
+func (c *CachedStorage) Close() error {
+	closer, ok := c.storage.(io.Closer)
+	if !ok {
+		return nil
+	}
+	return closer.Close()
+}
+
+// Unwrap returns the cached storage.
+func (c *CachedStorage) Unwrap() ContentStorage {
+	return c.storage
+}
+
+// checkRadius drops the cache once the storage pruned content. Pruning also
+// shrinks the radius, so content read before is gone from the storage if it
+// was out of the new radius.
+func (c *CachedStorage) checkRadius() {
+	radius := c.storage.Radius()
+	shrunk := radius.Cmp(c.radius) < 0
+	c.radius = radius
+	if !shrunk {
+		return
+	}
+	c.content.Purge()
+	c.size = 0
+	c.updateSize()
+	// the reads in flight may have returned pruned content
+	for _, lookup := range c.lookups {
+		lookup.written = true
+	}
+}
+
+// written invalidates the cached lookups of a key that was written and the
+// reads of it in flight.
+func (c *CachedStorage) written(key string) {
+	c.remove(key)
+	if lookup, ok := c.lookups[key]; ok {
+		lookup.written = true
+	}
+}
+
+func (c *CachedStorage) add(key string, content []byte) {
+	if uint64(len(content)) > c.maxSize || c.content.Contains(key) {
+		return
+	}
+	c.size += uint64(len(content))
+	for c.size > c.maxSize {
+		_, evicted, ok := c.content.RemoveOldest()
+		if !ok {
+			break
+		}
+		c.size -= uint64(len(evicted))
+	}
+	c.content.Add(key, content)
+	c.notFound.Remove(key)
+	c.updateSize()
+}
+
+func (c *CachedStorage) remove(key string) {
+	if content, ok := c.content.Peek(key); ok {
+		c.content.Remove(key)
+		c.size -= uint64(len(content))
+		c.updateSize()
+	}
+	c.notFound.Remove(key)
+}
+
+func (c *CachedStorage) updateSize() {
+	if c.sizeGauge != nil {
+		c.sizeGauge.Update(int64(c.size))
+	}
+}
+
+func cacheKey(contentKey []byte, contentId []byte) string {
+	return string(contentId) + string(contentKey)
+}
+
+func mark(meter metrics.Meter) {
+	if meter != nil {
+		meter.Mark(1)
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts the reads that reach the storage, onGet runs while
+// a read is in flight.
+type countingStorage struct {
+	*PebbleStorage
+	gets  int
+	onGet func()
+}
+
+func (s *countingStorage) Get(contentKey []byte, contentId []byte) ([]byte, error) {
+	s.gets++
+	content, err := s.PebbleStorage.Get(contentKey, contentId)
+	if s.onGet != nil {
+		s.onGet()
+	}
+	return content, err
+}
+
+func TestCachedStorage(t *testing.T) {
+	inner := &countingStorage{PebbleStorage: newPebbleStorage(t, t.TempDir(), 1)}
+	defer inner.Close()
+	s := NewCachedStorage(inner, 64*1024, "test")
+
+	// content not found is remembered until it is put
+	_, err := s.Get(nil, contentIdAt(1))
+	require.ErrorIs(t, err, ErrContentNotFound)
+	_, err = s.Get(nil, contentIdAt(1))
+	require.ErrorIs(t, err, ErrContentNotFound)
+	require.Equal(t, 1, inner.gets)
+	require.NoError(t, s.Put(nil, contentIdAt(1), []byte("value")))
+	for i := 0; i < 2; i++ {
+		content, err := s.Get(nil, contentIdAt(1))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), content)
+	}
+	require.Equal(t, 2, inner.gets)
+
+	// the least recently read content is evicted beyond the size
+	content := make([]byte, 32*1024)
+	require.NoError(t, s.Put(nil, contentIdAt(2), content))
+	require.NoError(t, s.Put(nil, contentIdAt(3), content))
+	for _, n := range []byte{2, 3, 1} {
+		_, err = s.Get(nil, contentIdAt(n))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 5, inner.gets)
+	require.LessOrEqual(t, s.size, uint64(64*1024))
+	_, err = s.Get(nil, contentIdAt(2))
+	require.NoError(t, err)
+	require.Equal(t, 6, inner.gets)
+
+	// pruning the storage drops the cache
+	require.NoError(t, s.SetCapacity(0))
+	require.Zero(t, s.size)
+	_, err = s.Get(nil, contentIdAt(3))
+	require.ErrorIs(t, err, ErrContentNotFound)
+	require.Equal(t, 7, inner.gets)
+}
+
+func TestCachedStorageRacedWrites(t *testing.T) {
+	inner := &countingStorage{PebbleStorage: newPebbleStorage(t, t.TempDir(), 1)}
+	defer inner.Close()
+	s := NewCachedStorage(inner, 64*1024, "test")
+	require.NoError(t, s.Put(nil, contentIdAt(1), []byte("value")))
+
+	// a write of another key doesn't keep the read from being cached
+	inner.onGet = func() {
+		require.NoError(t, s.Put(nil, contentIdAt(2), []byte("other")))
+	}
+	_, err := s.Get(nil, contentIdAt(1))
+	require.NoError(t, err)
+	inner.onGet = nil
+	_, err = s.Get(nil, contentIdAt(1))
+	require.NoError(t, err)
+	require.Equal(t, 1, inner.gets)
+
+	// a read that raced with a write of its key isn't cached
+	inner.onGet = func() {
+		require.NoError(t, s.Delete(nil, contentIdAt(2)))
+	}
+	content, err := s.Get(nil, contentIdAt(2))
+	require.NoError(t, err)
+	require.Equal(t, []byte("other"), content)
+	inner.onGet = nil
+	_, err = s.Get(nil, contentIdAt(2))
+	require.ErrorIs(t, err, ErrContentNotFound)
+	require.Equal(t, 3, inner.gets)
+	require.Empty(t, s.lookups)
+}
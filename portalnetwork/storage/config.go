@@ -3,6 +3,7 @@ package storage
 import (
 	"database/sql"
 
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 )
@@ -10,7 +11,13 @@ import (
 type PortalStorageConfig struct {
 	StorageCapacityMB uint64
 	DB                *sql.DB
-	NodeId            enode.ID
-	Spec              *common.Spec
-	NetworkName       string
+	// KVStore backs the pebble content storage.
+	KVStore     ethdb.KeyValueStore
+	NodeId      enode.ID
+	Spec        *common.Spec
+	NetworkName string
+	// Number of slots after which the beacon network drops its light client
+	// finality and optimistic updates, zero selects the default.
+	FinalityUpdateTTL   uint64
+	OptimisticUpdateTTL uint64
 }
@@ -39,6 +39,59 @@ type ContentStorage interface {
 	Radius() *uint256.Int
 }
 
+// ContentTypeStats is the number and the size of the stored content of a
+// content type.
+type ContentTypeStats struct {
+	Count uint64
+	Size  uint64
+}
+
+// StorageStats summarizes the stored content.
+type StorageStats struct {
+	ContentTypes map[byte]ContentTypeStats
+	// Unindexed is the content stored without its content key.
+	Unindexed ContentTypeStats
+	// FarthestDistance is nil for a storage that keeps content regardless of
+	// its distance.
+	FarthestDistance *uint256.Int
+	Radius           *uint256.Int
+}
+
+// ContentIndex is a content storage that keeps the content keys of the
+// stored content.
+type ContentIndex interface {
+	// LocalContentKeys returns limit content keys from the offset on, only
+	// the keys of the content type if it is set.
+	LocalContentKeys(offset, limit uint64, contentType *byte) ([][]byte, error)
+
+	Stats() (*StorageStats, error)
+}
+
+// ContentDeleter is a content storage that content can be deleted from.
+type ContentDeleter interface {
+	Delete(contentKey []byte, contentId []byte) error
+}
+
+// NopStorage stores nothing and has a zero radius, it backs networks that are
+// only joined to look up content.
+type NopStorage struct{}
+
+func NewNopStorage() ContentStorage {
+	return NopStorage{}
+}
+
+func (NopStorage) Get(contentKey []byte, contentId []byte) ([]byte, error) {
+	return nil, ErrContentNotFound
+}
+
+func (NopStorage) Put(contentKey []byte, contentId []byte, content []byte) error {
+	return nil
+}
+
+func (NopStorage) Radius() *uint256.Int {
+	return uint256.NewInt(0)
+}
+
 type MockStorage struct {
 	Db map[string][]byte
 }
@@ -61,6 +114,11 @@ func (m *MockStorage) Put(contentKey []byte, contentId []byte, content []byte) e
 	return nil
 }
 
+func (m *MockStorage) Delete(contentKey []byte, contentId []byte) error {
+	delete(m.Db, string(contentId))
+	return nil
+}
+
 func (m *MockStorage) Radius() *uint256.Int {
 	return uint256.MustFromHex("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
 }
@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	BytesInMB = 1000 * 1000
+
+	rebalanceInterval = time.Minute
+	// A network that doesn't fill its allocation is granted this fraction of
+	// the total budget on top of its usage to grow into.
+	growthFraction = 0.05
+	// A network that prunes and uses this fraction of its allocation asks for
+	// more capacity.
+	fullFraction = 0.9
+)
+
+var ErrUnknownNetwork = errors.New("network has no storage budget")
+
+// BudgetedStorage is a content storage whose capacity is set by the
+// coordinator.
+type BudgetedStorage interface {
+	ContentStorage
+	// UsedSize returns the number of bytes counted against the capacity.
+	UsedSize() (uint64, error)
+	// SetCapacity changes the capacity in bytes, the content that doesn't fit
+	// into it anymore is pruned.
+	SetCapacity(capacity uint64) error
+}
+
+// NetworkBudget is the share of the total budget a network is entitled to.
+// The budget is split by weight, a non-zero capacity caps the allocation of
+// the network in MB.
+type NetworkBudget struct {
+	Weight     uint64
+	CapacityMB uint64
+}
+
+// StorageAllocation is the capacity allocated to the storage of a network.
+type StorageAllocation struct {
+	Network   string `json:"network"`
+	Weight    uint64 `json:"weight"`
+	Cap       uint64 `json:"cap"`
+	Allocated uint64 `json:"allocated"`
+	Used      uint64 `json:"used"`
+	Full      bool   `json:"full"`
+}
+
+type budgetedNetwork struct {
+	name      string
+	budget    NetworkBudget
+	storage   BudgetedStorage
+	allocated uint64
+	used      uint64
+	full      bool
+
+	allocatedGauge metrics.Gauge
+	usedGauge      metrics.Gauge
+}
+
+// cap returns the most bytes the network may be allocated.
+func (n *budgetedNetwork) cap(total uint64) uint64 {
+	if n.budget.CapacityMB == 0 {
+		return total
+	}
+	return min(total, n.budget.CapacityMB*BytesInMB)
+}
+
+// StorageCoordinator enforces one storage budget across the networks. The
+// budget is first split by weight, then capacity moves from networks that
+// don't use their share to networks that prune content because their storage
+// is full.
+type StorageCoordinator struct {
+	total     uint64
+	log       log.Logger
+	closeCtx  context.Context
+	closeFunc context.CancelFunc
+
+	// rebalanceLock serializes the rebalancing, lock guards the allocations
+	rebalanceLock sync.Mutex
+	lock          sync.Mutex
+	networks      []*budgetedNetwork
+}
+
+// NewStorageCoordinator creates a coordinator for a total budget in MB, split
+// between the networks of the budgets.
+func NewStorageCoordinator(totalMB uint64, budgets map[string]NetworkBudget) *StorageCoordinator {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &StorageCoordinator{
+		total:     totalMB * BytesInMB,
+		log:       log.New("storage", "coordinator"),
+		closeCtx:  ctx,
+		closeFunc: cancel,
+	}
+	for name, budget := range budgets {
+		if budget.Weight == 0 {
+			budget.Weight = 1
+		}
+		n := &budgetedNetwork{name: name, budget: budget}
+		if metrics.Enabled {
+			n.allocatedGauge = metrics.GetOrRegisterGauge("portal/"+name+"/storage_allocation", nil)
+			n.usedGauge = metrics.GetOrRegisterGauge("portal/"+name+"/storage_used", nil)
+		}
+		c.networks = append(c.networks, n)
+	}
+	slices.SortFunc(c.networks, func(a, b *budgetedNetwork) int {
+		return strings.Compare(a.name, b.name)
+	})
+	c.allocate()
+	return c
+}
+
+// CapacityMB returns the capacity the storage of a network starts with.
+func (c *StorageCoordinator) CapacityMB(network string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if n := c.network(network); n != nil {
+		return n.allocated / BytesInMB
+	}
+	return 0
+}
+
+// Attach hands the storage of a network to the coordinator.
+func (c *StorageCoordinator) Attach(network string, storage BudgetedStorage) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n := c.network(network)
+	if n == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownNetwork, network)
+	}
+	n.storage = storage
+	return nil
+}
+
+// Start rebalances the allocations right away and then periodically.
+func (c *StorageCoordinator) Start() {
+	if err := c.Rebalance(); err != nil {
+		c.log.Warn("failed to rebalance storage", "err", err)
+	}
+	go c.loop()
+}
+
+func (c *StorageCoordinator) Stop() {
+	c.closeFunc()
+}
+
+func (c *StorageCoordinator) loop() {
+	ticker := time.NewTicker(rebalanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeCtx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Rebalance(); err != nil {
+				c.log.Warn("failed to rebalance storage", "err", err)
+			}
+		}
+	}
+}
+
+// Rebalance reads the usage of all storages and moves capacity to the
+// networks whose storage is full.
+func (c *StorageCoordinator) Rebalance() error {
+	c.rebalanceLock.Lock()
+	defer c.rebalanceLock.Unlock()
+	changes, err := c.reallocate()
+	if err != nil {
+		return err
+	}
+
+	// the capacities are changed without holding the lock, pruning may take
+	// a while and must not block the allocations from being read
+	var errs []error
+	for _, change := range changes {
+		c.log.Debug("changing storage capacity", "network", change.name, "from", change.from, "to", change.to, "used", change.used)
+		if err := change.storage.SetCapacity(change.to); err != nil {
+			errs = append(errs, fmt.Errorf("%s storage: %w", change.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// capacityChange is an allocation change that is yet to be applied to the
+// storage of a network.
+type capacityChange struct {
+	name     string
+	storage  BudgetedStorage
+	from, to uint64
+	used     uint64
+}
+
+// reallocate reads the usage of all storages and splits the budget anew. It
+// returns the changed allocations, the shrinking ones first so the budget is
+// never exceeded in between.
+func (c *StorageCoordinator) reallocate() ([]capacityChange, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, n := range c.networks {
+		if n.storage == nil {
+			continue
+		}
+		used, err := n.storage.UsedSize()
+		if err != nil {
+			return nil, fmt.Errorf("%s storage: %w", n.name, err)
+		}
+		n.used = used
+		n.full = n.storage.Radius().Cmp(MaxDistance) < 0 && float64(used) >= float64(n.allocated)*fullFraction
+	}
+	previous := make([]uint64, len(c.networks))
+	for i, n := range c.networks {
+		previous[i] = n.allocated
+	}
+	c.allocate()
+
+	var changes []capacityChange
+	for _, shrink := range []bool{true, false} {
+		for i, n := range c.networks {
+			if n.storage == nil || n.allocated == previous[i] || (n.allocated < previous[i]) != shrink {
+				continue
+			}
+			changes = append(changes, capacityChange{name: n.name, storage: n.storage, from: previous[i], to: n.allocated, used: n.used})
+		}
+	}
+	return changes, nil
+}
+
+// Allocations returns the current allocation of every network.
+func (c *StorageCoordinator) Allocations() []StorageAllocation {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	allocations := make([]StorageAllocation, 0, len(c.networks))
+	for _, n := range c.networks {
+		allocations = append(allocations, StorageAllocation{
+			Network:   n.name,
+			Weight:    n.budget.Weight,
+			Cap:       n.cap(c.total),
+			Allocated: n.allocated,
+			Used:      n.used,
+			Full:      n.full,
+		})
+	}
+	return allocations
+}
+
+// allocate splits the budget by weight, where no network gets more than it
+// demands. A full network demands its cap, any other network its usage and
+// some room to grow. The budget left after every demand is met is split by
+// weight up to the caps.
+func (c *StorageCoordinator) allocate() {
+	var (
+		weights = make([]uint64, len(c.networks))
+		demands = make([]uint64, len(c.networks))
+		room    = make([]uint64, len(c.networks))
+	)
+	for i, n := range c.networks {
+		weights[i] = n.budget.Weight
+		demands[i] = n.cap(c.total)
+		if n.storage != nil && !n.full {
+			demands[i] = min(demands[i], n.used+uint64(float64(c.total)*growthFraction))
+		}
+	}
+	allocated := waterFill(c.total, weights, demands)
+	var sum uint64
+	for i, n := range c.networks {
+		sum += allocated[i]
+		room[i] = n.cap(c.total) - allocated[i]
+	}
+	if sum < c.total {
+		for i, extra := range waterFill(c.total-sum, weights, room) {
+			allocated[i] += extra
+		}
+	}
+	for i, n := range c.networks {
+		n.allocated = allocated[i]
+		if n.allocatedGauge != nil {
+			n.allocatedGauge.Update(int64(n.allocated))
+			n.usedGauge.Update(int64(n.used))
+		}
+	}
+}
+
+// waterFill splits total by weight without giving anyone more than its
+// limit, the share a limited party can't take is split among the others.
+func waterFill(total uint64, weights []uint64, limits []uint64) []uint64 {
+	allocated := make([]uint64, len(weights))
+	active := make([]int, 0, len(weights))
+	for i := range weights {
+		if limits[i] > 0 {
+			active = append(active, i)
+		}
+	}
+	remaining := total
+	for len(active) > 0 && remaining > 0 {
+		var weightSum uint64
+		for _, i := range active {
+			weightSum += weights[i]
+		}
+		// the parties whose limit is within their share get their limit
+		var (
+			round = remaining
+			next  = active[:0:0]
+		)
+		for _, i := range active {
+			share := uint64(float64(round) * float64(weights[i]) / float64(weightSum))
+			if limits[i]-allocated[i] <= share {
+				remaining -= limits[i] - allocated[i]
+				allocated[i] = limits[i]
+			} else {
+				next = append(next, i)
+			}
+		}
+		if len(next) == len(active) {
+			// no one is limited, everyone gets their share
+			for _, i := range active {
+				allocated[i] += uint64(float64(remaining) * float64(weights[i]) / float64(weightSum))
+			}
+			break
+		}
+		active = next
+	}
+	return allocated
+}
+
+func (c *StorageCoordinator) network(name string) *budgetedNetwork {
+	for _, n := range c.networks {
+		if n.name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// API serves the storage allocations in the portal namespace.
+type API struct {
+	coordinator *StorageCoordinator
+}
+
+func NewStorageAPI(coordinator *StorageCoordinator) *API {
+	return &API{coordinator: coordinator}
+}
+
+// StorageAllocations returns the capacity allocated to every network and its
+// usage at the last rebalance.
+func (api *API) StorageAllocations() []StorageAllocation {
+	return api.coordinator.Allocations()
+}
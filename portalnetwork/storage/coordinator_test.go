@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+// budgetedStorage records the capacities set by the coordinator.
+type budgetedStorage struct {
+	NopStorage
+	used       uint64
+	radius     *uint256.Int
+	capacities []uint64
+	// onSetCapacity is called when the capacity is changed
+	onSetCapacity func()
+}
+
+func (s *budgetedStorage) Radius() *uint256.Int {
+	return s.radius
+}
+
+func (s *budgetedStorage) UsedSize() (uint64, error) {
+	return s.used, nil
+}
+
+func (s *budgetedStorage) SetCapacity(capacity uint64) error {
+	s.capacities = append(s.capacities, capacity)
+	s.used = min(s.used, capacity)
+	if s.onSetCapacity != nil {
+		s.onSetCapacity()
+	}
+	return nil
+}
+
+func allocations(c *StorageCoordinator) map[string]uint64 {
+	res := make(map[string]uint64)
+	for _, allocation := range c.Allocations() {
+		res[allocation.Network] = allocation.Allocated
+	}
+	return res
+}
+
+func TestWaterFill(t *testing.T) {
+	require.Equal(t, []uint64{50, 25, 25}, waterFill(100, []uint64{2, 1, 1}, []uint64{100, 100, 100}))
+	// the share a limited party can't take goes to the others
+	require.Equal(t, []uint64{10, 60, 30}, waterFill(100, []uint64{1, 2, 1}, []uint64{10, 100, 100}))
+	require.Equal(t, []uint64{10, 20, 0}, waterFill(100, []uint64{1, 1, 1}, []uint64{10, 20, 0}))
+}
+
+func TestStorageCoordinator(t *testing.T) {
+	c := NewStorageCoordinator(300, map[string]NetworkBudget{
+		"history": {Weight: 2},
+		"state":   {Weight: 1},
+		"beacon":  {CapacityMB: 10},
+	})
+	// the budget is split by weight up to the caps
+	require.Equal(t, uint64(10), c.CapacityMB("beacon"))
+	require.Equal(t, uint64(193), c.CapacityMB("history"))
+	require.Equal(t, uint64(96), c.CapacityMB("state"))
+	require.Zero(t, c.CapacityMB("unknown"))
+
+	history := &budgetedStorage{radius: MaxDistance}
+	state := &budgetedStorage{radius: MaxDistance}
+	beacon := &budgetedStorage{radius: MaxDistance, used: 1 * BytesInMB}
+	require.NoError(t, c.Attach("history", history))
+	require.NoError(t, c.Attach("state", state))
+	require.NoError(t, c.Attach("beacon", beacon))
+	require.ErrorIs(t, c.Attach("unknown", history), ErrUnknownNetwork)
+
+	// networks with room split the budget by weight
+	require.NoError(t, c.Rebalance())
+	alloc := allocations(c)
+	require.Equal(t, uint64(10*BytesInMB), alloc["beacon"])
+	require.InDelta(t, 2*(alloc["state"]-15*BytesInMB), alloc["history"]-15*BytesInMB, 2)
+	require.Empty(t, beacon.capacities)
+
+	// a full network gets the capacity the others don't use
+	history.used, history.radius = 193*BytesInMB, uint256.NewInt(1000)
+	state.used = 20 * BytesInMB
+	require.NoError(t, c.Rebalance())
+	alloc = allocations(c)
+	require.Equal(t, uint64(20*BytesInMB+15*BytesInMB), alloc["state"])
+	require.Equal(t, uint64(300*BytesInMB)-alloc["state"]-alloc["beacon"], alloc["history"])
+	require.Equal(t, alloc["history"], history.capacities[len(history.capacities)-1])
+	require.Equal(t, alloc["state"], state.capacities[len(state.capacities)-1])
+
+	// and gives it back once another network fills up
+	state.used, state.radius = alloc["state"], uint256.NewInt(1000)
+	require.NoError(t, c.Rebalance())
+	alloc = allocations(c)
+	require.Equal(t, uint64(10*BytesInMB), alloc["beacon"])
+	require.InDelta(t, 2*alloc["state"], alloc["history"], 2)
+	var total uint64
+	for _, allocated := range alloc {
+		total += allocated
+	}
+	require.LessOrEqual(t, total, uint64(300*BytesInMB))
+
+	// the allocations can be read while a storage is pruned
+	state.used, state.radius = 0, MaxDistance
+	state.onSetCapacity = func() { allocations(c) }
+	require.NoError(t, c.Rebalance())
+	require.NotEmpty(t, state.capacities)
+}
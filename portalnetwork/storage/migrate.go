@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var ErrSchemaTooNew = errors.New("database schema is newer than supported")
+
+// Migration upgrades the schema of a database by one version.
+type Migration struct {
+	Description string
+	Apply       func(tx *sql.Tx) error
+}
+
+// SchemaVersion returns the schema version of the database, zero for a new
+// database or one created before its schema was versioned.
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version;").Scan(&version)
+	return version, err
+}
+
+// Migrate brings the schema of the database up to the version of the last
+// migration, the first migration upgrades version 0 to 1. The version is
+// kept in the user_version of the database. Every migration runs in a
+// transaction together with the version update, so a failed migration leaves
+// the database at the version before it. A database of a newer version than
+// the migrations is refused.
+//
+// Databases created before their schema was versioned have version 0, the
+// first migration of a database has to accept the tables it creates to exist
+// already.
+func Migrate(db *sql.DB, name string, migrations []Migration) error {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("%w: the %s database has version %d, the newest supported version is %d", ErrSchemaTooNew, name, version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		migration := migrations[version]
+		if err = migrate(db, version+1, migration); err != nil {
+			return fmt.Errorf("%s database migration to version %d (%s): %w", name, version+1, migration.Description, err)
+		}
+		log.Debug("Migrated database schema", "database", name, "version", version+1, "migration", migration.Description)
+	}
+	return nil
+}
+
+func migrate(db *sql.DB, version int, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = migration.Apply(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", version)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExecMigration returns a migration that executes the statements.
+func ExecMigration(description string, statements ...string) Migration {
+	return Migration{
+		Description: description,
+		Apply: func(tx *sql.Tx) error {
+			for _, statement := range statements {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// HasColumn reports whether the table has the column.
+func HasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?1) WHERE name = ?2;", table, column).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+var testMigrations = []Migration{
+	ExecMigration("create the kv table", "CREATE TABLE IF NOT EXISTS kv (key BLOB PRIMARY KEY, value BLOB);"),
+	{Description: "add the note column", Apply: func(tx *sql.Tx) error {
+		hasColumn, err := HasColumn(tx, "kv", "note")
+		if err != nil || hasColumn {
+			return err
+		}
+		_, err = tx.Exec("ALTER TABLE kv ADD COLUMN note TEXT;")
+		return err
+	}},
+	ExecMigration("add the size column", "ALTER TABLE kv ADD COLUMN size INTEGER;", "UPDATE kv SET size = length(value);"),
+}
+
+// openFixture opens a copy of a fixture database, or a new database if the
+// fixture is empty.
+func openFixture(t *testing.T, fixture string) *sql.DB {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	if fixture != "" {
+		data, err := os.ReadFile(filepath.Join("testdata", fixture))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data, 0644))
+	}
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func requireVersion(t *testing.T, db *sql.DB, expected int) {
+	version, err := SchemaVersion(db)
+	require.NoError(t, err)
+	require.Equal(t, expected, version)
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	db := openFixture(t, "")
+	requireVersion(t, db, 0)
+	require.NoError(t, Migrate(db, "test", testMigrations))
+	requireVersion(t, db, 3)
+
+	_, err := db.Exec("INSERT INTO kv (key, value, note, size) VALUES (x'01', x'aabb', 'note', 2);")
+	require.NoError(t, err)
+	// a migrated database is left alone
+	require.NoError(t, Migrate(db, "test", testMigrations))
+	requireVersion(t, db, 3)
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	// the fixture has the kv table of the first migration but no version
+	db := openFixture(t, "unversioned.sqlite")
+	requireVersion(t, db, 0)
+	require.NoError(t, Migrate(db, "test", testMigrations[:2]))
+	requireVersion(t, db, 2)
+	require.NoError(t, Migrate(db, "test", testMigrations))
+	requireVersion(t, db, 3)
+
+	var (
+		value []byte
+		size  int
+	)
+	require.NoError(t, db.QueryRow("SELECT value, size FROM kv WHERE key = x'01';").Scan(&value, &size))
+	require.Equal(t, []byte{0xaa, 0xbb}, value)
+	require.Equal(t, 2, size)
+}
+
+func TestMigrateFailure(t *testing.T) {
+	db := openFixture(t, "unversioned.sqlite")
+	errFailed := errors.New("failed")
+	migrations := append(testMigrations[:1:1], Migration{
+		Description: "fail after a change",
+		Apply: func(tx *sql.Tx) error {
+			if _, err := tx.Exec("ALTER TABLE kv ADD COLUMN note TEXT;"); err != nil {
+				return err
+			}
+			return errFailed
+		},
+	})
+	err := Migrate(db, "test", migrations)
+	require.ErrorIs(t, err, errFailed)
+	// the first migration is kept, the failed one is rolled back
+	requireVersion(t, db, 1)
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('kv') WHERE name = 'note';").Scan(&count))
+	require.Equal(t, 0, count)
+
+	require.NoError(t, Migrate(db, "test", testMigrations))
+	requireVersion(t, db, 3)
+}
+
+func TestMigrateNewerDatabase(t *testing.T) {
+	// the fixture has version 3
+	db := openFixture(t, "newer.sqlite")
+	err := Migrate(db, "test", testMigrations[:2])
+	require.ErrorIs(t, err, ErrSchemaTooNew)
+	requireVersion(t, db, 3)
+
+	require.NoError(t, Migrate(db, "test", testMigrations))
+	requireVersion(t, db, 3)
+}
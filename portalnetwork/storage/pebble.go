@@ -0,0 +1,484 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/pebble"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/holiman/uint256"
+)
+
+const (
+	// Storage backends selectable with --storage.backend.
+	BackendSqlite = "sqlite"
+	BackendPebble = "pebble"
+
+	pebbleDirName           = "pebble"
+	pebbleCache             = 16 // MB
+	pebbleHandles           = 64
+	contentDeletionFraction = 0.05 // 5% of the content is deleted when the capacity is hit.
+)
+
+var (
+	pebbleContentPrefix = []byte("c")
+	pebbleContentEnd    = []byte("d")
+	pebbleIndexPrefix   = []byte("k") // content keys by the distance of their content
+	pebbleIndexEnd      = []byte("l")
+	pebbleRadiusKey     = []byte("m-radius")
+	pebbleUsageKey      = []byte("m-usage")
+)
+
+var (
+	_ ContentStorage = &PebbleStorage{}
+	_ ContentIndex   = &PebbleStorage{}
+	_ ContentDeleter = &PebbleStorage{}
+)
+
+// PebbleStorage is a ContentStorage on a pebble key-value store. Content is
+// keyed by its distance to the node, contentId XOR nodeId, with all bits
+// inverted so that iterating the keys visits the farthest content first.
+// Pruning then only reads the head of the key space and the content out of a
+// radius is one range deletion. The content keys are kept under the same
+// distance in a separate key range. The number of items and their size, which
+// doesn't include the content keys, are kept next to the content and updated
+// in the same batch.
+type PebbleStorage struct {
+	db      ethdb.KeyValueStore
+	nodeId  enode.ID
+	radius  atomic.Pointer[uint256.Int]
+	metrics *metrics.PortalStorageMetrics
+	log     log.Logger
+
+	lock     sync.Mutex // protects the usage and the capacity
+	capacity uint64
+	count    uint64
+	size     uint64
+}
+
+// NewPebbleDB opens the pebble database of the network in the data directory.
+func NewPebbleDB(dataDir string, network string) (ethdb.KeyValueStore, error) {
+	return pebble.New(path.Join(dataDir, network, pebbleDirName), pebbleCache, pebbleHandles, "", false)
+}
+
+func NewPebbleStorage(config PortalStorageConfig) (*PebbleStorage, error) {
+	if config.KVStore == nil {
+		return nil, errors.New("pebble storage requires a key-value store")
+	}
+	s := &PebbleStorage{
+		db:       config.KVStore,
+		nodeId:   config.NodeId,
+		capacity: config.StorageCapacityMB * 1000000,
+		log:      log.New("storage", config.NetworkName, "backend", BackendPebble),
+	}
+	if err := s.loadRadius(); err != nil {
+		return nil, err
+	}
+	if err := s.loadUsage(); err != nil {
+		return nil, err
+	}
+	// the metrics are only registered for the history network, tests use other names
+	if metrics.Enabled && strings.ToLower(config.NetworkName) == "history" {
+		network := strings.ToLower(config.NetworkName)
+		s.metrics = &metrics.PortalStorageMetrics{
+			RadiusRatio:         metrics.GetOrRegisterGaugeFloat64("portal/"+network+"/radius_ratio", nil),
+			EntriesCount:        metrics.GetOrRegisterGauge("portal/"+network+"/entry_count", nil),
+			ContentStorageUsage: metrics.GetOrRegisterGauge("portal/"+network+"/content_storage", nil),
+		}
+		s.updateMetrics()
+	}
+	return s, nil
+}
+
+func (s *PebbleStorage) Get(contentKey []byte, contentId []byte) ([]byte, error) {
+	s.log.Trace("get content", "contentKey", hexutil.Encode(contentKey), "contentId", hexutil.Encode(contentId))
+	key := prefixed(pebbleContentPrefix, s.distanceKey(contentId))
+	if has, err := s.db.Has(key); err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrContentNotFound
+	}
+	return s.db.Get(key)
+}
+
+// Put stores the content and prunes the farthest content once the capacity
+// is exceeded. The content is stored and pruned under the same lock, so
+// concurrent writes don't overshoot the capacity before it is pruned.
+func (s *PebbleStorage) Put(contentKey []byte, contentId []byte, content []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.putBatch([][]byte{contentKey}, [][]byte{contentId}, [][]byte{content}); err != nil {
+		return err
+	}
+	if s.size > s.capacity {
+		count, err := s.deleteContentFraction(contentDeletionFraction)
+		if err != nil {
+			s.log.Warn("failed to prune storage", "err", err)
+			return err
+		}
+		s.log.Debug("pruned storage", "count", count, "radius", s.Radius().Hex())
+	}
+	return nil
+}
+
+// PutBatch stores the contents in a single batch. Unlike Put it doesn't prune
+// the storage, so callers have to keep the content within the radius.
+func (s *PebbleStorage) PutBatch(contentKeys [][]byte, contentIds [][]byte, contents [][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.putBatch(contentKeys, contentIds, contents)
+}
+
+func (s *PebbleStorage) putBatch(contentKeys [][]byte, contentIds [][]byte, contents [][]byte) error {
+	count, size := s.count, s.size
+	batch := s.db.NewBatch()
+	for i, contentId := range contentIds {
+		distance := s.distanceKey(contentId)
+		key := prefixed(pebbleContentPrefix, distance)
+		if len(contentKeys[i]) != 0 {
+			if err := batch.Put(prefixed(pebbleIndexPrefix, distance), contentKeys[i]); err != nil {
+				return err
+			}
+		}
+		if old, err := s.get(key); err != nil {
+			return err
+		} else if old != nil {
+			count--
+			size -= entrySize(key, old)
+		}
+		if err := batch.Put(key, contents[i]); err != nil {
+			return err
+		}
+		count++
+		size += entrySize(key, contents[i])
+	}
+	if err := s.writeUsage(batch, count, size); err != nil {
+		return err
+	}
+	s.count, s.size = count, size
+	s.updateMetrics()
+	return nil
+}
+
+// Delete deletes the content and its content key.
+func (s *PebbleStorage) Delete(contentKey []byte, contentId []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	distance := s.distanceKey(contentId)
+	key := prefixed(pebbleContentPrefix, distance)
+	old, err := s.get(key)
+	if err != nil || old == nil {
+		return err
+	}
+	batch := s.db.NewBatch()
+	if err = batch.Delete(key); err != nil {
+		return err
+	}
+	if err = batch.Delete(prefixed(pebbleIndexPrefix, distance)); err != nil {
+		return err
+	}
+	count, size := s.count-1, s.size-entrySize(key, old)
+	if err = s.writeUsage(batch, count, size); err != nil {
+		return err
+	}
+	s.count, s.size = count, size
+	s.updateMetrics()
+	return nil
+}
+
+func (s *PebbleStorage) Radius() *uint256.Int {
+	return s.radius.Load()
+}
+
+// ForcePrune deletes the content farther than the radius and sets it as the
+// new radius.
+func (s *PebbleStorage) ForcePrune(radius *uint256.Int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.deleteBefore(invertedDistance(radius))
+	if err != nil {
+		return err
+	}
+	return s.storeRadius(radius)
+}
+
+// ContentCount returns the number of stored items.
+func (s *PebbleStorage) ContentCount() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.count
+}
+
+// UsedSize returns the size of the stored keys and content in bytes.
+func (s *PebbleStorage) UsedSize() (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size, nil
+}
+
+// Capacity returns the capacity of the storage in bytes.
+func (s *PebbleStorage) Capacity() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.capacity
+}
+
+// SetCapacity changes the capacity of the storage, the farthest content is
+// deleted until the used size fits into it.
+func (s *PebbleStorage) SetCapacity(capacity uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.capacity = capacity
+	if s.size <= capacity {
+		return nil
+	}
+	fraction := max(contentDeletionFraction, float64(s.size-capacity)/float64(s.size))
+	_, err := s.deleteContentFraction(fraction)
+	return err
+}
+
+// LocalContentKeys returns a page of the stored content keys ordered from the
+// farthest content on, only keys of the content type if it is set.
+func (s *PebbleStorage) LocalContentKeys(offset, limit uint64, contentType *byte) ([][]byte, error) {
+	it := s.db.NewIterator(pebbleIndexPrefix, nil)
+	defer it.Release()
+	keys := make([][]byte, 0, limit)
+	for uint64(len(keys)) < limit && it.Next() {
+		if contentType != nil && it.Value()[0] != *contentType {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		keys = append(keys, append([]byte{}, it.Value()...))
+	}
+	return keys, it.Error()
+}
+
+// Stats returns the number and size of the stored content by content type.
+// The content and the content keys are iterated side by side, as both are
+// ordered by distance.
+func (s *PebbleStorage) Stats() (*StorageStats, error) {
+	stats := &StorageStats{
+		ContentTypes:     make(map[byte]ContentTypeStats),
+		FarthestDistance: uint256.NewInt(0),
+		Radius:           s.Radius(),
+	}
+	it := s.db.NewIterator(pebbleContentPrefix, nil)
+	defer it.Release()
+	index := s.db.NewIterator(pebbleIndexPrefix, nil)
+	defer index.Release()
+	indexed := index.Next()
+	for first := true; it.Next(); first = false {
+		distance := it.Key()[len(pebbleContentPrefix):]
+		if first {
+			stats.FarthestDistance.SetBytes(invert(distance))
+		}
+		for indexed && bytes.Compare(index.Key()[len(pebbleIndexPrefix):], distance) < 0 {
+			indexed = index.Next()
+		}
+		if indexed && bytes.Equal(index.Key()[len(pebbleIndexPrefix):], distance) {
+			contentType := index.Value()[0]
+			typeStats := stats.ContentTypes[contentType]
+			typeStats.Count++
+			typeStats.Size += uint64(len(it.Value()))
+			stats.ContentTypes[contentType] = typeStats
+		} else {
+			stats.Unindexed.Count++
+			stats.Unindexed.Size += uint64(len(it.Value()))
+		}
+	}
+	if err := index.Error(); err != nil {
+		return nil, err
+	}
+	return stats, it.Error()
+}
+
+func (s *PebbleStorage) Close() error {
+	return s.db.Close()
+}
+
+// deleteContentFraction deletes the farthest content until the fraction of
+// the used size is freed, the distance of the farthest remaining content
+// becomes the radius.
+func (s *PebbleStorage) deleteContentFraction(fraction float64) (int, error) {
+	bytesToDelete := uint64(fraction * float64(s.size))
+	it := s.db.NewIterator(pebbleContentPrefix, nil)
+	var (
+		deleteBytes uint64
+		end         []byte
+	)
+	for it.Next() {
+		if deleteBytes >= bytesToDelete {
+			end = append([]byte{}, it.Key()[len(pebbleContentPrefix):]...)
+			break
+		}
+		deleteBytes += entrySize(it.Key(), it.Value())
+	}
+	err := it.Error()
+	it.Release()
+	if err != nil {
+		return 0, err
+	}
+	if end == nil {
+		// nothing remains, so no content is within the radius anymore
+		count, err := s.deleteBefore(nil)
+		if err != nil {
+			return count, err
+		}
+		return count, s.storeRadius(uint256.NewInt(0))
+	}
+	count, err := s.deleteBefore(end)
+	if err != nil {
+		return count, err
+	}
+	return count, s.storeRadius(new(uint256.Int).SetBytes(invert(end)))
+}
+
+// deleteBefore deletes the content before the inverted distance end, which is
+// the content farther than the distance, or all content if end is nil.
+func (s *PebbleStorage) deleteBefore(end []byte) (int, error) {
+	contentEnd, indexEnd := pebbleContentEnd, pebbleIndexEnd
+	if end != nil {
+		contentEnd, indexEnd = prefixed(pebbleContentPrefix, end), prefixed(pebbleIndexPrefix, end)
+	}
+	it := s.db.NewIterator(pebbleContentPrefix, nil)
+	count, size := 0, uint64(0)
+	for it.Next() && bytes.Compare(it.Key(), contentEnd) < 0 {
+		count++
+		size += entrySize(it.Key(), it.Value())
+	}
+	err := it.Error()
+	it.Release()
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	if err = s.db.DeleteRange(pebbleContentPrefix, contentEnd); err != nil {
+		return 0, err
+	}
+	if err = s.db.DeleteRange(pebbleIndexPrefix, indexEnd); err != nil {
+		return 0, err
+	}
+	if err = s.writeUsage(s.db.NewBatch(), s.count-uint64(count), s.size-size); err != nil {
+		return 0, err
+	}
+	s.count -= uint64(count)
+	s.size -= size
+	s.updateMetrics()
+	s.log.Trace("delete items", "count", count)
+	return count, nil
+}
+
+func (s *PebbleStorage) get(key []byte) ([]byte, error) {
+	if has, err := s.db.Has(key); err != nil || !has {
+		return nil, err
+	}
+	return s.db.Get(key)
+}
+
+// distanceKey returns the inverted distance of the content to the node, which
+// the content and its content key are stored under.
+func (s *PebbleStorage) distanceKey(contentId []byte) []byte {
+	// length of contentId maybe not 32bytes
+	padded := make([]byte, 32)
+	copy(padded, contentId)
+	key := make([]byte, 32)
+	for i := range padded {
+		key[i] = ^(padded[i] ^ s.nodeId[i])
+	}
+	return key
+}
+
+func prefixed(prefix, key []byte) []byte {
+	return append(append(make([]byte, 0, len(prefix)+len(key)), prefix...), key...)
+}
+
+func invertedDistance(distance *uint256.Int) []byte {
+	bytes32 := distance.Bytes32()
+	return invert(bytes32[:])
+}
+
+func invert(b []byte) []byte {
+	inverted := make([]byte, len(b))
+	for i := range b {
+		inverted[i] = ^b[i]
+	}
+	return inverted
+}
+
+func entrySize(key, value []byte) uint64 {
+	return uint64(len(key) + len(value))
+}
+
+func (s *PebbleStorage) writeUsage(batch ethdb.Batch, count, size uint64) error {
+	usage := make([]byte, 16)
+	binary.BigEndian.PutUint64(usage, count)
+	binary.BigEndian.PutUint64(usage[8:], size)
+	if err := batch.Put(pebbleUsageKey, usage); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// loadUsage reads the stored usage, or counts the content of a store that
+// has none.
+func (s *PebbleStorage) loadUsage() error {
+	usage, err := s.get(pebbleUsageKey)
+	if err != nil {
+		return err
+	}
+	if len(usage) == 16 {
+		s.count = binary.BigEndian.Uint64(usage)
+		s.size = binary.BigEndian.Uint64(usage[8:])
+		return nil
+	}
+	it := s.db.NewIterator(pebbleContentPrefix, nil)
+	defer it.Release()
+	for it.Next() {
+		s.count++
+		s.size += entrySize(it.Key(), it.Value())
+	}
+	return it.Error()
+}
+
+func (s *PebbleStorage) loadRadius() error {
+	radius, err := s.get(pebbleRadiusKey)
+	if err != nil {
+		return err
+	}
+	if radius == nil {
+		s.radius.Store(MaxDistance)
+		return nil
+	}
+	s.radius.Store(new(uint256.Int).SetBytes(radius))
+	return nil
+}
+
+func (s *PebbleStorage) storeRadius(radius *uint256.Int) error {
+	if err := s.db.Put(pebbleRadiusKey, radius.Bytes()); err != nil {
+		return err
+	}
+	s.radius.Store(radius)
+	s.updateMetrics()
+	return nil
+}
+
+func (s *PebbleStorage) updateMetrics() {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.EntriesCount.Update(int64(s.count))
+	s.metrics.ContentStorageUsage.Update(int64(s.size))
+	s.metrics.RadiusRatio.Update(s.Radius().Float64() / MaxDistance.Float64())
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func newPebbleStorage(t *testing.T, dir string, capacityMB uint64) *PebbleStorage {
+	db, err := NewPebbleDB(dir, "history")
+	require.NoError(t, err)
+	s, err := NewPebbleStorage(PortalStorageConfig{
+		StorageCapacityMB: capacityMB,
+		KVStore:           db,
+		NodeId:            enode.ID{},
+		NetworkName:       "test",
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func usedSize(t *testing.T, s *PebbleStorage) uint64 {
+	size, err := s.UsedSize()
+	require.NoError(t, err)
+	return size
+}
+
+// contentIdAt returns a content id at the distance of n 1/256th of the id
+// space from the zero node id.
+func contentIdAt(n byte) []byte {
+	id := make([]byte, 32)
+	id[0] = n
+	id[31] = 1
+	return id
+}
+
+func TestPebbleStorage(t *testing.T) {
+	dir := t.TempDir()
+	s := newPebbleStorage(t, dir, 10)
+
+	_, err := s.Get(nil, contentIdAt(1))
+	require.ErrorIs(t, err, ErrContentNotFound)
+	require.NoError(t, s.Put(nil, contentIdAt(1), []byte("value")))
+	content, err := s.Get(nil, contentIdAt(1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), content)
+
+	// replacing content only changes the size
+	require.NoError(t, s.Put(nil, contentIdAt(1), []byte("other value")))
+	require.NoError(t, s.Put(nil, contentIdAt(2), []byte("value")))
+	require.Equal(t, uint64(2), s.ContentCount())
+	require.Equal(t, uint64(2*33+len("other value")+len("value")), usedSize(t, s))
+	require.Equal(t, MaxDistance, s.Radius())
+
+	// the usage and radius survive a restart
+	require.NoError(t, s.ForcePrune(new(uint256.Int).SetBytes(contentIdAt(1))))
+	_, err = s.Get(nil, contentIdAt(2))
+	require.ErrorIs(t, err, ErrContentNotFound)
+	require.NoError(t, s.Close())
+	s = newPebbleStorage(t, dir, 10)
+	defer s.Close()
+	require.Equal(t, uint64(1), s.ContentCount())
+	require.Equal(t, uint64(33+len("other value")), usedSize(t, s))
+	require.Equal(t, new(uint256.Int).SetBytes(contentIdAt(1)), s.Radius())
+}
+
+func TestPebbleStoragePruning(t *testing.T) {
+	s := newPebbleStorage(t, t.TempDir(), 1)
+	defer s.Close()
+
+	content := make([]byte, 32*1024)
+	for i := 39; i >= 0; i-- {
+		require.NoError(t, s.Put(nil, contentIdAt(byte(i)), content))
+	}
+	require.LessOrEqual(t, usedSize(t, s), uint64(1000000))
+	require.Less(t, s.Radius().Cmp(MaxDistance), 0)
+
+	// the farthest content is gone and the rest is within the radius
+	kept := 0
+	for i := 0; i < 40; i++ {
+		_, err := s.Get(nil, contentIdAt(byte(i)))
+		distance := new(uint256.Int).SetBytes(contentIdAt(byte(i)))
+		if err == nil {
+			kept++
+			require.LessOrEqual(t, distance.Cmp(s.Radius()), 0)
+		} else {
+			require.ErrorIs(t, err, ErrContentNotFound)
+			require.Greater(t, distance.Cmp(s.Radius()), 0)
+		}
+	}
+	require.Equal(t, uint64(kept), s.ContentCount())
+	_, err := s.Get(nil, contentIdAt(0))
+	require.NoError(t, err)
+
+	// a smaller capacity deletes more of the farthest content
+	radius := s.Radius()
+	require.NoError(t, s.SetCapacity(500000))
+	require.LessOrEqual(t, usedSize(t, s), uint64(500000))
+	require.Equal(t, -1, s.Radius().Cmp(radius))
+
+	require.NoError(t, s.ForcePrune(uint256.NewInt(0)))
+	require.Zero(t, s.ContentCount())
+	require.Zero(t, usedSize(t, s))
+}
+
+func TestPebbleStorageConcurrentPuts(t *testing.T) {
+	s := newPebbleStorage(t, t.TempDir(), 1)
+	defer s.Close()
+
+	// the content is pruned under the lock it is stored with, so the capacity
+	// is never seen exceeded
+	content := make([]byte, 32*1024)
+	var g errgroup.Group
+	for writer := 0; writer < 4; writer++ {
+		g.Go(func() error {
+			for i := 0; i < 40; i++ {
+				contentId := contentIdAt(byte(i))
+				contentId[30] = byte(writer)
+				if err := s.Put(nil, contentId, content); err != nil {
+					return err
+				}
+				if size, _ := s.UsedSize(); size > 1000000 {
+					return fmt.Errorf("used size %d exceeds the capacity", size)
+				}
+			}
+			return nil
+		})
+	}
+	require.NoError(t, g.Wait())
+	require.Less(t, s.Radius().Cmp(MaxDistance), 0)
+}
+
+func TestPebbleContentKeys(t *testing.T) {
+	s := newPebbleStorage(t, t.TempDir(), 10)
+	defer s.Close()
+
+	// content keys are listed from the farthest content on
+	require.NoError(t, s.Put(nil, contentIdAt(9), []byte("unindexed")))
+	for i := byte(1); i <= 4; i++ {
+		require.NoError(t, s.Put([]byte{i % 2, i}, contentIdAt(i), make([]byte, i)))
+	}
+	keys, err := s.LocalContentKeys(0, 3, nil)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0, 4}, {1, 3}, {0, 2}}, keys)
+	keys, err = s.LocalContentKeys(3, 3, nil)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{1, 1}}, keys)
+	contentType := byte(1)
+	keys, err = s.LocalContentKeys(1, 3, &contentType)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{1, 1}}, keys)
+
+	stats, err := s.Stats()
+	require.NoError(t, err)
+	require.Equal(t, map[byte]ContentTypeStats{0: {Count: 2, Size: 6}, 1: {Count: 2, Size: 4}}, stats.ContentTypes)
+	require.Equal(t, ContentTypeStats{Count: 1, Size: uint64(len("unindexed"))}, stats.Unindexed)
+	require.Equal(t, new(uint256.Int).SetBytes(contentIdAt(9)), stats.FarthestDistance)
+
+	// pruned content is no longer listed
+	require.NoError(t, s.ForcePrune(new(uint256.Int).SetBytes(contentIdAt(2))))
+	keys, err = s.LocalContentKeys(0, 10, nil)
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0, 2}, {1, 1}}, keys)
+}
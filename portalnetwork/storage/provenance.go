@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/holiman/uint256"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	provenanceSqliteName = "provenance.sqlite"
+	// SQLite Statements
+	createProvenanceSql = `CREATE TABLE IF NOT EXISTS provenance (
+		content_key BLOB PRIMARY KEY,
+		source TEXT NOT NULL,
+		node_id BLOB,
+		received INTEGER NOT NULL,
+		validation_version INTEGER NOT NULL,
+		distance BLOB NOT NULL
+	);`
+	createProvenanceNodeIndexSql     = "CREATE INDEX IF NOT EXISTS provenance_node_id ON provenance (node_id);"
+	createProvenanceDistanceIndexSql = "CREATE INDEX IF NOT EXISTS provenance_distance ON provenance (distance);"
+	putProvenanceSql                 = `INSERT OR REPLACE INTO provenance (content_key, source, node_id, received, validation_version, distance)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6);`
+	getProvenanceSql    = "SELECT source, node_id, received, validation_version FROM provenance WHERE content_key = (?1);"
+	contentKeysFromSql  = "SELECT content_key FROM provenance WHERE node_id = (?1) LIMIT (?2);"
+	deleteProvenanceSql = "DELETE FROM provenance WHERE content_key = (?1);"
+	pruneProvenanceSql  = "DELETE FROM provenance WHERE distance > (?1);"
+)
+
+var provenanceMigrations = []Migration{
+	ExecMigration("create the provenance table", createProvenanceSql, createProvenanceNodeIndexSql, createProvenanceDistanceIndexSql),
+}
+
+// contentDistance is the distance of the content id to the node as stored,
+// 32 big endian bytes.
+func contentDistance(contentId []byte, nodeId enode.ID) []byte {
+	distance := new(uint256.Int).Xor(new(uint256.Int).SetBytes(contentId), new(uint256.Int).SetBytes(nodeId[:])).Bytes32()
+	return distance[:]
+}
+
+// ContentSource is how the content reached the storage.
+type ContentSource string
+
+const (
+	// SourceLocal is content stored by the node itself, over the RPC or by a
+	// bridge.
+	SourceLocal ContentSource = "local"
+	// SourceOffer is content accepted from an OFFER of the node.
+	SourceOffer ContentSource = "offer"
+	// SourceLookup is content found in a lookup, returned by the node.
+	SourceLookup ContentSource = "lookup"
+)
+
+// Provenance records where stored content came from. ValidationVersion is the
+// version of the validation rules the content passed, zero if the content was
+// stored without validation.
+type Provenance struct {
+	Source            ContentSource
+	Node              enode.ID
+	Received          time.Time
+	ValidationVersion uint64
+}
+
+// ValidationVersion is recorded in the provenance of the validated content, it
+// is raised when the validation rules of a network change.
+const ValidationVersion = 1
+
+// ValidatedProvenance is the provenance of content that passed the validation.
+func ValidatedProvenance(source ContentSource, node enode.ID) Provenance {
+	return Provenance{Source: source, Node: node, ValidationVersion: ValidationVersion}
+}
+
+// LocalProvenance is the provenance of the content the node stored itself.
+func LocalProvenance() Provenance {
+	return Provenance{Source: SourceLocal}
+}
+
+// ProvenanceStore keeps the provenance of the stored content of a network in
+// a side table, by content key. The distance of the content to the node is
+// recorded as well, so the provenance of the content a storage pruned is
+// dropped with Prune.
+type ProvenanceStore struct {
+	db     *sql.DB
+	nodeId enode.ID
+}
+
+// OpenProvenanceStore opens the provenance database of the node in the
+// directory of the network.
+func OpenProvenanceStore(dataDir string, network string, nodeId enode.ID) (*ProvenanceStore, error) {
+	dir := filepath.Join(dataDir, network)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, provenanceSqliteName))
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewProvenanceStore(db, nodeId)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func NewProvenanceStore(db *sql.DB, nodeId enode.ID) (*ProvenanceStore, error) {
+	if err := Migrate(db, "provenance", provenanceMigrations); err != nil {
+		return nil, err
+	}
+	return &ProvenanceStore{db: db, nodeId: nodeId}, nil
+}
+
+// Put records the provenance of the content, replacing the provenance of an
+// earlier copy. A zero receive time is the current time.
+func (s *ProvenanceStore) Put(contentKey []byte, contentId []byte, provenance Provenance) error {
+	if provenance.Received.IsZero() {
+		provenance.Received = time.Now()
+	}
+	var node []byte
+	if provenance.Source != SourceLocal {
+		node = provenance.Node[:]
+	}
+	_, err := s.db.Exec(putProvenanceSql, contentKey, string(provenance.Source), node, provenance.Received.UnixMilli(), provenance.ValidationVersion, contentDistance(contentId, s.nodeId))
+	return err
+}
+
+// PutBatch records the same provenance for all contents in a single
+// transaction, for imports of many items.
+func (s *ProvenanceStore) PutBatch(contentKeys [][]byte, contentIds [][]byte, provenance Provenance) error {
+	if provenance.Received.IsZero() {
+		provenance.Received = time.Now()
+	}
+	var node []byte
+	if provenance.Source != SourceLocal {
+		node = provenance.Node[:]
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for i, contentKey := range contentKeys {
+		if _, err = tx.Exec(putProvenanceSql, contentKey, string(provenance.Source), node, provenance.Received.UnixMilli(), provenance.ValidationVersion, contentDistance(contentIds[i], s.nodeId)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Get returns the provenance of the content, ErrContentNotFound if none is
+// recorded.
+func (s *ProvenanceStore) Get(contentKey []byte) (*Provenance, error) {
+	var (
+		source   string
+		node     []byte
+		received int64
+		res      Provenance
+	)
+	err := s.db.QueryRow(getProvenanceSql, contentKey).Scan(&source, &node, &received, &res.ValidationVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrContentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Source = ContentSource(source)
+	copy(res.Node[:], node)
+	res.Received = time.UnixMilli(received)
+	return &res, nil
+}
+
+// ContentKeysFrom returns up to limit keys of the content received from the
+// node.
+func (s *ProvenanceStore) ContentKeysFrom(node enode.ID, limit uint64) ([][]byte, error) {
+	rows, err := s.db.Query(contentKeysFromSql, node[:], limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var contentKeys [][]byte
+	for rows.Next() {
+		var contentKey []byte
+		if err = rows.Scan(&contentKey); err != nil {
+			return nil, err
+		}
+		contentKeys = append(contentKeys, contentKey)
+	}
+	return contentKeys, rows.Err()
+}
+
+// Delete removes the provenance of the content.
+func (s *ProvenanceStore) Delete(contentKey []byte) error {
+	_, err := s.db.Exec(deleteProvenanceSql, contentKey)
+	return err
+}
+
+// Prune removes the provenance of the content farther than radius, the
+// content a storage pruned to the radius.
+func (s *ProvenanceStore) Prune(radius *uint256.Int) error {
+	distance := radius.Bytes32()
+	_, err := s.db.Exec(pruneProvenanceSql, distance[:])
+	return err
+}
+
+func (s *ProvenanceStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvenanceStore(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := OpenProvenanceStore(dataDir, "history", enode.ID{})
+	require.NoError(t, err)
+
+	nodeA, nodeB := enode.ID{0xa}, enode.ID{0xb}
+	received := time.UnixMilli(time.Now().UnixMilli())
+	require.NoError(t, store.Put([]byte{1}, []byte{1}, Provenance{Source: SourceOffer, Node: nodeA, Received: received, ValidationVersion: 1}))
+	require.NoError(t, store.Put([]byte{2}, []byte{2}, Provenance{Source: SourceLookup, Node: nodeB, ValidationVersion: 1}))
+	require.NoError(t, store.Put([]byte{3}, []byte{3}, Provenance{Source: SourceLookup, Node: nodeA, ValidationVersion: 2}))
+	// the node of local content isn't recorded
+	require.NoError(t, store.Put([]byte{4}, []byte{4}, Provenance{Source: SourceLocal, Node: nodeA}))
+
+	provenance, err := store.Get([]byte{1})
+	require.NoError(t, err)
+	require.Equal(t, &Provenance{Source: SourceOffer, Node: nodeA, Received: received, ValidationVersion: 1}, provenance)
+	provenance, err = store.Get([]byte{4})
+	require.NoError(t, err)
+	require.Equal(t, SourceLocal, provenance.Source)
+	require.Equal(t, enode.ID{}, provenance.Node)
+	require.False(t, provenance.Received.IsZero())
+	_, err = store.Get([]byte{5})
+	require.ErrorIs(t, err, ErrContentNotFound)
+
+	contentKeys, err := store.ContentKeysFrom(nodeA, 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, [][]byte{{1}, {3}}, contentKeys)
+	contentKeys, err = store.ContentKeysFrom(nodeA, 1)
+	require.NoError(t, err)
+	require.Len(t, contentKeys, 1)
+
+	// a new copy replaces the provenance
+	require.NoError(t, store.Put([]byte{3}, []byte{3}, Provenance{Source: SourceOffer, Node: nodeB, ValidationVersion: 2}))
+	require.NoError(t, store.Delete([]byte{1}))
+	contentKeys, err = store.ContentKeysFrom(nodeA, 10)
+	require.NoError(t, err)
+	require.Empty(t, contentKeys)
+	require.NoError(t, store.Close())
+
+	// the provenance is kept across restarts
+	store, err = OpenProvenanceStore(dataDir, "history", enode.ID{})
+	require.NoError(t, err)
+	defer store.Close()
+	contentKeys, err = store.ContentKeysFrom(nodeB, 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, [][]byte{{2}, {3}}, contentKeys)
+}
+
+func TestProvenancePrune(t *testing.T) {
+	store, err := OpenProvenanceStore(t.TempDir(), "history", enode.ID{0x80})
+	require.NoError(t, err)
+	defer store.Close()
+
+	// the distances to the node are 0x00.., 0x7f.. and 0xff..
+	near, middle, far := enode.ID{0x80}, enode.ID{0xff}, enode.ID{0x7f}
+	for i, contentId := range []enode.ID{near, middle, far} {
+		require.NoError(t, store.Put([]byte{byte(i)}, contentId[:], LocalProvenance()))
+	}
+	require.NoError(t, store.Prune(MaxDistance))
+	_, err = store.Get([]byte{2})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Prune(uint256.MustFromHex("0x7f00000000000000000000000000000000000000000000000000000000000000")))
+	for i, pruned := range []bool{false, false, true} {
+		_, err = store.Get([]byte{byte(i)})
+		if pruned {
+			require.ErrorIs(t, err, ErrContentNotFound)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	scrubBatchSize = 100
+	// In the background the scrubber pauses after every batch and repeats
+	// the scrub once a day, so it doesn't compete with serving content.
+	scrubBatchDelay = time.Second
+	scrubInterval   = 24 * time.Hour
+)
+
+var ErrScrubUnsupported = errors.New("the storage can't list and delete its content")
+
+// ScrubValidator validates stored content again. It reads the content the
+// validation depends on from reader and returns ErrContentNotFound if that
+// content isn't stored, the content can't be checked then.
+type ScrubValidator func(reader ContentStorage, contentKey []byte, content []byte) error
+
+// ScrubConfig configures the scrubber of a network storage. The networks set
+// the name, the content types and the validation.
+type ScrubConfig struct {
+	NetworkName string
+	// ContentTypes are scrubbed in order, content the validation depends on
+	// comes first.
+	ContentTypes []byte
+	Validate     ScrubValidator
+	// QuarantineDir receives the invalid content, it is deleted if empty.
+	QuarantineDir string
+	// DryRun only reports the invalid content.
+	DryRun bool
+	// Provenance drops the provenance of the removed content, if it is set.
+	Provenance *ProvenanceStore
+}
+
+// scrubStorage is a content storage that can be scrubbed.
+type scrubStorage interface {
+	ContentStorage
+	ContentIndex
+	ContentDeleter
+}
+
+// ScrubResult summarizes a scrub of the storage.
+type ScrubResult struct {
+	Checked int
+	Invalid int
+	// Skipped is the content whose dependencies aren't stored, it can't be
+	// checked.
+	Skipped int
+	// Unindexed is the content stored without its content key, it can't be
+	// checked.
+	Unindexed uint64
+	Elapsed   time.Duration
+}
+
+// Scrubber revalidates the stored content and removes the content that fails
+// the validation. Failed content is either deleted or moved into a quarantine
+// directory, one file named by the content key for each item.
+type Scrubber struct {
+	storage scrubStorage
+	// reader reads the content past the content cache, so a scrub doesn't
+	// evict the hot content
+	reader     ContentStorage
+	config     ScrubConfig
+	batchDelay time.Duration
+	log        log.Logger
+	closeCtx   context.Context
+	closeFunc  context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// NewScrubber creates a scrubber of the content storage.
+func NewScrubber(contentStorage ContentStorage, config ScrubConfig) (*Scrubber, error) {
+	s, ok := contentStorage.(scrubStorage)
+	if !ok {
+		return nil, ErrScrubUnsupported
+	}
+	var reader ContentStorage = s
+	if cached, ok := s.(*CachedStorage); ok {
+		reader = cached.Unwrap()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scrubber{
+		storage:   s,
+		reader:    reader,
+		config:    config,
+		log:       log.New("sub-protocol", config.NetworkName, "scrub", true),
+		closeCtx:  ctx,
+		closeFunc: cancel,
+	}, nil
+}
+
+// Start scrubs the storage in the background, pausing between batches.
+func (s *Scrubber) Start() {
+	s.batchDelay = scrubBatchDelay
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop stops the scrubber and waits until it no longer reads the storage.
+func (s *Scrubber) Stop() {
+	s.closeFunc()
+	s.wg.Wait()
+}
+
+func (s *Scrubber) loop() {
+	defer s.wg.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-timer.C:
+			res, err := s.Scrub(s.closeCtx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				s.log.Warn("Failed to scrub storage", "err", err)
+			} else if err == nil {
+				s.log.Info("Scrubbed storage", "checked", res.Checked, "invalid", res.Invalid, "skipped", res.Skipped, "unindexed", res.Unindexed, "elapsed", common.PrettyDuration(res.Elapsed))
+			}
+			timer.Reset(scrubInterval)
+		}
+	}
+}
+
+// Scrub validates all stored content with a content key.
+func (s *Scrubber) Scrub(ctx context.Context) (*ScrubResult, error) {
+	start := time.Now()
+	res := new(ScrubResult)
+	for _, contentType := range s.config.ContentTypes {
+		if err := s.scrubType(ctx, contentType, res); err != nil {
+			return res, err
+		}
+	}
+	stats, err := s.storage.Stats()
+	if err != nil {
+		return res, err
+	}
+	res.Unindexed = stats.Unindexed.Count
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+func (s *Scrubber) scrubType(ctx context.Context, contentType byte, res *ScrubResult) error {
+	var offset uint64
+	for {
+		contentKeys, err := s.storage.LocalContentKeys(offset, scrubBatchSize, &contentType)
+		if err != nil {
+			return err
+		}
+		// removed content shifts the following keys to lower offsets
+		offset += uint64(len(contentKeys))
+		for _, contentKey := range contentKeys {
+			removed, err := s.scrubContent(contentKey, res)
+			if err != nil {
+				return err
+			}
+			if removed {
+				offset--
+			}
+		}
+		if len(contentKeys) < scrubBatchSize {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.batchDelay):
+		}
+	}
+}
+
+// scrubContent validates the content of the key and removes it if it is
+// invalid, it returns whether the content was removed.
+func (s *Scrubber) scrubContent(contentKey []byte, res *ScrubResult) (bool, error) {
+	contentId := scrubContentId(contentKey)
+	content, err := s.reader.Get(contentKey, contentId)
+	if errors.Is(err, ErrContentNotFound) {
+		// pruned since it was listed
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	res.Checked++
+	err = s.config.Validate(s.reader, contentKey, content)
+	if errors.Is(err, ErrContentNotFound) {
+		res.Skipped++
+		return false, nil
+	} else if err == nil {
+		return false, nil
+	}
+	res.Invalid++
+	s.log.Warn("Found invalid content", "contentKey", hexutil.Encode(contentKey), "err", err)
+	if s.config.DryRun {
+		return false, nil
+	}
+	if s.config.QuarantineDir != "" {
+		if err = s.quarantine(contentKey, content); err != nil {
+			return false, err
+		}
+	}
+	if err = s.storage.Delete(contentKey, contentId); err != nil {
+		return false, err
+	}
+	if s.config.Provenance != nil {
+		if err = s.config.Provenance.Delete(contentKey); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (s *Scrubber) quarantine(contentKey []byte, content []byte) error {
+	if err := os.MkdirAll(s.config.QuarantineDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.config.QuarantineDir, hexutil.Encode(contentKey)), content, 0644)
+}
+
+// scrubContentId returns the content id of the content key, the sha256 of the
+// key like in all networks.
+func scrubContentId(contentKey []byte) []byte {
+	digest := sha256.Sum256(contentKey)
+	return digest[:]
+}
@@ -47,3 +47,44 @@ func TraverseTrieNode(node node, path []byte) ([]byte, []byte, error) {
 	}
 	return nil, nil, errors.New("unknown type")
 }
+
+// ErrPathNotFound is returned by LookupTrieNode if the trie holds no value at
+// the path.
+var ErrPathNotFound = errors.New("the path doesn't exist in the trie")
+
+// LookupTrieNode follows path through node and its embedded children. It
+// returns the value if the path ends inside node, otherwise the hash of the
+// child node the path continues in together with the remaining path.
+func LookupTrieNode(n node, path []byte) (value []byte, hash []byte, rest []byte, err error) {
+	switch v := n.(type) {
+	case *fullNode:
+		if len(path) == 0 {
+			if value, ok := v.Children[16].(valueNode); ok {
+				return value, nil, nil, nil
+			}
+			return nil, nil, nil, ErrPathNotFound
+		}
+		return LookupTrieNode(v.Children[path[0]], path[1:])
+	case *shortNode:
+		if hasTerm(v.Key) {
+			if !bytes.Equal(v.Key[:len(v.Key)-1], path) {
+				return nil, nil, nil, ErrPathNotFound
+			}
+			return v.Val.(valueNode), nil, nil, nil
+		}
+		if len(path) < len(v.Key) || !bytes.Equal(v.Key, path[:len(v.Key)]) {
+			return nil, nil, nil, ErrPathNotFound
+		}
+		return LookupTrieNode(v.Val, path[len(v.Key):])
+	case hashNode:
+		return nil, v, path, nil
+	case valueNode:
+		if len(path) != 0 {
+			return nil, nil, nil, ErrPathNotFound
+		}
+		return v, nil, nil, nil
+	case nil:
+		return nil, nil, nil, ErrPathNotFound
+	}
+	return nil, nil, nil, errors.New("unknown type")
+}
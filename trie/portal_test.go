@@ -1,9 +1,12 @@
 package trie
 
 import (
+	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
 )
 
 const data = "0xf90211a0491f396d5d4768a01ee4282a3ab1127f2a4dc7d42e6c1dbb3e71ad4e9299f5e7a05b4645219e614b388ba9672452b40f291987b15e35bdbd3dfebfac9a085aeab2a0979ebca2a6a0df389fdfef5bfa4a31f2efa8d385bf2f43cd69c27e61165c3667a01bbe04543bb6bf8026ee3ec2a3ec3d6173a60c090008b59779f767e5769d7a0aa051d347ec61c7dde5c4149a943d0aa489544cbea511ed3d7f4fc6aaa52a420d3ea05358bc8e1e1f20e510887226d67efee73769d0b13ebb24a3e750c2214ef090d5a04df1c24ebf40befce60c8eb30a31894102881454fcdeb6f7b83e1fb916c953a8a00a85e04f30a4978712c58a825e7bd2f8a83731ab1aa3e234a0207e918790505fa0778a45437218486b7849aef890d483dcc2deb1423cb81e488b7be2921d505bf4a051dbaef3c3d3fe82bd1484954f38508f651d867f387f71dedbfb0f7355987ed5a061679f43f3db26673bb687c584f30ae3cff7261e71acf07ed1feaecae098fc79a099761ea7d94e01b14285b7ced7dcd1677fbd3ce093a627a8e1472074baad8bd9a0bb6d269fc61443aa28b14af0448aaf6f1f570477d8b4ef2eb7d31543202ce602a06c41cd2d1701b058853591a2f306ddcfd1e55d3e12011cadb100e7c525aaf460a0bcf37abaac566bb98e091575af403804dbb278fbfa5547de6805cbaa529ae137a0de9918a2a976a2b0b3f4aeff801fc79557426b19c15d414ddf8fae843785b0b780"
@@ -13,3 +16,48 @@ func TestDecodeNodePortal(t *testing.T) {
 	n, _ := DecodeTrieNode(nil, dataBytes)
 	t.Log(n)
 }
+
+func TestLookupTrieNode(t *testing.T) {
+	trie := NewEmpty(newTestDatabase(rawdb.NewMemoryDatabase(), rawdb.HashScheme))
+	values := map[string]string{
+		"120000": "qwerqwerqwerqwerqwerqwerqwerqwer",
+		"123456": "asdfasdfasdfasdfasdfasdfasdfasdf",
+		"123457": "x",
+	}
+	for key, value := range values {
+		updateString(trie, key, value)
+	}
+	root, nodes := trie.Commit(false)
+
+	lookup := func(key string) ([]byte, error) {
+		full := keybytesToHex([]byte(key))
+		full = full[:len(full)-1]
+		hash, path := root[:], full
+		for {
+			blob := nodes.Nodes[string(full[:len(full)-len(path)])].Blob
+			n, err := DecodeTrieNode(hash, blob)
+			if err != nil {
+				return nil, err
+			}
+			value, child, rest, err := LookupTrieNode(n, path)
+			if err != nil || value != nil {
+				return value, err
+			}
+			hash, path = child, rest
+		}
+	}
+	for key, value := range values {
+		got, err := lookup(key)
+		if err != nil {
+			t.Fatalf("key %q: %v", key, err)
+		}
+		if !bytes.Equal(got, []byte(value)) {
+			t.Fatalf("key %q: value mismatch, got %q want %q", key, got, value)
+		}
+	}
+	for _, key := range []string{"123458", "120001", "999999"} {
+		if _, err := lookup(key); !errors.Is(err, ErrPathNotFound) {
+			t.Fatalf("key %q: expected ErrPathNotFound, got %v", key, err)
+		}
+	}
+}